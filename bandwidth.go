@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	"golang.org/x/time/rate"
+)
+
+// maxBandwidthBurst caps the bytes a single read may take from the
+// --max-bandwidth budget, so that one large read can't use up several
+// seconds of it at once while the other workers wait
+const maxBandwidthBurst = 64 << 10
+
+// newBandwidthLimiter returns the limiter shared by all image downloads for
+// --max-bandwidth, allowing bytesPerSecond in total however many workers read
+func newBandwidthLimiter(bytesPerSecond int64) *rate.Limiter {
+	return rate.NewLimiter(rate.Limit(bytesPerSecond), int(min(bytesPerSecond, maxBandwidthBurst)))
+}
+
+// bandwidthTransport throttles reading the body of every response with a
+// shared limiter. Only the bodies count, not headers or request bodies, and
+// bodies the transport decompresses itself count with their decompressed size.
+type bandwidthTransport struct {
+	base    http.RoundTripper
+	limiter *rate.Limiter
+}
+
+func (t *bandwidthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = &throttledBody{ReadCloser: resp.Body, ctx: req.Context(), limiter: t.limiter}
+	return resp, nil
+}
+
+// throttledBody is a response body that waits for the limiter after every read
+type throttledBody struct {
+	io.ReadCloser
+	ctx     context.Context
+	limiter *rate.Limiter
+}
+
+func (b *throttledBody) Read(p []byte) (int, error) {
+	// WaitN fails for more than the burst, so never read more than that at once
+	if burst := b.limiter.Burst(); len(p) > burst {
+		p = p[:burst]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		if werr := b.limiter.WaitN(b.ctx, n); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	return n, err
+}
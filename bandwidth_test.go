@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNewBandwidthLimiter(t *testing.T) {
+	if l := newBandwidthLimiter(1000); l.Burst() != 1000 || float64(l.Limit()) != 1000 {
+		t.Errorf("limit %v, burst %d, want 1000 for both", l.Limit(), l.Burst())
+	}
+	if l := newBandwidthLimiter(10 << 20); l.Burst() != maxBandwidthBurst {
+		t.Errorf("burst %d, want it capped at %d", l.Burst(), maxBandwidthBurst)
+	}
+}
+
+func TestBandwidthTransport(t *testing.T) {
+	const (
+		rate    = 20000
+		size    = 10000
+		workers = 4
+	)
+	body := bytes.Repeat([]byte("x"), size)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(body)
+	}))
+	defer srv.Close()
+
+	client, err := newHTTPClient(httpOptions{bandwidth: newBandwidthLimiter(rate)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Get(srv.URL)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer resp.Body.Close()
+			if data, err := io.ReadAll(resp.Body); err != nil || len(data) != size {
+				t.Errorf("read %d bytes, err %v", len(data), err)
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	// The first burst is free; everything after it must stay under the
+	// ceiling, shared by all workers rather than granted to each
+	throttled := float64(workers*size - rate)
+	if throughput := throttled / elapsed.Seconds(); throughput > rate*1.1 {
+		t.Errorf("read at %.0f B/s after the burst, want at most %d", throughput, rate)
+	}
+	if elapsed > 3*time.Second {
+		t.Errorf("took %v, want about 1s", elapsed)
+	}
+}
+
+func TestThrottledBodyCancel(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(bytes.Repeat([]byte("x"), 4000))
+	}))
+	defer srv.Close()
+
+	// Once the burst is spent, the next read waits far beyond the deadline
+	client, err := newHTTPClient(httpOptions{bandwidth: newBandwidthLimiter(1000)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	start := time.Now()
+	if _, err := io.ReadAll(resp.Body); err == nil {
+		t.Error("no error reading past the deadline")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("read returned after %v, want it to stop at the deadline", elapsed)
+	}
+}
+
+func TestMaxBandwidthFlag(t *testing.T) {
+	srv := newFakeServer(t)
+	data := noisyPNG(t, 32, 32)
+	file := sourceFile(t,
+		"a", srv.img("a.png", data),
+		"b", srv.img("b.png", data),
+		"c", srv.img("c.png", data),
+		"d", srv.img("d.png", data),
+	)
+
+	// Twice the image size per second: after the first burst of two images,
+	// the other two take a second
+	start := time.Now()
+	code, _, out := runImport(t, srv, file, "--max-bandwidth", strconv.Itoa(2*len(data)), "-c", "4")
+	elapsed := time.Since(start)
+	if code != exitOK {
+		t.Fatalf("exit code %d, output:\n%s", code, out)
+	}
+	if n := len(srv.uploaded()); n != 4 {
+		t.Errorf("%d uploads, want 4", n)
+	}
+	if elapsed < 900*time.Millisecond {
+		t.Errorf("import took %v, want the downloads throttled to about 1s", elapsed)
+	}
+}
+
+func TestInvalidMaxBandwidth(t *testing.T) {
+	srv := newFakeServer(t)
+	file := sourceFile(t, "party", srv.img("party.png", pngData))
+	if code, _, out := runImport(t, srv, file, "--max-bandwidth", "-1"); code != exitSetup {
+		t.Errorf("exit code %d, want %d\n%s", code, exitSetup, out)
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// command is a subcommand such as "list", parsed with its own flag set that
+// only knows the flags the command uses.
+type command struct {
+	name string
+	// args describes the positional arguments for the usage message
+	args    string
+	summary string
+	// flags registers the command's flags on fs
+	flags func(fs *flag.FlagSet)
+	// apply sets the mode variables for the command from its positional
+	// arguments after the flags were parsed
+	apply func(args []string) error
+}
+
+// commands are the subcommands in the order the usage message lists them.
+// Without one the default command line takes every flag, as before there
+// were subcommands.
+var commands = []command{
+	{
+		name:    "upload",
+		summary: "Upload the emojis of a JSON or YAML file, a directory or a ZIP archive.",
+		flags: func(fs *flag.FlagSet) {
+			connectionFlags(fs)
+			sourceFlags(fs)
+			importFlags(fs)
+		},
+		apply: noArgs,
+	},
+	{
+		name:    "delete",
+		summary: "Delete the emojis of the source from the server, or with --by-prefix every emoji whose name starts with a prefix.",
+		flags: func(fs *flag.FlagSet) {
+			connectionFlags(fs)
+			sourceFlags(fs)
+			fs.StringVar(&deletePrefix, "by-prefix", "", "Delete every emoji on the server whose name starts with this prefix instead")
+		},
+		apply: func(args []string) error {
+			deleteMode = deletePrefix == ""
+			return noArgs(args)
+		},
+	},
+	{
+		name:    "list",
+		summary: "Print the names of the custom emojis on the server.",
+		flags: func(fs *flag.FlagSet) {
+			connectionFlags(fs)
+			fs.BoolVar(&listDetails, "details", false, "Also print the creator and creation date of every emoji")
+			fs.StringVar(&listOutput, "output", outputText, "Output format: text or json")
+		},
+		apply: func(args []string) error {
+			listMode = true
+			return noArgs(args)
+		},
+	},
+	{
+		name:    "export",
+		args:    " DIRECTORY",
+		summary: "Download all custom emojis from the server into DIRECTORY, with an emoji.json to upload them elsewhere.",
+		flags:   connectionFlags,
+		apply: func(args []string) error {
+			if len(args) == 0 || args[0] == "" {
+				return errors.New("export needs the directory to write to")
+			}
+			exportDir = args[0]
+			return noArgs(args[1:])
+		},
+	},
+}
+
+// noArgs rejects positional arguments for commands that take none
+func noArgs(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("unexpected argument %q", args[0])
+	}
+	return nil
+}
+
+// findCommand returns the subcommand called name, or nil
+func findCommand(name string) *command {
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i]
+		}
+	}
+	return nil
+}
+
+// parseCommandLine parses args, the arguments without the program name, with
+// the flag set of the subcommand they start with, or with the default command
+// line if they start with a flag. It returns the flag set used, so that the
+// config file can be applied to the same flags.
+func parseCommandLine(args []string) (*flag.FlagSet, error) {
+	fs := flag.CommandLine
+	cmd := (*command)(nil)
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		if cmd = findCommand(args[0]); cmd == nil {
+			fmt.Fprintf(os.Stderr, "❌ Error: unknown command %q\n", args[0])
+			flag.Usage()
+			return nil, errors.New("unknown command")
+		}
+		fs = flag.NewFlagSet(os.Args[0]+" "+cmd.name, flag.ContinueOnError)
+		cmd.flags(fs)
+		fs.Usage = commandUsage(fs, cmd)
+		args = args[1:]
+	} else {
+		flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	positional := fs.Args()
+	apply := noArgs
+	if cmd != nil {
+		apply = cmd.apply
+		// Subcommands also take flags after their arguments, as in
+		// "export ./backup -v", while the default command line keeps
+		// stopping at the first argument
+		positional = nil
+		for rest := fs.Args(); len(rest) > 0; rest = fs.Args() {
+			positional = append(positional, rest[0])
+			if err := fs.Parse(rest[1:]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := apply(positional); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Error: %v\n", err)
+		fs.Usage()
+		return nil, err
+	}
+	return fs, nil
+}
+
+// commandUsage returns the usage message of a subcommand. Its flags are
+// listed like in the main usage message, with the short and long forms of a
+// flag on one line.
+func commandUsage(fs *flag.FlagSet, cmd *command) func() {
+	return func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s %s [OPTIONS]%s\n\n", os.Args[0], cmd.name, cmd.args)
+		fmt.Fprintf(os.Stderr, "%s\n\n", cmd.summary)
+		fmt.Fprintf(os.Stderr, "Options:\n")
+
+		// Short and long forms of a flag share the variable they write to
+		names := make(map[string][]string)
+		var targets []string
+		flags := make(map[string]*flag.Flag)
+		fs.VisitAll(func(f *flag.Flag) {
+			target := flagTarget(f.Value)
+			if _, seen := names[target]; !seen {
+				targets = append(targets, target)
+			}
+			names[target] = append(names[target], f.Name)
+			if len(f.Name) > 1 || flags[target] == nil {
+				flags[target] = f
+			}
+		})
+		sort.Slice(targets, func(i, j int) bool { return flags[targets[i]].Name < flags[targets[j]].Name })
+
+		for _, target := range targets {
+			f := flags[target]
+			forms := names[target]
+			sort.Slice(forms, func(i, j int) bool { return len(forms[i]) < len(forms[j]) })
+			for i, name := range forms {
+				if len(name) == 1 {
+					forms[i] = "-" + name
+				} else {
+					forms[i] = "--" + name
+				}
+			}
+			kind, usage := flag.UnquoteUsage(f)
+			if kind != "" {
+				kind = " " + kind
+			}
+			fmt.Fprintf(os.Stderr, "  %s%s\n", strings.Join(forms, ", "), kind)
+			switch f.DefValue {
+			case "", "0", "0s", "false":
+				fmt.Fprintf(os.Stderr, "        %s\n", usage)
+			default:
+				fmt.Fprintf(os.Stderr, "        %s (default %s)\n", usage, f.DefValue)
+			}
+		}
+		fmt.Fprintf(os.Stderr, "\nRun %s --help for a description of every option.\n", os.Args[0])
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// captureStderr runs f and returns what it wrote to os.Stderr, where the
+// usage messages go
+func captureStderr(t *testing.T, f func()) string {
+	t.Helper()
+	file, err := os.Create(filepath.Join(t.TempDir(), "stderr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	stderr := os.Stderr
+	os.Stderr = file
+	defer func() { os.Stderr = stderr }()
+
+	f()
+	data, err := os.ReadFile(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestParseCommandLine(t *testing.T) {
+	tests := []struct {
+		name  string
+		args  []string
+		set   string
+		check func() bool
+	}{
+		{"default", []string{"-f", "emoji.json"}, "",
+			func() bool { return len(jsonFiles) == 1 && !deleteMode && !listMode && exportDir == "" }},
+		{"upload", []string{"upload", "-f", "emoji.json", "-c", "4"}, "upload",
+			func() bool {
+				return len(jsonFiles) == 1 && concurrency == 4 && !deleteMode && !listMode && exportDir == ""
+			}},
+		{"delete", []string{"delete", "-f", "emoji.json"}, "delete",
+			func() bool { return deleteMode && deletePrefix == "" && len(jsonFiles) == 1 }},
+		{"delete by prefix", []string{"delete", "--by-prefix", "old_"}, "delete",
+			func() bool { return !deleteMode && deletePrefix == "old_" }},
+		{"list", []string{"list", "--details", "--output", "json"}, "list",
+			func() bool { return listMode && listDetails && listOutput == outputJSON }},
+		// Flags may follow the arguments of a subcommand
+		{"export", []string{"export", "./backup", "-v"}, "export",
+			func() bool { return exportDir == "./backup" && verbose }},
+	}
+	for _, tt := range tests {
+		resetOptions(t)
+		fs, err := parseCommandLine(tt.args)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if tt.set == "" {
+			if fs != flag.CommandLine {
+				t.Errorf("%s: parsed with %s, want the default command line", tt.name, fs.Name())
+			}
+		} else if !strings.HasSuffix(fs.Name(), " "+tt.set) {
+			t.Errorf("%s: parsed with %q, want the %s flag set", tt.name, fs.Name(), tt.set)
+		}
+		if !tt.check() {
+			t.Errorf("%s: options not set as %q asks", tt.name, tt.args)
+		}
+	}
+}
+
+func TestParseCommandLineErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		args  []string
+		usage string
+		msg   string
+	}{
+		{"unknown command", []string{"frobnicate"}, "Usage:", `unknown command "frobnicate"`},
+		{"export without directory", []string{"export"}, "Usage: " + os.Args[0] + " export [OPTIONS] DIRECTORY", "export needs the directory"},
+		{"extra argument", []string{"list", "extra"}, "Usage: " + os.Args[0] + " list [OPTIONS]", `unexpected argument "extra"`},
+		// Each subcommand only knows its own flags
+		{"flag of another command", []string{"list", "-f", "emoji.json"}, "Usage: " + os.Args[0] + " list [OPTIONS]", "flag provided but not defined: -f"},
+		{"upload flag on delete", []string{"delete", "--concurrency", "4"}, "Usage: " + os.Args[0] + " delete [OPTIONS]", "not defined: -concurrency"},
+	}
+	for _, tt := range tests {
+		resetOptions(t)
+		var err error
+		stderr := captureStderr(t, func() { _, err = parseCommandLine(tt.args) })
+		if err == nil {
+			t.Errorf("%s: no error", tt.name)
+			continue
+		}
+		if !strings.Contains(stderr, tt.usage) || !strings.Contains(stderr, tt.msg) {
+			t.Errorf("%s: stderr %q, want %q and the usage %q", tt.name, stderr, tt.msg, tt.usage)
+		}
+	}
+}
+
+func TestCommandUsage(t *testing.T) {
+	resetOptions(t)
+	stderr := captureStderr(t, func() { parseCommandLine([]string{"delete", "-h"}) })
+	for _, want := range []string{
+		"Usage: " + os.Args[0] + " delete [OPTIONS]",
+		findCommand("delete").summary,
+		// Short and long forms share a line
+		"  -s, --server string\n",
+		"  --by-prefix string\n",
+	} {
+		if !strings.Contains(stderr, want) {
+			t.Errorf("usage doesn't contain %q:\n%s", want, stderr)
+		}
+	}
+	if strings.Contains(stderr, "--concurrency") {
+		t.Errorf("delete usage lists the upload flags:\n%s", stderr)
+	}
+}
+
+func TestUploadCommand(t *testing.T) {
+	srv := newFakeServer(t)
+	file := sourceFile(t, "party", srv.img("party.png", pngData))
+	code, out := runCLI(t, "upload", "-s", srv.URL, "-t", "tok", "-f", file, "--delay", "0")
+	if code != exitOK {
+		t.Fatalf("exit code %d, output:\n%s", code, out)
+	}
+	if uploads := srv.uploaded(); len(uploads) != 1 || uploads[0].Name != "party" {
+		t.Errorf("uploads %v, want party", uploads)
+	}
+}
+
+func TestListCommand(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.addEmoji("party", pngData)
+	srv.addEmoji("wave", pngData)
+	var code int
+	var out string
+	stdout := captureStdout(t, func() { code, out = runCLI(t, "list", "-s", srv.URL, "-t", "tok") })
+	if code != exitOK {
+		t.Fatalf("exit code %d, output:\n%s", code, out)
+	}
+	if stdout != "party\nwave\n" {
+		t.Errorf("stdout %q, want the two names", stdout)
+	}
+	if n := srv.requestCount("POST ") + srv.requestCount("DELETE "); n != 0 {
+		t.Errorf("list sent %d writes to the server", n)
+	}
+}
+
+func TestUnknownCommand(t *testing.T) {
+	srv := newFakeServer(t)
+	var code int
+	stderr := captureStderr(t, func() { code, _ = runCLI(t, "frobnicate", "-s", srv.URL, "-t", "tok") })
+	if code != exitSetup {
+		t.Errorf("exit code %d, want %d", code, exitSetup)
+	}
+	if !strings.Contains(stderr, `unknown command "frobnicate"`) {
+		t.Errorf("stderr doesn't name the command:\n%s", stderr)
+	}
+	for _, cmd := range commands {
+		if !strings.Contains(stderr, cmd.name) {
+			t.Errorf("usage doesn't list %s:\n%s", cmd.name, stderr)
+		}
+	}
+	if n := srv.requestCount(""); n != 0 {
+		t.Errorf("%d API requests for an unknown command", n)
+	}
+}
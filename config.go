@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// defaultConfigFile is loaded from the working directory when --config isn't given
+const defaultConfigFile = ".emoji-uploader.json"
+
+// configEnv maps settings that can also come from the environment to their
+// variable; the environment takes precedence over the config file
+var configEnv = map[string]string{
+	"server":      serverURLEnv,
+	"s":           serverURLEnv,
+	"token":       tokenEnv,
+	"t":           tokenEnv,
+	"slack-token": slackTokenEnv,
+}
+
+// secretSettings are the config keys that shouldn't be readable by other users
+var secretSettings = []string{"token", "t", "password", "image-basic-auth", "slack-token"}
+
+// applyConfig sets every flag of fs named in the JSON config file at path
+// that was neither given on the command line nor, for server, token and
+// slack-token, in the environment. Values are strings, numbers or booleans
+// as they would be written on the command line, or arrays for repeatable
+// flags. Settings for flags that only other commands have are ignored, so
+// one file can serve all of them.
+func applyConfig(fs *flag.FlagSet, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var settings map[string]json.RawMessage
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	// Short and long forms of a flag share the variable they write to
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		explicit[flagTarget(f.Value)] = true
+	})
+
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		f := fs.Lookup(key)
+		if f == nil && flag.Lookup(key) != nil && key != "config" {
+			continue
+		}
+		if f == nil || key == "config" {
+			return fmt.Errorf("%s: unknown setting %q", path, key)
+		}
+		if explicit[flagTarget(f.Value)] {
+			continue
+		}
+		if env, ok := configEnv[key]; ok && os.Getenv(env) != "" {
+			continue
+		}
+
+		values, err := configValues(settings[key])
+		if err != nil {
+			return fmt.Errorf("%s: %s: %w", path, key, err)
+		}
+		for _, value := range values {
+			if err := f.Value.Set(value); err != nil {
+				return fmt.Errorf("%s: %s: %w", path, key, err)
+			}
+		}
+	}
+
+	for _, key := range secretSettings {
+		if _, ok := settings[key]; ok {
+			warnIfShared(path)
+			break
+		}
+	}
+	return nil
+}
+
+// configPath returns the config file to load: --config if set, otherwise
+// defaultConfigFile if it exists, or "" for none
+func configPath(flagValue string) string {
+	if flagValue != "" {
+		return flagValue
+	}
+	if _, err := os.Stat(defaultConfigFile); errors.Is(err, fs.ErrNotExist) {
+		return ""
+	}
+	return defaultConfigFile
+}
+
+// configValues converts a JSON value into the command line form(s) of a flag
+func configValues(raw json.RawMessage) ([]string, error) {
+	raw = bytes.TrimSpace(raw)
+	switch {
+	case len(raw) == 0 || string(raw) == "null":
+		return nil, errors.New("missing value")
+	case raw[0] == '"':
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return nil, err
+		}
+		return []string{s}, nil
+	case raw[0] == '[':
+		var items []json.RawMessage
+		if err := json.Unmarshal(raw, &items); err != nil {
+			return nil, err
+		}
+		var values []string
+		for _, item := range items {
+			if bytes.HasPrefix(bytes.TrimSpace(item), []byte("[")) {
+				return nil, errors.New("nested arrays are not supported")
+			}
+			v, err := configValues(item)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v...)
+		}
+		return values, nil
+	case raw[0] == '{':
+		return nil, errors.New("objects are not supported")
+	default:
+		// Numbers and booleans are passed on as written
+		return []string{string(raw)}, nil
+	}
+}
+
+// flagTarget identifies the variable behind a flag value
+func flagTarget(v flag.Value) string {
+	return fmt.Sprintf("%T %p", v, v)
+}
+
+// warnIfShared warns when a config file holding credentials can be read by
+// other users
+func warnIfShared(path string) {
+	if runtime.GOOS == "windows" {
+		return
+	}
+	info, err := os.Stat(path)
+	if err != nil || info.Mode().Perm()&0o077 == 0 {
+		return
+	}
+	logError("⚠️  %s contains credentials but is readable by other users; restrict it with: chmod 600 %s\n", path, strings.ReplaceAll(path, " ", "\\ "))
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// configuredSlackToken parses args with the flags of the upload command, applies the
+// config file at path and resolves the Slack token like run does
+func configuredSlackToken(t *testing.T, path string, args ...string) string {
+	t.Helper()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	connectionFlags(fs)
+	sourceFlags(fs)
+	importFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyConfig(fs, path); err != nil {
+		t.Fatal(err)
+	}
+	return resolveSetting(slackToken, slackTokenEnv)
+}
+
+func TestConfigSlackTokenPrecedence(t *testing.T) {
+	resetOptions(t)
+	path := writeFile(t, t.TempDir(), "config.json", []byte(`{"slack-token": "from-config"}`))
+
+	if got := configuredSlackToken(t, path); got != "from-config" {
+		t.Errorf("config only: got %q, want from-config", got)
+	}
+	t.Setenv(slackTokenEnv, "from-env")
+	if got := configuredSlackToken(t, path); got != "from-env" {
+		t.Errorf("config and environment: got %q, want from-env", got)
+	}
+	if got := configuredSlackToken(t, path, "--slack-token", "from-flag"); got != "from-flag" {
+		t.Errorf("config, environment and flag: got %q, want from-flag", got)
+	}
+}
+
+func TestConfigSlackTokenWarnsIfShared(t *testing.T) {
+	resetOptions(t)
+	var out strings.Builder
+	logOutput = &out
+	path := writeFile(t, t.TempDir(), "config.json", []byte(`{"slack-token": "xoxp-secret"}`))
+
+	configuredSlackToken(t, path)
+	if !strings.Contains(out.String(), "readable by other users") {
+		t.Errorf("no warning for a world-readable config with a Slack token, output: %q", out.String())
+	}
+}
+
+func TestConfigTokenPrecedence(t *testing.T) {
+	srv, auth := authServer(t)
+	file := sourceFile(t, "party", srv.img("party.png", pngData))
+	config := writeFile(t, t.TempDir(), "config.json", []byte(`{"server": "`+srv.URL+`", "token": "config-token", "delay": "0"}`))
+	os.Chmod(config, 0o600)
+
+	tests := []struct {
+		name string
+		env  map[string]string
+		args []string
+		want string
+	}{
+		{"config only", nil, nil, "Bearer config-token"},
+		{"environment over config", map[string]string{tokenEnv: "env-token"}, nil, "Bearer env-token"},
+		{"flag over environment", map[string]string{tokenEnv: "env-token"}, []string{"-t", "flag-token"}, "Bearer flag-token"},
+	}
+	// Commands get a fresh flag set, unlike the default command line that
+	// remembers which flags earlier tests gave
+	for _, tt := range tests {
+		*auth = ""
+		code, out := runCLIEnv(t, tt.env, append([]string{"upload", "--config", config, "-f", file}, tt.args...)...)
+		if code != exitOK {
+			t.Fatalf("%s: exit code %d, output:\n%s", tt.name, code, out)
+		}
+		if *auth != tt.want {
+			t.Errorf("%s: Authorization = %q, want %q", tt.name, *auth, tt.want)
+		}
+	}
+}
+
+func TestConfigFlagsOverrideFile(t *testing.T) {
+	srv := newFakeServer(t)
+	file := sourceFile(t, "cat-a", srv.img("a.png", pngData), "dog-b", srv.img("b.png", pngData), "cow-c", srv.img("c.png", pngData))
+	config := writeFile(t, t.TempDir(), "config.json", []byte(`{
+		"concurrency": 4,
+		"include": ["cat-*", "dog-*"],
+		"verbose": true,
+		"list-details": true
+	}`))
+
+	code, out := runCLI(t, "upload", "--config", config, "-s", srv.URL, "-t", "tok", "-f", file, "--delay", "0", "--concurrency", "2")
+	if code != exitOK {
+		t.Fatalf("exit code %d, output:\n%s", code, out)
+	}
+	if concurrency != 2 {
+		t.Errorf("concurrency = %d, want the 2 from the command line", concurrency)
+	}
+	if !verbose {
+		t.Error("verbose from the config file was not applied")
+	}
+	// Repeatable flags take arrays; settings of other commands such as
+	// --list-details are ignored
+	if got := strings.Join(includePatterns, " "); got != "cat-* dog-*" {
+		t.Errorf("include = %q, want both patterns from the config file", got)
+	}
+	if len(srv.uploaded()) != 2 {
+		t.Errorf("%d uploads, want 2", len(srv.uploaded()))
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	for _, config := range []string{
+		`{"no-such-flag": true}`,
+		`{"config": "other.json"}`,
+		`{"concurrency": "many"}`,
+		`{"include": [["nested"]]}`,
+		`{"server": {"url": "x"}}`,
+		`not json`,
+	} {
+		path := writeFile(t, t.TempDir(), "config.json", []byte(config))
+		if code, out := runCLI(t, "upload", "--config", path, "-t", "tok", "-f", "x.json"); code != exitSetup || !strings.Contains(out, "Error reading config") {
+			t.Errorf("%s: exit code %d, want %d with an error\n%s", config, code, exitSetup, out)
+		}
+	}
+	if code, _ := runCLI(t, "--config", filepath.Join(t.TempDir(), "missing.json"), "-f", "x.json"); code != exitSetup {
+		t.Errorf("missing config: exit code %d, want %d", code, exitSetup)
+	}
+}
+
+func TestConfigPath(t *testing.T) {
+	resetOptions(t)
+	if got := configPath(""); got != "" {
+		t.Errorf("configPath without a file = %q, want none", got)
+	}
+	writeFile(t, ".", defaultConfigFile, []byte(`{}`))
+	if got := configPath(""); got != defaultConfigFile {
+		t.Errorf("configPath = %q, want %s from the working directory", got, defaultConfigFile)
+	}
+	if got := configPath("other.json"); got != "other.json" {
+		t.Errorf("configPath(other.json) = %q, want --config to win", got)
+	}
+}
+
+func TestConfigTokenWarnsIfShared(t *testing.T) {
+	for _, mode := range []os.FileMode{0o644, 0o600} {
+		resetOptions(t)
+		var out strings.Builder
+		logOutput = &out
+		path := writeFile(t, t.TempDir(), "config.json", []byte(`{"token": "secret"}`))
+		if err := os.Chmod(path, mode); err != nil {
+			t.Fatal(err)
+		}
+		configuredSlackToken(t, path)
+		if warned := strings.Contains(out.String(), "readable by other users"); warned != (mode == 0o644) {
+			t.Errorf("mode %o: warned %v, output: %q", mode, warned, out.String())
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// defaultConfirmAbove is the number of uploads above which the tool asks
+// before starting, unless --confirm-above says otherwise
+const defaultConfirmAbove = 100
+
+// needsConfirmation reports whether to ask before uploading pending emojis:
+// always with --interactive, otherwise when there are more than confirmAbove
+// (0 never asks). Nobody can answer without a terminal, and --yes answers in
+// advance, so neither case asks.
+func needsConfirmation(pending int) bool {
+	if assumeYes || pending == 0 || !term.IsTerminal(int(os.Stdin.Fd())) {
+		return false
+	}
+	return interactive || (confirmAbove > 0 && pending > confirmAbove)
+}
+
+// confirm prints question to out and reads the answer from in; only y or yes
+// (in any case) agree. It gives up with ctx.Err() when ctx is cancelled while
+// waiting, e.g. by Ctrl+C.
+func confirm(ctx context.Context, in io.Reader, out io.Writer, question string) (bool, error) {
+	fmt.Fprintf(out, "%s [y/N] ", question)
+
+	answer := make(chan string, 1)
+	errc := make(chan error, 1)
+	go func() {
+		line, err := bufio.NewReader(in).ReadString('\n')
+		if err != nil && line == "" {
+			errc <- err
+			return
+		}
+		answer <- line
+	}()
+
+	select {
+	case line := <-answer:
+		switch strings.ToLower(strings.TrimSpace(line)) {
+		case "y", "yes":
+			return true, nil
+		}
+		return false, nil
+	case err := <-errc:
+		fmt.Fprintln(out)
+		if err == io.EOF {
+			return false, nil
+		}
+		return false, err
+	case <-ctx.Done():
+		fmt.Fprintln(out)
+		return false, ctx.Err()
+	}
+}
+
+// pendingUploads counts the jobs that would actually be uploaded, i.e. that
+// aren't already on the server, finished in a previous run or name conflicts
+func (imp *importer) pendingUploads(jobs ...[]emojiJob) int {
+	n := 0
+	for _, list := range jobs {
+		for _, job := range list {
+			if !imp.done[job.safeName] && !imp.existing[job.safeName] && job.conflictWith == "" {
+				n++
+			}
+		}
+	}
+	return n
+}
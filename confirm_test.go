@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestConfirm(t *testing.T) {
+	tests := []struct {
+		input string
+		want  bool
+	}{
+		{"y\n", true},
+		{"yes\n", true},
+		{"  YES \r\n", true},
+		{"Y", true},
+		{"n\n", false},
+		{"no\n", false},
+		{"\n", false},
+		{"yes please\n", false},
+		{"sure\n", false},
+		// End of input without an answer means no
+		{"", false},
+	}
+	for _, tt := range tests {
+		var out strings.Builder
+		got, err := confirm(context.Background(), strings.NewReader(tt.input), &out, "About to upload 3 emojis to https://chat.example.com. Continue?")
+		if err != nil {
+			t.Errorf("%q: %v", tt.input, err)
+		}
+		if got != tt.want {
+			t.Errorf("%q: confirm = %v, want %v", tt.input, got, tt.want)
+		}
+		if !strings.HasPrefix(out.String(), "About to upload 3 emojis to https://chat.example.com. Continue? [y/N] ") {
+			t.Errorf("%q: prompt = %q", tt.input, out.String())
+		}
+	}
+}
+
+func TestConfirmReadError(t *testing.T) {
+	failure := errors.New("terminal gone")
+	ok, err := confirm(context.Background(), iotest.ErrReader(failure), io.Discard, "Continue?")
+	if ok || !errors.Is(err, failure) {
+		t.Errorf("confirm = %v, %v, want the read error", ok, err)
+	}
+}
+
+func TestConfirmCancel(t *testing.T) {
+	// Nobody ever answers
+	in, w := io.Pipe()
+	defer w.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	ok, err := confirm(ctx, in, io.Discard, "Continue?")
+	if ok || !errors.Is(err, context.Canceled) {
+		t.Errorf("confirm = %v, %v, want to give up when cancelled", ok, err)
+	}
+}
+
+func TestNeedsConfirmation(t *testing.T) {
+	t.Cleanup(func() { interactive, assumeYes = false, false })
+	interactive = true
+	// The tests don't run on a terminal, so nobody could answer
+	if needsConfirmation(1000) {
+		t.Error("asked without a terminal")
+	}
+	assumeYes = true
+	if needsConfirmation(1000) {
+		t.Error("asked despite --yes")
+	}
+}
+
+func TestPendingUploads(t *testing.T) {
+	imp := &importer{
+		done:     map[string]bool{"finished": true},
+		existing: map[string]bool{"exists": true},
+	}
+	regular := []emojiJob{{safeName: "new"}, {safeName: "finished"}, {safeName: "exists"}, {safeName: "twin", conflictWith: "new"}}
+	aliases := []emojiJob{{safeName: "alias"}}
+	if n := imp.pendingUploads(regular, aliases); n != 2 {
+		t.Errorf("pendingUploads = %d, want 2", n)
+	}
+}
+
+func TestImportWithoutTerminalDoesNotAsk(t *testing.T) {
+	srv := newFakeServer(t)
+	file := sourceFile(t, "a", srv.img("a.png", pngData), "b", srv.img("b.png", solidPNG(t, 2, 2)))
+
+	code, _, out := runImport(t, srv, file, "--interactive", "--confirm-above", "1")
+	if code != exitOK {
+		t.Fatalf("exit code %d, output:\n%s", code, out)
+	}
+	// A prompt would have read no answer from the test's stdin and aborted
+	if n := len(srv.uploaded()); n != 2 {
+		t.Errorf("%d uploads, want both without a prompt:\n%s", n, out)
+	}
+}
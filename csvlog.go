@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/csv"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// csvHeader names the columns of the --csv file
+var csvHeader = []string{"original_name", "sanitized_name", "status", "http_status", "size_bytes", "error", "emoji_id"}
+
+// csvLog writes one row per result as soon as it is known, so the file is
+// usable even if the tool crashes halfway through
+type csvLog struct {
+	mu   sync.Mutex
+	file *os.File
+	w    *csv.Writer
+}
+
+// createCSVLog truncates path and writes the header row
+func createCSVLog(path string) (*csvLog, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	l := &csvLog{file: f, w: csv.NewWriter(f)}
+	if err := l.write(csvHeader); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// record appends the row of a single result. The error column holds the
+// reason an emoji was skipped or failed and stays empty for successes, the
+// emoji_id column is only set for emojis uploaded in this run.
+func (l *csvLog) record(r Result) error {
+	var status, size, reason string
+	if r.HTTPStatus != 0 {
+		status = strconv.Itoa(r.HTTPStatus)
+	}
+	if r.SizeBytes != 0 {
+		size = strconv.Itoa(r.SizeBytes)
+	}
+	if r.Action == actionSkipped || r.Action == actionFailed {
+		reason = r.Reason
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.write([]string{r.OriginalName, r.SanitizedName, r.Action, status, size, reason, r.EmojiID})
+}
+
+// write adds a row and flushes it to disk right away
+func (l *csvLog) write(row []string) error {
+	if err := l.w.Write(row); err != nil {
+		return err
+	}
+	l.w.Flush()
+	return l.w.Error()
+}
+
+func (l *csvLog) Close() error {
+	return l.file.Close()
+}
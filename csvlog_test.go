@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// readCSV parses the --csv file at path
+func readCSV(t *testing.T, path string) [][]string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatalf("invalid CSV: %v", err)
+	}
+	return rows
+}
+
+func TestCSVLogQuoting(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.csv")
+	l, err := createCSVLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := []Result{
+		{OriginalName: "party", SanitizedName: "party", Action: actionUploaded, Reason: "resized", HTTPStatus: 201, SizeBytes: 1234, EmojiID: "e1"},
+		{OriginalName: `say "hi", world`, SanitizedName: "say-hi-world", Action: actionFailed, Reason: "Upload error: HTTP 400\nline two", HTTPStatus: 400},
+		{OriginalName: "old", SanitizedName: "old", Action: actionSkipped, Reason: "already exists on the server"},
+	}
+	for _, r := range results {
+		if err := l.record(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Rows are flushed as they are recorded, before the file is closed
+	rows := readCSV(t, path)
+	l.Close()
+
+	want := [][]string{
+		csvHeader,
+		{"party", "party", actionUploaded, "201", "1234", "", "e1"},
+		{`say "hi", world`, "say-hi-world", actionFailed, "400", "", "Upload error: HTTP 400\nline two", ""},
+		{"old", "old", actionSkipped, "", "", "already exists on the server", ""},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("rows = %q\nwant %q", rows, want)
+	}
+}
+
+func TestCSVFlag(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.addEmoji("existing", pngData)
+	file := sourceFile(t,
+		"new", srv.img("new.png", pngData),
+		"existing", srv.img("existing.png", pngData),
+		"missing", srv.URL+"/img/missing.png",
+	)
+	path := filepath.Join(t.TempDir(), "results.csv")
+
+	code, report, out := runImport(t, srv, file, "--csv", path, "--retries", "0")
+	if code != exitFailures {
+		t.Errorf("exit code %d, want %d\n%s", code, exitFailures, out)
+	}
+	rows := readCSV(t, path)
+	if len(rows) != 4 || !reflect.DeepEqual(rows[0], csvHeader) {
+		t.Fatalf("rows = %q, want the header and 3 results", rows)
+	}
+	results := byName(report)
+	for _, row := range rows[1:] {
+		r, ok := results[row[0]]
+		if !ok {
+			t.Errorf("row for unknown emoji %q", row[0])
+			continue
+		}
+		if row[1] != r.SanitizedName || row[2] != r.Action || row[6] != r.EmojiID {
+			t.Errorf("row %q doesn't match the report %+v", row, r)
+		}
+	}
+	byOriginal := make(map[string][]string)
+	for _, row := range rows[1:] {
+		byOriginal[row[0]] = row
+	}
+	if row := byOriginal["new"]; row[2] != actionUploaded || row[5] != "" {
+		t.Errorf("new: row %q, want uploaded without an error", row)
+	}
+	if row := byOriginal["missing"]; row[2] != actionFailed || row[3] != "404" || row[5] == "" {
+		t.Errorf("missing: row %q, want failed with HTTP 404 and the error", row)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"mime"
+	"net/url"
+	"strings"
+
+	"github.com/formatCvt/mattermost-emoji-uploader/emojiuploader"
+)
+
+// isDataURI reports whether source is an inline data: URI
+func isDataURI(source string) bool {
+	return len(source) >= 5 && strings.EqualFold(source[:5], "data:")
+}
+
+// decodeDataURI returns the image embedded in a data: URI (RFC 2397), such as
+// data:image/png;base64,iVBORw0KGgo... The declared media type is used unless
+// the data is recognizably something else.
+func decodeDataURI(source string) ([]byte, string, error) {
+	header, payload, ok := strings.Cut(source[len("data:"):], ",")
+	if !ok {
+		return nil, "", errors.New("missing comma before the data")
+	}
+
+	mediaType, isBase64 := header, false
+	if before, found := strings.CutSuffix(strings.ToLower(header), ";base64"); found {
+		mediaType, isBase64 = header[:len(before)], true
+	}
+	if mediaType != "" {
+		parsed, _, err := mime.ParseMediaType(mediaType)
+		if err != nil {
+			return nil, "", fmt.Errorf("media type %q: %w", mediaType, err)
+		}
+		mediaType = parsed
+	}
+
+	var data []byte
+	var err error
+	if isBase64 {
+		// Line breaks and padding are common in generated URIs, so be lenient about both
+		payload = strings.Join(strings.Fields(payload), "")
+		data, err = base64.StdEncoding.DecodeString(payload)
+		if err != nil {
+			data, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(payload, "="))
+		}
+		if err != nil {
+			return nil, "", fmt.Errorf("base64: %w", err)
+		}
+	} else {
+		decoded, err := url.PathUnescape(payload)
+		if err != nil {
+			return nil, "", err
+		}
+		data = []byte(decoded)
+	}
+	if len(data) == 0 {
+		return nil, "", errors.New("no data")
+	}
+	return data, emojiuploader.DetectContentType(data, mediaType), nil
+}
+
+// describeSource shortens data: URIs for log messages
+func describeSource(source string) string {
+	if isDataURI(source) {
+		if header, _, ok := strings.Cut(source, ","); ok {
+			return header + ",..."
+		}
+	}
+	return source
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestDecodeDataURI(t *testing.T) {
+	gifData := animatedGIF(t, 4, 4, 2)
+	png64 := base64.StdEncoding.EncodeToString(pngData)
+	tests := []struct {
+		name, uri string
+		want      []byte
+		wantType  string
+	}{
+		{"png", "data:image/png;base64," + png64, pngData, "image/png"},
+		{"gif", "data:image/gif;base64," + base64.StdEncoding.EncodeToString(gifData), gifData, "image/gif"},
+		{"uppercase scheme and parameters", "DATA:Image/PNG;name=x.png;BASE64," + png64, pngData, "image/png"},
+		{"line breaks and no padding", "data:image/png;base64," + strings.TrimRight(png64[:20]+"\n"+png64[20:], "="), pngData, "image/png"},
+		// The bytes win over a wrong or missing media type
+		{"wrong media type", "data:image/jpeg;base64," + png64, pngData, "image/png"},
+		{"no media type", "data:;base64," + png64, pngData, "image/png"},
+		{"percent-encoded", "data:image/svg+xml,%3Csvg%20xmlns%3D%22http%3A%2F%2Fwww.w3.org%2F2000%2Fsvg%22%2F%3E", []byte(`<svg xmlns="http://www.w3.org/2000/svg"/>`), "image/svg+xml"},
+	}
+	for _, tt := range tests {
+		data, contentType, err := decodeDataURI(tt.uri)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !bytes.Equal(data, tt.want) || contentType != tt.wantType {
+			t.Errorf("%s: got %d bytes of %q, want %d bytes of %q", tt.name, len(data), contentType, len(tt.want), tt.wantType)
+		}
+	}
+}
+
+func TestDecodeMalformedDataURI(t *testing.T) {
+	for _, uri := range []string{
+		"data:image/png;base64",
+		"data:image/png;base64,not*base64!",
+		"data:image/png;base64,",
+		"data:image/png,",
+		"data:image/;base64,iVBORw0KGgo=",
+		"data:image/png,%zz",
+	} {
+		if _, _, err := decodeDataURI(uri); err == nil {
+			t.Errorf("decodeDataURI(%q): no error", uri)
+		}
+	}
+}
+
+func TestUploadDataURI(t *testing.T) {
+	srv := newFakeServer(t)
+	file := sourceFile(t,
+		"inline", "data:image/png;base64,"+base64.StdEncoding.EncodeToString(pngData),
+		"broken", "data:image/png;base64,!!!",
+	)
+
+	code, _, out := runImport(t, srv, file)
+	if code != exitOK {
+		t.Errorf("exit code %d, output:\n%s", code, out)
+	}
+	uploads := srv.uploaded()
+	if len(uploads) != 1 || uploads[0].Name != "inline" || !bytes.Equal(uploads[0].Data, pngData) || !strings.HasSuffix(uploads[0].Filename, ".png") {
+		t.Errorf("%d uploads, want the inline PNG", len(uploads))
+	}
+	// Malformed URIs are caught while the file is validated
+	if !strings.Contains(out, "[:broken:] invalid data URI: base64") {
+		t.Errorf("output doesn't report the malformed URI:\n%s", out)
+	}
+	// Log lines don't repeat the whole payload
+	if strings.Contains(out, base64.StdEncoding.EncodeToString(pngData)) {
+		t.Errorf("output contains the data URI payload:\n%s", out)
+	}
+	if got := describeSource("data:image/png;base64,AAAA"); got != "data:image/png;base64,..." {
+		t.Errorf("describeSource = %q", got)
+	}
+}
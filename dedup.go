@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"sync"
+)
+
+// imageCache avoids fetching and keeping the same image twice when several
+// emojis share a source or their sources serve identical bytes
+type imageCache struct {
+	mu    sync.Mutex
+	byURL map[string]*cachedImage
+	// bySum maps the SHA-256 of an image to the first emoji that used it
+	bySum map[[sha256.Size]byte]*cachedImage
+}
+
+// cachedImage is a download that is in progress or finished successfully
+type cachedImage struct {
+	// ready is closed once the download has finished
+	ready chan struct{}
+	name  string
+	img   emojiImage
+	err   error
+}
+
+func newImageCache() *imageCache {
+	return &imageCache{
+		byURL: make(map[string]*cachedImage),
+		bySum: make(map[[sha256.Size]byte]*cachedImage),
+	}
+}
+
+// fetch returns the image for source, calling load only if no other emoji of
+// this run has fetched it yet. When the image is shared, from is the name of
+// the emoji it was first fetched for. Failed downloads aren't cached, so
+// every emoji gets its own attempt.
+func (c *imageCache) fetch(ctx context.Context, source, name string, load func() ([]byte, string, error)) (img emojiImage, from string, err error) {
+	c.mu.Lock()
+	entry, ok := c.byURL[source]
+	if !ok {
+		entry = &cachedImage{ready: make(chan struct{}), name: name}
+		c.byURL[source] = entry
+	}
+	c.mu.Unlock()
+
+	if ok {
+		select {
+		case <-entry.ready:
+		case <-ctx.Done():
+			return emojiImage{}, "", ctx.Err()
+		}
+		if entry.err == nil {
+			return entry.img, entry.name, nil
+		}
+		return c.fetch(ctx, source, name, load)
+	}
+
+	data, contentType, err := load()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	defer close(entry.ready)
+	if err != nil {
+		entry.err = err
+		delete(c.byURL, source)
+		return emojiImage{}, "", err
+	}
+
+	// Different sources with the same contents share one copy of the bytes
+	sum := sha256.Sum256(data)
+	if first, ok := c.bySum[sum]; ok {
+		entry.name, entry.img = first.name, first.img
+		return first.img, first.name, nil
+	}
+	entry.img = emojiImage{data: data, contentType: contentType}
+	c.bySum[sum] = entry
+	return entry.img, "", nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestImageCacheSharesURL(t *testing.T) {
+	c := newImageCache()
+	var loads atomic.Int32
+	load := func() ([]byte, string, error) {
+		loads.Add(1)
+		return pngData, "image/png", nil
+	}
+
+	ctx := context.Background()
+	img, from, err := c.fetch(ctx, "https://example.com/a.png", "first", load)
+	if err != nil || from != "" || string(img.data) != string(pngData) {
+		t.Fatalf("first fetch = %q, %v, want a fresh download", from, err)
+	}
+	var wg sync.WaitGroup
+	for _, name := range []string{"second", "third"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			img, from, err := c.fetch(ctx, "https://example.com/a.png", name, load)
+			if err != nil || from != "first" || img.contentType != "image/png" {
+				t.Errorf("%s: fetch = %q, %q, %v, want the image of first", name, from, img.contentType, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := loads.Load(); n != 1 {
+		t.Errorf("%d downloads, want 1", n)
+	}
+}
+
+func TestImageCacheSharesContents(t *testing.T) {
+	c := newImageCache()
+	ctx := context.Background()
+	load := func() ([]byte, string, error) { return append([]byte(nil), pngData...), "image/png", nil }
+
+	a, _, _ := c.fetch(ctx, "https://a.example.com/x.png", "a", load)
+	b, from, err := c.fetch(ctx, "https://b.example.com/y.png", "b", load)
+	if err != nil || from != "a" {
+		t.Fatalf("fetch = %q, %v, want identical bytes from another URL shared with a", from, err)
+	}
+	if &a.data[0] != &b.data[0] {
+		t.Error("identical images are kept twice")
+	}
+	if _, from, _ := c.fetch(ctx, "https://c.example.com/z.png", "c", func() ([]byte, string, error) {
+		return []byte("GIF89a"), "image/gif", nil
+	}); from != "" {
+		t.Errorf("a different image is shared with %q", from)
+	}
+}
+
+func TestImageCacheRetriesFailures(t *testing.T) {
+	c := newImageCache()
+	ctx := context.Background()
+	if _, _, err := c.fetch(ctx, "https://example.com/a.png", "a", func() ([]byte, string, error) {
+		return nil, "", errors.New("connection reset")
+	}); err == nil {
+		t.Fatal("no error from a failed download")
+	}
+	_, from, err := c.fetch(ctx, "https://example.com/a.png", "b", func() ([]byte, string, error) {
+		return pngData, "image/png", nil
+	})
+	if err != nil || from != "" {
+		t.Errorf("fetch after a failure = %q, %v, want a new download", from, err)
+	}
+}
+
+func TestUploadSharedURL(t *testing.T) {
+	srv := newFakeServer(t)
+	shared := srv.img("shared.png", pngData)
+	file := sourceFile(t, "party", shared, "party-variant", shared, "other", srv.img("copy.png", pngData))
+
+	code, report, out := runImport(t, srv, file, "--verbose")
+	if code != exitOK {
+		t.Fatalf("exit code %d, output:\n%s", code, out)
+	}
+	if report.Summary.Uploaded != 3 {
+		t.Errorf("%d uploaded, want all 3\n%s", report.Summary.Uploaded, out)
+	}
+	if n := srv.requestCount("GET /img/shared.png"); n != 1 {
+		t.Errorf("shared image downloaded %d times, want once", n)
+	}
+	// "other" sorts first and downloads copy.png, whose bytes the others then reuse
+	for _, name := range []string{"party", "party-variant"} {
+		if want := "[:" + name + ":] reusing image from :other:"; !strings.Contains(out, want) {
+			t.Errorf("output doesn't contain %q:\n%s", want, out)
+		}
+	}
+}
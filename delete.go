@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/formatCvt/mattermost-emoji-uploader/emojiuploader"
+)
+
+// deleteJob is an emoji to remove; id is empty until it has been looked up by name
+type deleteJob struct {
+	name string
+	id   string
+}
+
+// deletionNames returns the names the emojis in the source file get when
+// imported, so that deleting with the same file and flags undoes an import
+func deletionNames(emojis EmojiMap) []deleteJob {
+	regular, aliases, renamed := planJobs(emojis, nil)
+	if renamed > 0 {
+		logInfo("\n")
+	}
+
+	jobs := make([]deleteJob, 0, len(emojis))
+	for _, job := range append(regular, aliases...) {
+		// The emoji it conflicts with has the same name and deletes it
+		if job.conflictWith != "" {
+			continue
+		}
+		jobs = append(jobs, deleteJob{name: job.safeName})
+	}
+	return jobs
+}
+
+// prefixedEmojis returns the emojis on the server whose name starts with prefix
+func prefixedEmojis(ctx context.Context, api *emojiuploader.Client, prefix string) ([]deleteJob, error) {
+	emojis, err := listEmojis(ctx, api)
+	if err != nil {
+		return nil, err
+	}
+
+	var jobs []deleteJob
+	for _, emoji := range emojis {
+		if strings.HasPrefix(emoji.Name, prefix) {
+			jobs = append(jobs, deleteJob{name: emoji.Name, id: emoji.ID})
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].name < jobs[j].name })
+	return jobs, nil
+}
+
+// deleteEmojis removes the emojis one by one, printing the outcome of each.
+// It stops starting new deletions once ctx is cancelled.
+func deleteEmojis(ctx context.Context, api *emojiuploader.Client, limiter *uploadLimiter, jobs []deleteJob) (deleted, missing, failed int) {
+	for _, job := range jobs {
+		limiter.wait(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		prefix := "Deleting: [:" + job.name + ":]... "
+		err := withRetry(ctx, retries+1, func() error {
+			if job.id == "" {
+				emoji, err := api.EmojiByName(ctx, job.name)
+				if err != nil {
+					return err
+				}
+				job.id = emoji.ID
+			}
+			return api.Delete(ctx, job.id)
+		})
+		switch {
+		case err == nil:
+			logInfo("%s🗑️  Deleted\n", prefix)
+			deleted++
+		case emojiuploader.HasStatus(err, http.StatusNotFound):
+			logInfo("%s⏭️  Not found on the server\n", prefix)
+			missing++
+		default:
+			logError("%s❌ Delete error: %v\n", prefix, err)
+			failed++
+		}
+	}
+	return
+}
+
+// runDelete removes the emojis of the source file, or those matching
+// --delete-by-prefix, prints a summary and returns the exit code
+func runDelete(ctx context.Context, api *emojiuploader.Client, limiter *uploadLimiter, emojis EmojiMap) int {
+	var jobs []deleteJob
+	if deletePrefix != "" {
+		var err error
+		jobs, err = prefixedEmojis(ctx, api, deletePrefix)
+		if err != nil {
+			logError("❌ Error listing existing emojis: %v\n", err)
+			return exitSetup
+		}
+		logInfo("📋 Found %d emojis starting with %q on the server\n", len(jobs), deletePrefix)
+	} else {
+		jobs = deletionNames(emojis)
+	}
+
+	logInfo("🗑️  Deleting %d emojis...\n\n", len(jobs))
+	deleted, missing, failed := deleteEmojis(ctx, api, limiter, jobs)
+
+	interrupted := ctx.Err() != nil
+	if interrupted {
+		logSummary("\n🛑 Stopped early: %d of %d emojis were not processed\n", len(jobs)-deleted-missing-failed, len(jobs))
+	}
+	logSummary("\n🏁 Done: %d deleted, %d not found, %d failed\n", deleted, missing, failed)
+
+	if interrupted {
+		return exitInterrupted
+	}
+	if failed > 0 {
+		return exitFailures
+	}
+	return exitOK
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// deletedNames returns the names of the emojis deleted from srv, sorted
+func (s *fakeServer) deletedNames() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := append([]string(nil), s.deleted...)
+	sort.Strings(names)
+	return names
+}
+
+func TestDeleteFromFile(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.addEmoji("party", pngData)
+	srv.addEmoji("party-parrot", pngData)
+	srv.addEmoji("keep", pngData)
+	// Deleting finds the emojis by the names an import would have given them
+	file := sourceFile(t, "Party", "https://example.com/1.png", "Party Parrot", "https://example.com/2.png", "gone", "https://example.com/3.png")
+
+	code, out := runCLI(t, "-s", srv.URL, "-t", "tok", "-f", file, "--delete", "--delay", "0")
+	if code != exitOK {
+		t.Fatalf("exit code %d, output:\n%s", code, out)
+	}
+	if got, want := srv.deletedNames(), []string{"party", "party-parrot"}; !slices.Equal(got, want) {
+		t.Errorf("deleted %q, want %q", got, want)
+	}
+	if !strings.Contains(out, "1 not found") {
+		t.Errorf("the missing emoji isn't counted:\n%s", out)
+	}
+	if n := srv.requestCount("GET /img/"); n != 0 {
+		t.Errorf("%d image downloads while deleting", n)
+	}
+	if len(srv.uploaded()) != 0 {
+		t.Error("--delete uploaded emojis")
+	}
+}
+
+func TestDeleteByPrefix(t *testing.T) {
+	srv := newFakeServer(t)
+	for _, name := range []string{"old_a", "old_b", "older", "new_old_c"} {
+		srv.addEmoji(name, pngData)
+	}
+
+	code, out := runCLI(t, "-s", srv.URL, "-t", "tok", "--delete-by-prefix", "old_", "--delay", "0")
+	if code != exitOK {
+		t.Fatalf("exit code %d, output:\n%s", code, out)
+	}
+	if got, want := srv.deletedNames(), []string{"old_a", "old_b"}; !slices.Equal(got, want) {
+		t.Errorf("deleted %q, want %q", got, want)
+	}
+}
+
+func TestDeleteFailure(t *testing.T) {
+	srv := newFakeServer(t)
+	e := srv.addEmoji("party", pngData)
+	srv.handle("DELETE /api/v4/emoji/"+e.ID, func(w http.ResponseWriter, r *http.Request) {
+		writeAppError(w, http.StatusForbidden, "api.context.permissions.app_error", "You do not have the appropriate permissions.")
+	})
+	file := sourceFile(t, "party", "https://example.com/1.png")
+
+	_, out := runCLI(t, "-s", srv.URL, "-t", "tok", "-f", file, "--delete", "--delay", "0")
+	if !strings.Contains(out, "appropriate permissions") || !strings.Contains(out, "1 failed") {
+		t.Errorf("the server's error isn't shown:\n%s", out)
+	}
+}
+
+func TestModeFlagConflicts(t *testing.T) {
+	srv := newFakeServer(t)
+	file := sourceFile(t, "party", "https://example.com/1.png")
+	for _, args := range [][]string{
+		{"--delete", "--delete-by-prefix", "old_"},
+		{"--delete", "--dry-run"},
+		{"--delete", "--export", t.TempDir()},
+	} {
+		args = append([]string{"-s", srv.URL, "-t", "tok", "-f", file}, args...)
+		if code, _ := runCLI(t, args...); code != exitSetup {
+			t.Errorf("%q: exit code %d, want %d", args, code, exitSetup)
+		}
+	}
+	if n := srv.requestCount(""); n != 0 {
+		t.Errorf("%d requests, want none", n)
+	}
+}
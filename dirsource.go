@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// imageExtensions are the file extensions picked up by --dir
+var imageExtensions = map[string]bool{
+	".png":  true,
+	".jpg":  true,
+	".jpeg": true,
+	".gif":  true,
+	".webp": true,
+}
+
+// loadDirectory builds an emoji map from the image files in dir, named after
+// the file without its extension. With recursive, subdirectories are included
+// as well, and with dirPrefixes their path is prepended to the names, e.g.
+// cats/happy.png becomes cats-happy. The values are absolute paths. Files
+// whose names collide are reported as issues, keeping the first in sorted order.
+func loadDirectory(dir string, recursive, dirPrefixes bool) (EmojiMap, []string, error) {
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	if info, err := os.Stat(root); err != nil {
+		return nil, nil, err
+	} else if !info.IsDir() {
+		return nil, nil, fmt.Errorf("%s is not a directory", dir)
+	}
+
+	var paths []string
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			// Skip hidden folders such as .git, and everything below dir unless recursive
+			if path != root && (!recursive || strings.HasPrefix(d.Name(), ".")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Type().IsRegular() && imageExtensions[strings.ToLower(filepath.Ext(path))] {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(paths) == 0 {
+		return nil, nil, fmt.Errorf("no image files found in %s", dir)
+	}
+	sort.Strings(paths)
+
+	emojis := make(EmojiMap, len(paths))
+	var issues []string
+	sources := make(map[string]string, len(paths))
+	for _, path := range paths {
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return nil, nil, err
+		}
+		name := strings.TrimSuffix(filepath.Base(rel), filepath.Ext(rel))
+		if sub := filepath.Dir(rel); dirPrefixes && sub != "." {
+			name = strings.ReplaceAll(filepath.ToSlash(sub), "/", "-") + "-" + name
+		}
+
+		if first, ok := sources[name]; ok {
+			issues = append(issues, fmt.Sprintf("[:%s:] %s ignored, the name is already used by %s", name, rel, first))
+			continue
+		}
+		sources[name] = rel
+		emojis[name] = path
+	}
+	return emojis, issues, nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+// mixedDir creates a directory of images, other files and subdirectories
+func mixedDir(t *testing.T) string {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"party.png", "party.jpg", "Wave.GIF", "blob.webp",
+		"notes.txt", "README", ".hidden/secret.png",
+		"cats/happy.png", "cats/deep/sad.jpeg",
+	} {
+		writeFile(t, dir, name, pngData)
+	}
+	return dir
+}
+
+func TestLoadDirectory(t *testing.T) {
+	dir := mixedDir(t)
+	tests := []struct {
+		name                   string
+		recursive, dirPrefixes bool
+		want                   map[string]string
+	}{
+		{"top level", false, false, map[string]string{
+			"party": "party.jpg", "Wave": "Wave.GIF", "blob": "blob.webp",
+		}},
+		{"recursive", true, false, map[string]string{
+			"party": "party.jpg", "Wave": "Wave.GIF", "blob": "blob.webp",
+			"happy": "cats/happy.png", "sad": "cats/deep/sad.jpeg",
+		}},
+		{"recursive with prefixes", true, true, map[string]string{
+			"party": "party.jpg", "Wave": "Wave.GIF", "blob": "blob.webp",
+			"cats-happy": "cats/happy.png", "cats-deep-sad": "cats/deep/sad.jpeg",
+		}},
+	}
+	for _, tt := range tests {
+		emojis, issues, err := loadDirectory(dir, tt.recursive, tt.dirPrefixes)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		want := make(EmojiMap, len(tt.want))
+		for name, rel := range tt.want {
+			want[name] = filepath.Join(dir, rel)
+		}
+		if !reflect.DeepEqual(emojis, want) {
+			t.Errorf("%s: emojis = %v, want %v", tt.name, emojis, want)
+		}
+		// party.jpg sorts before party.png and keeps the name
+		if want := []string{"[:party:] party.png ignored, the name is already used by party.jpg"}; !slices.Equal(issues, want) {
+			t.Errorf("%s: issues = %q, want %q", tt.name, issues, want)
+		}
+	}
+}
+
+func TestLoadDirectoryErrors(t *testing.T) {
+	empty := t.TempDir()
+	writeFile(t, empty, "notes.txt", []byte("no images here"))
+	file := writeFile(t, t.TempDir(), "party.png", pngData)
+
+	for _, dir := range []string{empty, file, filepath.Join(empty, "missing")} {
+		if _, _, err := loadDirectory(dir, true, false); err == nil {
+			t.Errorf("loadDirectory(%s): no error", dir)
+		}
+	}
+}
+
+func TestUploadDirectory(t *testing.T) {
+	srv := newFakeServer(t)
+	dir := mixedDir(t)
+
+	code, out := runCLI(t, "-s", srv.URL, "-t", "tok", "--dir", dir, "--recursive", "--dir-prefixes", "--delay", "0")
+	if code != exitOK {
+		t.Fatalf("exit code %d, output:\n%s", code, out)
+	}
+	var names []string
+	for _, u := range srv.uploaded() {
+		names = append(names, u.Name)
+	}
+	slices.Sort(names)
+	if want := []string{"blob", "cats-deep-sad", "cats-happy", "party", "wave"}; !slices.Equal(names, want) {
+		t.Errorf("uploaded %q, want %q", names, want)
+	}
+}
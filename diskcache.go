@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/formatCvt/mattermost-emoji-uploader/emojiuploader"
+)
+
+// diskCache keeps downloaded images in a directory across runs (--cache-dir).
+// Every URL is stored as two files named after its SHA-256: the image itself
+// and a small JSON file with the response headers needed to revalidate it.
+type diskCache struct {
+	dir string
+}
+
+// diskCacheEntry is the metadata stored next to a cached image
+type diskCacheEntry struct {
+	URL          string `json:"url"`
+	ContentType  string `json:"content_type"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	// Expires is when the server wants the image revalidated; zero means it
+	// gave no expiry and the image is reused as is
+	Expires time.Time `json:"expires,omitempty"`
+	// NoCache is set for Cache-Control: no-cache, which requires revalidating every time
+	NoCache bool `json:"no_cache,omitempty"`
+}
+
+// openDiskCache creates dir if needed and, when maxAge is positive, removes
+// the entries that haven't been fetched or revalidated for longer than maxAge
+func openDiskCache(dir string, maxAge time.Duration) (*diskCache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	c := &diskCache{dir: dir}
+	if maxAge > 0 {
+		if err := c.prune(maxAge); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// prune deletes the entries whose metadata is older than maxAge
+func (c *diskCache) prune(maxAge time.Duration) error {
+	files, err := os.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+	removed := 0
+	for _, f := range files {
+		key, ok := strings.CutSuffix(f.Name(), ".json")
+		if !ok {
+			continue
+		}
+		info, err := f.Info()
+		if err != nil || time.Since(info.ModTime()) <= maxAge {
+			continue
+		}
+		os.Remove(c.dataPath(key))
+		os.Remove(c.metaPath(key))
+		removed++
+	}
+	if removed > 0 {
+		logDebug("🔎 Removed %d cached images older than %s\n", removed, maxAge)
+	}
+	return nil
+}
+
+func (c *diskCache) dataPath(key string) string {
+	return filepath.Join(c.dir, key+".img")
+}
+
+func (c *diskCache) metaPath(key string) string {
+	return filepath.Join(c.dir, key+".json")
+}
+
+// cacheKey names the cache files of a URL
+func cacheKey(source string) string {
+	sum := sha256.Sum256([]byte(source))
+	return hex.EncodeToString(sum[:])
+}
+
+// download returns the image at source from the cache when it is still
+// fresh, revalidates it with If-None-Match/If-Modified-Since when it has
+// expired, and downloads and stores it otherwise
+func (c *diskCache) download(ctx context.Context, client *http.Client, source string, header http.Header) ([]byte, string, error) {
+	key := cacheKey(source)
+	entry, data, err := c.load(key)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		logDebug("🔎 Ignoring the cached copy of %s: %v\n", source, err)
+	}
+	cached := err == nil
+	if cached && !entry.NoCache && (entry.Expires.IsZero() || time.Now().Before(entry.Expires)) {
+		logDebug("🔎 %s read from the cache\n", source)
+		return data, entry.ContentType, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", source, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	for key, values := range header {
+		req.Header[key] = append(req.Header[key], values...)
+	}
+	if cached {
+		if entry.ETag != "" {
+			req.Header.Set("If-None-Match", entry.ETag)
+		}
+		if entry.LastModified != "" {
+			req.Header.Set("If-Modified-Since", entry.LastModified)
+		}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if cached && resp.StatusCode == http.StatusNotModified {
+		logDebug("🔎 %s revalidated, using the cached copy\n", source)
+		updated := newDiskCacheEntry(source, entry.ContentType, resp.Header)
+		updated.ETag = firstNonEmpty(updated.ETag, entry.ETag)
+		updated.LastModified = firstNonEmpty(updated.LastModified, entry.LastModified)
+		c.store(key, updated, nil)
+		return data, entry.ContentType, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", emojiuploader.NewStatusError(resp, "")
+	}
+
+	data, err = emojiuploader.ReadBodyResume(client, resp)
+	if err != nil {
+		return nil, "", err
+	}
+	contentType := emojiuploader.DetectContentType(data, resp.Header.Get("Content-Type"))
+	if !strings.Contains(resp.Header.Get("Cache-Control"), "no-store") {
+		c.store(key, newDiskCacheEntry(source, contentType, resp.Header), data)
+	}
+	return data, contentType, nil
+}
+
+// firstNonEmpty returns a unless it is empty, otherwise b
+func firstNonEmpty(a, b string) string {
+	if a != "" {
+		return a
+	}
+	return b
+}
+
+// newDiskCacheEntry extracts the validators and expiry from response headers
+func newDiskCacheEntry(source, contentType string, h http.Header) diskCacheEntry {
+	entry := diskCacheEntry{
+		URL:          source,
+		ContentType:  contentType,
+		ETag:         h.Get("ETag"),
+		LastModified: h.Get("Last-Modified"),
+	}
+
+	// Cache-Control takes precedence over Expires
+	for _, directive := range strings.Split(h.Get("Cache-Control"), ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(strings.ToLower(directive)), "=")
+		switch name {
+		case "no-cache":
+			entry.NoCache = true
+		case "max-age":
+			if seconds, err := strconv.Atoi(strings.Trim(value, `"`)); err == nil {
+				age, _ := strconv.Atoi(h.Get("Age"))
+				entry.Expires = time.Now().Add(time.Duration(seconds-age) * time.Second)
+				return entry
+			}
+		}
+	}
+	if expires := h.Get("Expires"); expires != "" {
+		if t, err := http.ParseTime(expires); err == nil {
+			entry.Expires = t
+		} else {
+			// Invalid dates such as "0" mean already expired
+			entry.Expires = time.Unix(1, 0)
+		}
+	}
+	return entry
+}
+
+// load reads the cached image and metadata for key
+func (c *diskCache) load(key string) (diskCacheEntry, []byte, error) {
+	var entry diskCacheEntry
+	meta, err := os.ReadFile(c.metaPath(key))
+	if err != nil {
+		return entry, nil, err
+	}
+	if err := json.Unmarshal(meta, &entry); err != nil {
+		return entry, nil, err
+	}
+	data, err := os.ReadFile(c.dataPath(key))
+	if err != nil {
+		return entry, nil, err
+	}
+	return entry, data, nil
+}
+
+// store writes the metadata and, unless data is nil, the image for key. The
+// files are replaced atomically so an interrupted run can't leave half an
+// image behind. Failures only cost a download on the next run, so they are
+// logged and otherwise ignored.
+func (c *diskCache) store(key string, entry diskCacheEntry, data []byte) {
+	if data != nil {
+		if err := writeFileAtomic(c.dataPath(key), data); err != nil {
+			logError("⚠️  Could not cache %s: %v\n", entry.URL, err)
+			return
+		}
+	}
+	meta, err := json.Marshal(entry)
+	if err == nil {
+		err = writeFileAtomic(c.metaPath(key), meta)
+	}
+	if err != nil {
+		logError("⚠️  Could not cache %s: %v\n", entry.URL, err)
+	}
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames it into place
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// isHTTPSource reports whether an emoji source is downloaded over http(s)
+// and can therefore be cached
+func isHTTPSource(source string) bool {
+	u, err := url.Parse(source)
+	if err != nil {
+		return false
+	}
+	scheme := strings.ToLower(u.Scheme)
+	return scheme == "http" || scheme == "https"
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// cachingServer serves pngData with the given response headers, answering
+// 304 to requests whose If-None-Match matches the ETag. It counts the full
+// and the not modified responses.
+func cachingServer(t *testing.T, header http.Header) (*httptest.Server, *atomic.Int32, *atomic.Int32) {
+	t.Helper()
+	full, notModified := new(atomic.Int32), new(atomic.Int32)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for k, v := range header {
+			w.Header()[k] = v
+		}
+		if etag := header.Get("ETag"); etag != "" && r.Header.Get("If-None-Match") == etag {
+			notModified.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		full.Add(1)
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(pngData)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, full, notModified
+}
+
+func TestDiskCache(t *testing.T) {
+	tests := []struct {
+		name                 string
+		header               http.Header
+		wantFull, wantNotMod int32
+	}{
+		// Without an expiry the cached copy is used as is
+		{"no expiry", nil, 1, 0},
+		{"fresh", http.Header{"Cache-Control": {"max-age=3600"}}, 1, 0},
+		{"expired with ETag", http.Header{"Cache-Control": {"max-age=0"}, "Etag": {`"v1"`}}, 1, 1},
+		{"no-cache with ETag", http.Header{"Cache-Control": {"no-cache"}, "Etag": {`"v1"`}}, 1, 1},
+		{"expired without validator", http.Header{"Expires": {"0"}}, 2, 0},
+		{"no-store", http.Header{"Cache-Control": {"no-store"}}, 2, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, full, notModified := cachingServer(t, tt.header)
+			c, err := openDiskCache(filepath.Join(t.TempDir(), "cache"), 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for run := 0; run < 2; run++ {
+				data, contentType, err := c.download(context.Background(), srv.Client(), srv.URL+"/party.png", nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(data) != string(pngData) || contentType != "image/png" {
+					t.Errorf("run %d: got %d bytes of %q", run, len(data), contentType)
+				}
+			}
+			if full.Load() != tt.wantFull || notModified.Load() != tt.wantNotMod {
+				t.Errorf("%d downloads and %d revalidations, want %d and %d", full.Load(), notModified.Load(), tt.wantFull, tt.wantNotMod)
+			}
+		})
+	}
+}
+
+func TestDiskCachePrune(t *testing.T) {
+	dir := t.TempDir()
+	c, _ := openDiskCache(dir, 0)
+	c.store(cacheKey("https://example.com/old.png"), diskCacheEntry{URL: "https://example.com/old.png"}, pngData)
+	c.store(cacheKey("https://example.com/new.png"), diskCacheEntry{URL: "https://example.com/new.png"}, pngData)
+	old := time.Now().Add(-48 * time.Hour)
+	if err := os.Chtimes(c.metaPath(cacheKey("https://example.com/old.png")), old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := openDiskCache(dir, 24*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.load(cacheKey("https://example.com/old.png")); !os.IsNotExist(err) {
+		t.Errorf("old entry: err = %v, want it removed", err)
+	}
+	if _, _, err := c.load(cacheKey("https://example.com/new.png")); err != nil {
+		t.Errorf("new entry: %v, want it kept", err)
+	}
+}
+
+func TestCacheDirFlag(t *testing.T) {
+	images, full, _ := cachingServer(t, nil)
+	file := sourceFile(t, "party", images.URL+"/party.png", "wave", images.URL+"/wave.png")
+	cacheDir := filepath.Join(t.TempDir(), "cache")
+
+	// Each run goes to an empty server, so both emojis are uploaded every time
+	for run := 0; run < 2; run++ {
+		srv := newFakeServer(t)
+		code, _, out := runImport(t, srv, file, "--cache-dir", cacheDir)
+		if code != exitOK {
+			t.Fatalf("run %d: exit code %d, output:\n%s", run, code, out)
+		}
+		if len(srv.uploaded()) != 2 {
+			t.Errorf("run %d: %d uploads, want 2", run, len(srv.uploaded()))
+		}
+	}
+	if n := full.Load(); n != 2 {
+		t.Errorf("%d image downloads, want 2 in the first run and none in the second", n)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// dryRun prints what would happen to every emoji without touching the network
+// and returns the number of sanitized-name collisions found. Invalid entries are
+// expected to be filtered out by validateEmojis beforehand.
+func dryRun(emojis EmojiMap) int {
+	regular, aliases, renamed := planJobs(emojis, nil)
+	finalNames := make(map[string]string, len(emojis))
+	for _, job := range append(regular, aliases...) {
+		finalNames[job.originalName] = job.safeName
+	}
+	if renamed > 0 {
+		logInfo("\n")
+	}
+
+	problems := 0
+	sources := make(map[string][]string)
+
+	// Report in the order of a real run
+	for _, job := range append(regular, aliases...) {
+		originalName, url, safeName := job.originalName, job.url, job.safeName
+		prefix := fmt.Sprintf("Checking: [:%s:] -> [:%s:]... ", originalName, safeName)
+
+		if job.conflictWith != "" {
+			logInfo("%s⏭️  Would skip (%s)\n", prefix, conflictReason(job))
+		} else if target, ok := strings.CutPrefix(url, "alias:"); ok {
+			targetName, ok := finalNames[target]
+			if !ok {
+				targetName = emojiName(target)
+			}
+			logInfo("%s🔗 Would copy the image of :%s: (alias)\n", prefix, targetName)
+		} else {
+			logInfo("%s📦 Would upload\n", prefix)
+		}
+
+		base := emojiName(originalName)
+		sources[base] = append(sources[base], originalName)
+	}
+
+	collisions := make([]string, 0)
+	for safeName, originals := range sources {
+		if len(originals) > 1 {
+			collisions = append(collisions, safeName)
+		}
+	}
+	sort.Strings(collisions)
+
+	if len(collisions) > 0 {
+		logError("\n")
+	}
+	for _, safeName := range collisions {
+		logError("❌ Collision: [:%s:] is produced by %s (%s)\n", safeName, strings.Join(sources[safeName], ", "), collisionOutcome())
+		problems++
+	}
+
+	return problems
+}
+
+// collisionOutcome describes what an import does with a name collision under --on-conflict
+func collisionOutcome() string {
+	switch onConflict {
+	case conflictSkip:
+		return "only the first one will be uploaded"
+	case conflictOverwrite:
+		return "only the last one will be uploaded"
+	case conflictError:
+		return "the import will stop"
+	}
+	return "numeric suffixes will be added"
+}
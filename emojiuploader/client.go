@@ -0,0 +1,429 @@
+// Package emojiuploader uploads custom emojis to a Mattermost server.
+//
+// A Client wraps the server URL, the access token and the HTTP client used
+// for all API calls:
+//
+//	c := emojiuploader.NewClient("https://mattermost.example.com", token, nil)
+//	c.CreatorID, err = c.UserID(ctx)
+//	data, contentType, err := emojiuploader.Download(ctx, http.DefaultClient, url)
+//	emoji, err := c.Upload(ctx, c.SanitizeName("жду"), data, contentType)
+//
+// Embedders with their own naming policy can replace how names are sanitized
+// and which names are accepted via NameSanitizer and NameValidator.
+package emojiuploader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/time/rate"
+)
+
+// MaxPageSize is the maximum page size accepted by GET /api/v4/emoji
+const MaxPageSize = 200
+
+// Emoji is a custom emoji as returned by the Mattermost API
+type Emoji struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	CreatorID string `json:"creator_id"`
+	CreateAt  int64  `json:"create_at"`
+}
+
+// Client talks to the Mattermost REST API
+type Client struct {
+	// ServerURL is the server address, including the base path if Mattermost
+	// is served below one, e.g. https://intra.example.com/mattermost
+	ServerURL string
+	// Token is a personal access token or session token, see Login
+	Token string
+	// CreatorID is sent as the creator of uploaded emojis, normally the ID of
+	// the authenticated user (see UserID). Mattermost refuses other users with
+	// ErrorIDOtherUser unless the server allows it.
+	CreatorID  string
+	HTTPClient *http.Client
+	// OnRateLimit, if set, is called with the rate limit reported by every API
+	// response that carries one. It may be called from several goroutines.
+	OnRateLimit func(RateLimit)
+	// Limiter, if set, paces all API requests, shared by every goroutine
+	// using the client. Image downloads from other hosts are not affected.
+	Limiter *rate.Limiter
+	// NameSanitizer, if set, replaces SanitizeName in Client.SanitizeName,
+	// e.g. to keep a team prefix or map names through a lookup table
+	NameSanitizer func(string) string
+	// NameValidator, if set, replaces ValidateName in Client.ValidateName.
+	// Upload checks every name with it before sending anything, so it can
+	// enforce rules stricter than the server's, such as a required prefix.
+	NameValidator func(string) error
+	// NoRequestedWith turns off the X-Requested-With: XMLHttpRequest header
+	// that is otherwise sent with every API request that changes something
+	NoRequestedWith bool
+}
+
+// emojiMetadata is the "emoji" form field sent when creating an emoji
+type emojiMetadata struct {
+	Name      string `json:"name"`
+	CreatorID string `json:"creator_id"`
+}
+
+// NewClient returns a client for the given server. A nil httpClient means
+// http.DefaultClient.
+func NewClient(serverURL, token string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Client{
+		ServerURL:  serverURL,
+		Token:      token,
+		HTTPClient: httpClient,
+	}
+}
+
+// endpoint resolves an API path such as /api/v4/emoji?page=0 against
+// ServerURL. The path is appended to the base path of ServerURL rather than
+// replacing it, and a trailing slash on ServerURL doesn't double the separator.
+func (c *Client) endpoint(path string) (string, error) {
+	base, err := url.Parse(c.ServerURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid server URL: %w", err)
+	}
+	ref, err := url.Parse(path)
+	if err != nil {
+		return "", err
+	}
+	// JoinPath takes escaped segments, so escaped names such as a%2Fb stay one segment
+	u := base.JoinPath(ref.EscapedPath())
+	u.RawQuery = ref.RawQuery
+	return u.String(), nil
+}
+
+// newRequest builds an authenticated request for an API path such as /api/v4/emoji
+func (c *Client) newRequest(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
+	endpoint, err := c.endpoint(path)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	// Hardened deployments refuse writes without it as a CSRF protection,
+	// even when the request carries a valid token
+	if method != http.MethodGet && !c.NoRequestedWith {
+		req.Header.Set("X-Requested-With", "XMLHttpRequest")
+	}
+	return req, nil
+}
+
+// UserID retrieves the ID of the user the token belongs to
+func (c *Client) UserID(ctx context.Context) (string, error) {
+	user, err := c.Me(ctx)
+	if err != nil {
+		return "", err
+	}
+	return user.ID, nil
+}
+
+// Ping checks via GET /api/v4/system/ping that ServerURL points at a
+// Mattermost server that reports itself healthy. It needs no valid token, so
+// a failure means a wrong URL or base path, or a server that is down, rather
+// than a credentials problem.
+func (c *Client) Ping(ctx context.Context) error {
+	req, err := c.newRequest(ctx, "GET", "/api/v4/system/ping", nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return NewStatusError(resp, string(respBody))
+	}
+
+	var status struct {
+		Status string `json:"status"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return fmt.Errorf("not a Mattermost ping response: %w", err)
+	}
+	if status.Status != "OK" {
+		return fmt.Errorf("server reports status %q", status.Status)
+	}
+	return nil
+}
+
+// Login logs in via POST /api/v4/users/login and stores the session token
+// from the Token response header in c.Token
+func (c *Client) Login(ctx context.Context, loginID, password string) error {
+	credentials, err := json.Marshal(struct {
+		LoginID  string `json:"login_id"`
+		Password string `json:"password"`
+	}{loginID, password})
+	if err != nil {
+		return err
+	}
+
+	req, err := c.newRequest(ctx, "POST", "/api/v4/users/login", bytes.NewReader(credentials))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return NewStatusError(resp, string(respBody))
+	}
+
+	sessionToken := resp.Header.Get("Token")
+	if sessionToken == "" {
+		return fmt.Errorf("server did not return a session token")
+	}
+	c.Token = sessionToken
+	return nil
+}
+
+// EmojiPage fetches a single page of custom emojis; perPage is at most MaxPageSize
+func (c *Client) EmojiPage(ctx context.Context, page, perPage int) ([]Emoji, error) {
+	req, err := c.newRequest(ctx, "GET", fmt.Sprintf("/api/v4/emoji?page=%d&per_page=%d", page, perPage), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, NewStatusError(resp, string(respBody))
+	}
+
+	var emojis []Emoji
+	if err := json.NewDecoder(resp.Body).Decode(&emojis); err != nil {
+		return nil, err
+	}
+	return emojis, nil
+}
+
+// EmojiByName looks up a custom emoji via GET /api/v4/emoji/name/{name}
+func (c *Client) EmojiByName(ctx context.Context, name string) (*Emoji, error) {
+	req, err := c.newRequest(ctx, "GET", "/api/v4/emoji/name/"+url.PathEscape(name), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, NewStatusError(resp, string(respBody))
+	}
+
+	var emoji Emoji
+	if err := json.NewDecoder(resp.Body).Decode(&emoji); err != nil {
+		return nil, err
+	}
+	return &emoji, nil
+}
+
+// EmojiImage downloads the image of a custom emoji via GET /api/v4/emoji/{id}/image
+// and returns it together with its media type
+func (c *Client) EmojiImage(ctx context.Context, id string) ([]byte, string, error) {
+	req, err := c.newRequest(ctx, "GET", "/api/v4/emoji/"+url.PathEscape(id)+"/image", nil)
+	if err != nil {
+		return nil, "", err
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, "", NewStatusError(resp, string(respBody))
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, DetectContentType(data, resp.Header.Get("Content-Type")), nil
+}
+
+// Upload creates a custom emoji with the given name and image via a
+// multipart/form-data POST to /api/v4/emoji. The name must already be valid,
+// see SanitizeName; names refused by ValidateName fail with ErrInvalidName
+// without a request. It returns the emoji as created by the server, including
+// the ID it was assigned.
+func (c *Client) Upload(ctx context.Context, name string, data []byte, contentType string) (*Emoji, error) {
+	if err := c.ValidateName(name); err != nil {
+		return nil, fmt.Errorf("%w %q: %w", ErrInvalidName, name, err)
+	}
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+
+	// 'emoji' field containing JSON metadata with creator_id
+	emojiMeta, err := json.Marshal(emojiMetadata{Name: name, CreatorID: c.CreatorID})
+	if err != nil {
+		return nil, err
+	}
+	if err := writer.WriteField("emoji", string(emojiMeta)); err != nil {
+		return nil, err
+	}
+
+	// 'image' field containing binary data, with a file name matching its type
+	ext, _ := Extension(contentType)
+	part, err := writer.CreateFormFile("image", name+ext)
+	if err != nil {
+		return nil, err
+	}
+	_, err = part.Write(data)
+	if err != nil {
+		return nil, err
+	}
+
+	writer.Close()
+
+	req, err := c.newRequest(ctx, "POST", "/api/v4/emoji", body)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// Mattermost may return either 200 (OK) or 201 (Created) for successful emoji creation
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, NewStatusError(resp, string(respBody))
+	}
+
+	// The emoji exists at this point, so a body that can't be parsed (e.g. from
+	// a proxy rewriting the response) only costs the ID rather than failing
+	// the upload and inviting a retry that would hit a duplicate name
+	emoji := Emoji{Name: name}
+	if err := json.NewDecoder(resp.Body).Decode(&emoji); err != nil {
+		emoji = Emoji{Name: name}
+	}
+	return &emoji, nil
+}
+
+// Delete removes a custom emoji via DELETE /api/v4/emoji/{id}
+func (c *Client) Delete(ctx context.Context, id string) error {
+	req, err := c.newRequest(ctx, "DELETE", "/api/v4/emoji/"+url.PathEscape(id), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return NewStatusError(resp, string(respBody))
+	}
+	return nil
+}
+
+// Team is a Mattermost team as returned by the API
+type Team struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+}
+
+// TeamByName looks up a team via GET /api/v4/teams/name/{name}
+func (c *Client) TeamByName(ctx context.Context, name string) (*Team, error) {
+	req, err := c.newRequest(ctx, "GET", "/api/v4/teams/name/"+url.PathEscape(name), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, NewStatusError(resp, string(respBody))
+	}
+
+	var team Team
+	if err := json.NewDecoder(resp.Body).Decode(&team); err != nil {
+		return nil, err
+	}
+	return &team, nil
+}
+
+// IsTeamMember reports whether a user belongs to a team, using
+// GET /api/v4/teams/{team_id}/members/{user_id}
+func (c *Client) IsTeamMember(ctx context.Context, teamID, userID string) (bool, error) {
+	req, err := c.newRequest(ctx, "GET", "/api/v4/teams/"+url.PathEscape(teamID)+"/members/"+url.PathEscape(userID), nil)
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		// Former members are still returned, with delete_at set
+		var member struct {
+			DeleteAt int64 `json:"delete_at"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&member); err != nil {
+			return false, err
+		}
+		return member.DeleteAt == 0, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		respBody, _ := io.ReadAll(resp.Body)
+		return false, NewStatusError(resp, string(respBody))
+	}
+}
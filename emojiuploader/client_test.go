@@ -0,0 +1,381 @@
+package emojiuploader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestEndpoint(t *testing.T) {
+	tests := []struct {
+		server, path, want string
+	}{
+		{"https://chat.example.com", "/api/v4/emoji", "https://chat.example.com/api/v4/emoji"},
+		{"https://chat.example.com/", "/api/v4/emoji", "https://chat.example.com/api/v4/emoji"},
+		{"https://intra.example.com/mattermost", "/api/v4/emoji", "https://intra.example.com/mattermost/api/v4/emoji"},
+		{"https://intra.example.com/mattermost/", "/api/v4/system/ping", "https://intra.example.com/mattermost/api/v4/system/ping"},
+		{"https://intra.example.com/chat/mm", "/api/v4/emoji?page=2&per_page=200", "https://intra.example.com/chat/mm/api/v4/emoji?page=2&per_page=200"},
+		// An escaped name stays a single segment
+		{"https://intra.example.com/mattermost", "/api/v4/emoji/name/a%2Fb", "https://intra.example.com/mattermost/api/v4/emoji/name/a%2Fb"},
+	}
+	for _, tt := range tests {
+		got, err := NewClient(tt.server, "tok", nil).endpoint(tt.path)
+		if err != nil || got != tt.want {
+			t.Errorf("endpoint(%s, %s) = %q, %v, want %q", tt.server, tt.path, got, err, tt.want)
+		}
+	}
+	if _, err := NewClient("http://bad host", "tok", nil).endpoint("/api/v4/emoji"); err == nil {
+		t.Error("no error for an invalid server URL")
+	}
+}
+
+func TestPing(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		wantErr string
+	}{
+		{"healthy", http.StatusOK, `{"status":"OK","ActiveSearchBackend":"database"}`, ""},
+		{"unhealthy", http.StatusOK, `{"status":"UNHEALTHY"}`, `status "UNHEALTHY"`},
+		{"down", http.StatusInternalServerError, `{"status":"UNHEALTHY"}`, "500"},
+		{"not mattermost", http.StatusOK, `<html>Welcome to nginx!</html>`, "not a Mattermost ping response"},
+		{"not found", http.StatusNotFound, `<html>404</html>`, "404"},
+	}
+	for _, tt := range tests {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet || r.URL.Path != "/api/v4/system/ping" {
+				t.Errorf("%s: unexpected request %s %s", tt.name, r.Method, r.URL.Path)
+			}
+			w.WriteHeader(tt.status)
+			w.Write([]byte(tt.body))
+		}))
+		err := NewClient(srv.URL, "", nil).Ping(context.Background())
+		srv.Close()
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("%s: err = %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestPingBasePath(t *testing.T) {
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		if r.URL.Path != "/mattermost/api/v4/system/ping" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"status":"OK"}`))
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+
+	for _, server := range []string{srv.URL + "/mattermost", srv.URL + "/mattermost/"} {
+		if err := NewClient(server, "", nil).Ping(ctx); err != nil {
+			t.Errorf("Ping(%s): %v", server, err)
+		}
+	}
+	// Without the base path the requests miss the server
+	if err := NewClient(srv.URL, "", nil).Ping(ctx); !HasStatus(err, http.StatusNotFound) {
+		t.Errorf("Ping at the root: err = %v, want a 404", err)
+	}
+	if want := []string{"/mattermost/api/v4/system/ping", "/mattermost/api/v4/system/ping", "/api/v4/system/ping"}; !slices.Equal(paths, want) {
+		t.Errorf("requested %q, want %q", paths, want)
+	}
+}
+
+func TestLoginHeaders(t *testing.T) {
+	var got http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v4/users/login" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		got = r.Header.Clone()
+		w.Header().Set("Token", "session-token")
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.URL, "", nil)
+	if err := c.Login(context.Background(), "me", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if c.Token != "session-token" {
+		t.Errorf("Token = %q, want the session token", c.Token)
+	}
+	if v := got.Get("Content-Type"); v != "application/json" {
+		t.Errorf("Content-Type = %q", v)
+	}
+	if v := got.Get("Authorization"); v != "" {
+		t.Errorf("Authorization = %q, want none before logging in", v)
+	}
+}
+
+func TestLogin(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var creds struct {
+			LoginID  string `json:"login_id"`
+			Password string `json:"password"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&creds); err != nil {
+			t.Errorf("invalid login body: %v", err)
+		}
+		if creds.LoginID != "me" || creds.Password != `se"cret` {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"id":"api.user.login.invalid_credentials_email_username","message":"Enter a valid email or username and/or password.","status_code":401}`))
+			return
+		}
+		w.Header().Set("Token", "session-token")
+		w.Write([]byte(`{"id":"user1"}`))
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.URL, "", nil)
+	if err := c.Login(context.Background(), "me", `se"cret`); err != nil {
+		t.Fatal(err)
+	}
+	if c.Token != "session-token" {
+		t.Errorf("Token = %q, want the Token header", c.Token)
+	}
+
+	c = NewClient(srv.URL, "", nil)
+	err := c.Login(context.Background(), "me", "wrong")
+	if !HasStatus(err, http.StatusUnauthorized) || !HasErrorID(err, "api.user.login.invalid_credentials_email_username") {
+		t.Errorf("wrong password: err = %v, want the 401 error", err)
+	}
+	if c.Token != "" {
+		t.Errorf("wrong password: Token = %q, want none", c.Token)
+	}
+}
+
+func TestLoginWithoutToken(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id":"user1"}`))
+	}))
+	defer srv.Close()
+
+	if err := NewClient(srv.URL, "", nil).Login(context.Background(), "me", "secret"); err == nil {
+		t.Error("no error for a login answer without a Token header")
+	}
+}
+
+func TestUploadMetadataIsValidJSON(t *testing.T) {
+	var meta []byte
+	var filename string
+	var image []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Errorf("invalid multipart body: %v", err)
+			return
+		}
+		meta = []byte(r.FormValue("emoji"))
+		f, fh, err := r.FormFile("image")
+		if err != nil {
+			t.Errorf("no image part: %v", err)
+			return
+		}
+		defer f.Close()
+		filename = fh.Filename
+		image, _ = io.ReadAll(f)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id":"e1","name":"x"}`))
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.URL, "tok", nil)
+	// The validator would refuse these characters, so let them through to reach the metadata
+	c.NameValidator = func(string) error { return nil }
+	c.CreatorID = `user"1\`
+	name := `say "hi" \o`
+	emoji, err := c.Upload(context.Background(), name, []byte("GIF89a"), "image/gif")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if emoji.ID != "e1" {
+		t.Errorf("ID = %q, want e1", emoji.ID)
+	}
+
+	var got struct {
+		Name      string `json:"name"`
+		CreatorID string `json:"creator_id"`
+	}
+	if err := json.Unmarshal(meta, &got); err != nil {
+		t.Fatalf("emoji part %q is not valid JSON: %v", meta, err)
+	}
+	if got.Name != name || got.CreatorID != c.CreatorID {
+		t.Errorf("metadata = %+v, want name %q and creator %q", got, name, c.CreatorID)
+	}
+	if filename != name+".gif" || string(image) != "GIF89a" {
+		t.Errorf("image part %q with %q", filename, image)
+	}
+}
+
+func TestRequestedWithHeader(t *testing.T) {
+	got := map[string]string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got[r.Method] = r.Header.Get("X-Requested-With")
+		switch r.Method {
+		case http.MethodPost:
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"id":"e1","name":"party"}`))
+		case http.MethodGet:
+			w.Write([]byte(`{"id":"e1","name":"party"}`))
+		}
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+
+	for _, disabled := range []bool{false, true} {
+		clear(got)
+		c := NewClient(srv.URL, "tok", nil)
+		c.NoRequestedWith = disabled
+		if _, err := c.Upload(ctx, "party", gifMagic, "image/gif"); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Delete(ctx, "e1"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.EmojiByName(ctx, "party"); err != nil {
+			t.Fatal(err)
+		}
+
+		want := "XMLHttpRequest"
+		if disabled {
+			want = ""
+		}
+		for _, method := range []string{http.MethodPost, http.MethodDelete} {
+			if got[method] != want {
+				t.Errorf("NoRequestedWith %v: %s sent X-Requested-With %q, want %q", disabled, method, got[method], want)
+			}
+		}
+		// Reads don't need it
+		if got[http.MethodGet] != "" {
+			t.Errorf("NoRequestedWith %v: GET sent X-Requested-With %q", disabled, got[http.MethodGet])
+		}
+	}
+}
+
+func TestUploadRejectsInvalidName(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+	}))
+	defer srv.Close()
+
+	_, err := NewClient(srv.URL, "tok", nil).Upload(context.Background(), `a"b`, []byte("GIF89a"), "image/gif")
+	if !errors.Is(err, ErrInvalidName) {
+		t.Errorf("err = %v, want ErrInvalidName", err)
+	}
+}
+
+func TestIsTeamMember(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v4/teams/t1/members/member":
+			w.Write([]byte(`{"team_id":"t1","user_id":"member","delete_at":0}`))
+		case "/api/v4/teams/t1/members/former":
+			w.Write([]byte(`{"team_id":"t1","user_id":"former","delete_at":1714564800000}`))
+		case "/api/v4/teams/t1/members/broken":
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"id":"app.team.get_member.app_error","message":"Unable to get the team member."}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"id":"app.team.get_member.missing.app_error","message":"No team member found."}`))
+		}
+	}))
+	defer srv.Close()
+	c := NewClient(srv.URL, "tok", nil)
+
+	tests := []struct {
+		user    string
+		want    bool
+		wantErr bool
+	}{
+		{"member", true, false},
+		{"former", false, false},
+		{"stranger", false, false},
+		{"broken", false, true},
+	}
+	for _, tt := range tests {
+		got, err := c.IsTeamMember(context.Background(), "t1", tt.user)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("IsTeamMember(%s) = %v, %v, want %v, error: %v", tt.user, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestUploadFilenameExtension(t *testing.T) {
+	var filename string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Errorf("invalid multipart body: %v", err)
+			return
+		}
+		_, fh, err := r.FormFile("image")
+		if err != nil {
+			t.Errorf("no image part: %v", err)
+			return
+		}
+		filename = fh.Filename
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id":"e1","name":"x"}`))
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.URL, "tok", nil)
+	for contentType, want := range map[string]string{
+		"image/png":     "x.png",
+		"image/gif":     "x.gif",
+		"image/jpeg":    "x.jpg",
+		"image/webp":    "x.webp",
+		"image/svg+xml": "x.svg",
+		"image/bmp":     "x.bmp",
+		"image/x-icon":  "x" + DefaultExtension,
+	} {
+		if _, err := c.Upload(context.Background(), "x", []byte("data"), contentType); err != nil {
+			t.Fatal(err)
+		}
+		if filename != want {
+			t.Errorf("%s uploaded as %q, want %q", contentType, filename, want)
+		}
+	}
+}
+
+func TestUploadReturnsCreatedEmoji(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   Emoji
+	}{
+		{"201", http.StatusCreated, `{"id":"kq7xqguxp3ba5jmbnmys1fpdzy","creator_id":"user1","name":"party","create_at":1714564800000}`,
+			Emoji{ID: "kq7xqguxp3ba5jmbnmys1fpdzy", Name: "party", CreatorID: "user1", CreateAt: 1714564800000}},
+		{"200", http.StatusOK, `{"id":"e2","name":"party"}`, Emoji{ID: "e2", Name: "party"}},
+		// The emoji was created all the same, only its id is unknown
+		{"empty body", http.StatusCreated, ``, Emoji{Name: "party"}},
+		{"not JSON", http.StatusCreated, `<html>ok</html>`, Emoji{Name: "party"}},
+	}
+	for _, tt := range tests {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tt.status)
+			w.Write([]byte(tt.body))
+		}))
+		emoji, err := NewClient(srv.URL, "tok", nil).Upload(context.Background(), "party", []byte("GIF89a"), "image/gif")
+		srv.Close()
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if *emoji != tt.want {
+			t.Errorf("%s: emoji = %+v, want %+v", tt.name, *emoji, tt.want)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package emojiuploader
+
+import (
+	"bytes"
+	"context"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// genericContentTypes are header values that don't say anything about the image format
+var genericContentTypes = map[string]bool{
+	"":                         true,
+	"application/octet-stream": true,
+	"binary/octet-stream":      true,
+	"application/binary":       true,
+	"text/plain":               true,
+}
+
+// extensions maps image media types to the file extension used for them
+var extensions = map[string]string{
+	"image/png":     ".png",
+	"image/gif":     ".gif",
+	"image/jpeg":    ".jpg",
+	"image/webp":    ".webp",
+	"image/svg+xml": ".svg",
+	"image/bmp":     ".bmp",
+}
+
+// DefaultExtension is used for media types without a known extension
+const DefaultExtension = ".png"
+
+// Extension returns the file extension for an image of the given media type.
+// For unknown types it returns DefaultExtension and false.
+func Extension(contentType string) (string, bool) {
+	if ext, ok := extensions[strings.ToLower(contentType)]; ok {
+		return ext, true
+	}
+	return DefaultExtension, false
+}
+
+// Download fetches an image from an external URL, such as a Slack export,
+// and returns it together with its media type
+func Download(ctx context.Context, client *http.Client, url string) ([]byte, string, error) {
+	return DownloadWithHeader(ctx, client, url, nil)
+}
+
+// DownloadWithHeader is like Download but adds header to the request, e.g.
+// credentials for an image host that requires authentication
+func DownloadWithHeader(ctx context.Context, client *http.Client, url string, header http.Header) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	for key, values := range header {
+		req.Header[key] = append(req.Header[key], values...)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", NewStatusError(resp, "")
+	}
+
+	data, err := ReadBodyResume(client, resp)
+	if err != nil {
+		return nil, "", err
+	}
+
+	contentType := DetectContentType(data, resp.Header.Get("Content-Type"))
+	return data, contentType, nil
+}
+
+// DetectContentType returns the media type of an image. Recognizable image
+// signatures take precedence over the Content-Type header, so that an
+// animated GIF served as image/png is still uploaded as a GIF. The header is
+// used when sniffing is inconclusive.
+func DetectContentType(data []byte, header string) string {
+	// http.DetectContentType considers at most the first 512 bytes
+	if sniffed := http.DetectContentType(data); strings.HasPrefix(sniffed, "image/") {
+		return sniffed
+	}
+	if isSVG(data) {
+		return "image/svg+xml"
+	}
+
+	mediaType, _, err := mime.ParseMediaType(header)
+	if err != nil {
+		mediaType = header
+	}
+	mediaType = strings.ToLower(mediaType)
+	if !genericContentTypes[mediaType] {
+		return mediaType
+	}
+
+	if sniffed := http.DetectContentType(data); sniffed != "application/octet-stream" && !strings.HasPrefix(sniffed, "text/plain") {
+		return sniffed
+	}
+	return mediaType
+}
+
+// isSVG reports whether data looks like an SVG document: an <svg> root
+// element, optionally after an XML declaration, comments or a doctype
+func isSVG(data []byte) bool {
+	head := data[:min(len(data), 512)]
+	i := bytes.Index(head, []byte("<svg"))
+	if i < 0 {
+		return false
+	}
+	prolog := bytes.TrimSpace(head[:i])
+	return len(prolog) == 0 || bytes.HasPrefix(prolog, []byte("<?xml")) || bytes.HasPrefix(prolog, []byte("<!"))
+}
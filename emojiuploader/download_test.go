@@ -0,0 +1,89 @@
+package emojiuploader
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+var (
+	gifMagic  = []byte("GIF89a\x01\x00\x01\x00\x00\x00\x00;")
+	pngMagic  = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	jpegMagic = []byte("\xff\xd8\xff\xe0\x00\x10JFIF\x00")
+	bmpMagic  = []byte("BM\x3a\x00\x00\x00\x00\x00\x00\x00\x36\x00\x00\x00")
+)
+
+func TestDetectContentType(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   []byte
+		header string
+		want   string
+	}{
+		{"gif", gifMagic, "image/gif", "image/gif"},
+		{"png", pngMagic, "image/png", "image/png"},
+		{"jpeg", jpegMagic, "image/jpeg", "image/jpeg"},
+		{"parameters dropped", pngMagic, "Image/PNG; foo=bar", "image/png"},
+		// A generic or missing header is replaced by the sniffed type
+		{"jpeg served as binary", jpegMagic, "application/octet-stream", "image/jpeg"},
+		{"png served as text", pngMagic, "text/plain", "image/png"},
+		{"gif without header", gifMagic, "", "image/gif"},
+		{"svg", []byte(`<?xml version="1.0"?><svg xmlns="http://www.w3.org/2000/svg"/>`), "text/plain", "image/svg+xml"},
+		{"svg with doctype", []byte("<!DOCTYPE svg>\n<svg width=\"8\" height=\"8\"></svg>"), "", "image/svg+xml"},
+		{"bare svg", []byte(`  <svg viewBox="0 0 8 8"/>`), "application/octet-stream", "image/svg+xml"},
+		{"html mentioning svg", []byte(`<html><body><svg/></body></html>`), "text/html", "text/html"},
+		{"bmp", bmpMagic, "image/png", "image/bmp"},
+		// Without a signature the header is kept
+		{"unknown bytes", []byte{0, 1, 2, 3}, "application/octet-stream", "application/octet-stream"},
+		{"nothing known", []byte{0, 1, 2, 3}, "", ""},
+	}
+	for _, tt := range tests {
+		if got := DetectContentType(tt.data, tt.header); got != tt.want {
+			t.Errorf("%s: DetectContentType = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestExtension(t *testing.T) {
+	tests := []struct {
+		contentType string
+		want        string
+		known       bool
+	}{
+		{"image/png", ".png", true},
+		{"image/gif", ".gif", true},
+		{"image/jpeg", ".jpg", true},
+		{"image/webp", ".webp", true},
+		{"image/svg+xml", ".svg", true},
+		{"image/bmp", ".bmp", true},
+		{"Image/GIF", ".gif", true},
+		{"image/x-icon", DefaultExtension, false},
+		{"", DefaultExtension, false},
+	}
+	for _, tt := range tests {
+		ext, known := Extension(tt.contentType)
+		if ext != tt.want || known != tt.known {
+			t.Errorf("Extension(%q) = %q, %v, want %q, %v", tt.contentType, ext, known, tt.want, tt.known)
+		}
+	}
+}
+
+func TestDownloadSniffsContentType(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(gifMagic)
+	}))
+	defer srv.Close()
+
+	data, contentType, err := Download(context.Background(), srv.Client(), srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != string(gifMagic) {
+		t.Errorf("data = %q", data)
+	}
+	if contentType != "image/gif" {
+		t.Errorf("content type = %q, want image/gif despite the generic header", contentType)
+	}
+}
@@ -0,0 +1,135 @@
+package emojiuploader
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ReadBody reads the body of an image response, undoing any Content-Encoding.
+// Go's transport only decompresses gzip transparently when it asked for it
+// itself, so a custom Accept-Encoding header, or a host that compresses
+// without being asked, would otherwise hand over compressed bytes. Bodies
+// labelled gzip that don't start with the gzip signature are returned as they
+// are, since some servers send the header for images they never compressed.
+func ReadBody(resp *http.Response) ([]byte, error) {
+	var r io.Reader = resp.Body
+	if !resp.Uncompressed {
+		// Encodings are listed in the order they were applied
+		encodings := strings.Split(resp.Header.Get("Content-Encoding"), ",")
+		for i := len(encodings) - 1; i >= 0; i-- {
+			var err error
+			if r, err = decodeBody(r, strings.ToLower(strings.TrimSpace(encodings[i]))); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return io.ReadAll(r)
+}
+
+// maxResumes is how often an interrupted image download is continued with a
+// Range request before giving up
+const maxResumes = 3
+
+// ReadBodyResume is like ReadBody, but when the connection breaks while the
+// body is read and the server supports range requests (Accept-Ranges: bytes),
+// it requests the rest from where the transfer stopped instead of failing, so
+// a large animated GIF on a flaky connection doesn't start over. If-Range
+// makes sure the pieces belong to the same version of the image. Encoded
+// bodies are never resumed, since the ranges would refer to the compressed
+// bytes; the error is returned as is and a retry starts from scratch.
+func ReadBodyResume(client *http.Client, resp *http.Response) ([]byte, error) {
+	data, err := ReadBody(resp)
+	for i := 0; i < maxResumes && err != nil && canResume(resp, data); i++ {
+		req := resp.Request.Clone(resp.Request.Context())
+		req.Header.Del("If-None-Match")
+		req.Header.Del("If-Modified-Since")
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", len(data)))
+		if validator := rangeValidator(resp.Header); validator != "" {
+			req.Header.Set("If-Range", validator)
+		}
+
+		rest, rerr := client.Do(req)
+		if rerr != nil {
+			return nil, rerr
+		}
+		switch {
+		case rest.StatusCode == http.StatusPartialContent && contentRangeStart(rest.Header.Get("Content-Range")) == int64(len(data)):
+			var more []byte
+			more, err = io.ReadAll(rest.Body)
+			data = append(data, more...)
+		case rest.StatusCode == http.StatusOK:
+			// The image changed or the server ignored the range; start over
+			resp = rest
+			data, err = ReadBody(rest)
+		default:
+			rest.Body.Close()
+			return nil, NewStatusError(rest, "")
+		}
+		rest.Body.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// canResume reports whether the rest of a partially read body can be requested
+func canResume(resp *http.Response, data []byte) bool {
+	if len(data) == 0 || resp.Uncompressed || resp.Request == nil || resp.Request.Context().Err() != nil {
+		return false
+	}
+	if encoding := resp.Header.Get("Content-Encoding"); encoding != "" && encoding != "identity" {
+		return false
+	}
+	return strings.EqualFold(resp.Header.Get("Accept-Ranges"), "bytes")
+}
+
+// rangeValidator returns the If-Range value for a response: its ETag unless
+// it is weak, which If-Range doesn't allow, and otherwise its Last-Modified
+func rangeValidator(h http.Header) string {
+	if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return h.Get("Last-Modified")
+}
+
+// contentRangeStart returns the first byte of a "bytes first-last/total"
+// Content-Range header, or -1 if it can't be parsed
+func contentRangeStart(value string) int64 {
+	var first, last int64
+	var total string
+	if _, err := fmt.Sscanf(value, "bytes %d-%d/%s", &first, &last, &total); err != nil {
+		return -1
+	}
+	return first
+}
+
+// decodeBody wraps r into a reader that undoes a single content encoding
+func decodeBody(r io.Reader, encoding string) (io.Reader, error) {
+	switch encoding {
+	case "", "identity":
+		return r, nil
+	case "gzip", "x-gzip":
+		br := bufio.NewReader(r)
+		if magic, err := br.Peek(2); err != nil || !bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+			return br, nil
+		}
+		return gzip.NewReader(br)
+	case "deflate":
+		// Deflate is supposed to be zlib-wrapped, but some servers send raw deflate data
+		br := bufio.NewReader(r)
+		if header, err := br.Peek(2); err == nil && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 && header[0]&0x0f == 8 {
+			return zlib.NewReader(br)
+		}
+		return flate.NewReader(br), nil
+	default:
+		return nil, fmt.Errorf("unsupported Content-Encoding %q", encoding)
+	}
+}
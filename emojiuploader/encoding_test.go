@@ -0,0 +1,238 @@
+package emojiuploader
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// pngImage is a complete 1x1 PNG, long enough for compression to matter
+var pngImage = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR\x00\x00\x00\x01\x00\x00\x00\x01\x08\x06\x00\x00\x00\x1f\x15\xc4\x89\x00\x00\x00\rIDATx\x9cc\xf8\xff\xff?\x00\x05\xfe\x02\xfe\xa75\x81\x84\x00\x00\x00\x00IEND\xaeB`\x82")
+
+// compress returns data compressed by the writer w makes
+func compress(t *testing.T, data []byte, w func(io.Writer) io.WriteCloser) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := w(&buf)
+	if _, err := zw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func gzipped(t *testing.T, data []byte) []byte {
+	return compress(t, data, func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) })
+}
+
+func TestDownloadDecodesContentEncoding(t *testing.T) {
+	rawDeflate := compress(t, pngImage, func(w io.Writer) io.WriteCloser {
+		fw, _ := flate.NewWriter(w, flate.BestCompression)
+		return fw
+	})
+	tests := []struct {
+		name     string
+		encoding string
+		body     []byte
+	}{
+		{"gzip", "gzip", gzipped(t, pngImage)},
+		{"x-gzip", "x-gzip", gzipped(t, pngImage)},
+		{"upper case", "GZIP", gzipped(t, pngImage)},
+		{"zlib deflate", "deflate", compress(t, pngImage, func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) })},
+		{"raw deflate", "deflate", rawDeflate},
+		{"identity", "identity", pngImage},
+		// Applied in the listed order, so undone from the end
+		{"deflate then gzip", "deflate, gzip", gzipped(t, rawDeflate)},
+		// Some hosts label images as gzip without compressing them
+		{"gzip header only", "gzip", pngImage},
+	}
+	for _, tt := range tests {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "image/png")
+			w.Header().Set("Content-Encoding", tt.encoding)
+			w.Write(tt.body)
+		}))
+		// Asking for an encoding ourselves turns off the transport's own gzip handling
+		header := http.Header{"Accept-Encoding": {"gzip, deflate"}}
+		data, contentType, err := DownloadWithHeader(context.Background(), srv.Client(), srv.URL, header)
+		srv.Close()
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !bytes.Equal(data, pngImage) || contentType != "image/png" {
+			t.Errorf("%s: got %d bytes of %s, want the decoded PNG", tt.name, len(data), contentType)
+		}
+	}
+}
+
+func TestDownloadTransparentGzip(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept-Encoding") != "gzip" {
+			t.Errorf("Accept-Encoding = %q, want the transport's gzip", r.Header.Get("Accept-Encoding"))
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(gzipped(t, pngImage))
+	}))
+	defer srv.Close()
+
+	// The transport decompresses by itself, which must not be undone twice
+	data, _, err := Download(context.Background(), srv.Client(), srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, pngImage) {
+		t.Errorf("got %d bytes, want the %d byte PNG", len(data), len(pngImage))
+	}
+}
+
+func TestDownloadUnsupportedEncoding(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "br")
+		w.Write([]byte("\x0b\x02\x80compressed"))
+	}))
+	defer srv.Close()
+
+	if _, _, err := Download(context.Background(), srv.Client(), srv.URL); err == nil {
+		t.Error("no error for a Brotli body that can't be decoded")
+	}
+}
+
+// flakyRangeServer serves image, dropping the connection after cut bytes of
+// the first drops responses. Range requests are answered by partial, which
+// gets the requested start, or 0 when there is no valid Range header.
+// It returns the server and the Range and If-Range headers of every request.
+func flakyRangeServer(t *testing.T, image []byte, cut, drops int, acceptRanges bool, partial func(w http.ResponseWriter, start int)) (*httptest.Server, *[][2]string) {
+	var requests [][2]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, [2]string{r.Header.Get("Range"), r.Header.Get("If-Range")})
+		w.Header().Set("ETag", `"v1"`)
+		if acceptRanges {
+			w.Header().Set("Accept-Ranges", "bytes")
+		}
+		var start int
+		if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-", &start); err == nil && partial != nil {
+			partial(w, start)
+			return
+		}
+		if len(requests) > drops {
+			w.Write(image)
+			return
+		}
+		// Promise the whole image, then hang up halfway
+		w.Header().Set("Content-Length", strconv.Itoa(len(image)))
+		w.Write(image[:cut])
+		w.(http.Flusher).Flush()
+		conn, _, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conn.Close()
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &requests
+}
+
+// servePartial answers a range request for image with the bytes from start
+func servePartial(image []byte) func(w http.ResponseWriter, start int) {
+	return func(w http.ResponseWriter, start int) {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, len(image)-1, len(image)))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(image[start:])
+	}
+}
+
+func TestDownloadResumesDroppedConnection(t *testing.T) {
+	image := bytes.Repeat(pngImage, 100)
+	srv, requests := flakyRangeServer(t, image, 1000, 1, true, servePartial(image))
+
+	data, _, err := Download(context.Background(), srv.Client(), srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, image) {
+		t.Errorf("got %d bytes, want the whole %d byte image", len(data), len(image))
+	}
+	want := [][2]string{{"", ""}, {"bytes=1000-", `"v1"`}}
+	if !reflect.DeepEqual(*requests, want) {
+		t.Errorf("requests = %q, want the image and then the rest from byte 1000", *requests)
+	}
+}
+
+func TestDownloadResumeFallbacks(t *testing.T) {
+	image := bytes.Repeat(pngImage, 100)
+	tests := []struct {
+		name         string
+		drops        int
+		acceptRanges bool
+		partial      func(w http.ResponseWriter, start int)
+		wantErr      bool
+		wantRequests int
+	}{
+		// Without range support only a retry from scratch can help
+		{"no ranges", 1, false, nil, true, 1},
+		// A 200 answer to the range request is the whole image again
+		{"range ignored", 1, true, nil, false, 2},
+		{"wrong range", 1, true, func(w http.ResponseWriter, start int) {
+			servePartial(image)(w, start/2)
+		}, true, 2},
+		{"drops every time", 10, true, func(w http.ResponseWriter, start int) {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, len(image)-1, len(image)))
+			w.Header().Set("Content-Length", strconv.Itoa(len(image)-start))
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(image[start : start+10])
+			w.(http.Flusher).Flush()
+			if conn, _, err := http.NewResponseController(w).Hijack(); err == nil {
+				conn.Close()
+			}
+		}, true, 1 + maxResumes},
+	}
+	for _, tt := range tests {
+		srv, requests := flakyRangeServer(t, image, 1000, tt.drops, tt.acceptRanges, tt.partial)
+		data, _, err := Download(context.Background(), srv.Client(), srv.URL)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: err = %v, want error: %v", tt.name, err, tt.wantErr)
+		}
+		if err == nil && !bytes.Equal(data, image) {
+			t.Errorf("%s: got %d bytes, want the whole %d byte image", tt.name, len(data), len(image))
+		}
+		if len(*requests) != tt.wantRequests {
+			t.Errorf("%s: %d requests, want %d", tt.name, len(*requests), tt.wantRequests)
+		}
+	}
+}
+
+func TestDownloadDoesNotResumeEncodedBody(t *testing.T) {
+	image := gzipped(t, bytes.Repeat(pngImage, 100))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" {
+			t.Error("resumed a gzip encoded body")
+		}
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Set("Content-Length", strconv.Itoa(len(image)))
+		w.Write(image[:len(image)/2])
+		w.(http.Flusher).Flush()
+		if conn, _, err := http.NewResponseController(w).Hijack(); err == nil {
+			conn.Close()
+		}
+	}))
+	defer srv.Close()
+
+	header := http.Header{"Accept-Encoding": {"gzip"}}
+	if _, _, err := DownloadWithHeader(context.Background(), srv.Client(), srv.URL, header); err == nil {
+		t.Error("no error for a truncated gzip body")
+	}
+}
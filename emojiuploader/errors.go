@@ -0,0 +1,108 @@
+package emojiuploader
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Error ids in Mattermost's JSON error bodies, see StatusError.ErrorID
+const (
+	ErrorIDDuplicate   = "api.emoji.create.duplicate.app_error"
+	ErrorIDInvalidName = "model.emoji.name.app_error"
+	ErrorIDTooLarge    = "api.emoji.create.too_large.app_error"
+	ErrorIDOtherUser   = "api.emoji.create.other_user.app_error"
+)
+
+// StatusError is returned when a server answers with an unexpected HTTP status
+type StatusError struct {
+	StatusCode int
+	Body       string
+	// ErrorID, Message and DetailedError are taken from a Mattermost JSON
+	// error body such as {"id": "api.emoji.create.duplicate.app_error",
+	// "message": "...", "detailed_error": "..."} and are empty for other bodies
+	ErrorID       string
+	Message       string
+	DetailedError string
+	// RetryAfter is the delay requested by the server via the Retry-After header (0 if absent)
+	RetryAfter time.Duration
+}
+
+// maxErrorBody is how much of a body that isn't a Mattermost error is shown in Error
+const maxErrorBody = 200
+
+// Error describes the response in one line: the message and id of a
+// Mattermost error, e.g. "HTTP 400: Unable to create emoji. Image too large
+// (api.emoji.create.too_large.app_error)", and otherwise the start of the body
+func (e *StatusError) Error() string {
+	if e.Message != "" {
+		msg := strings.TrimSuffix(e.Message, ".")
+		if e.DetailedError != "" {
+			msg += ": " + e.DetailedError
+		}
+		if e.ErrorID != "" {
+			msg += " (" + e.ErrorID + ")"
+		}
+		return fmt.Sprintf("HTTP %d: %s", e.StatusCode, msg)
+	}
+
+	body := strings.Join(strings.Fields(e.Body), " ")
+	if body == "" {
+		return fmt.Sprintf("HTTP %d", e.StatusCode)
+	}
+	if len(body) > maxErrorBody {
+		body = strings.ToValidUTF8(body[:maxErrorBody], "") + "..."
+	}
+	return fmt.Sprintf("HTTP %d: %s", e.StatusCode, body)
+}
+
+// NewStatusError builds a StatusError from a non-successful response
+func NewStatusError(resp *http.Response, body string) *StatusError {
+	se := &StatusError{
+		StatusCode: resp.StatusCode,
+		Body:       body,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+
+	var appErr struct {
+		ID            string `json:"id"`
+		Message       string `json:"message"`
+		DetailedError string `json:"detailed_error"`
+	}
+	if json.Unmarshal([]byte(body), &appErr) == nil {
+		se.ErrorID, se.Message, se.DetailedError = appErr.ID, appErr.Message, strings.TrimSpace(appErr.DetailedError)
+	}
+	return se
+}
+
+// HasErrorID reports whether err is a StatusError with the given Mattermost error id
+func HasErrorID(err error, id string) bool {
+	var se *StatusError
+	return errors.As(err, &se) && se.ErrorID == id
+}
+
+// HasStatus reports whether err is a StatusError with the given HTTP status
+func HasStatus(err error, code int) bool {
+	var se *StatusError
+	return errors.As(err, &se) && se.StatusCode == code
+}
+
+// parseRetryAfter converts a Retry-After header (seconds or HTTP date) into a duration
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(date); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
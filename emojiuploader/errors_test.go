@@ -0,0 +1,95 @@
+package emojiuploader
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatusErrorMessage(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   string
+		id     string
+	}{
+		{"duplicate", http.StatusBadRequest,
+			`{"id":"api.emoji.create.duplicate.app_error","message":"Unable to create emoji. Another emoji with the same name already exists.","detailed_error":"","request_id":"8t9xw4wqbjgy7rzfu7b6s5c8ic","status_code":400}`,
+			"HTTP 400: Unable to create emoji. Another emoji with the same name already exists (api.emoji.create.duplicate.app_error)",
+			ErrorIDDuplicate},
+		{"with details", http.StatusInternalServerError,
+			`{"id":"app.emoji.create.internal_error","message":"Unable to save emoji.","detailed_error":"  pq: duplicate key value violates unique constraint \"idx_emoji_name_delete_at\"\n","request_id":"x","status_code":500}`,
+			`HTTP 500: Unable to save emoji: pq: duplicate key value violates unique constraint "idx_emoji_name_delete_at" (app.emoji.create.internal_error)`,
+			"app.emoji.create.internal_error"},
+		{"message without id", http.StatusForbidden, `{"message":"Forbidden"}`, "HTTP 403: Forbidden", ""},
+		// Anything else is shown as it is, on one line and shortened
+		{"HTML", http.StatusBadGateway, "<html>\n<head><title>502 Bad Gateway</title></head>\n<body>nginx</body>\n</html>\n",
+			"HTTP 502: <html> <head><title>502 Bad Gateway</title></head> <body>nginx</body> </html>", ""},
+		{"plain text", http.StatusRequestEntityTooLarge, "request entity too large", "HTTP 413: request entity too large", ""},
+		{"other JSON", http.StatusBadRequest, `{"error":"bad request"}`, `HTTP 400: {"error":"bad request"}`, ""},
+		{"JSON array", http.StatusBadRequest, `["a","b"]`, `HTTP 400: ["a","b"]`, ""},
+		{"empty", http.StatusServiceUnavailable, "", "HTTP 503", ""},
+		{"long", http.StatusInternalServerError, strings.Repeat("x", 300), "HTTP 500: " + strings.Repeat("x", maxErrorBody) + "...", ""},
+	}
+	for _, tt := range tests {
+		err := NewStatusError(&http.Response{StatusCode: tt.status, Header: http.Header{}}, tt.body)
+		if got := err.Error(); got != tt.want {
+			t.Errorf("%s: Error() = %q, want %q", tt.name, got, tt.want)
+		}
+		if err.ErrorID != tt.id {
+			t.Errorf("%s: ErrorID = %q, want %q", tt.name, err.ErrorID, tt.id)
+		}
+		if err.Body != tt.body || err.StatusCode != tt.status {
+			t.Errorf("%s: the status and raw body weren't kept", tt.name)
+		}
+	}
+}
+
+func TestStatusErrorTruncatesUTF8(t *testing.T) {
+	// A multi-byte character straddles the cut
+	body := strings.Repeat("a", maxErrorBody-1) + "é and more"
+	msg := (&StatusError{StatusCode: 500, Body: body}).Error()
+	if want := "HTTP 500: " + strings.Repeat("a", maxErrorBody-1) + "..."; msg != want {
+		t.Errorf("Error() = %q, want %q", msg, want)
+	}
+}
+
+func TestHasErrorIDAndStatus(t *testing.T) {
+	err := fmt.Errorf("upload: %w", &StatusError{StatusCode: 400, ErrorID: ErrorIDTooLarge})
+	if !HasErrorID(err, ErrorIDTooLarge) || HasErrorID(err, ErrorIDDuplicate) {
+		t.Error("HasErrorID doesn't match the wrapped error id")
+	}
+	if !HasStatus(err, 400) || HasStatus(err, 500) {
+		t.Error("HasStatus doesn't match the wrapped status")
+	}
+	if HasStatus(fmt.Errorf("plain"), 400) {
+		t.Error("HasStatus matched an error without a status")
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"3", 3 * time.Second},
+		{"0", 0},
+		{"-5", 0},
+		{"soon", 0},
+		{time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), 0},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.value); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+	// A date is turned into the time left until then
+	date := time.Now().Add(90 * time.Second).UTC().Format(http.TimeFormat)
+	if got := parseRetryAfter(date); got < 80*time.Second || got > 90*time.Second {
+		t.Errorf("parseRetryAfter(%q) = %v, want about 90s", date, got)
+	}
+}
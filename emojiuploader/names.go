@@ -0,0 +1,93 @@
+package emojiuploader
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/mozillazg/go-unidecode"
+	"golang.org/x/text/unicode/norm"
+)
+
+// MaxNameLength is the longest emoji name Mattermost accepts
+const MaxNameLength = 64
+
+// invalidNameChars matches everything Mattermost doesn't allow in emoji names
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9\-_]+`)
+
+// ErrInvalidName is returned by Upload, wrapped together with the reason, for
+// a name rejected by the client's NameValidator, see Client.ValidateName
+var ErrInvalidName = errors.New("invalid emoji name")
+
+// NameRule is an extra renaming step for SanitizeNameWith: every match of
+// Pattern is replaced with Replacement, which may refer to submatches as in
+// regexp.Regexp.ReplaceAllString
+type NameRule struct {
+	Pattern     *regexp.Regexp
+	Replacement string
+}
+
+// SanitizeName converts a name to Mattermost-compatible format. The result
+// may be empty if nothing usable is left.
+func SanitizeName(name string) string {
+	return SanitizeNameWith(name)
+}
+
+// SanitizeNameWith is SanitizeName with additional rules, applied in order to
+// the transliterated, lowercased name with spaces turned into dashes, before
+// the forbidden characters are removed
+func SanitizeNameWith(name string, rules ...NameRule) string {
+	// Compose combining sequences first, so that "e" followed by a combining
+	// acute accent transliterates like the precomposed "é"
+	name = norm.NFC.String(name)
+	// Transliterate non-latin characters (e.g., "жду" -> "zhdu")
+	name = unidecode.Unidecode(name)
+	// Convert to lowercase
+	name = strings.ToLower(name)
+	// Replace spaces with dashes
+	name = strings.ReplaceAll(name, " ", "-")
+	for _, rule := range rules {
+		name = rule.Pattern.ReplaceAllString(name, rule.Replacement)
+	}
+	// Remove all forbidden characters (anything not a-z, 0-9, - or _)
+	name = invalidNameChars.ReplaceAllString(name, "")
+	// Truncate to Mattermost limit (64 chars)
+	if len(name) > MaxNameLength {
+		name = name[:MaxNameLength]
+	}
+	return name
+}
+
+// ValidateName checks that name is an emoji name Mattermost accepts: 1 to
+// MaxNameLength characters, only lowercase letters, digits, '-' and '_'.
+// SanitizeName always returns such names unless they come out empty.
+func ValidateName(name string) error {
+	switch {
+	case name == "":
+		return errors.New("the name is empty")
+	case len(name) > MaxNameLength:
+		return fmt.Errorf("the name is longer than %d characters", MaxNameLength)
+	case invalidNameChars.MatchString(name):
+		return errors.New("the name may only contain lowercase letters, digits, '-' and '_'")
+	}
+	return nil
+}
+
+// SanitizeName converts a name with the client's NameSanitizer, or with the
+// package's SanitizeName if it has none
+func (c *Client) SanitizeName(name string) string {
+	if c.NameSanitizer != nil {
+		return c.NameSanitizer(name)
+	}
+	return SanitizeName(name)
+}
+
+// ValidateName checks a name with the client's NameValidator, or with the
+// package's ValidateName if it has none
+func (c *Client) ValidateName(name string) error {
+	if c.NameValidator != nil {
+		return c.NameValidator(name)
+	}
+	return ValidateName(name)
+}
@@ -0,0 +1,149 @@
+package emojiuploader
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+func TestSanitizeNameNormalization(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"café", "cafe"},
+		{"Ñandú", "nandu"},
+		{"Ångström", "angstrom"},
+		{"naïve über", "naive-uber"},
+		{"Crème brûlée", "creme-brulee"},
+		{"Düsseldorf", "dusseldorf"},
+	}
+	for _, tt := range tests {
+		nfc, nfd := norm.NFC.String(tt.input), norm.NFD.String(tt.input)
+		if nfc == nfd {
+			t.Fatalf("%q has no decomposed form", tt.input)
+		}
+		if got := SanitizeName(nfc); got != tt.want {
+			t.Errorf("SanitizeName(NFC %q) = %q, want %q", tt.input, got, tt.want)
+		}
+		if got := SanitizeName(nfd); got != tt.want {
+			t.Errorf("SanitizeName(NFD %q) = %q, want %q like the NFC form", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestSanitizeName(t *testing.T) {
+	tests := []struct {
+		input, want string
+	}{
+		{"party_parrot", "party_parrot"},
+		{"Party Parrot", "party-parrot"},
+		{"жду", "zhdu"},
+		{"thumbs-up!", "thumbs-up"},
+		{"a.b+c", "abc"},
+		{"🎉", ""},
+		{strings.Repeat("x", 70), strings.Repeat("x", MaxNameLength)},
+	}
+	for _, tt := range tests {
+		got := SanitizeName(tt.input)
+		if got != tt.want {
+			t.Errorf("SanitizeName(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+		if got != "" && ValidateName(got) != nil {
+			t.Errorf("SanitizeName(%q) = %q, which ValidateName rejects", tt.input, got)
+		}
+	}
+}
+
+func TestSanitizeNameWith(t *testing.T) {
+	rules := []NameRule{
+		{Pattern: regexp.MustCompile(`^slack-`), Replacement: ""},
+		{Pattern: regexp.MustCompile(`(\w+)\+(\w+)`), Replacement: "${2}_$1"},
+	}
+	// The rules see the transliterated, lowercased name, and the characters
+	// they leave behind are still removed
+	if got := SanitizeNameWith("Slack Zoë+Bob!", rules...); got != "bob_zoe" {
+		t.Errorf("SanitizeNameWith = %q, want bob_zoe", got)
+	}
+}
+
+func TestValidateName(t *testing.T) {
+	for _, name := range []string{"a", "party-parrot", "under_score", "123", strings.Repeat("x", MaxNameLength)} {
+		if err := ValidateName(name); err != nil {
+			t.Errorf("ValidateName(%q) = %v, want valid", name, err)
+		}
+	}
+	for _, name := range []string{"", "Party", "with space", "dot.ted", "émoji", strings.Repeat("x", MaxNameLength+1)} {
+		if err := ValidateName(name); err == nil {
+			t.Errorf("ValidateName(%q) = nil, want an error", name)
+		}
+	}
+}
+
+func TestClientNameDefaults(t *testing.T) {
+	c := NewClient("http://mattermost.invalid", "tok", nil)
+	if got := c.SanitizeName("Party Parrot!"); got != SanitizeName("Party Parrot!") {
+		t.Errorf("SanitizeName = %q, want the package default", got)
+	}
+	if err := c.ValidateName("Party"); err == nil {
+		t.Error("ValidateName accepted an uppercase name, want the package default")
+	}
+}
+
+func TestClientNameSanitizer(t *testing.T) {
+	c := NewClient("http://mattermost.invalid", "tok", nil)
+	// A team policy: every emoji gets the team prefix
+	c.NameSanitizer = func(name string) string { return "acme_" + SanitizeName(name) }
+	if got := c.SanitizeName("Party Parrot"); got != "acme_party-parrot" {
+		t.Errorf("SanitizeName = %q, want acme_party-parrot", got)
+	}
+	// The package function stays as it is
+	if got := SanitizeName("Party Parrot"); got != "party-parrot" {
+		t.Errorf("package SanitizeName = %q, want party-parrot", got)
+	}
+}
+
+func TestClientNameValidator(t *testing.T) {
+	var uploads []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Errorf("invalid multipart body: %v", err)
+		}
+		_, fh, _ := r.FormFile("image")
+		uploads = append(uploads, fh.Filename)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id":"e1"}`))
+	}))
+	defer srv.Close()
+
+	errNoPrefix := errors.New("emoji names must start with acme_")
+	c := NewClient(srv.URL, "tok", nil)
+	c.NameValidator = func(name string) error {
+		if !strings.HasPrefix(name, "acme_") {
+			return errNoPrefix
+		}
+		return ValidateName(name)
+	}
+	ctx := context.Background()
+
+	// Valid for Mattermost, but not for the policy
+	_, err := c.Upload(ctx, "party", gifMagic, "image/gif")
+	if !errors.Is(err, ErrInvalidName) || !errors.Is(err, errNoPrefix) {
+		t.Errorf("err = %v, want ErrInvalidName with the validator's reason", err)
+	}
+	if err := c.ValidateName("party"); !errors.Is(err, errNoPrefix) {
+		t.Errorf("ValidateName = %v, want the validator's error", err)
+	}
+	if _, err := c.Upload(ctx, "acme_party", gifMagic, "image/gif"); err != nil {
+		t.Errorf("err = %v for a name the validator accepts", err)
+	}
+	if len(uploads) != 1 || uploads[0] != "acme_party.gif" {
+		t.Errorf("uploaded %q, want only acme_party", uploads)
+	}
+}
@@ -0,0 +1,158 @@
+package emojiuploader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// PermissionCreateEmojis is the Mattermost permission required by Upload
+const PermissionCreateEmojis = "create_emojis"
+
+// User is the authenticated user as returned by GET /api/v4/users/me
+type User struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+	// Roles is a space separated list of system roles, e.g. "system_user"
+	Roles string `json:"roles"`
+	// IsBot is set for bot accounts created in the System Console
+	IsBot bool `json:"is_bot"`
+}
+
+// Me retrieves the user the token belongs to. An invalid or expired token
+// results in a StatusError with status 401.
+func (c *Client) Me(ctx context.Context) (*User, error) {
+	return c.getUser(ctx, "/api/v4/users/me")
+}
+
+// getUser fetches a single user from an API path
+func (c *Client) getUser(ctx context.Context, path string) (*User, error) {
+	req, err := c.newRequest(ctx, "GET", path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, NewStatusError(resp, string(respBody))
+	}
+
+	var user User
+	if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// UserByUsername looks up a user via GET /api/v4/users/username/{username}
+func (c *Client) UserByUsername(ctx context.Context, username string) (*User, error) {
+	return c.getUser(ctx, "/api/v4/users/username/"+url.PathEscape(username))
+}
+
+// UserByID looks up a user via GET /api/v4/users/{user_id}
+func (c *Client) UserByID(ctx context.Context, id string) (*User, error) {
+	return c.getUser(ctx, "/api/v4/users/"+url.PathEscape(id))
+}
+
+// HasPermission reports whether the user holds a permission through any of
+// their system roles or the roles of their team memberships. Mattermost
+// grants create_emojis at either level.
+func (c *Client) HasPermission(ctx context.Context, user *User, permission string) (bool, error) {
+	roles := strings.Fields(user.Roles)
+	teamRoles, err := c.teamMemberRoles(ctx)
+	if err != nil {
+		return false, err
+	}
+	roles = append(roles, teamRoles...)
+	if len(roles) == 0 {
+		return false, nil
+	}
+
+	permissions, err := c.rolePermissions(ctx, roles)
+	if err != nil {
+		return false, err
+	}
+	return permissions[permission], nil
+}
+
+// teamMemberRoles returns the roles from all team memberships of the
+// authenticated user via GET /api/v4/users/me/teams/members
+func (c *Client) teamMemberRoles(ctx context.Context) ([]string, error) {
+	req, err := c.newRequest(ctx, "GET", "/api/v4/users/me/teams/members", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, NewStatusError(resp, string(respBody))
+	}
+
+	var members []struct {
+		Roles string `json:"roles"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&members); err != nil {
+		return nil, err
+	}
+	var roles []string
+	for _, m := range members {
+		roles = append(roles, strings.Fields(m.Roles)...)
+	}
+	return roles, nil
+}
+
+// rolePermissions returns the combined permissions of the named roles via
+// POST /api/v4/roles/names
+func (c *Client) rolePermissions(ctx context.Context, names []string) (map[string]bool, error) {
+	body, err := json.Marshal(names)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := c.newRequest(ctx, "POST", "/api/v4/roles/names", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, NewStatusError(resp, string(respBody))
+	}
+
+	var roles []struct {
+		Permissions []string `json:"permissions"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&roles); err != nil {
+		return nil, err
+	}
+	permissions := make(map[string]bool)
+	for _, role := range roles {
+		for _, p := range role.Permissions {
+			permissions[p] = true
+		}
+	}
+	return permissions, nil
+}
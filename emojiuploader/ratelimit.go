@@ -0,0 +1,56 @@
+package emojiuploader
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RateLimit is the request budget Mattermost reports in the X-Ratelimit-*
+// response headers when rate limiting is enabled on the server
+type RateLimit struct {
+	// Limit is the number of requests allowed per period
+	Limit int
+	// Remaining is the number of requests left in the current period
+	Remaining int
+	// Reset is the time until the budget is refilled
+	Reset time.Duration
+}
+
+// parseRateLimit reads the rate limit headers of a response. It reports false
+// when the server didn't send them.
+func parseRateLimit(h http.Header) (RateLimit, bool) {
+	remaining, err := strconv.Atoi(h.Get("X-Ratelimit-Remaining"))
+	if err != nil {
+		return RateLimit{}, false
+	}
+
+	rl := RateLimit{Remaining: remaining}
+	rl.Limit, _ = strconv.Atoi(h.Get("X-Ratelimit-Limit"))
+	// Mattermost sends the number of seconds until the reset
+	if seconds, err := strconv.Atoi(h.Get("X-Ratelimit-Reset")); err == nil && seconds > 0 {
+		rl.Reset = time.Duration(seconds) * time.Second
+	}
+	return rl, true
+}
+
+// do sends an API request once Limiter allows it and passes the rate limit
+// headers of the response, if any, to OnRateLimit
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	if c.Limiter != nil {
+		if err := c.Limiter.Wait(req.Context()); err != nil {
+			return nil, err
+		}
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.OnRateLimit != nil {
+		if rl, ok := parseRateLimit(resp.Header); ok {
+			c.OnRateLimit(rl)
+		}
+	}
+	return resp, nil
+}
@@ -0,0 +1,109 @@
+package emojiuploader
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestClientLimiter(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Write([]byte(`[]`))
+	}))
+	defer srv.Close()
+
+	const perSecond, burst, requests = 40, 2, 12
+	c := NewClient(srv.URL, "tok", nil)
+	c.Limiter = rate.NewLimiter(perSecond, burst)
+
+	// The workers share the limiter, so together they stay under the rate
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < requests/4; j++ {
+				if _, err := c.EmojiPage(context.Background(), 0, 10); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	if n := calls.Load(); n != requests {
+		t.Fatalf("%d requests, want %d", n, requests)
+	}
+	// The burst goes out at once, the rest one every 1/perSecond
+	minimum := time.Duration(requests-burst) * time.Second / perSecond
+	if elapsed < minimum*9/10 {
+		t.Errorf("%d requests took %v, want at least %v at %d per second", requests, elapsed, minimum, perSecond)
+	}
+}
+
+func TestClientLimiterCancel(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("request sent despite the cancelled wait")
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.URL, "tok", nil)
+	c.Limiter = rate.NewLimiter(rate.Every(time.Hour), 1)
+	c.Limiter.Allow() // spend the only token
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := c.EmojiPage(ctx, 0, 10); err == nil {
+		t.Error("no error when the limiter can't allow the request in time")
+	}
+}
+
+func TestOnRateLimit(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Ratelimit-Limit", "10")
+		w.Header().Set("X-Ratelimit-Remaining", "3")
+		w.Header().Set("X-Ratelimit-Reset", "2")
+		w.Write([]byte(`[]`))
+	}))
+	defer srv.Close()
+
+	var got []RateLimit
+	c := NewClient(srv.URL, "tok", nil)
+	c.OnRateLimit = func(rl RateLimit) { got = append(got, rl) }
+	if _, err := c.EmojiPage(context.Background(), 0, 10); err != nil {
+		t.Fatal(err)
+	}
+	if want := (RateLimit{Limit: 10, Remaining: 3, Reset: 2 * time.Second}); len(got) != 1 || got[0] != want {
+		t.Errorf("OnRateLimit got %+v, want %+v", got, want)
+	}
+}
+
+func TestParseRateLimit(t *testing.T) {
+	tests := []struct {
+		header http.Header
+		want   RateLimit
+		ok     bool
+	}{
+		{http.Header{"X-Ratelimit-Remaining": {"0"}, "X-Ratelimit-Limit": {"10"}, "X-Ratelimit-Reset": {"1"}}, RateLimit{10, 0, time.Second}, true},
+		{http.Header{"X-Ratelimit-Remaining": {"5"}}, RateLimit{Remaining: 5}, true},
+		{http.Header{"X-Ratelimit-Remaining": {"5"}, "X-Ratelimit-Reset": {"soon"}}, RateLimit{Remaining: 5}, true},
+		{http.Header{"X-Ratelimit-Limit": {"10"}}, RateLimit{}, false},
+		{http.Header{}, RateLimit{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseRateLimit(tt.header)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("parseRateLimit(%v) = %+v, %v, want %+v, %v", tt.header, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/formatCvt/mattermost-emoji-uploader/emojiuploader"
+)
+
+// exportMapFile is the name of the emoji map written next to the exported images
+const exportMapFile = "emoji.json"
+
+// runExport downloads every custom emoji on the server into dir and writes an
+// emoji map pointing at the saved files, which can be imported again with -f.
+// It returns the exit code.
+func runExport(ctx context.Context, api *emojiuploader.Client, limiter *uploadLimiter, dir string) int {
+	emojis, err := listEmojis(ctx, api)
+	if err != nil {
+		logError("❌ Error listing existing emojis: %v\n", err)
+		return exitSetup
+	}
+	sort.Slice(emojis, func(i, j int) bool { return emojis[i].Name < emojis[j].Name })
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		logError("❌ Error creating export directory: %v\n", err)
+		return exitSetup
+	}
+
+	logInfo("📦 Exporting %d emojis to %s...\n\n", len(emojis), dir)
+
+	exported := make(EmojiMap, len(emojis))
+	failed := 0
+	for _, emoji := range emojis {
+		limiter.wait(ctx)
+		if ctx.Err() != nil {
+			break
+		}
+
+		prefix := "Exporting: [:" + emoji.Name + ":]... "
+		var data []byte
+		var contentType string
+		err := withRetry(ctx, retries+1, func() error {
+			var err error
+			data, contentType, err = api.EmojiImage(ctx, emoji.ID)
+			return err
+		})
+		if err != nil {
+			logError("%s❌ Download error: %v\n", prefix, err)
+			failed++
+			continue
+		}
+
+		// The extension must match the data, which is more reliable than the header
+		if sniffed := emojiuploader.DetectContentType(data, ""); sniffed != "" {
+			contentType = sniffed
+		}
+
+		// Paths in the map are relative to the map itself, so the folder can be moved
+		ext, _ := emojiuploader.Extension(contentType)
+		file := emoji.Name + ext
+		if err := os.WriteFile(filepath.Join(dir, file), data, 0o644); err != nil {
+			logError("%s❌ Write error: %v\n", prefix, err)
+			failed++
+			continue
+		}
+		exported[emoji.Name] = file
+		logInfo("%s✅ Saved %s (%s)\n", prefix, file, formatSize(len(data)))
+	}
+
+	mapPath := filepath.Join(dir, exportMapFile)
+	if err := writeEmojiMap(mapPath, exported); err != nil {
+		logError("❌ Error writing %s: %v\n", mapPath, err)
+		return exitSetup
+	}
+
+	interrupted := ctx.Err() != nil
+	if interrupted {
+		logSummary("\n🛑 Stopped early: %d of %d emojis were not processed\n", len(emojis)-len(exported)-failed, len(emojis))
+	}
+	logSummary("\n🏁 Done: %d exported, %d failed\n", len(exported), failed)
+	logInfo("📝 Emoji map written to %s\n", mapPath)
+
+	if interrupted {
+		return exitInterrupted
+	}
+	if failed > 0 {
+		return exitFailures
+	}
+	return exitOK
+}
+
+// writeEmojiMap saves emojis as an indented JSON object sorted by name
+func writeEmojiMap(path string, emojis EmojiMap) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(f)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(emojis); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
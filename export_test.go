@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/formatCvt/mattermost-emoji-uploader/emojiuploader"
+)
+
+func TestListEmojisPagination(t *testing.T) {
+	tests := []struct {
+		emojis, pages int
+	}{
+		{0, 1},
+		{3, 1},
+		// A full last page needs one more request to see that it was the last
+		{emojiuploader.MaxPageSize, 2},
+		{2*emojiuploader.MaxPageSize + 50, 3},
+	}
+	for _, tt := range tests {
+		srv := newFakeServer(t)
+		for i := 0; i < tt.emojis; i++ {
+			srv.addEmoji(fmt.Sprintf("emoji%04d", i), pngData)
+		}
+		emojis, err := listEmojis(context.Background(), emojiuploader.NewClient(srv.URL, "tok", nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(emojis) != tt.emojis {
+			t.Errorf("%d emojis: listed %d", tt.emojis, len(emojis))
+		}
+		seen := make(map[string]bool)
+		for _, e := range emojis {
+			if seen[e.Name] {
+				t.Errorf("%d emojis: %s listed twice", tt.emojis, e.Name)
+			}
+			seen[e.Name] = true
+		}
+		if n := srv.requestCount("GET /api/v4/emoji"); n != tt.pages {
+			t.Errorf("%d emojis: %d page requests, want %d", tt.emojis, n, tt.pages)
+		}
+	}
+}
+
+func TestExport(t *testing.T) {
+	srv := newFakeServer(t)
+	gif := []byte("GIF89a\x01\x00\x01\x00\x00\x00\x00;")
+	srv.addEmoji("party", pngData)
+	srv.addEmoji("parrot", gif)
+	dir := filepath.Join(t.TempDir(), "backup")
+
+	code, out := runCLI(t, "-s", srv.URL, "-t", "tok", "--export", dir, "--delay", "0")
+	if code != exitOK {
+		t.Fatalf("exit code %d, output:\n%s", code, out)
+	}
+
+	// The extension follows the image data
+	for file, want := range map[string][]byte{"party.png": pngData, "parrot.gif": gif} {
+		data, err := os.ReadFile(filepath.Join(dir, file))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if string(data) != string(want) {
+			t.Errorf("%s has the wrong contents", file)
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, exportMapFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var emojis EmojiMap
+	if err := json.Unmarshal(data, &emojis); err != nil {
+		t.Fatal(err)
+	}
+	if want := (EmojiMap{"party": "party.png", "parrot": "parrot.gif"}); !reflect.DeepEqual(emojis, want) {
+		t.Errorf("%s = %v, want %v", exportMapFile, emojis, want)
+	}
+}
+
+func TestExportRoundTrip(t *testing.T) {
+	source := newFakeServer(t)
+	source.addEmoji("party", pngData)
+	dir := t.TempDir()
+	if code, out := runCLI(t, "-s", source.URL, "-t", "tok", "--export", dir, "--delay", "0"); code != exitOK {
+		t.Fatalf("export: exit code %d, output:\n%s", code, out)
+	}
+
+	// The exported map uploads the same emojis elsewhere
+	target := newFakeServer(t)
+	code, _, out := runImport(t, target, filepath.Join(dir, exportMapFile))
+	if code != exitOK {
+		t.Fatalf("import: exit code %d, output:\n%s", code, out)
+	}
+	uploads := target.uploaded()
+	if len(uploads) != 1 || uploads[0].Name != "party" || string(uploads[0].Data) != string(pngData) {
+		t.Errorf("re-imported %d emojis, want party with its image", len(uploads))
+	}
+}
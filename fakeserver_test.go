@@ -0,0 +1,400 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeUpload is an emoji created on a fakeServer
+type fakeUpload struct {
+	ID        string
+	Name      string
+	CreatorID string
+	Filename  string
+	Data      []byte
+	Header    http.Header
+}
+
+// fakeServer is a small in-memory Mattermost with the API routes the tool
+// uses. The token user is user1 ("me"), who logs in with the password
+// "secret", svc is a second user for --creator-username, and team t (id t1)
+// has the members listed in members. Images put into images are served at
+// /img/<name>.
+type fakeServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	emojis   map[string]*fakeUpload
+	nextID   int
+	uploads  []*fakeUpload
+	deleted  []string
+	requests []string
+	members  map[string]bool
+	images   map[string][]byte
+	// imageTypes sets the Content-Type of images, detected from the name if unset
+	imageTypes map[string]string
+	// handlers replace the fake's own handling of a path, e.g. to fail a request
+	handlers map[string]http.HandlerFunc
+}
+
+// newFakeServer starts a fakeServer that is shut down when the test ends
+func newFakeServer(t *testing.T) *fakeServer {
+	t.Helper()
+	s := &fakeServer{
+		emojis:     make(map[string]*fakeUpload),
+		members:    map[string]bool{"user1": true},
+		images:     make(map[string][]byte),
+		imageTypes: make(map[string]string),
+		handlers:   make(map[string]http.HandlerFunc),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// addEmoji puts an emoji on the server as if it had been uploaded before
+func (s *fakeServer) addEmoji(name string, data []byte) *fakeUpload {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	e := &fakeUpload{ID: "e" + strconv.Itoa(s.nextID), Name: name, CreatorID: "user1", Data: data}
+	s.emojis[name] = e
+	return e
+}
+
+// handle replaces the handling of path, which may be "METHOD /path" or "/path"
+func (s *fakeServer) handle(path string, h http.HandlerFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[path] = h
+}
+
+// img returns the URL of the image called name, serving data there
+func (s *fakeServer) img(name string, data []byte) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.images[name] = data
+	return s.URL + "/img/" + name
+}
+
+// uploaded returns the emojis created during the test, in order
+func (s *fakeServer) uploaded() []*fakeUpload {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*fakeUpload(nil), s.uploads...)
+}
+
+// requestCount returns how many requests matched "METHOD /path" prefix
+func (s *fakeServer) requestCount(prefix string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, r := range s.requests {
+		if strings.HasPrefix(r, prefix) {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *fakeServer) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+	h := s.handlers[r.Method+" "+r.URL.Path]
+	if h == nil {
+		h = s.handlers[r.URL.Path]
+	}
+	s.mu.Unlock()
+	if h != nil {
+		h(w, r)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := r.URL.Path
+	switch {
+	case strings.HasPrefix(p, "/img/"):
+		data, ok := s.images[strings.TrimPrefix(p, "/img/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if ct := s.imageTypes[strings.TrimPrefix(p, "/img/")]; ct != "" {
+			w.Header().Set("Content-Type", ct)
+		}
+		w.Write(data)
+	case p == "/api/v4/system/ping":
+		fmt.Fprint(w, `{"status":"OK"}`)
+	case p == "/api/v4/users/login" && r.Method == http.MethodPost:
+		var creds struct {
+			LoginID  string `json:"login_id"`
+			Password string `json:"password"`
+		}
+		json.NewDecoder(r.Body).Decode(&creds)
+		if creds.LoginID != "me" || creds.Password != "secret" {
+			writeAppError(w, http.StatusUnauthorized, "api.user.login.invalid_credentials", "Invalid credentials.")
+			return
+		}
+		w.Header().Set("Token", "session-token")
+		fmt.Fprint(w, `{"id":"user1","username":"me"}`)
+	case !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer "):
+		writeAppError(w, http.StatusUnauthorized, "api.context.session_expired.app_error", "Invalid or expired session.")
+	case p == "/api/v4/users/me":
+		fmt.Fprint(w, `{"id":"user1","username":"me","roles":"system_user"}`)
+	case p == "/api/v4/users/me/teams/members":
+		fmt.Fprint(w, `[]`)
+	case p == "/api/v4/users/username/svc", p == "/api/v4/users/svc1":
+		fmt.Fprint(w, `{"id":"svc1","username":"svc"}`)
+	case strings.HasPrefix(p, "/api/v4/users/"):
+		writeAppError(w, http.StatusNotFound, "app.user.missing_account.const", "Unable to find the user.")
+	case p == "/api/v4/roles/names":
+		fmt.Fprint(w, `[{"name":"system_user","permissions":["create_emojis","delete_emojis"]}]`)
+	case p == "/api/v4/teams/name/t":
+		fmt.Fprint(w, `{"id":"t1","name":"t","display_name":"Team T"}`)
+	case strings.HasPrefix(p, "/api/v4/teams/t1/members/"):
+		if !s.members[strings.TrimPrefix(p, "/api/v4/teams/t1/members/")] {
+			writeAppError(w, http.StatusNotFound, "app.team.get_member.missing.app_error", "No team member found.")
+			return
+		}
+		fmt.Fprint(w, `{"team_id":"t1"}`)
+	case strings.HasPrefix(p, "/api/v4/teams/"):
+		writeAppError(w, http.StatusNotFound, "app.team.get_by_name.missing.app_error", "Unable to find the team.")
+	case p == "/api/v4/emoji" && r.Method == http.MethodGet:
+		s.listEmojis(w, r)
+	case p == "/api/v4/emoji" && r.Method == http.MethodPost:
+		s.createEmoji(w, r)
+	case strings.HasPrefix(p, "/api/v4/emoji/name/"):
+		e, ok := s.emojis[strings.TrimPrefix(p, "/api/v4/emoji/name/")]
+		if !ok {
+			writeAppError(w, http.StatusNotFound, "app.emoji.get_by_name.no_result", "No emoji found.")
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id": e.ID, "name": e.Name, "creator_id": e.CreatorID})
+	case strings.HasPrefix(p, "/api/v4/emoji/") && strings.HasSuffix(p, "/image"):
+		id := strings.TrimSuffix(strings.TrimPrefix(p, "/api/v4/emoji/"), "/image")
+		for _, e := range s.emojis {
+			if e.ID == id {
+				w.Write(e.Data)
+				return
+			}
+		}
+		writeAppError(w, http.StatusNotFound, "app.emoji.get.no_result", "No emoji found.")
+	case strings.HasPrefix(p, "/api/v4/emoji/") && r.Method == http.MethodDelete:
+		id := strings.TrimPrefix(p, "/api/v4/emoji/")
+		for name, e := range s.emojis {
+			if e.ID == id {
+				delete(s.emojis, name)
+				s.deleted = append(s.deleted, name)
+				fmt.Fprint(w, `{"status":"OK"}`)
+				return
+			}
+		}
+		writeAppError(w, http.StatusNotFound, "app.emoji.get.no_result", "No emoji found.")
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// listEmojis serves GET /api/v4/emoji sorted by name, one page at a time
+func (s *fakeServer) listEmojis(w http.ResponseWriter, r *http.Request) {
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	perPage, _ := strconv.Atoi(r.URL.Query().Get("per_page"))
+	if perPage <= 0 {
+		perPage = 60
+	}
+	names := make([]string, 0, len(s.emojis))
+	for name := range s.emojis {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	out := []map[string]any{}
+	for i := page * perPage; i < (page+1)*perPage && i < len(names); i++ {
+		e := s.emojis[names[i]]
+		out = append(out, map[string]any{"id": e.ID, "name": e.Name, "creator_id": e.CreatorID, "create_at": 1714564800000})
+	}
+	json.NewEncoder(w).Encode(out)
+}
+
+// createEmoji serves the multipart POST /api/v4/emoji
+func (s *fakeServer) createEmoji(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseMultipartForm(10 << 20); err != nil {
+		writeAppError(w, http.StatusBadRequest, "api.emoji.create.parse.app_error", err.Error())
+		return
+	}
+	var meta struct {
+		Name      string `json:"name"`
+		CreatorID string `json:"creator_id"`
+	}
+	if err := json.Unmarshal([]byte(r.FormValue("emoji")), &meta); err != nil {
+		writeAppError(w, http.StatusBadRequest, "api.emoji.create.parse.app_error", "Invalid emoji JSON.")
+		return
+	}
+	f, fh, err := r.FormFile("image")
+	if err != nil {
+		writeAppError(w, http.StatusBadRequest, "api.emoji.create.no_file.app_error", "No image.")
+		return
+	}
+	defer f.Close()
+	data, _ := io.ReadAll(f)
+	if _, ok := s.emojis[meta.Name]; ok {
+		writeAppError(w, http.StatusBadRequest, "api.emoji.create.duplicate.app_error", "Unable to create emoji. Another emoji with the same name already exists.")
+		return
+	}
+
+	s.nextID++
+	e := &fakeUpload{ID: "e" + strconv.Itoa(s.nextID), Name: meta.Name, CreatorID: meta.CreatorID, Filename: fh.Filename, Data: data, Header: r.Header.Clone()}
+	s.emojis[meta.Name] = e
+	s.uploads = append(s.uploads, e)
+	json.NewEncoder(w).Encode(map[string]string{"id": e.ID, "name": e.Name, "creator_id": e.CreatorID})
+}
+
+// writeAppError answers with a Mattermost JSON error body
+func writeAppError(w http.ResponseWriter, status int, id, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{"id": id, "message": message, "status_code": status})
+}
+
+// runCLI runs the tool with args as its command line, starting from the
+// default of every option, and returns the exit code and everything logged.
+// Tests using it must not run in parallel, as the options are global.
+func runCLI(t *testing.T, args ...string) (int, string) {
+	t.Helper()
+	return runCLIEnv(t, nil, args...)
+}
+
+// runCLIEnv is runCLI with the environment variables in env set, as
+// resetOptions clears those the tool reads
+func runCLIEnv(t *testing.T, env map[string]string, args ...string) (int, string) {
+	t.Helper()
+	resetOptions(t)
+	for key, value := range env {
+		t.Setenv(key, value)
+	}
+	var out syncBuffer
+	logOutput = &out
+
+	oldArgs := os.Args
+	os.Args = append([]string{"mattermost-emoji-uploader"}, args...)
+	defer func() { os.Args = oldArgs }()
+	code := run()
+	return code, out.String()
+}
+
+// captureStdout runs f and returns what it wrote to os.Stdout, where results
+// such as --list and --json-summary go rather than to logOutput
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	var out bytes.Buffer
+	copied := make(chan struct{})
+	go func() {
+		io.Copy(&out, r)
+		close(copied)
+	}()
+	f()
+	w.Close()
+	<-copied
+	r.Close()
+	return out.String()
+}
+
+// syncBuffer is a bytes.Buffer that concurrent workers can log to, as they
+// can to stdout
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// defaultFormats is allowFormats before any flag changed it; Set replaces
+// the map rather than changing it, so it stays intact
+var defaultFormats = allowFormats
+
+// resetOptions restores every flag and the state derived from them, so that
+// one runCLI doesn't leak into the next
+func resetOptions(t *testing.T) {
+	t.Helper()
+	// The repeatable flags add to their value, so they are cleared directly
+	jsonFiles, replaceRules, includePatterns, excludePatterns = nil, nil, nil, nil
+	allowFormats = defaultFormats
+	for key := range imageHeader {
+		delete(imageHeader, key)
+	}
+	flag.CommandLine.VisitAll(func(f *flag.Flag) {
+		if strings.HasPrefix(f.Name, "test.") {
+			return
+		}
+		switch f.Value.(type) {
+		case *fileListFlag, *replaceFlag, *patternFlag, *formatsFlag, headerFlag:
+			return
+		}
+		if err := f.Value.Set(f.DefValue); err != nil {
+			t.Fatalf("resetting -%s: %v", f.Name, err)
+		}
+	})
+	since = time.Time{}
+	nameMap, entrySettings = nil, nil
+	verbosity, jsonLog, progress = levelNormal, nil, nil
+	logOutput = os.Stdout
+	t.Cleanup(func() { logOutput = os.Stdout })
+
+	// A config file in the working directory would be picked up
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	for _, env := range []string{serverURLEnv, tokenEnv, slackTokenEnv, "HTTP_PROXY", "HTTPS_PROXY", "http_proxy", "https_proxy"} {
+		t.Setenv(env, "")
+	}
+}
+
+// writeFile writes data to name in dir and returns the path
+func writeFile(t *testing.T, dir, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
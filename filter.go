@@ -0,0 +1,58 @@
+package main
+
+import (
+	"path"
+	"strings"
+)
+
+// patternFlag collects repeated glob flags, rejecting malformed patterns
+// while the flags are parsed
+type patternFlag []string
+
+func (f *patternFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *patternFlag) Set(pattern string) error {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return err
+	}
+	*f = append(*f, pattern)
+	return nil
+}
+
+// includePatterns and excludePatterns are the --include and --exclude globs
+var includePatterns, excludePatterns patternFlag
+
+// matchesFilter reports whether an original emoji name passes the
+// --include and --exclude patterns. Without --include every name is included.
+func matchesFilter(originalName string) bool {
+	if len(includePatterns) > 0 && !matchAny(includePatterns, originalName) {
+		return false
+	}
+	return !matchAny(excludePatterns, originalName)
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		// The patterns were checked by Set, so errors can't happen here
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// filterJobs drops the jobs whose original names don't pass the filter and
+// returns the rest together with the number dropped
+func filterJobs(jobs []emojiJob) ([]emojiJob, int) {
+	kept := jobs[:0]
+	for _, job := range jobs {
+		if matchesFilter(job.originalName) {
+			kept = append(kept, job)
+		} else {
+			logDebug("🔎 [:%s:] filtered out by --include/--exclude\n", job.originalName)
+		}
+	}
+	return kept, len(jobs) - len(kept)
+}
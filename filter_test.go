@@ -0,0 +1,69 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMatchesFilter(t *testing.T) {
+	names := []string{"cat-happy", "cat-sad", "dog-happy", "parrot"}
+	tests := []struct {
+		name             string
+		include, exclude patternFlag
+		want             []string
+	}{
+		{"no patterns", nil, nil, names},
+		{"include only", patternFlag{"cat-*"}, nil, []string{"cat-happy", "cat-sad"}},
+		{"several includes", patternFlag{"cat-*", "parrot"}, nil, []string{"cat-happy", "cat-sad", "parrot"}},
+		{"exclude only", nil, patternFlag{"*-happy"}, []string{"cat-sad", "parrot"}},
+		{"both", patternFlag{"cat-*"}, patternFlag{"*-sad"}, []string{"cat-happy"}},
+		{"exclude wins", patternFlag{"parrot"}, patternFlag{"parrot"}, nil},
+	}
+	t.Cleanup(func() { includePatterns, excludePatterns = nil, nil })
+	for _, tt := range tests {
+		includePatterns, excludePatterns = tt.include, tt.exclude
+		var got []string
+		for _, name := range names {
+			if matchesFilter(name) {
+				got = append(got, name)
+			}
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%s: matched %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestInvalidPattern(t *testing.T) {
+	var f patternFlag
+	if err := f.Set("cat-[a"); err == nil {
+		t.Error("no error for a malformed glob")
+	}
+	if len(f) != 0 {
+		t.Errorf("malformed glob was kept: %q", f)
+	}
+}
+
+func TestIncludeExcludeFlags(t *testing.T) {
+	srv := newFakeServer(t)
+	file := sourceFile(t,
+		"cat-happy", srv.img("cat-happy.png", pngData),
+		"cat-sad", srv.img("cat-sad.png", pngData),
+		"dog-happy", srv.img("dog-happy.png", pngData),
+	)
+
+	code, report, out := runImport(t, srv, file, "--include", "cat-*", "--exclude", "*-sad")
+	if code != exitOK {
+		t.Fatalf("exit code %d, output:\n%s", code, out)
+	}
+	// Filtered emojis are left out, not counted as failures
+	if s := report.Summary; s.Total != 1 || s.Uploaded != 1 || s.Failed != 0 {
+		t.Errorf("summary = %+v, want only cat-happy", s)
+	}
+	if uploads := srv.uploaded(); len(uploads) != 1 || uploads[0].Name != "cat-happy" {
+		t.Errorf("%d uploads, want only cat-happy", len(uploads))
+	}
+	if !strings.Contains(out, "Skipping 2 of 3 emojis that don't match --include/--exclude") {
+		t.Errorf("output doesn't mention the filtered emojis:\n%s", out)
+	}
+}
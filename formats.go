@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// formatMediaTypes maps the format names accepted by --allow-formats to media types
+var formatMediaTypes = map[string]string{
+	"png":  "image/png",
+	"jpg":  "image/jpeg",
+	"jpeg": "image/jpeg",
+	"gif":  "image/gif",
+	"webp": "image/webp",
+	"svg":  "image/svg+xml",
+	"bmp":  "image/bmp",
+}
+
+// formatsFlag is the set of media types that may be uploaded (--allow-formats).
+// It starts out with the formats Mattermost accepts; setting the flag replaces them.
+type formatsFlag struct {
+	types map[string]bool
+	set   bool
+}
+
+func (f *formatsFlag) String() string {
+	names := make([]string, 0, len(f.types))
+	for t := range f.types {
+		names = append(names, t)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+// Set accepts a comma separated list of format names such as png or media
+// types such as image/png; repeated flags add to the list
+func (f *formatsFlag) Set(value string) error {
+	if !f.set {
+		f.types, f.set = make(map[string]bool), true
+	}
+	for _, name := range strings.Split(value, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if mediaType, ok := formatMediaTypes[name]; ok {
+			name = mediaType
+		} else if !strings.Contains(name, "/") {
+			return fmt.Errorf("unknown format %q", name)
+		}
+		f.types[name] = true
+	}
+	return nil
+}
+
+// allowed reports whether images of the given media type may be uploaded
+func (f *formatsFlag) allowed(mediaType string) bool {
+	return f.types[mediaType]
+}
+
+// allowFormats holds --allow-formats; WebP is left out because it is
+// converted to PNG before the check
+var allowFormats = formatsFlag{types: map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+}}
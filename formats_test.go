@@ -0,0 +1,87 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+var (
+	svgData = []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<svg xmlns="http://www.w3.org/2000/svg" width="16" height="16"><circle cx="8" cy="8" r="8"/></svg>`)
+	bmpData = []byte("BM\x3a\x00\x00\x00\x00\x00\x00\x00\x36\x00\x00\x00\x28\x00\x00\x00\x01\x00\x00\x00\x01\x00\x00\x00\x01\x00\x18\x00\x00\x00\x00\x00\x04\x00\x00\x00\x13\x0b\x00\x00\x13\x0b\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\xff\x00\x00\x00")
+)
+
+func TestFormatsFlag(t *testing.T) {
+	var f formatsFlag
+	for _, v := range []string{"png, JPG", "image/svg+xml"} {
+		if err := f.Set(v); err != nil {
+			t.Fatalf("Set(%q): %v", v, err)
+		}
+	}
+	if got, want := f.String(), "image/jpeg,image/png,image/svg+xml"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+	if f.allowed("image/gif") {
+		t.Error("setting the flag kept the default formats")
+	}
+	if err := f.Set("tiff"); err == nil {
+		t.Error("no error for an unknown format name")
+	}
+}
+
+// oddFormats returns a source file with a PNG, an SVG and a BMP on srv
+func oddFormats(t *testing.T, srv *fakeServer) string {
+	// The server claims they are all PNGs, sniffing has to find out
+	for _, name := range []string{"ok.png", "vector.png", "bitmap.png"} {
+		srv.imageTypes[name] = "image/png"
+	}
+	return sourceFile(t,
+		"ok", srv.img("ok.png", pngData),
+		"vector", srv.img("vector.png", svgData),
+		"bitmap", srv.img("bitmap.png", bmpData),
+	)
+}
+
+func TestUnsupportedFormats(t *testing.T) {
+	srv := newFakeServer(t)
+	code, report, out := runImport(t, srv, oddFormats(t, srv))
+	if code != exitOK {
+		t.Fatalf("exit code %d, output:\n%s", code, out)
+	}
+	results := byName(report)
+	if r := results["ok"]; r.Action != actionUploaded {
+		t.Errorf("ok: %+v, want uploaded", r)
+	}
+	for name, format := range map[string]string{"vector": "image/svg+xml", "bitmap": "image/bmp"} {
+		r := results[name]
+		if r.SkipReason != skipFormat || r.Reason != "unsupported format: "+format {
+			t.Errorf("%s: %+v, want skipped as %s", name, r, format)
+		}
+	}
+	if uploads := srv.uploaded(); len(uploads) != 1 {
+		t.Errorf("%d uploads, want only the PNG", len(uploads))
+	}
+}
+
+func TestAllowFormats(t *testing.T) {
+	srv := newFakeServer(t)
+	code, _, out := runImport(t, srv, oddFormats(t, srv), "--allow-formats", "svg,bmp")
+	if code != exitOK {
+		t.Fatalf("exit code %d, output:\n%s", code, out)
+	}
+	files := make(map[string]string)
+	for _, u := range srv.uploaded() {
+		files[u.Name] = u.Filename
+	}
+	if len(files) != 2 || files["vector"] != "vector.svg" || files["bitmap"] != "bitmap.bmp" {
+		t.Errorf("uploaded %v, want the SVG and BMP with their own extensions", files)
+	}
+	if !strings.Contains(out, "[:ok:]... ⚠️  Skipped (unsupported format: image/png)") {
+		t.Errorf("the PNG wasn't skipped once left out of --allow-formats:\n%s", out)
+	}
+
+	code, _ = runCLI(t, "-s", srv.URL, "-t", "tok", "-f", "x.json", "--allow-formats", "tiff")
+	if code != exitSetup {
+		t.Errorf("exit code %d for an unknown format, want %d", code, exitSetup)
+	}
+}
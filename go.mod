@@ -2,4 +2,14 @@ module github.com/formatCvt/mattermost-emoji-uploader
 
 go 1.22.2
 
-require github.com/mozillazg/go-unidecode v0.2.0
+require (
+	github.com/mozillazg/go-unidecode v0.2.0
+	golang.org/x/image v0.24.0
+	golang.org/x/net v0.35.0
+	golang.org/x/term v0.29.0
+	golang.org/x/text v0.22.0
+	golang.org/x/time v0.10.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require golang.org/x/sys v0.30.0 // indirect
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// hostLimiter caps the number of simultaneous downloads from a single host
+// (--per-host-concurrency), so that many workers don't all hammer one image
+// host while downloads from other hosts go ahead
+type hostLimiter struct {
+	mu    sync.Mutex
+	limit int
+	slots map[string]chan struct{}
+}
+
+func newHostLimiter(limit int) *hostLimiter {
+	return &hostLimiter{limit: limit, slots: make(map[string]chan struct{})}
+}
+
+// acquire waits for a free slot for the host of source and returns the
+// function that releases it. A nil limiter and sources without a host, such
+// as local paths, aren't limited. It fails only when ctx is done first.
+func (l *hostLimiter) acquire(ctx context.Context, source string) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	u, err := url.Parse(source)
+	if err != nil || u.Host == "" {
+		return func() {}, nil
+	}
+	host := strings.ToLower(u.Host)
+
+	l.mu.Lock()
+	slots, ok := l.slots[host]
+	if !ok {
+		slots = make(chan struct{}, l.limit)
+		l.slots[host] = slots
+	}
+	l.mu.Unlock()
+
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, context.Cause(ctx)
+	}
+}
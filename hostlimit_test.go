@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// gauge tracks how many callers are inside a section at once and the most seen
+type gauge struct {
+	cur, peak atomic.Int32
+}
+
+func (g *gauge) enter() {
+	n := g.cur.Add(1)
+	for {
+		peak := g.peak.Load()
+		if n <= peak || g.peak.CompareAndSwap(peak, n) {
+			return
+		}
+	}
+}
+
+func (g *gauge) leave() { g.cur.Add(-1) }
+
+func TestHostLimiter(t *testing.T) {
+	l := newHostLimiter(2)
+	var a, b gauge
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		for _, host := range []struct {
+			source string
+			g      *gauge
+		}{{"https://a.example.com/x.png", &a}, {"https://A.example.com/y.png", &a}, {"https://b.example.com/z.png", &b}} {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				release, err := l.acquire(context.Background(), host.source)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				host.g.enter()
+				time.Sleep(5 * time.Millisecond)
+				host.g.leave()
+				release()
+			}()
+		}
+	}
+	wg.Wait()
+	// Host names are case insensitive, so both spellings share a limit
+	if peak := a.peak.Load(); peak != 2 {
+		t.Errorf("a.example.com: %d at once, want 2", peak)
+	}
+	if peak := b.peak.Load(); peak != 2 {
+		t.Errorf("b.example.com: %d at once, want 2", peak)
+	}
+}
+
+func TestHostLimiterUnlimited(t *testing.T) {
+	var l *hostLimiter
+	release, err := l.acquire(context.Background(), "https://example.com/x.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+
+	l = newHostLimiter(1)
+	for i := 0; i < 3; i++ {
+		// Local files have no host and are never held up
+		if _, err := l.acquire(context.Background(), "images/party.png"); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestHostLimiterCancel(t *testing.T) {
+	l := newHostLimiter(1)
+	if _, err := l.acquire(context.Background(), "https://example.com/a.png"); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := l.acquire(ctx, "https://example.com/b.png"); err == nil {
+		t.Error("acquired a second slot with a limit of 1")
+	}
+}
+
+// slowImageHost serves pngData slowly and records the simultaneous requests
+func slowImageHost(t *testing.T, g *gauge) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		g.enter()
+		defer g.leave()
+		time.Sleep(20 * time.Millisecond)
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(pngData)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestPerHostConcurrency(t *testing.T) {
+	srv := newFakeServer(t)
+	var slow, fast gauge
+	slowHost, fastHost := slowImageHost(t, &slow), slowImageHost(t, &fast)
+	var pairs []string
+	for i := 0; i < 6; i++ {
+		pairs = append(pairs,
+			fmt.Sprintf("slow%d", i), fmt.Sprintf("%s/%d.png", slowHost.URL, i),
+			fmt.Sprintf("fast%d", i), fmt.Sprintf("%s/%d.png", fastHost.URL, i))
+	}
+
+	code, _, out := runImport(t, srv, sourceFile(t, pairs...), "--concurrency", "6", "--per-host-concurrency", "2")
+	if code != exitOK {
+		t.Fatalf("exit code %d, output:\n%s", code, out)
+	}
+	if n := len(srv.uploaded()); n != 12 {
+		t.Errorf("%d uploads, want 12", n)
+	}
+	if peak := slow.peak.Load(); peak > 2 {
+		t.Errorf("%d simultaneous downloads from one host, want at most 2", peak)
+	}
+	if peak := fast.peak.Load(); peak > 2 {
+		t.Errorf("%d simultaneous downloads from the other host, want at most 2", peak)
+	}
+	// Each host gets its own slots, so the workers download from both at once
+	if slow.peak.Load() < 2 && fast.peak.Load() < 2 {
+		t.Errorf("at most %d and %d downloads at once, want the limit used", slow.peak.Load(), fast.peak.Load())
+	}
+}
+
+func TestInvalidPerHostConcurrency(t *testing.T) {
+	if code, _ := runCLI(t, "-s", "http://localhost", "-t", "tok", "-f", "x.json", "--per-host-concurrency", "-1"); code != exitSetup {
+		t.Errorf("exit code %d, want %d", code, exitSetup)
+	}
+}
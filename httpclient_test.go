@@ -0,0 +1,501 @@
+package main
+
+import (
+	"encoding/pem"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHTTPClientTimeout(t *testing.T) {
+	client, err := newHTTPClient(httpOptions{timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.Timeout != 5*time.Second {
+		t.Errorf("Timeout = %v, want 5s", client.Timeout)
+	}
+}
+
+func TestTimeoutFlag(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.handle("/img/slow.png", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(2 * time.Second):
+		case <-r.Context().Done():
+		}
+		w.Write(pngData)
+	})
+	file := sourceFile(t, "slow", srv.URL+"/img/slow.png")
+
+	start := time.Now()
+	_, report, out := runImport(t, srv, file, "--timeout", "100ms", "--retries", "0")
+	// Depending on when the deadline hits, net/http reports it with or
+	// without its "Client.Timeout exceeded" note
+	if r := byName(report)["slow"]; r.Action != actionFailed || !strings.Contains(r.Reason, "Timeout") && !strings.Contains(r.Reason, "deadline exceeded") {
+		t.Errorf("slow: %+v, want a timeout\n%s", r, out)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("the run took %v, the --timeout of 100ms wasn't applied", elapsed)
+	}
+}
+
+// tlsServer starts an HTTPS server with a self-signed certificate and writes
+// that certificate to a PEM file, returning both
+func tlsServer(t *testing.T) (*httptest.Server, string) {
+	t.Helper()
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	t.Cleanup(srv.Close)
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	return srv, writeFile(t, t.TempDir(), "ca.pem", cert)
+}
+
+func TestHTTPClientTLS(t *testing.T) {
+	srv, caPath := tlsServer(t)
+	tests := []struct {
+		name    string
+		opts    httpOptions
+		wantErr bool
+	}{
+		{"system roots", httpOptions{}, true},
+		{"custom CA", httpOptions{caCertPath: caPath}, false},
+		{"insecure", httpOptions{insecure: true}, false},
+	}
+	for _, tt := range tests {
+		client, err := newHTTPClient(tt.opts)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		resp, err := client.Get(srv.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: err = %v, want error: %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestHTTPClientInvalidCA(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := newHTTPClient(httpOptions{caCertPath: filepath.Join(dir, "missing.pem")}); err == nil {
+		t.Error("no error for a missing CA file")
+	}
+	path := writeFile(t, dir, "ca.pem", []byte("not a certificate"))
+	if _, err := newHTTPClient(httpOptions{caCertPath: path}); err == nil || !strings.Contains(err.Error(), "no PEM certificates") {
+		t.Errorf("err = %v, want no PEM certificates", err)
+	}
+}
+
+// baseTransport returns the *http.Transport below the wrappers of newHTTPClient
+func baseTransport(t *testing.T, client *http.Client) *http.Transport {
+	t.Helper()
+	rt := client.Transport
+	for {
+		switch wrapper := rt.(type) {
+		case *userAgentTransport:
+			rt = wrapper.base
+		case *bandwidthTransport:
+			rt = wrapper.base
+		case *http.Transport:
+			return wrapper
+		default:
+			t.Fatalf("unexpected transport %T", rt)
+		}
+	}
+}
+
+func TestHTTPClientProxy(t *testing.T) {
+	client, err := newHTTPClient(httpOptions{proxyURL: "http://proxy.example.com:3128"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, "https://emoji.example.com/party.png", nil)
+	proxy, err := baseTransport(t, client).Proxy(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if proxy == nil || proxy.String() != "http://proxy.example.com:3128" {
+		t.Errorf("proxy = %v, want the --proxy URL", proxy)
+	}
+}
+
+func TestHTTPClientUsesProxy(t *testing.T) {
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// A request to a proxy carries the absolute URL of the target
+		proxied = append(proxied, r.URL.String())
+		w.Write(pngData)
+	}))
+	defer proxy.Close()
+
+	client, err := newHTTPClient(httpOptions{proxyURL: proxy.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Get("http://emoji.invalid/party.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if len(proxied) != 1 || proxied[0] != "http://emoji.invalid/party.png" {
+		t.Errorf("proxy received %q, want the request for emoji.invalid", proxied)
+	}
+}
+
+func TestParseProxyURL(t *testing.T) {
+	for _, value := range []string{"http://proxy:3128", "https://proxy", "socks5://user:pw@proxy:1080", "socks5h://proxy:1080"} {
+		if _, err := parseProxyURL(value); err != nil {
+			t.Errorf("parseProxyURL(%q): %v", value, err)
+		}
+	}
+	for _, value := range []string{"proxy:3128", "ftp://proxy", "http://", "://proxy"} {
+		if _, err := parseProxyURL(value); err == nil {
+			t.Errorf("parseProxyURL(%q): no error", value)
+		}
+	}
+	if _, err := newHTTPClient(httpOptions{proxyURL: "ftp://proxy"}); err == nil {
+		t.Error("newHTTPClient accepted an invalid --proxy")
+	}
+}
+
+// redirectServer redirects /r/N to /r/N-1 and serves a PNG at /r/0
+func redirectServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/r/"))
+		switch {
+		case err != nil:
+			http.NotFound(w, r)
+		case n == 0:
+			w.Write(pngData)
+		default:
+			http.Redirect(w, r, "/r/"+strconv.Itoa(n-1), http.StatusFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestMaxRedirects(t *testing.T) {
+	srv := redirectServer(t)
+	client, err := newHTTPClient(httpOptions{maxRedirects: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := client.Get(srv.URL + "/r/3")
+	if err != nil {
+		t.Fatalf("3 redirects: %v", err)
+	}
+	resp.Body.Close()
+	if resp.Request.URL.Path != "/r/0" {
+		t.Errorf("ended at %s, want /r/0", resp.Request.URL)
+	}
+
+	_, err = client.Get(srv.URL + "/r/4")
+	if !errors.Is(err, errTooManyRedirects) {
+		t.Errorf("4 redirects: err = %v, want errTooManyRedirects", err)
+	}
+}
+
+func TestMaxRedirectsFlag(t *testing.T) {
+	redirects := redirectServer(t)
+	srv := newFakeServer(t)
+	file := sourceFile(t, "near", redirects.URL+"/r/2", "far", redirects.URL+"/r/5")
+
+	_, report, out := runImport(t, srv, file, "--max-redirects", "2", "-v")
+	results := byName(report)
+	if r := results["near"]; r.Action != actionUploaded {
+		t.Errorf("near: %+v, want uploaded", r)
+	}
+	if r := results["far"]; r.Action != actionFailed || !strings.Contains(r.Reason, "too many redirects") {
+		t.Errorf("far: %+v, want too many redirects", r)
+	}
+	// Verbose output names where the redirects lead
+	if !strings.Contains(out, "redirected to "+redirects.URL+"/r/0") {
+		t.Errorf("the final URL isn't logged:\n%s", out)
+	}
+}
+
+func TestHTTPClientTransportSettings(t *testing.T) {
+	client, err := newHTTPClient(httpOptions{maxConnsPerHost: 4, maxIdleConnsPerHost: 200})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := baseTransport(t, client)
+	if tr.DisableKeepAlives {
+		t.Error("keep-alives are disabled")
+	}
+	if tr.MaxConnsPerHost != 4 || tr.MaxIdleConnsPerHost != 200 {
+		t.Errorf("MaxConnsPerHost = %d, MaxIdleConnsPerHost = %d, want 4 and 200", tr.MaxConnsPerHost, tr.MaxIdleConnsPerHost)
+	}
+	if tr.MaxIdleConns < 200 {
+		t.Errorf("MaxIdleConns = %d, which would cap the idle connections per host", tr.MaxIdleConns)
+	}
+
+	// Without the options the defaults of net/http apply
+	client, err = newHTTPClient(httpOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr = baseTransport(t, client)
+	def := http.DefaultTransport.(*http.Transport)
+	if tr.MaxConnsPerHost != 0 || tr.MaxIdleConnsPerHost != def.MaxIdleConnsPerHost || tr.MaxIdleConns != def.MaxIdleConns {
+		t.Errorf("defaults changed: %d, %d, %d", tr.MaxConnsPerHost, tr.MaxIdleConnsPerHost, tr.MaxIdleConns)
+	}
+}
+
+// connCountingServer counts the connections made to it and the most that
+// were active at the same time; every request takes delay
+func connCountingServer(t *testing.T, delay time.Duration) (*httptest.Server, func() (int, int)) {
+	var mu sync.Mutex
+	var opened, active, peak int
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delay)
+		w.Write([]byte("ok"))
+	}))
+	srv.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch state {
+		case http.StateNew:
+			opened++
+			active++
+			peak = max(peak, active)
+		case http.StateClosed, http.StateHijacked:
+			active--
+		}
+	}
+	srv.Start()
+	t.Cleanup(srv.Close)
+	return srv, func() (int, int) {
+		mu.Lock()
+		defer mu.Unlock()
+		return opened, peak
+	}
+}
+
+func TestHTTPClientReusesConnections(t *testing.T) {
+	srv, counts := connCountingServer(t, 0)
+	client, err := newHTTPClient(httpOptions{timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	if opened, _ := counts(); opened != 1 {
+		t.Errorf("%d connections for 20 requests, want 1 kept alive", opened)
+	}
+}
+
+func TestHTTPClientMaxConns(t *testing.T) {
+	srv, counts := connCountingServer(t, 20*time.Millisecond)
+	client, err := newHTTPClient(httpOptions{timeout: 5 * time.Second, maxConnsPerHost: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Get(srv.URL)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}()
+	}
+	wg.Wait()
+	if _, peak := counts(); peak > 2 {
+		t.Errorf("%d simultaneous connections, want at most 2", peak)
+	}
+}
+
+func TestInvalidConnectionFlags(t *testing.T) {
+	for _, flag := range []string{"--max-conns", "--max-idle-conns"} {
+		srv := newFakeServer(t)
+		if code, _, out := runImport(t, srv, sourceFile(t, "x", srv.img("x.png", pngData)), flag, "-1"); code != exitSetup {
+			t.Errorf("%s -1: exit code %d, want %d\n%s", flag, code, exitSetup, out)
+		}
+	}
+}
+
+// socksServer is a SOCKS5 proxy without authentication that connects the
+// host emoji.invalid to target. It returns its URL and the hosts clients
+// asked it to connect to.
+func socksServer(t *testing.T, target string) (string, func() []string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	var mu sync.Mutex
+	var hosts []string
+	serve := func(conn net.Conn) {
+		defer conn.Close()
+		buf := make([]byte, 256)
+		// Greeting: version, number of methods and the methods
+		if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+			return
+		}
+		if _, err := io.ReadFull(conn, buf[:buf[1]]); err != nil {
+			return
+		}
+		conn.Write([]byte{5, 0})
+		// Request: version, CONNECT, reserved, address type, address and port
+		if _, err := io.ReadFull(conn, buf[:4]); err != nil {
+			return
+		}
+		var host string
+		switch buf[3] {
+		case 1:
+			io.ReadFull(conn, buf[:4])
+			host = net.IP(buf[:4]).String()
+		case 3:
+			io.ReadFull(conn, buf[:1])
+			n := int(buf[0])
+			io.ReadFull(conn, buf[:n])
+			host = string(buf[:n])
+		default:
+			return
+		}
+		if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+			return
+		}
+		mu.Lock()
+		hosts = append(hosts, host)
+		mu.Unlock()
+
+		addr := net.JoinHostPort(host, strconv.Itoa(int(buf[0])<<8|int(buf[1])))
+		if host == "emoji.invalid" {
+			addr = target
+		}
+		upstream, err := net.Dial("tcp", addr)
+		if err != nil {
+			conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+			return
+		}
+		defer upstream.Close()
+		conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+		go io.Copy(upstream, conn)
+		io.Copy(conn, upstream)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serve(conn)
+		}
+	}()
+	return "socks5://" + ln.Addr().String(), func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), hosts...)
+	}
+}
+
+func TestSocksDialer(t *testing.T) {
+	for _, value := range []string{"socks5://proxy:1080", "socks5h://user:pw@proxy:1080"} {
+		if _, err := socksDialer(value); err != nil {
+			t.Errorf("socksDialer(%q): %v", value, err)
+		}
+	}
+	for _, value := range []string{"http://proxy:3128", "proxy:1080", "socks5://", "socks4://proxy:1080", "socks5://proxy:1080/%zz"} {
+		if _, err := socksDialer(value); err == nil {
+			t.Errorf("socksDialer(%q): no error", value)
+		}
+	}
+}
+
+func TestHTTPClientUsesSocksProxy(t *testing.T) {
+	images := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(pngData)
+	}))
+	defer images.Close()
+	proxyURL, hosts := socksServer(t, images.Listener.Addr().String())
+
+	client, err := newHTTPClient(httpOptions{socksProxy: proxyURL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// emoji.invalid only resolves on the proxy
+	resp, err := client.Get("http://emoji.invalid/party.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != string(pngData) {
+		t.Errorf("body = %q, want the image", body)
+	}
+	if got := hosts(); len(got) != 1 || got[0] != "emoji.invalid" {
+		t.Errorf("proxy connected to %q, want emoji.invalid", got)
+	}
+}
+
+func TestDownloadProxyFlag(t *testing.T) {
+	srv := newFakeServer(t)
+	proxyURL, hosts := socksServer(t, srv.Listener.Addr().String())
+	_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+	srv.img("party.png", pngData)
+	srv.img("wave.png", pngData)
+	file := sourceFile(t,
+		"party", "http://emoji.invalid:"+port+"/img/party.png",
+		"wave", "http://emoji.invalid:"+port+"/img/wave.png",
+	)
+
+	code, _, out := runImport(t, srv, file, "--download-proxy", proxyURL)
+	if code != exitOK {
+		t.Fatalf("exit code %d, output:\n%s", code, out)
+	}
+	if n := len(srv.uploaded()); n != 2 {
+		t.Errorf("%d uploads, want 2\n%s", n, out)
+	}
+	// The API calls go straight to srv, so only the image hosts reach the proxy
+	got := hosts()
+	if len(got) == 0 {
+		t.Fatal("no connection through the proxy")
+	}
+	for _, host := range got {
+		if host != "emoji.invalid" {
+			t.Errorf("proxy connected to %q, want only the image host", host)
+		}
+	}
+}
+
+func TestInvalidDownloadProxy(t *testing.T) {
+	srv := newFakeServer(t)
+	file := sourceFile(t, "party", srv.img("party.png", pngData))
+	for _, value := range []string{"http://proxy:3128", "socks5://"} {
+		if code, _, out := runImport(t, srv, file, "--download-proxy", value); code != exitSetup {
+			t.Errorf("--download-proxy %s: exit code %d, want %d\n%s", value, code, exitSetup, out)
+		}
+	}
+	if n := len(srv.uploaded()); n != 0 {
+		t.Errorf("%d uploads with an invalid proxy", n)
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	_ "image/jpeg"
+	"image/png"
+)
+
+// resizeMaxDimension is the longest side of a downscaled image in pixels.
+// Emojis render at around 64px, so this keeps enough detail for HiDPI screens.
+const resizeMaxDimension = 128
+
+// isAnimatedGIF reports whether data is a GIF with more than one frame.
+// Animated GIFs are uploaded byte for byte unless --truncate-gifs shortens
+// them, since resizing or padding would keep only the first frame. A GIF
+// that can't be fully decoded counts as animated too, as a single frame
+// can't be ruled out.
+func isAnimatedGIF(data []byte) bool {
+	if !bytes.HasPrefix(data, []byte("GIF8")) {
+		return false
+	}
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return true
+	}
+	return len(g.Image) > 1
+}
+
+// gifFrames returns the number of frames of a GIF
+func gifFrames(data []byte) (int, error) {
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+	return len(g.Image), nil
+}
+
+// truncateGIF keeps the first n frames of an animated GIF and re-encodes it.
+// The loop count, the global palette and the delay and disposal of every kept
+// frame stay as they were, so the animation plays the same until it is cut.
+func truncateGIF(data []byte, n int) ([]byte, error) {
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("decode: %w", err)
+	}
+	if len(g.Image) <= n {
+		return data, nil
+	}
+	g.Image, g.Delay = g.Image[:n], g.Delay[:n]
+	if len(g.Disposal) > n {
+		g.Disposal = g.Disposal[:n]
+	}
+
+	buf := &bytes.Buffer{}
+	if err := gif.EncodeAll(buf, g); err != nil {
+		return nil, fmt.Errorf("encode: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// sizeLimit returns the maximum accepted size in bytes for an image.
+// Animated GIFs get a larger allowance than static images, as in Mattermost.
+func sizeLimit(animated bool) int {
+	if animated {
+		return maxGIFSizeKB * 1024
+	}
+	return maxSizeKB * 1024
+}
+
+// formatSize renders a byte count in kilobytes for log messages
+func formatSize(n int) string {
+	return fmt.Sprintf("%dKB", (n+1023)/1024)
+}
+
+// formatBytes renders a byte count with a binary unit for the summary, e.g. 42.3 MB
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	value, prefix := float64(n)/unit, 0
+	for value >= unit && prefix < 3 {
+		value /= unit
+		prefix++
+	}
+	return fmt.Sprintf("%.1f %cB", value, "KMGT"[prefix])
+}
+
+// resizeImage decodes a static image, scales it down so that its longest side
+// is at most maxDimension pixels and re-encodes it as PNG
+func resizeImage(data []byte, maxDimension int) ([]byte, error) {
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("decode: %w", err)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, scaleDown(src, maxDimension)); err != nil {
+		return nil, fmt.Errorf("encode: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// scaleDown proportionally shrinks src to fit in a maxDimension square using
+// area averaging. Images that already fit are returned unchanged.
+func scaleDown(src image.Image, maxDimension int) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= maxDimension && h <= maxDimension {
+		return src
+	}
+
+	dw, dh := maxDimension, maxDimension
+	if w > h {
+		dh = max(1, h*maxDimension/w)
+	} else {
+		dw = max(1, w*maxDimension/h)
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		y0, y1 := b.Min.Y+y*h/dh, b.Min.Y+(y+1)*h/dh
+		for x := 0; x < dw; x++ {
+			x0, x1 := b.Min.X+x*w/dw, b.Min.X+(x+1)*w/dw
+
+			// Average the premultiplied colors of the source pixels covered by this one
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, bl, a = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			if n == 0 || a == 0 {
+				continue
+			}
+			dst.Set(x, y, color.RGBA64{
+				R: uint16(r / n),
+				G: uint16(g / n),
+				B: uint16(bl / n),
+				A: uint16(a / n),
+			})
+		}
+	}
+	return dst
+}
+
+// imageDimensions returns the width and height of an image without decoding its pixels
+func imageDimensions(data []byte) (int, int, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return 0, 0, err
+	}
+	return cfg.Width, cfg.Height, nil
+}
+
+// aspectRatio returns the ratio of the longer side to the shorter one, so
+// that a square is 1 and wide and tall images compare the same way
+func aspectRatio(w, h int) float64 {
+	if w == 0 || h == 0 {
+		return 0
+	}
+	return float64(max(w, h)) / float64(min(w, h))
+}
+
+// padToSquare decodes a static image, centers it on a transparent square
+// canvas as wide as its longer side and re-encodes it as PNG
+func padToSquare(data []byte) ([]byte, error) {
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("decode: %w", err)
+	}
+
+	b := src.Bounds()
+	side := max(b.Dx(), b.Dy())
+	dst := image.NewNRGBA(image.Rect(0, 0, side, side))
+	offset := image.Pt((side-b.Dx())/2, (side-b.Dy())/2)
+	draw.Draw(dst, b.Sub(b.Min).Add(offset), src, b.Min, draw.Src)
+
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, dst); err != nil {
+		return nil, fmt.Errorf("encode: %w", err)
+	}
+	return buf.Bytes(), nil
+}
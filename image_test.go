@@ -0,0 +1,439 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestSizeLimit(t *testing.T) {
+	if got := sizeLimit(false); got != 512*1024 {
+		t.Errorf("static limit = %d, want 512KB", got)
+	}
+	if got := sizeLimit(true); got != 1024*1024 {
+		t.Errorf("animated limit = %d, want 1024KB", got)
+	}
+}
+
+func TestSizeLimitDecision(t *testing.T) {
+	srv := newFakeServer(t)
+	large := noisyPNG(t, 100, 100)
+	if len(large) <= 20*1024 {
+		t.Fatalf("test image is only %d bytes", len(large))
+	}
+	file := sourceFile(t,
+		"small", srv.img("small.png", pngData),
+		"large", srv.img("large.png", large),
+	)
+
+	code, report, out := runImport(t, srv, file, "--max-size", "20")
+	if code != exitOK {
+		t.Fatalf("exit code %d, output:\n%s", code, out)
+	}
+	results := byName(report)
+	if r := results["small"]; r.Action != actionUploaded {
+		t.Errorf("small: %+v, want uploaded", r)
+	}
+	if r := results["large"]; r.Action != actionSkipped || !strings.HasPrefix(r.Reason, "too large") {
+		t.Errorf("large: %+v, want skipped as too large", r)
+	}
+	// The oversized image is never sent
+	if uploads := srv.uploaded(); len(uploads) != 1 || uploads[0].Name != "small" {
+		t.Errorf("%d uploads, want only small", len(uploads))
+	}
+}
+
+func TestResizeImage(t *testing.T) {
+	tests := []struct {
+		w, h, wantW, wantH int
+	}{
+		{400, 400, 128, 128},
+		{300, 150, 128, 64},
+		{150, 300, 64, 128},
+		{1000, 3, 128, 1},
+		// Images that already fit keep their size
+		{100, 50, 100, 50},
+	}
+	for _, tt := range tests {
+		resized, err := resizeImage(solidPNG(t, tt.w, tt.h), resizeMaxDimension)
+		if err != nil {
+			t.Fatalf("%dx%d: %v", tt.w, tt.h, err)
+		}
+		w, h, err := imageDimensions(resized)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w != tt.wantW || h != tt.wantH {
+			t.Errorf("%dx%d resized to %dx%d, want %dx%d", tt.w, tt.h, w, h, tt.wantW, tt.wantH)
+		}
+	}
+}
+
+func TestResizeOversizedImage(t *testing.T) {
+	srv := newFakeServer(t)
+	large := noisyPNG(t, 450, 450)
+	if len(large) <= 512*1024 {
+		t.Fatalf("test image is only %d bytes", len(large))
+	}
+	file := sourceFile(t, "large", srv.img("large.png", large))
+
+	code, report, out := runImport(t, srv, file, "--resize")
+	if code != exitOK {
+		t.Fatalf("exit code %d, output:\n%s", code, out)
+	}
+	uploads := srv.uploaded()
+	if len(uploads) != 1 {
+		t.Fatalf("%d uploads, want the resized image\n%s", len(uploads), out)
+	}
+	if len(uploads[0].Data) > 512*1024 {
+		t.Errorf("uploaded %d bytes, want at most 512KB", len(uploads[0].Data))
+	}
+	if w, h, err := imageDimensions(uploads[0].Data); err != nil || w != resizeMaxDimension || h != resizeMaxDimension {
+		t.Errorf("uploaded a %dx%d image (%v), want %dx%[4]d", w, h, err, resizeMaxDimension)
+	}
+
+	// The output shows the size before and after
+	want := "resized " + formatSize(len(large)) + " -> " + formatSize(len(uploads[0].Data))
+	if r := byName(report)["large"]; r.Reason != want {
+		t.Errorf("reason = %q, want %q", r.Reason, want)
+	}
+	if !strings.Contains(out, want) {
+		t.Errorf("output doesn't mention %q:\n%s", want, out)
+	}
+}
+
+func TestAspectRatio(t *testing.T) {
+	tests := []struct {
+		w, h int
+		want float64
+	}{
+		{64, 64, 1},
+		{128, 32, 4},
+		{32, 128, 4},
+		{0, 10, 0},
+	}
+	for _, tt := range tests {
+		if got := aspectRatio(tt.w, tt.h); got != tt.want {
+			t.Errorf("aspectRatio(%d, %d) = %v, want %v", tt.w, tt.h, got, tt.want)
+		}
+	}
+}
+
+func TestPadToSquare(t *testing.T) {
+	for _, size := range [][2]int{{40, 10}, {10, 40}, {20, 20}} {
+		padded, err := padToSquare(solidPNG(t, size[0], size[1]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err := png.Decode(bytes.NewReader(padded))
+		if err != nil {
+			t.Fatal(err)
+		}
+		side := max(size[0], size[1])
+		if b := img.Bounds(); b.Dx() != side || b.Dy() != side {
+			t.Errorf("%dx%d padded to %dx%d, want %dx%[3]d", size[0], size[1], b.Dx(), b.Dy(), side)
+		}
+		// The image is centered on a transparent canvas
+		if _, _, _, a := img.At(side/2, side/2).RGBA(); a == 0 {
+			t.Errorf("%dx%d: the center is transparent", size[0], size[1])
+		}
+		if size[0] != size[1] {
+			if _, _, _, a := img.At(0, 0).RGBA(); a != 0 {
+				t.Errorf("%dx%d: the corner isn't transparent", size[0], size[1])
+			}
+		}
+	}
+}
+
+// aspectSources returns a source file with a square, a wide and a tall image on srv
+func aspectSources(t *testing.T, srv *fakeServer) string {
+	return sourceFile(t,
+		"square", srv.img("square.png", solidPNG(t, 32, 32)),
+		"wide", srv.img("wide.png", solidPNG(t, 128, 32)),
+		"tall", srv.img("tall.png", solidPNG(t, 32, 96)),
+	)
+}
+
+func TestMaxAspect(t *testing.T) {
+	srv := newFakeServer(t)
+	code, report, out := runImport(t, srv, aspectSources(t, srv), "--max-aspect", "2")
+	if code != exitOK {
+		t.Fatalf("exit code %d, output:\n%s", code, out)
+	}
+	results := byName(report)
+	if r := results["square"]; r.Action != actionUploaded {
+		t.Errorf("square: %+v, want uploaded", r)
+	}
+	for _, name := range []string{"wide", "tall"} {
+		if r := results[name]; r.Action != actionSkipped || !strings.HasPrefix(r.Reason, "aspect ratio") {
+			t.Errorf("%s: %+v, want skipped for its aspect ratio", name, r)
+		}
+	}
+}
+
+func TestPadSquare(t *testing.T) {
+	srv := newFakeServer(t)
+	code, _, out := runImport(t, srv, aspectSources(t, srv), "--pad-square")
+	if code != exitOK {
+		t.Fatalf("exit code %d, output:\n%s", code, out)
+	}
+	sides := map[string]int{"square": 32, "wide": 128, "tall": 96}
+	uploads := srv.uploaded()
+	if len(uploads) != len(sides) {
+		t.Fatalf("%d uploads, want %d", len(uploads), len(sides))
+	}
+	for _, u := range uploads {
+		w, h, err := imageDimensions(u.Data)
+		if err != nil || w != sides[u.Name] || h != sides[u.Name] {
+			t.Errorf("%s uploaded as %dx%d (%v), want %dx%[5]d", u.Name, w, h, err, sides[u.Name])
+		}
+	}
+}
+
+func animatedGIF(t *testing.T, w, h, frames int) []byte {
+	t.Helper()
+	palette := color.Palette{color.Black, color.White, color.NRGBA{200, 30, 30, 255}}
+	anim := &gif.GIF{}
+	for i := 0; i < frames; i++ {
+		frame := image.NewPaletted(image.Rect(0, 0, w, h), palette)
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				frame.SetColorIndex(x, y, uint8((x+y+i)%len(palette)))
+			}
+		}
+		anim.Image = append(anim.Image, frame)
+		anim.Delay = append(anim.Delay, 10)
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, anim); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestMinDimension(t *testing.T) {
+	srv := newFakeServer(t)
+	file := sourceFile(t, "big", srv.img("big.png", solidPNG(t, 32, 32)), "thin", srv.img("thin.png", solidPNG(t, 32, 4)))
+
+	code, report, out := runImport(t, srv, file, "--min-dimension", "16")
+	if code != exitOK {
+		t.Fatalf("exit code %d, output:\n%s", code, out)
+	}
+	results := byName(report)
+	if r := results["big"]; r.Action != actionUploaded {
+		t.Errorf("big: %+v, want uploaded", r)
+	}
+	if r := results["thin"]; r.SkipReason != skipTooSmall {
+		t.Errorf("thin: %+v, want skipped as too small", r)
+	}
+}
+
+func TestMinSize(t *testing.T) {
+	srv := newFakeServer(t)
+	tiny := []byte("GIF89a\x01\x00\x01\x00\x00\x00\x00;")
+	file := sourceFile(t,
+		"pixel", srv.img("pixel.gif", tiny),
+		"dot", srv.img("dot.png", pngData),
+		"big", srv.img("big.png", solidPNG(t, 32, 32)),
+	)
+
+	code, report, out := runImport(t, srv, file, "--min-size", "50")
+	if code != exitOK {
+		t.Fatalf("exit code %d, output:\n%s", code, out)
+	}
+	results := byName(report)
+	want := fmt.Sprintf("too small: %d bytes < 50, probably not a real image", len(tiny))
+	if r := results["pixel"]; r.SkipReason != skipTooSmall || r.Reason != want {
+		t.Errorf("pixel: %+v, want skipped with %q", r, want)
+	}
+	for _, name := range []string{"dot", "big"} {
+		if r := results[name]; r.Action != actionUploaded {
+			t.Errorf("%s: %+v, want uploaded", name, r)
+		}
+	}
+
+	// The 1x1 PNG is large enough in bytes but not in pixels
+	srv = newFakeServer(t)
+	file = sourceFile(t, "dot", srv.img("dot.png", pngData), "big", srv.img("big.png", solidPNG(t, 32, 32)))
+	code, report, out = runImport(t, srv, file, "--min-size", "50", "--min-dimension", "8")
+	if code != exitOK {
+		t.Fatalf("exit code %d, output:\n%s", code, out)
+	}
+	if r := byName(report)["dot"]; r.SkipReason != skipTooSmall {
+		t.Errorf("dot: %+v, want skipped as too small", r)
+	}
+	if uploads := srv.uploaded(); len(uploads) != 1 || uploads[0].Name != "big" {
+		t.Errorf("%d uploads, want only big", len(uploads))
+	}
+}
+
+func TestInvalidMinSize(t *testing.T) {
+	if code, _ := runCLI(t, "-s", "http://localhost", "-t", "tok", "-f", "x.json", "--min-size", "-1"); code != exitSetup {
+		t.Errorf("exit code %d for a negative --min-size, want %d", code, exitSetup)
+	}
+}
+
+func TestIsAnimatedGIF(t *testing.T) {
+	if !isAnimatedGIF(animatedGIF(t, 8, 8, 3)) {
+		t.Error("isAnimatedGIF = false for 3 frames")
+	}
+	if isAnimatedGIF(animatedGIF(t, 8, 8, 1)) {
+		t.Error("isAnimatedGIF = true for a single frame")
+	}
+	if isAnimatedGIF(pngData) {
+		t.Error("isAnimatedGIF = true for a PNG")
+	}
+}
+
+func TestAnimatedGIFUploadedUnchanged(t *testing.T) {
+	srv := newFakeServer(t)
+	square := animatedGIF(t, 32, 32, 4)
+	wide := animatedGIF(t, 96, 16, 3)
+	file := sourceFile(t, "square", srv.img("square.gif", square), "wide", srv.img("wide.gif", wide))
+
+	// None of the image processing may flatten the animation
+	code, report, out := runImport(t, srv, file, "--resize", "--pad-square", "--max-aspect", "2")
+	if code != exitOK {
+		t.Fatalf("exit code %d, output:\n%s", code, out)
+	}
+	sources := map[string][]byte{"square": square, "wide": wide}
+	uploads := srv.uploaded()
+	if len(uploads) != len(sources) {
+		t.Fatalf("%d uploads, want %d\n%s", len(uploads), len(sources), out)
+	}
+	for _, u := range uploads {
+		if !bytes.Equal(u.Data, sources[u.Name]) {
+			t.Errorf("%s: uploaded %d bytes that differ from the %d byte source", u.Name, len(u.Data), len(sources[u.Name]))
+		}
+		if !strings.HasSuffix(u.Filename, ".gif") {
+			t.Errorf("%s uploaded as %q, want a .gif file", u.Name, u.Filename)
+		}
+	}
+	if r := byName(report)["wide"]; !strings.Contains(r.Reason, "animated GIF with aspect ratio 6.0:1 kept as is") {
+		t.Errorf("wide: reason = %q, want a note that it was kept", r.Reason)
+	}
+}
+
+// timedGIF is animatedGIF with a delay of 10*(i+1) for frame i, disposal
+// to the background and a loop count of 3
+func timedGIF(t *testing.T, frames int) []byte {
+	t.Helper()
+	g, err := gif.DecodeAll(bytes.NewReader(animatedGIF(t, 8, 8, frames)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range g.Image {
+		g.Delay[i] = 10 * (i + 1)
+	}
+	g.Disposal = make([]byte, frames)
+	for i := range g.Disposal {
+		g.Disposal[i] = gif.DisposalBackground
+	}
+	g.LoopCount = 3
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, g); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestTruncateGIF(t *testing.T) {
+	data := timedGIF(t, 6)
+	truncated, err := truncateGIF(data, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := gif.DecodeAll(bytes.NewReader(truncated))
+	if err != nil {
+		t.Fatalf("truncated GIF doesn't decode: %v", err)
+	}
+	if len(g.Image) != 4 {
+		t.Fatalf("%d frames, want 4", len(g.Image))
+	}
+	if g.LoopCount != 3 {
+		t.Errorf("loop count %d, want 3", g.LoopCount)
+	}
+	for i := range g.Image {
+		if g.Delay[i] != 10*(i+1) || g.Disposal[i] != gif.DisposalBackground {
+			t.Errorf("frame %d: delay %d, disposal %d, want %d and %d", i, g.Delay[i], g.Disposal[i], 10*(i+1), gif.DisposalBackground)
+		}
+	}
+	original, _ := gif.DecodeAll(bytes.NewReader(data))
+	for i := range g.Image {
+		if !bytes.Equal(g.Image[i].Pix, original.Image[i].Pix) {
+			t.Errorf("frame %d changed", i)
+		}
+	}
+
+	// Short enough already: nothing to re-encode
+	if same, err := truncateGIF(data, 6); err != nil || !bytes.Equal(same, data) {
+		t.Errorf("a GIF within the limit was changed, err %v", err)
+	}
+	if _, err := truncateGIF([]byte("GIF89a broken"), 2); err == nil {
+		t.Error("no error for a broken GIF")
+	}
+}
+
+func TestMaxGIFFrames(t *testing.T) {
+	long := timedGIF(t, 6)
+	short := animatedGIF(t, 8, 8, 3)
+
+	t.Run("skip", func(t *testing.T) {
+		srv := newFakeServer(t)
+		file := sourceFile(t, "long", srv.img("long.gif", long), "short", srv.img("short.gif", short))
+		code, report, out := runImport(t, srv, file, "--max-gif-frames", "4")
+		if code != exitOK {
+			t.Fatalf("exit code %d, output:\n%s", code, out)
+		}
+		if uploads := srv.uploaded(); len(uploads) != 1 || uploads[0].Name != "short" || !bytes.Equal(uploads[0].Data, short) {
+			t.Errorf("uploads %v, want only short, unchanged", uploads)
+		}
+		if r := byName(report)["long"]; r.Action != actionSkipped || !strings.Contains(r.Reason, "too many frames: 6 > 4") {
+			t.Errorf("long: %s, %q, want skipped for its frames", r.Action, r.Reason)
+		}
+	})
+
+	t.Run("truncate", func(t *testing.T) {
+		srv := newFakeServer(t)
+		file := sourceFile(t, "long", srv.img("long.gif", long), "short", srv.img("short.gif", short))
+		code, report, out := runImport(t, srv, file, "--max-gif-frames", "4", "--truncate-gifs")
+		if code != exitOK {
+			t.Fatalf("exit code %d, output:\n%s", code, out)
+		}
+		uploads := map[string][]byte{}
+		for _, u := range srv.uploaded() {
+			uploads[u.Name] = u.Data
+		}
+		if !bytes.Equal(uploads["short"], short) {
+			t.Error("short was changed")
+		}
+		g, err := gif.DecodeAll(bytes.NewReader(uploads["long"]))
+		if err != nil {
+			t.Fatalf("long: %v", err)
+		}
+		if len(g.Image) != 4 || g.LoopCount != 3 || g.Delay[3] != 40 {
+			t.Errorf("long: %d frames, loop count %d, delays %v, want the first 4 frames as they were", len(g.Image), g.LoopCount, g.Delay)
+		}
+		if r := byName(report)["long"]; !strings.Contains(r.Reason, "truncated from 6 to 4 frames") {
+			t.Errorf("long: reason %q, want a note about the truncation", r.Reason)
+		}
+	})
+}
+
+func TestInvalidGIFFrameFlags(t *testing.T) {
+	srv := newFakeServer(t)
+	file := sourceFile(t, "long", srv.img("long.gif", animatedGIF(t, 8, 8, 3)))
+	for _, args := range [][]string{
+		{"--max-gif-frames", "-1"},
+		{"--truncate-gifs"},
+	} {
+		if code, _, out := runImport(t, srv, file, args...); code != exitSetup {
+			t.Errorf("%v: exit code %d, want %d\n%s", args, code, exitSetup, out)
+		}
+	}
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/formatCvt/mattermost-emoji-uploader/emojiuploader"
+	"gopkg.in/yaml.v3"
+)
+
+// stdinPath is the -file value that reads the source from standard input
+const stdinPath = "-"
+
+// fileListFlag collects the repeated --file/-f values. A value with glob
+// characters such as "emoji/*.json" stands for every file it matches, in
+// lexical order.
+type fileListFlag []string
+
+func (f *fileListFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *fileListFlag) Set(value string) error {
+	if value == stdinPath && slices.Contains(*f, stdinPath) {
+		return errors.New("stdin can only be read once")
+	}
+	if !strings.ContainsAny(value, "*?[") {
+		*f = append(*f, value)
+		return nil
+	}
+	matches, err := filepath.Glob(value)
+	if err != nil {
+		return err
+	}
+	if len(matches) == 0 {
+		return fmt.Errorf("no files match %s", value)
+	}
+	*f = append(*f, matches...)
+	return nil
+}
+
+// loadSourceFiles reads and validates every source file and merges them into
+// one emoji map, with later files overriding the entries of earlier ones.
+// Relative image paths stay relative to the file they are listed in. It
+// returns the merged map, the settings of the object entries and the problems
+// found, prefixed with the file name when there is more than one.
+func loadSourceFiles(paths []string, formatFlag string) (EmojiMap, map[string]emojiEntry, []string, error) {
+	merged := make(EmojiMap)
+	mergedSettings := make(map[string]emojiEntry)
+	var issues []string
+	// origin remembers which file an entry came from to report overrides
+	origin := make(map[string]string)
+
+	for _, path := range paths {
+		emojis, settings, invalid, err := loadSourceFile(path, formatFlag)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		name := sourceName(path)
+		if len(paths) > 1 {
+			for i, issue := range invalid {
+				invalid[i] = name + ": " + issue
+			}
+		}
+		issues = append(issues, invalid...)
+
+		originals := make([]string, 0, len(emojis))
+		for originalName := range emojis {
+			originals = append(originals, originalName)
+		}
+		sort.Strings(originals)
+		for _, originalName := range originals {
+			source := emojis[originalName]
+			if len(paths) > 1 && path != stdinPath {
+				source = rebaseLocalPath(source, filepath.Dir(path))
+			}
+			if previous, ok := merged[originalName]; ok && previous != source {
+				logError("⚠️  [:%s:] is defined in %s and %s, using the one from %s\n", originalName, origin[originalName], name, name)
+			}
+			merged[originalName] = source
+			origin[originalName] = name
+			if s, ok := settings[originalName]; ok {
+				mergedSettings[originalName] = s
+			} else {
+				delete(mergedSettings, originalName)
+			}
+		}
+	}
+	return merged, mergedSettings, issues, nil
+}
+
+// loadSourceFile reads, filters by --since and validates a single source
+// file. It also returns the settings of its object entries, see parseEmojiMap.
+func loadSourceFile(path, formatFlag string) (EmojiMap, map[string]emojiEntry, []string, error) {
+	format, err := fileFormat(path, formatFlag)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	file, err := readSource(path, os.Stdin)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("reading file: %w", err)
+	}
+
+	emojis, settings, err := parseEmojiMap(file, format)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("in %s: %w", sourceName(path), err)
+	}
+
+	// Only Slack exports carry creation times; other entries count as unknown
+	if !since.IsZero() {
+		var created map[string]time.Time
+		if format == "slack" {
+			if created, err = slackCreatedTimes(file); err != nil {
+				return nil, nil, nil, fmt.Errorf("in %s: %w", sourceName(path), err)
+			}
+		} else {
+			logError("⚠️  -since only knows the creation times of Slack exports (--format slack), not of %s\n", sourceName(path))
+		}
+		var dropped int
+		emojis, dropped = filterSince(emojis, created, since, !strict)
+		logInfo("⏭️  Skipping %d emojis of %s not created since %s\n", dropped, sourceName(path), since.Format(time.RFC3339))
+	}
+
+	// Report every problem in the file at once, before any network work is done
+	valid, issues := validateEmojis(emojis, settings, filepath.Dir(path))
+	return valid, settings, issues, nil
+}
+
+// rebaseLocalPath makes a relative local image path relative to dir instead
+// of the source file, leaving URLs, aliases and other sources as they are
+func rebaseLocalPath(source, dir string) string {
+	trimmed := strings.TrimSpace(source)
+	if strings.HasPrefix(trimmed, "alias:") || strings.HasPrefix(trimmed, zipPrefix) || isDataURI(trimmed) {
+		return source
+	}
+	if u, err := url.Parse(trimmed); err == nil && len(u.Scheme) > 1 {
+		return source
+	}
+	if filepath.IsAbs(trimmed) {
+		return source
+	}
+	return filepath.Join(dir, trimmed)
+}
+
+// readSource returns the contents of the source file, or everything read from
+// stdin when path is stdinPath
+func readSource(path string, stdin io.Reader) ([]byte, error) {
+	if path == stdinPath {
+		return io.ReadAll(stdin)
+	}
+	return os.ReadFile(path)
+}
+
+// sourceName describes the source file in log messages
+func sourceName(path string) string {
+	if path == stdinPath {
+		return "stdin"
+	}
+	return path
+}
+
+// fileFormat determines the input format from the --format flag or, when not
+// set, from the file extension. Anything that isn't .yml/.yaml is read as JSON.
+func fileFormat(path, format string) (string, error) {
+	switch strings.ToLower(format) {
+	case "json":
+		return "json", nil
+	case "yaml", "yml":
+		return "yaml", nil
+	case "slack":
+		return "slack", nil
+	case "":
+	default:
+		return "", fmt.Errorf("unknown format %q (expected json, yaml or slack)", format)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yml", ".yaml":
+		return "yaml", nil
+	}
+	return "json", nil
+}
+
+// parseEmojiMap decodes the source file contents in the given format. Besides
+// the image sources it returns the object values that set a name or content
+// type for their emoji, keyed by original name. Skipped entries are left out.
+func parseEmojiMap(data []byte, format string) (EmojiMap, map[string]emojiEntry, error) {
+	var entries map[string]emojiEntry
+	switch format {
+	case "yaml":
+		if err := yaml.Unmarshal(data, &entries); err != nil {
+			return nil, nil, fmt.Errorf("parsing YAML: %w", err)
+		}
+	case "slack":
+		emojis, err := parseSlackEmojis(data)
+		return emojis, nil, err
+	default:
+		if err := json.Unmarshal(data, &entries); err != nil {
+			return nil, nil, fmt.Errorf("parsing JSON: %w", err)
+		}
+	}
+
+	emojis := make(EmojiMap, len(entries))
+	settings := make(map[string]emojiEntry)
+	for originalName, entry := range entries {
+		if entry.Skip {
+			logDebug("🔎 [:%s:] is marked skip in the source\n", originalName)
+			continue
+		}
+		emojis[originalName] = entry.URL
+		if entry.Name != "" || entry.ContentType != "" {
+			settings[originalName] = entry
+		}
+	}
+	return emojis, settings, nil
+}
+
+// emojiEntry is a value of a JSON or YAML source file: either just the image
+// source, or an object such as {"url": "...", "name": "partyparrot"} that
+// also sets the exact Mattermost name or content type, or skips the emoji
+type emojiEntry struct {
+	URL string `json:"url" yaml:"url"`
+	// Name is used as is instead of the sanitized original name, like a --name-map override
+	Name string `json:"name" yaml:"name"`
+	// ContentType is the media type of the image, such as image/png, taking
+	// precedence over both the Content-Type header and the image contents
+	ContentType string `json:"contentType" yaml:"contentType"`
+	// Skip leaves the emoji out, e.g. to keep an entry in a generated file without uploading it
+	Skip bool `json:"skip" yaml:"skip"`
+}
+
+func (e *emojiEntry) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	switch {
+	case bytes.Equal(data, []byte("null")):
+		return nil
+	case len(data) > 0 && data[0] == '"':
+		return json.Unmarshal(data, &e.URL)
+	case len(data) > 0 && data[0] == '{':
+		// The alias keeps the decoder from calling this method again
+		type fields emojiEntry
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		return dec.Decode((*fields)(e))
+	}
+	return fmt.Errorf("expected a string or an object, got %s", data)
+}
+
+func (e *emojiEntry) UnmarshalYAML(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		return node.Decode(&e.URL)
+	case yaml.MappingNode:
+		for i := 0; i < len(node.Content); i += 2 {
+			if key := node.Content[i]; key.Value != "url" && key.Value != "name" && key.Value != "contentType" && key.Value != "skip" {
+				return fmt.Errorf("line %d: unknown field %q", key.Line, key.Value)
+			}
+		}
+		type fields emojiEntry
+		return node.Decode((*fields)(e))
+	}
+	return fmt.Errorf("line %d: expected a string or a mapping", node.Line)
+}
+
+// validateEntryContentTypes checks the content types set by object entries:
+// each must be an image type that Mattermost gets a file extension for, and
+// aliases can't have one, as they reuse the image of their target. Valid types
+// are normalized in settings, e.g. "Image/PNG; q=1" to "image/png". Emojis
+// with an invalid type are removed from emojis and described in the issues.
+func validateEntryContentTypes(emojis EmojiMap, settings map[string]emojiEntry) []string {
+	originals := make([]string, 0, len(settings))
+	for original, entry := range settings {
+		if entry.ContentType != "" {
+			originals = append(originals, original)
+		}
+	}
+	sort.Strings(originals)
+
+	var issues []string
+	for _, original := range originals {
+		entry := settings[original]
+		source, ok := emojis[original]
+		if !ok {
+			continue
+		}
+		if strings.HasPrefix(source, "alias:") {
+			issues = append(issues, fmt.Sprintf("[:%s:] is an alias and can't have a contentType", original))
+			delete(emojis, original)
+			continue
+		}
+		mediaType, _, err := mime.ParseMediaType(entry.ContentType)
+		if _, known := emojiuploader.Extension(mediaType); err != nil || !known {
+			issues = append(issues, fmt.Sprintf("[:%s:] has the contentType %q, which is not a supported image type", original, entry.ContentType))
+			delete(emojis, original)
+			continue
+		}
+		entry.ContentType = mediaType
+		settings[original] = entry
+	}
+	return issues
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// parseSource parses the source file at path like main does
+func parseSource(t *testing.T, path string) EmojiMap {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	format, err := fileFormat(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	emojis, _, err := parseEmojiMap(data, format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return emojis
+}
+
+func TestYAMLMatchesJSON(t *testing.T) {
+	fromJSON := parseSource(t, filepath.Join(testdata, "emoji.json"))
+	fromYAML := parseSource(t, filepath.Join(testdata, "emoji.yaml"))
+
+	if len(fromJSON) != 4 {
+		t.Errorf("JSON: %d emojis, want 4: %v", len(fromJSON), fromJSON)
+	}
+	if !reflect.DeepEqual(fromYAML, fromJSON) {
+		t.Errorf("YAML emojis = %v, want the JSON ones %v", fromYAML, fromJSON)
+	}
+}
+
+func TestFileFormat(t *testing.T) {
+	tests := []struct {
+		path, flag, want string
+	}{
+		{"emoji.json", "", "json"},
+		{"emoji.yaml", "", "yaml"},
+		{"emoji.YML", "", "yaml"},
+		{"emoji.txt", "", "json"},
+		{"emoji.json", "yaml", "yaml"},
+		{"emoji.yaml", "JSON", "json"},
+		{"export.json", "slack", "slack"},
+	}
+	for _, tt := range tests {
+		got, err := fileFormat(tt.path, tt.flag)
+		if err != nil || got != tt.want {
+			t.Errorf("fileFormat(%q, %q) = %q, %v, want %q", tt.path, tt.flag, got, err, tt.want)
+		}
+	}
+	if _, err := fileFormat("emoji.json", "xml"); err == nil {
+		t.Error("no error for an unknown --format")
+	}
+}
+
+func TestParseInvalidYAML(t *testing.T) {
+	if _, _, err := parseEmojiMap([]byte("party: [unclosed"), "yaml"); err == nil {
+		t.Error("no error for invalid YAML")
+	}
+}
+
+func TestReadSource(t *testing.T) {
+	data := `{"party":"https://example.com/party.gif"}`
+	got, err := readSource(stdinPath, strings.NewReader(data))
+	if err != nil || string(got) != data {
+		t.Errorf("readSource(-) = %q, %v, want the reader's contents", got, err)
+	}
+
+	path := writeFile(t, t.TempDir(), "emoji.json", []byte(`{"file":"x"}`))
+	got, err = readSource(path, strings.NewReader(data))
+	if err != nil || string(got) != `{"file":"x"}` {
+		t.Errorf("readSource(%s) = %q, %v, want the file's contents", path, got, err)
+	}
+}
+
+func TestUploadFromStdin(t *testing.T) {
+	srv := newFakeServer(t)
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdin := os.Stdin
+	os.Stdin = r
+	t.Cleanup(func() { os.Stdin = stdin; r.Close() })
+	go func() {
+		fmt.Fprintf(w, `{"piped":%q,"other":%q}`, srv.img("piped.png", pngData), srv.img("other.png", pngData))
+		w.Close()
+	}()
+
+	code, report, out := runImport(t, srv, "-")
+	if code != exitOK {
+		t.Fatalf("exit code %d, output:\n%s", code, out)
+	}
+	if report.Summary.Uploaded != 2 || len(srv.uploaded()) != 2 {
+		t.Errorf("summary %+v, %d uploads, want both piped emojis\n%s", report.Summary, len(srv.uploaded()), out)
+	}
+}
+
+func TestFileListFlag(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"b.json", "a.json", "c.yaml"} {
+		writeFile(t, dir, name, []byte("{}"))
+	}
+	var f fileListFlag
+	for _, v := range []string{"first.json", filepath.Join(dir, "*.json"), stdinPath} {
+		if err := f.Set(v); err != nil {
+			t.Fatalf("Set(%q): %v", v, err)
+		}
+	}
+	want := fileListFlag{"first.json", filepath.Join(dir, "a.json"), filepath.Join(dir, "b.json"), stdinPath}
+	if !reflect.DeepEqual(f, want) {
+		t.Errorf("files = %q, want %q", f, want)
+	}
+	if err := f.Set(stdinPath); err == nil {
+		t.Error("no error for reading stdin twice")
+	}
+	if err := f.Set(filepath.Join(dir, "*.txt")); err == nil {
+		t.Error("no error for a glob without matches")
+	}
+}
+
+func TestLoadSourceFilesMerge(t *testing.T) {
+	t.Cleanup(func() { jsonLog = nil })
+	var log bytes.Buffer
+	jsonLog = newJSONLogger(&log)
+	first := writeFile(t, t.TempDir(), "people.json", []byte(`{
+		"party": "https://example.com/party.png",
+		"wave": "https://example.com/wave.png"
+	}`))
+	secondDir := t.TempDir()
+	writeFile(t, secondDir, "images/party-cat.png", pngData)
+	second := writeFile(t, secondDir, "animals.json", []byte(`{
+		"party": "images/party-cat.png",
+		"wave": "https://example.com/wave.png",
+		"cat": "alias:party"
+	}`))
+
+	emojis, _, _, err := loadSourceFiles([]string{first, second}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The later file wins, and its relative path is resolved against its own directory
+	want := EmojiMap{
+		"party": filepath.Join(secondDir, "images/party-cat.png"),
+		"wave":  "https://example.com/wave.png",
+		"cat":   "alias:party",
+	}
+	if !reflect.DeepEqual(emojis, want) {
+		t.Errorf("merged = %v, want %v", emojis, want)
+	}
+	out := log.String()
+	if want := fmt.Sprintf("[:party:] is defined in %s and %s, using the one from %[2]s", first, second); !strings.Contains(out, want) {
+		t.Errorf("conflict not reported, want %q in:\n%s", want, out)
+	}
+	// An identical entry in both files is no conflict
+	if strings.Contains(out, "[:wave:]") {
+		t.Errorf("identical entries reported as a conflict:\n%s", out)
+	}
+}
+
+func TestUploadMultipleFiles(t *testing.T) {
+	srv := newFakeServer(t)
+	first := writeFile(t, t.TempDir(), "first.json", []byte(fmt.Sprintf(`{"party": %q, "Party Time": %q}`,
+		srv.img("old.png", pngData), srv.img("time.png", solidPNG(t, 2, 2)))))
+	second := writeFile(t, t.TempDir(), "second.json", []byte(fmt.Sprintf(`{"party": %q, "party-time": %q}`,
+		srv.img("new.png", solidPNG(t, 3, 3)), srv.img("time2.png", solidPNG(t, 4, 4)))))
+
+	code, out := runCLI(t, "-s", srv.URL, "-t", "tok", "-f", first, "-f", second, "--delay", "0")
+	if code != exitOK {
+		t.Fatalf("exit code %d, output:\n%s", code, out)
+	}
+	uploads := make(map[string][]byte)
+	var names []string
+	for _, u := range srv.uploaded() {
+		uploads[u.Name] = u.Data
+		names = append(names, u.Name)
+	}
+	if len(uploads) != 3 {
+		t.Fatalf("uploaded %q, want 3 emojis\n%s", names, out)
+	}
+	if !reflect.DeepEqual(uploads["party"], solidPNG(t, 3, 3)) {
+		t.Error("party was uploaded from the first file, want the second")
+	}
+	// Names from different files that sanitize alike get the usual suffixes
+	if uploads["party-time"] == nil || uploads["party-time-2"] == nil {
+		t.Errorf("uploaded %q, want party-time and party-time-2", names)
+	}
+}
+
+func TestParseEmojiMapEntries(t *testing.T) {
+	tests := []struct {
+		name, format, data string
+		emojis             EmojiMap
+		settings           map[string]emojiEntry
+	}{
+		{"legacy JSON", "json", `{"party": "https://example.com/party.gif", "parrot": "alias:party"}`,
+			EmojiMap{"party": "https://example.com/party.gif", "parrot": "alias:party"}, map[string]emojiEntry{}},
+		{"object JSON", "json", `{"Ship It": {"url": "https://example.com/ship.png", "name": "shipit"}, "old": {"url": "https://example.com/old.png", "skip": true}, "plain": {"url": "https://example.com/plain.png"}}`,
+			EmojiMap{"Ship It": "https://example.com/ship.png", "plain": "https://example.com/plain.png"},
+			map[string]emojiEntry{"Ship It": {URL: "https://example.com/ship.png", Name: "shipit"}}},
+		{"mixed JSON", "json", `{"party": "https://example.com/party.gif", "logo": {"url": "logo.img", "contentType": "image/png"}, "gone": null}`,
+			EmojiMap{"party": "https://example.com/party.gif", "logo": "logo.img", "gone": ""},
+			map[string]emojiEntry{"logo": {URL: "logo.img", ContentType: "image/png"}}},
+		{"legacy YAML", "yaml", "party: https://example.com/party.gif\nparrot: alias:party\n",
+			EmojiMap{"party": "https://example.com/party.gif", "parrot": "alias:party"}, map[string]emojiEntry{}},
+		{"object YAML", "yaml", "Ship It:\n  url: https://example.com/ship.png\n  name: shipit\nold:\n  url: https://example.com/old.png\n  skip: true\n",
+			EmojiMap{"Ship It": "https://example.com/ship.png"},
+			map[string]emojiEntry{"Ship It": {URL: "https://example.com/ship.png", Name: "shipit"}}},
+	}
+	for _, tt := range tests {
+		emojis, settings, err := parseEmojiMap([]byte(tt.data), tt.format)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(emojis, tt.emojis) {
+			t.Errorf("%s: emojis = %v, want %v", tt.name, emojis, tt.emojis)
+		}
+		if !reflect.DeepEqual(settings, tt.settings) {
+			t.Errorf("%s: settings = %+v, want %+v", tt.name, settings, tt.settings)
+		}
+	}
+}
+
+func TestParseInvalidEntries(t *testing.T) {
+	for _, tt := range []struct{ format, data string }{
+		{"json", `{"party": 42}`},
+		{"json", `{"party": ["https://example.com/party.gif"]}`},
+		{"json", `{"party": {"url": "https://example.com/party.gif", "nmae": "typo"}}`},
+		{"json", `{"party": {"url": "https://example.com/party.gif", "skip": "yes"}}`},
+		{"yaml", "party:\n  - https://example.com/party.gif\n"},
+		{"yaml", "party:\n  url: https://example.com/party.gif\n  nmae: typo\n"},
+	} {
+		if _, _, err := parseEmojiMap([]byte(tt.data), tt.format); err == nil {
+			t.Errorf("%s %q: no error", tt.format, tt.data)
+		}
+	}
+}
+
+func TestUploadObjectEntries(t *testing.T) {
+	srv := newFakeServer(t)
+	file := writeFile(t, t.TempDir(), "emoji.json", []byte(fmt.Sprintf(`{
+		"Party Parrot": %q,
+		"Ship It!": {"url": %q, "name": "shipit"},
+		"retired": {"url": %q, "skip": true}
+	}`, srv.img("parrot.png", pngData), srv.img("ship.png", solidPNG(t, 2, 2)), srv.img("retired.png", solidPNG(t, 3, 3)))))
+
+	code, report, out := runImport(t, srv, file)
+	if code != exitOK {
+		t.Fatalf("exit code %d, output:\n%s", code, out)
+	}
+	var names []string
+	for _, u := range srv.uploaded() {
+		names = append(names, u.Name)
+	}
+	// The legacy entry is sanitized, the object entry keeps its exact name
+	if want := []string{"party-parrot", "shipit"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("uploaded %q, want %q", names, want)
+	}
+	if _, ok := byName(report)["retired"]; ok {
+		t.Error("the skipped entry is in the report")
+	}
+}
+
+func TestObjectEntryNamesUnsanitizableKey(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "party.gif", pngData)
+	path := writeFile(t, dir, "emoji.json", []byte(`{
+		"🎉🎉": {"url": "party.gif", "name": "party"},
+		"✨✨": {"url": "party.gif"}
+	}`))
+
+	emojis, settings, issues, err := loadSourceFile(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The key only matters when the entry doesn't name the emoji
+	if _, ok := emojis["🎉🎉"]; !ok || settings["🎉🎉"].Name != "party" {
+		t.Errorf("emojis = %v, settings = %+v, want the named entry kept", emojis, settings)
+	}
+	if want := []string{"[:✨✨:] name is empty after sanitization (see --on-empty)"}; !reflect.DeepEqual(issues, want) {
+		t.Errorf("issues = %q, want %q", issues, want)
+	}
+}
+
+func TestValidateEntryContentTypes(t *testing.T) {
+	emojis := EmojiMap{"logo": "logo.img", "icon": "icon.img", "party": "alias:logo", "plain": "plain.png"}
+	settings := map[string]emojiEntry{
+		"logo":  {ContentType: "Image/SVG+XML; charset=utf-8"},
+		"icon":  {ContentType: "image/x-icon"},
+		"party": {ContentType: "image/png"},
+	}
+	issues := validateEntryContentTypes(emojis, settings)
+	if len(issues) != 2 || !strings.Contains(issues[0], "[:icon:]") || !strings.Contains(issues[1], "[:party:] is an alias") {
+		t.Errorf("issues %q, want the unsupported type and the alias", issues)
+	}
+	if _, ok := emojis["logo"]; !ok || len(emojis) != 2 {
+		t.Errorf("kept %v, want logo and plain", emojis)
+	}
+	// The type is normalized once, for all later comparisons
+	if got := settings["logo"].ContentType; got != "image/svg+xml" {
+		t.Errorf("logo content type %q, want image/svg+xml", got)
+	}
+}
+
+func TestContentTypeOverride(t *testing.T) {
+	srv := newFakeServer(t)
+	// The license comment pushes the <svg> element past what sniffing
+	// looks at, so only the wrong header is left to go by
+	logo := []byte("<!--\n" + strings.Repeat("Licensed under the Apache License, Version 2.0.\n", 12) + "-->\n" + string(svgData[strings.Index(string(svgData), "<svg"):]))
+	srv.imageTypes["logo.txt"] = "text/plain"
+	srv.imageTypes["badge.txt"] = "text/plain"
+	file := writeFile(t, t.TempDir(), "emoji.json", []byte(fmt.Sprintf(`{
+		"logo": {"url": %q, "contentType": "image/svg+xml"},
+		"badge": %q
+	}`, srv.img("logo.txt", logo), srv.img("badge.txt", logo))))
+
+	code, report, out := runImport(t, srv, file, "--allow-formats", "png,svg", "-v")
+	if code != exitOK {
+		t.Fatalf("exit code %d, output:\n%s", code, out)
+	}
+	uploads := srv.uploaded()
+	if len(uploads) != 1 || uploads[0].Filename != "logo.svg" || !bytes.Equal(uploads[0].Data, logo) {
+		t.Fatalf("uploads %+v, want logo.svg unchanged", uploads)
+	}
+	if !strings.Contains(out, "[:logo:] using the content type image/svg+xml from the source instead of text/plain") {
+		t.Errorf("the override isn't logged:\n%s", out)
+	}
+	// Without the override the header wins and the format is refused
+	if r := byName(report)["badge"]; r.SkipReason != skipFormat {
+		t.Errorf("badge: %+v, want skipped for its format", r)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"strings"
+)
+
+// Values of --normalize-jpeg-format
+const (
+	jpegFormatJPEG = "jpeg"
+	jpegFormatPNG  = "png"
+)
+
+// normalizedJPEGQuality is the quality of JPEGs re-encoded by --normalize-jpeg,
+// high enough that the second lossy pass isn't visible at emoji size
+const normalizedJPEGQuality = 95
+
+// normalizeJPEG fixes JPEGs that Mattermost would display wrongly
+// (--normalize-jpeg): browsers ignore the EXIF orientation of emojis, so
+// photos taken with a rotated camera show up sideways, and CMYK JPEGs are
+// rendered with wrong colors or not at all. Such images are decoded, turned
+// upright, converted to RGB and re-encoded as JPEG or, with toPNG, as PNG.
+// JPEGs that need neither fix are returned unchanged with an empty note, so
+// they don't lose quality to a needless re-encode.
+func normalizeJPEG(data []byte, toPNG bool) (normalized []byte, contentType, note string, err error) {
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", "", fmt.Errorf("decode jpeg: %w", err)
+	}
+	orientation := jpegOrientation(data)
+	cmyk := cfg.ColorModel == color.CMYKModel
+	if orientation <= 1 && !cmyk {
+		return data, "image/jpeg", "", nil
+	}
+
+	src, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", "", fmt.Errorf("decode jpeg: %w", err)
+	}
+	// Drawing onto an RGBA canvas converts CMYK and grayscale as well
+	img := image.NewRGBA(image.Rect(0, 0, src.Bounds().Dx(), src.Bounds().Dy()))
+	draw.Draw(img, img.Bounds(), src, src.Bounds().Min, draw.Src)
+	img = orient(img, orientation)
+
+	buf := &bytes.Buffer{}
+	contentType = "image/jpeg"
+	if toPNG {
+		contentType = "image/png"
+		err = png.Encode(buf, img)
+	} else {
+		// The EXIF data is not carried over, so the orientation isn't applied twice
+		err = jpeg.Encode(buf, img, &jpeg.Options{Quality: normalizedJPEGQuality})
+	}
+	if err != nil {
+		return nil, "", "", fmt.Errorf("encode: %w", err)
+	}
+
+	var fixes []string
+	if orientation > 1 {
+		fixes = append(fixes, fmt.Sprintf("EXIF orientation %d applied", orientation))
+	}
+	if cmyk {
+		fixes = append(fixes, "converted from CMYK")
+	}
+	if toPNG {
+		fixes = append(fixes, "re-encoded as PNG")
+	}
+	return buf.Bytes(), contentType, "JPEG " + strings.Join(fixes, ", "), nil
+}
+
+// jpegOrientation returns the EXIF orientation (1 to 8) of a JPEG, or 0 if it
+// has none. Only the segments before the image data are read.
+func jpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return 0
+	}
+	for pos := 2; pos+4 <= len(data) && data[pos] == 0xff; {
+		marker := data[pos+1]
+		size := int(binary.BigEndian.Uint16(data[pos+2 : pos+4]))
+		// Start of scan: no more metadata segments follow
+		if marker == 0xda || size < 2 || pos+2+size > len(data) {
+			return 0
+		}
+		segment := data[pos+4 : pos+2+size]
+		if marker == 0xe1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[6:])
+		}
+		pos += 2 + size
+	}
+	return 0
+}
+
+// exifOrientation reads the orientation tag (0x0112) from the first IFD of
+// the TIFF structure in an EXIF segment
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+
+	ifd := int(order.Uint32(tiff[4:8]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 0
+	}
+	entries := int(order.Uint16(tiff[ifd : ifd+2]))
+	for i := 0; i < entries; i++ {
+		// Entries are 12 bytes: tag, type, count and the value or its offset
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 0
+		}
+		if order.Uint16(tiff[entry:entry+2]) != 0x0112 {
+			continue
+		}
+		// A SHORT value is stored in the first two bytes of the value field
+		if value := int(order.Uint16(tiff[entry+8 : entry+10])); value >= 1 && value <= 8 {
+			return value
+		}
+		return 0
+	}
+	return 0
+}
+
+// orient transforms src as the EXIF orientation asks, so that it is upright.
+// Orientations 5 to 8 swap width and height.
+func orient(src *image.RGBA, orientation int) *image.RGBA {
+	if orientation <= 1 || orientation > 8 {
+		return src
+	}
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			// The source pixel that ends up at x, y
+			var sx, sy int
+			switch orientation {
+			case 2: // mirrored horizontally
+				sx, sy = w-1-x, y
+			case 3: // rotated by 180°
+				sx, sy = w-1-x, h-1-y
+			case 4: // mirrored vertically
+				sx, sy = x, h-1-y
+			case 5: // mirrored along the top-left to bottom-right diagonal
+				sx, sy = y, x
+			case 6: // needs a 90° clockwise rotation
+				sx, sy = y, h-1-x
+			case 7: // mirrored along the top-right to bottom-left diagonal
+				sx, sy = w-1-y, h-1-x
+			case 8: // needs a 90° counterclockwise rotation
+				sx, sy = w-1-y, x
+			}
+			dst.SetRGBA(x, y, src.RGBAAt(sx, sy))
+		}
+	}
+	return dst
+}
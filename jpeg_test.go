@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+var (
+	red  = color.RGBA{255, 0, 0, 255}
+	blue = color.RGBA{0, 0, 255, 255}
+)
+
+// exifSegment is an APP1 segment holding only the given EXIF orientation
+func exifSegment(order binary.ByteOrder, orientation uint16) []byte {
+	tiff := &bytes.Buffer{}
+	if order == binary.LittleEndian {
+		tiff.WriteString("II")
+	} else {
+		tiff.WriteString("MM")
+	}
+	binary.Write(tiff, order, uint16(42))
+	binary.Write(tiff, order, uint32(8))
+	// One IFD entry: orientation, SHORT, count 1, value padded to 4 bytes
+	binary.Write(tiff, order, uint16(1))
+	binary.Write(tiff, order, []uint16{0x0112, 3})
+	binary.Write(tiff, order, uint32(1))
+	binary.Write(tiff, order, []uint16{orientation, 0})
+	binary.Write(tiff, order, uint32(0))
+
+	payload := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+	segment := []byte{0xff, 0xe1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+// sidewaysJPEG is a 32x16 JPEG as a camera held upright stores it: red on
+// the left and blue on the right, with EXIF orientation 6 asking for a
+// clockwise turn. Upright it is 16x32, red on top and blue below.
+func sidewaysJPEG(t *testing.T) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 32, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 32; x++ {
+			c := red
+			if x >= 16 {
+				c = blue
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+	buf := &bytes.Buffer{}
+	if err := jpeg.Encode(buf, img, &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	// The EXIF segment goes right after the start of image marker
+	return append(append(append([]byte{}, data[:2]...), exifSegment(binary.BigEndian, 6)...), data[2:]...)
+}
+
+// checkUpright fails unless img is 16x32, red in its top half and blue below
+func checkUpright(t *testing.T, img image.Image) {
+	t.Helper()
+	if b := img.Bounds(); b.Dx() != 16 || b.Dy() != 32 {
+		t.Fatalf("image is %dx%d, want 16x32", b.Dx(), b.Dy())
+	}
+	near := func(c color.Color, want color.RGBA) bool {
+		r, g, b, _ := c.RGBA()
+		diff := func(v uint32, w uint8) bool { d := int(v>>8) - int(w); return d > -40 && d < 40 }
+		return diff(r, want.R) && diff(g, want.G) && diff(b, want.B)
+	}
+	if c := img.At(8, 8); !near(c, red) {
+		t.Errorf("top is %v, want red", c)
+	}
+	if c := img.At(8, 24); !near(c, blue) {
+		t.Errorf("bottom is %v, want blue", c)
+	}
+}
+
+func TestJPEGOrientation(t *testing.T) {
+	plain := &bytes.Buffer{}
+	jpeg.Encode(plain, image.NewRGBA(image.Rect(0, 0, 4, 4)), nil)
+	withExif := func(order binary.ByteOrder, orientation uint16) []byte {
+		data := plain.Bytes()
+		return append(append(append([]byte{}, data[:2]...), exifSegment(order, orientation)...), data[2:]...)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		want int
+	}{
+		{"no exif", plain.Bytes(), 0},
+		{"big endian", withExif(binary.BigEndian, 6), 6},
+		{"little endian", withExif(binary.LittleEndian, 8), 8},
+		{"normal", withExif(binary.BigEndian, 1), 1},
+		{"out of range", withExif(binary.BigEndian, 9), 0},
+		{"not a jpeg", pngData, 0},
+		{"truncated", withExif(binary.BigEndian, 6)[:12], 0},
+	}
+	for _, tt := range tests {
+		if got := jpegOrientation(tt.data); got != tt.want {
+			t.Errorf("%s: jpegOrientation = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestOrient(t *testing.T) {
+	// A 2x3 image with a different value in every pixel:
+	// 1 2
+	// 3 4
+	// 5 6
+	src := image.NewRGBA(image.Rect(0, 0, 2, 3))
+	for i := 0; i < 6; i++ {
+		src.SetRGBA(i%2, i/2, color.RGBA{uint8(i + 1), 0, 0, 255})
+	}
+	tests := []struct {
+		orientation int
+		want        [][]uint8
+	}{
+		{1, [][]uint8{{1, 2}, {3, 4}, {5, 6}}},
+		{2, [][]uint8{{2, 1}, {4, 3}, {6, 5}}},
+		{3, [][]uint8{{6, 5}, {4, 3}, {2, 1}}},
+		{4, [][]uint8{{5, 6}, {3, 4}, {1, 2}}},
+		{5, [][]uint8{{1, 3, 5}, {2, 4, 6}}},
+		{6, [][]uint8{{5, 3, 1}, {6, 4, 2}}},
+		{7, [][]uint8{{6, 4, 2}, {5, 3, 1}}},
+		{8, [][]uint8{{2, 4, 6}, {1, 3, 5}}},
+	}
+	for _, tt := range tests {
+		dst := orient(src, tt.orientation)
+		var got [][]uint8
+		for y := 0; y < dst.Bounds().Dy(); y++ {
+			var row []uint8
+			for x := 0; x < dst.Bounds().Dx(); x++ {
+				row = append(row, dst.RGBAAt(x, y).R)
+			}
+			got = append(got, row)
+		}
+		if !equalRows(got, tt.want) {
+			t.Errorf("orientation %d: %v, want %v", tt.orientation, got, tt.want)
+		}
+	}
+}
+
+func equalRows(a, b [][]uint8) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestNormalizeJPEG(t *testing.T) {
+	data := sidewaysJPEG(t)
+	for _, toPNG := range []bool{false, true} {
+		normalized, contentType, note, err := normalizeJPEG(data, toPNG)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var img image.Image
+		if toPNG {
+			if contentType != "image/png" {
+				t.Errorf("content type %q, want image/png", contentType)
+			}
+			img, err = png.Decode(bytes.NewReader(normalized))
+		} else {
+			if contentType != "image/jpeg" {
+				t.Errorf("content type %q, want image/jpeg", contentType)
+			}
+			if o := jpegOrientation(normalized); o != 0 {
+				t.Errorf("re-encoded JPEG keeps orientation %d", o)
+			}
+			img, err = jpeg.Decode(bytes.NewReader(normalized))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkUpright(t, img)
+		if note == "" {
+			t.Error("no note about the fixes")
+		}
+	}
+}
+
+func TestNormalizeJPEGUnchanged(t *testing.T) {
+	buf := &bytes.Buffer{}
+	jpeg.Encode(buf, image.NewRGBA(image.Rect(0, 0, 8, 8)), nil)
+	normalized, contentType, note, err := normalizeJPEG(buf.Bytes(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(normalized, buf.Bytes()) || contentType != "image/jpeg" || note != "" {
+		t.Errorf("an upright RGB JPEG was changed: %s, note %q", contentType, note)
+	}
+
+	if _, _, _, err := normalizeJPEG([]byte("not a jpeg"), false); err == nil {
+		t.Error("no error for invalid data")
+	}
+}
+
+func TestUploadNormalizedJPEG(t *testing.T) {
+	srv := newFakeServer(t)
+	file := sourceFile(t, "photo", srv.img("photo.jpg", sidewaysJPEG(t)))
+
+	code, _, out := runImport(t, srv, file, "--normalize-jpeg", "--normalize-jpeg-format", "png")
+	if code != exitOK {
+		t.Fatalf("exit code %d, output:\n%s", code, out)
+	}
+	uploads := srv.uploaded()
+	if len(uploads) != 1 {
+		t.Fatalf("%d uploads, want 1\n%s", len(uploads), out)
+	}
+	img, err := png.Decode(bytes.NewReader(uploads[0].Data))
+	if err != nil {
+		t.Fatalf("upload is not a PNG: %v", err)
+	}
+	checkUpright(t, img)
+}
+
+func TestUploadJPEGWithoutNormalizing(t *testing.T) {
+	srv := newFakeServer(t)
+	data := sidewaysJPEG(t)
+	file := sourceFile(t, "photo", srv.img("photo.jpg", data))
+
+	if code, _, out := runImport(t, srv, file); code != exitOK {
+		t.Fatalf("exit code %d, output:\n%s", code, out)
+	}
+	if uploads := srv.uploaded(); len(uploads) != 1 || !bytes.Equal(uploads[0].Data, data) {
+		t.Error("the JPEG was changed without --normalize-jpeg")
+	}
+}
+
+func TestInvalidNormalizeJPEGFormat(t *testing.T) {
+	srv := newFakeServer(t)
+	file := sourceFile(t, "photo", srv.img("photo.jpg", sidewaysJPEG(t)))
+	for _, args := range [][]string{
+		{"--normalize-jpeg", "--normalize-jpeg-format", "gif"},
+		{"--normalize-jpeg-format", "png"},
+	} {
+		if code, _, out := runImport(t, srv, file, args...); code != exitSetup {
+			t.Errorf("%v: exit code %d, want %d\n%s", args, code, exitSetup, out)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/formatCvt/mattermost-emoji-uploader/emojiuploader"
+)
+
+// Values of --output
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+// listedEmoji is an emoji as printed by --list --output json
+type listedEmoji struct {
+	Name      string    `json:"name"`
+	ID        string    `json:"id"`
+	CreatorID string    `json:"creator_id"`
+	Creator   string    `json:"creator,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// runList prints the custom emojis on the server sorted by name (--list), one
+// name per line or as a JSON array. With details the creator's username and
+// the creation date are added to the text output as well; the JSON output
+// always has the IDs and the date and, with details, the username too.
+// It returns the exit code.
+func runList(ctx context.Context, api *emojiuploader.Client, output string, details bool) int {
+	emojis, err := listEmojis(ctx, api)
+	if err != nil {
+		logError("❌ Error listing existing emojis: %v\n", err)
+		return exitSetup
+	}
+	sort.Slice(emojis, func(i, j int) bool { return emojis[i].Name < emojis[j].Name })
+
+	var creators map[string]string
+	if details {
+		creators = creatorNames(ctx, api, emojis)
+	}
+
+	if output == outputJSON {
+		listed := make([]listedEmoji, 0, len(emojis))
+		for _, emoji := range emojis {
+			listed = append(listed, listedEmoji{
+				Name:      emoji.Name,
+				ID:        emoji.ID,
+				CreatorID: emoji.CreatorID,
+				Creator:   creators[emoji.CreatorID],
+				CreatedAt: time.UnixMilli(emoji.CreateAt).UTC(),
+			})
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(listed); err != nil {
+			logError("❌ Error writing the list: %v\n", err)
+			return exitSetup
+		}
+		return exitOK
+	}
+
+	if !details {
+		for _, emoji := range emojis {
+			fmt.Println(emoji.Name)
+		}
+		return exitOK
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, emoji := range emojis {
+		creator := creators[emoji.CreatorID]
+		if creator == "" {
+			creator = emoji.CreatorID
+		}
+		created := time.UnixMilli(emoji.CreateAt).Format(time.DateTime)
+		fmt.Fprintf(w, "%s\t%s\t%s\n", emoji.Name, creator, created)
+	}
+	if err := w.Flush(); err != nil {
+		logError("❌ Error writing the list: %v\n", err)
+		return exitSetup
+	}
+	return exitOK
+}
+
+// creatorNames looks up the username of every creator of emojis, once per user.
+// Users that can't be looked up, e.g. because they were deleted or the token
+// may not read them, are left out and shown by their ID.
+func creatorNames(ctx context.Context, api *emojiuploader.Client, emojis []emojiuploader.Emoji) map[string]string {
+	names := make(map[string]string)
+	tried := make(map[string]bool)
+	for _, emoji := range emojis {
+		id := emoji.CreatorID
+		if id == "" || tried[id] {
+			continue
+		}
+		tried[id] = true
+
+		var user *emojiuploader.User
+		err := withRetry(ctx, retries+1, func() error {
+			var err error
+			user, err = api.UserByID(ctx, id)
+			return err
+		})
+		if err != nil {
+			logDebug("🔎 Could not look up creator %s: %v\n", id, err)
+			continue
+		}
+		names[id] = user.Username
+	}
+	return names
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/formatCvt/mattermost-emoji-uploader/emojiuploader"
+)
+
+// listServer returns a fakeServer with more emojis than fit on one page, the
+// first of them created by svc
+func listServer(t *testing.T) (*fakeServer, int) {
+	srv := newFakeServer(t)
+	n := emojiuploader.MaxPageSize + 5
+	for i := 0; i < n; i++ {
+		e := srv.addEmoji(fmt.Sprintf("emoji%03d", i), pngData)
+		if i == 0 {
+			e.CreatorID = "svc1"
+		}
+	}
+	return srv, n
+}
+
+// runListCLI runs the tool with args against srv and returns the exit code,
+// what was printed to stdout and the log output
+func runListCLI(t *testing.T, srv *fakeServer, args ...string) (int, string, string) {
+	var code int
+	var log string
+	stdout := captureStdout(t, func() {
+		code, log = runCLI(t, append([]string{"-s", srv.URL, "-t", "tok", "--list"}, args...)...)
+	})
+	return code, stdout, log
+}
+
+func TestList(t *testing.T) {
+	srv, n := listServer(t)
+	code, stdout, log := runListCLI(t, srv)
+	if code != exitOK {
+		t.Fatalf("exit code %d, output:\n%s", code, log)
+	}
+	lines := strings.Split(strings.TrimSuffix(stdout, "\n"), "\n")
+	if len(lines) != n {
+		t.Fatalf("%d lines, want all %d emojis across the pages", len(lines), n)
+	}
+	for i, line := range lines {
+		if want := fmt.Sprintf("emoji%03d", i); line != want {
+			t.Errorf("line %d = %q, want %q in name order", i+1, line, want)
+			break
+		}
+	}
+	if pages := srv.requestCount("GET /api/v4/emoji"); pages != 2 {
+		t.Errorf("%d page requests, want 2", pages)
+	}
+	// Listing changes nothing
+	if uploads := srv.requestCount("POST "); uploads != 0 {
+		t.Errorf("%d POST requests while listing", uploads)
+	}
+}
+
+func TestListDetails(t *testing.T) {
+	srv, n := listServer(t)
+	code, stdout, log := runListCLI(t, srv, "--list-details")
+	if code != exitOK {
+		t.Fatalf("exit code %d, output:\n%s", code, log)
+	}
+	lines := strings.Split(strings.TrimSuffix(stdout, "\n"), "\n")
+	if len(lines) != n {
+		t.Fatalf("%d lines, want %d", len(lines), n)
+	}
+	created := time.UnixMilli(1714564800000).Format(time.DateTime)
+	// Known creators are shown by username and the others by ID
+	if fields := strings.Fields(lines[0]); len(fields) != 4 || fields[0] != "emoji000" || fields[1] != "svc" || fields[2]+" "+fields[3] != created {
+		t.Errorf("line 1 = %q, want emoji000 by svc at %s", lines[0], created)
+	}
+	if fields := strings.Fields(lines[1]); len(fields) != 4 || fields[1] != "user1" {
+		t.Errorf("line 2 = %q, want the creator's ID", lines[1])
+	}
+	// Every creator is looked up once
+	if lookups := srv.requestCount("GET /api/v4/users/user1"); lookups != 1 {
+		t.Errorf("%d lookups of user1, want 1", lookups)
+	}
+}
+
+func TestListJSON(t *testing.T) {
+	srv, n := listServer(t)
+	code, stdout, log := runListCLI(t, srv, "--output", "json", "--list-details")
+	if code != exitOK {
+		t.Fatalf("exit code %d, output:\n%s", code, log)
+	}
+	var listed []listedEmoji
+	if err := json.Unmarshal([]byte(stdout), &listed); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, stdout)
+	}
+	if len(listed) != n {
+		t.Fatalf("%d emojis, want %d", len(listed), n)
+	}
+	want := listedEmoji{Name: "emoji000", ID: "e1", CreatorID: "svc1", Creator: "svc", CreatedAt: time.UnixMilli(1714564800000).UTC()}
+	if listed[0] != want {
+		t.Errorf("first emoji = %+v, want %+v", listed[0], want)
+	}
+	if listed[1].Creator != "" || listed[1].CreatorID != "user1" {
+		t.Errorf("second emoji = %+v, want no username for an unknown creator", listed[1])
+	}
+}
+
+func TestListInvalidOutput(t *testing.T) {
+	srv, _ := listServer(t)
+	if code, _, _ := runListCLI(t, srv, "--output", "xml"); code != exitSetup {
+		t.Errorf("exit code %d for --output xml, want %d", code, exitSetup)
+	}
+}
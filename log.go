@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// logLevel controls how much is printed while importing
+type logLevel int
+
+const (
+	// levelQuiet prints only errors and the final summary
+	levelQuiet logLevel = iota
+	// levelNormal additionally prints a line per emoji (the default)
+	levelNormal
+	// levelVerbose additionally prints URLs, content types, sizes and timings
+	levelVerbose
+)
+
+var verbosity = levelNormal
+
+// logOutput receives all messages and the progress bar: stdout, or stderr
+// with --json-summary so that stdout carries nothing but the summary
+var logOutput io.Writer = os.Stdout
+
+// progress is the active progress bar, if any; messages are printed above it
+var progress *progressBar
+
+// Values of --log-format
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// jsonLog replaces the human readable output with --log-format json
+var jsonLog *slog.Logger
+
+// newJSONLogger returns a logger writing a JSON object per line to w. The
+// level filtering is done by verbosity, so the handler passes everything.
+func newJSONLogger(w io.Writer) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: slog.LevelDebug}))
+}
+
+// printLog writes a formatted message to logOutput, keeping the progress bar intact.
+// With --log-format json the message becomes a log record at the given level.
+func printLog(level slog.Level, format string, args ...any) {
+	if jsonLog != nil {
+		if msg := plainMessage(fmt.Sprintf(format, args...)); msg != "" {
+			jsonLog.Log(context.Background(), level, msg)
+		}
+		return
+	}
+	if progress != nil {
+		progress.print(fmt.Sprintf(format, args...))
+		return
+	}
+	fmt.Fprintf(logOutput, format, args...)
+}
+
+// plainMessage strips the decoration meant for terminals, i.e. surrounding
+// whitespace and leading emojis, from a message
+func plainMessage(msg string) string {
+	return strings.TrimLeftFunc(strings.TrimSpace(msg), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune(`["'(`, r)
+	})
+}
+
+// logError prints problems; they are shown at every verbosity level
+func logError(format string, args ...any) {
+	level := slog.LevelError
+	if strings.HasPrefix(format, "⚠️") {
+		level = slog.LevelWarn
+	}
+	printLog(level, format, args...)
+}
+
+// logSummary prints the outcome of the run; it is shown at every verbosity level
+func logSummary(format string, args ...any) {
+	printLog(slog.LevelInfo, format, args...)
+}
+
+// logInfo prints progress messages, hidden with --quiet
+func logInfo(format string, args ...any) {
+	if verbosity >= levelNormal {
+		printLog(slog.LevelInfo, format, args...)
+	}
+}
+
+// logDebug prints details only shown with --verbose
+func logDebug(format string, args ...any) {
+	if verbosity >= levelVerbose {
+		printLog(slog.LevelDebug, format, args...)
+	}
+}
+
+// logResult records the outcome of a single emoji with --log-format json
+func logResult(r Result, duration time.Duration) {
+	level := slog.LevelInfo
+	switch {
+	case r.Action == actionFailed:
+		level = slog.LevelError
+	case r.Action == actionSkipped && r.warning:
+		level = slog.LevelWarn
+	case verbosity < levelNormal:
+		return
+	}
+
+	attrs := []slog.Attr{
+		slog.String("name", r.OriginalName),
+		slog.String("sanitized_name", r.SanitizedName),
+		slog.String("url", describeSource(r.source)),
+		slog.String("action", r.Action),
+		slog.Float64("duration_seconds", duration.Seconds()),
+	}
+	if r.HTTPStatus != 0 {
+		attrs = append(attrs, slog.Int("status", r.HTTPStatus))
+	}
+	if r.SizeBytes != 0 {
+		attrs = append(attrs, slog.Int("size_bytes", r.SizeBytes))
+	}
+	if r.EmojiID != "" {
+		attrs = append(attrs, slog.String("emoji_id", r.EmojiID))
+	}
+	if r.SkipReason != "" {
+		attrs = append(attrs, slog.String("skip_reason", r.SkipReason))
+	}
+	if r.Reason != "" {
+		key := "reason"
+		if r.Action == actionFailed {
+			key = "error"
+		}
+		attrs = append(attrs, slog.String(key, r.Reason))
+	}
+	jsonLog.LogAttrs(context.Background(), level, "emoji "+r.Action, attrs...)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// logRecords parses the JSON log lines in out
+func logRecords(t *testing.T, out string) []map[string]any {
+	t.Helper()
+	var records []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		var rec map[string]any
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("line %q is not JSON: %v", line, err)
+		}
+		records = append(records, rec)
+	}
+	return records
+}
+
+func TestJSONLog(t *testing.T) {
+	t.Cleanup(func() { jsonLog, verbosity = nil, levelNormal })
+	var buf bytes.Buffer
+	jsonLog = newJSONLogger(&buf)
+	verbosity = levelNormal
+
+	logError("❌ Error listing existing emojis: %v\n", "boom")
+	logError("⚠️  Could not update state file\n")
+	logInfo("📋 Found %d existing emojis on the server\n", 3)
+	logDebug("🔎 not shown without --verbose\n")
+	logResult(Result{OriginalName: "Party", SanitizedName: "party", Action: actionUploaded, HTTPStatus: 201, SizeBytes: 42, EmojiID: "e1", source: "https://example.com/p.png"}, 1500*time.Millisecond)
+	logResult(Result{OriginalName: "gone", SanitizedName: "gone", Action: actionFailed, Reason: "Download error: HTTP 404", HTTPStatus: 404}, time.Second)
+
+	records := logRecords(t, buf.String())
+	want := []struct{ level, msg string }{
+		{"ERROR", "Error listing existing emojis: boom"},
+		{"WARN", "Could not update state file"},
+		{"INFO", "Found 3 existing emojis on the server"},
+		{"INFO", "emoji uploaded"},
+		{"ERROR", "emoji failed"},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("%d records, want %d:\n%s", len(records), len(want), buf.String())
+	}
+	for i, w := range want {
+		if records[i]["level"] != w.level || records[i]["msg"] != w.msg {
+			t.Errorf("record %d = %v, want %s %q", i, records[i], w.level, w.msg)
+		}
+	}
+
+	uploaded := records[3]
+	for key, value := range map[string]any{
+		"name": "Party", "sanitized_name": "party", "url": "https://example.com/p.png",
+		"action": actionUploaded, "status": 201.0, "size_bytes": 42.0, "emoji_id": "e1", "duration_seconds": 1.5,
+	} {
+		if uploaded[key] != value {
+			t.Errorf("uploaded record %s = %v, want %v", key, uploaded[key], value)
+		}
+	}
+	if failed := records[4]; failed["error"] != "Download error: HTTP 404" || failed["reason"] != nil {
+		t.Errorf("failed record = %v, want the reason as error", failed)
+	}
+}
+
+func TestPlainMessage(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"❌ Error: nope\n", "Error: nope"},
+		{"⚠️  [:party:] unknown type\n", "[:party:] unknown type"},
+		{"\n✅ Done\n", "Done"},
+		{"📝 \"quoted\" file\n", `"quoted" file`},
+		{"🎉\n", ""},
+	}
+	for _, tt := range tests {
+		if got := plainMessage(tt.in); got != tt.want {
+			t.Errorf("plainMessage(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestLogFormatJSON(t *testing.T) {
+	srv := newFakeServer(t)
+	file := sourceFile(t, "party", srv.img("party.png", pngData), "missing", srv.URL+"/img/missing.png")
+
+	code, _, out := runImport(t, srv, file, "--log-format", "json", "--retries", "0")
+	if code != exitFailures {
+		t.Errorf("exit code %d, want %d\n%s", code, exitFailures, out)
+	}
+	// Every line is a JSON record, including the summary
+	results := make(map[string]map[string]any)
+	for _, rec := range logRecords(t, out) {
+		if name, ok := rec["name"].(string); ok {
+			results[name] = rec
+		}
+	}
+	if r := results["party"]; r["msg"] != "emoji uploaded" || r["size_bytes"] != float64(len(pngData)) || r["emoji_id"] == nil {
+		t.Errorf("party: %v, want an uploaded record", r)
+	}
+	if r := results["missing"]; r["level"] != "ERROR" || r["status"] != 404.0 || r["error"] == nil {
+		t.Errorf("missing: %v, want an error record with the status", r)
+	}
+}
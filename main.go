@@ -1,257 +1,1989 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
+	"archive/zip"
+	"context"
+	"encoding/base64"
+	"errors"
 	"flag"
 	"fmt"
-	"io"
-	"mime/multipart"
 	"net/http"
+	"net/url"
 	"os"
-	"regexp"
+	"os/signal"
+	"path/filepath"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
-	"github.com/mozillazg/go-unidecode"
+	"github.com/formatCvt/mattermost-emoji-uploader/emojiuploader"
+	"golang.org/x/time/rate"
 )
 
 // --- CONFIGURATION ---
 var (
-	serverURL string
-	token     string
-	jsonFile  string
+	serverURL    string
+	token        string
+	jsonFiles    fileListFlag
+	configFile   string
+	imageDir     string
+	zipPath      string
+	recursive    bool
+	dirPrefixes  bool
+	sinceValue   string
+	since        time.Time
+	concurrency  int
+	limit        int
+	retries      int
+	dryRunMode   bool
+	maxSizeKB    int
+	minSize      int
+	minDimension int
+	maxGIFSizeKB int
+	resizeImages bool
+	inputFormat  string
+	force        bool
+	overwrite    bool
+	failFast     bool
+	verifyUpload bool
+	reportFile   string
+	csvFile      string
+	skippedOut   string
+	statePath    string
+	strict       bool
+	maxURLReuse  int
+	loginID      string
+	password     string
+	verbose      bool
+	quiet        bool
+	timeout      time.Duration
+	itemTimeout  time.Duration
+	maxDuration  time.Duration
+	delay        time.Duration
+	cacheDir     string
+	cacheMaxAge  time.Duration
+	showProgress bool
+	logFormat    string
+	insecure     bool
+	maxConns     int
+	maxIdleConns int
+	perHost      int
+	caCertPath   string
+	proxyURL     string
+	userAgent    string
+	// imageHeader is sent with image downloads only (--image-header, --image-basic-auth)
+	imageHeader    = http.Header{}
+	imageBasicAuth string
+	namePrefix     string
+	nameSuffix     string
+	deleteMode     bool
+	deletePrefix   string
+	exportDir      string
+	nameMapPath    string
+	maxRedirects   int
+	maxAspect      float64
+	padSquare      bool
+	teamName       string
+	creatorID      string
+	creatorUser    string
+	// downloadConcurrency and uploadConcurrency size the two stages of the
+	// import; both default to --concurrency
+	downloadConcurrency int
+	uploadConcurrency   int
+	listMode            bool
+	listDetails         bool
+	listOutput          string
+	assumeYes           bool
+	interactive         bool
+	confirmAbove        int
+	requestRate         float64
+	burst               int
+	downloadProxy       string
+	metricsFile         string
+	normalizeJPEGs      bool
+	normalizeJPEGFormat string
+	noCSRFHeader        bool
+	jsonSummary         bool
+	maxGIFFrames        int
+	truncateGIFs        bool
+	slackToken          string
+	maxBandwidth        int64
+	noPing              bool
+)
+
+// Environment variables used when the corresponding flag is not set
+const (
+	serverURLEnv  = "MATTERMOST_URL"
+	tokenEnv      = "MATTERMOST_TOKEN"
+	slackTokenEnv = "SLACK_TOKEN"
+)
+
+// shutdownGrace is how long in-flight requests may run after an interrupt
+const shutdownGrace = 5 * time.Second
+
+// Exit codes of the tool
+const (
+	exitOK = 0
+	// exitSetup means the tool could not do its job: invalid flags or input,
+	// or an error outside of processing individual emojis
+	exitSetup = 1
+	// exitFailures means at least one emoji failed; skips don't count
+	exitFailures = 2
+	// exitAuth means the server rejected the credentials or the user lacks a
+	// permission the run needs
+	exitAuth = 3
+	// exitInterrupted means the run was stopped by a signal or --max-duration
+	// before every emoji was processed
+	exitInterrupted = 4
 )
 
 func init() {
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: %s [OPTIONS]\n\n", os.Args[0])
-		fmt.Fprintf(os.Stderr, "A tool to upload emojis to Mattermost from a JSON file.\n\n")
+		fmt.Fprintf(os.Stderr, "Usage: %s [COMMAND] [OPTIONS]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "A tool to upload emojis to Mattermost from a JSON or YAML file.\n\n")
+		fmt.Fprintf(os.Stderr, "Commands:\n")
+		for _, cmd := range commands {
+			fmt.Fprintf(os.Stderr, "  %-8s %s\n", cmd.name, cmd.summary)
+		}
+		fmt.Fprintf(os.Stderr, "\nRun %s COMMAND --help for the options of a command. Without a command\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "all options below are accepted and the mode flags choose what to do.\n\n")
 		fmt.Fprintf(os.Stderr, "Options:\n")
 		fmt.Fprintf(os.Stderr, "  -s, --server string\n")
-		fmt.Fprintf(os.Stderr, "        Mattermost server URL without trailing slash (required)\n")
+		fmt.Fprintf(os.Stderr, "        Mattermost server URL, e.g. https://mattermost.example.com (required, env: %s)\n", serverURLEnv)
 		fmt.Fprintf(os.Stderr, "  -t, --token string\n")
-		fmt.Fprintf(os.Stderr, "        Personal Access Token (required)\n")
+		fmt.Fprintf(os.Stderr, "        Personal Access Token (required, env: %s)\n", tokenEnv)
+		fmt.Fprintf(os.Stderr, "  --login-id string\n")
+		fmt.Fprintf(os.Stderr, "        Username or email to log in with instead of a token\n")
+		fmt.Fprintf(os.Stderr, "  --password string\n")
+		fmt.Fprintf(os.Stderr, "        Password for --login-id\n")
+		fmt.Fprintf(os.Stderr, "  --team string\n")
+		fmt.Fprintf(os.Stderr, "        Abort unless the user is a member of this team (team name as in the URL)\n")
+		fmt.Fprintf(os.Stderr, "  --config string\n")
+		fmt.Fprintf(os.Stderr, "        JSON file with default values for these flags (default: %s if present)\n", defaultConfigFile)
+		fmt.Fprintf(os.Stderr, "  --creator-id string\n")
+		fmt.Fprintf(os.Stderr, "        Record this user ID as the creator of the emojis instead of the token owner (admin use)\n")
+		fmt.Fprintf(os.Stderr, "  --creator-username string\n")
+		fmt.Fprintf(os.Stderr, "        Like --creator-id, but looks the user up by username\n")
 		fmt.Fprintf(os.Stderr, "  -f, --file string\n")
-		fmt.Fprintf(os.Stderr, "        Path to your source JSON file (required)\n")
+		fmt.Fprintf(os.Stderr, "        Path to your source JSON or YAML file, or - for stdin; repeat it or use a glob such as 'emoji/*.json' to merge several (required)\n")
+		fmt.Fprintf(os.Stderr, "  --dir string\n")
+		fmt.Fprintf(os.Stderr, "        Upload every image in this directory, named after the file, instead of using -f\n")
+		fmt.Fprintf(os.Stderr, "  --zip string\n")
+		fmt.Fprintf(os.Stderr, "        Upload the images in this ZIP archive, listed in its emoji.json or named after the file, instead of using -f\n")
+		fmt.Fprintf(os.Stderr, "  --recursive\n")
+		fmt.Fprintf(os.Stderr, "        Include the subdirectories of --dir\n")
+		fmt.Fprintf(os.Stderr, "  --dir-prefixes\n")
+		fmt.Fprintf(os.Stderr, "        Prefix names with their subdirectory, e.g. cats/happy.png becomes cats-happy\n")
+		fmt.Fprintf(os.Stderr, "  --format, --input-format string\n")
+		fmt.Fprintf(os.Stderr, "        Source file format: json, yaml or slack (default: detected from the file extension)\n")
+		fmt.Fprintf(os.Stderr, "  --name-map string\n")
+		fmt.Fprintf(os.Stderr, "        JSON or YAML file mapping original names to the names to use instead\n")
+		fmt.Fprintf(os.Stderr, "  --replace /pattern/replacement/\n")
+		fmt.Fprintf(os.Stderr, "        Rename with a regular expression while sanitizing names, e.g. '/^slack_//'; can be repeated\n")
+		fmt.Fprintf(os.Stderr, "  --strict-names\n")
+		fmt.Fprintf(os.Stderr, "        Report names that sanitizing would change as invalid entries instead of renaming them\n")
+		fmt.Fprintf(os.Stderr, "  --prefix string\n")
+		fmt.Fprintf(os.Stderr, "        Prepend this to every emoji name, e.g. acme-\n")
+		fmt.Fprintf(os.Stderr, "  --suffix string\n")
+		fmt.Fprintf(os.Stderr, "        Append this to every emoji name\n")
+		fmt.Fprintf(os.Stderr, "  -c, --concurrency int\n")
+		fmt.Fprintf(os.Stderr, "        Number of emojis processed in parallel (default 1)\n")
+		fmt.Fprintf(os.Stderr, "  --download-concurrency int\n")
+		fmt.Fprintf(os.Stderr, "        Number of images downloaded in parallel (default: --concurrency)\n")
+		fmt.Fprintf(os.Stderr, "  --upload-concurrency int\n")
+		fmt.Fprintf(os.Stderr, "        Number of emojis uploaded to Mattermost in parallel (default: --concurrency)\n")
+		fmt.Fprintf(os.Stderr, "  --per-host-concurrency int\n")
+		fmt.Fprintf(os.Stderr, "        Maximum simultaneous image downloads from a single host (default: no limit)\n")
+		fmt.Fprintf(os.Stderr, "  --limit int\n")
+		fmt.Fprintf(os.Stderr, "        Stop after this many emojis that aren't already on the server or finished (default: no limit)\n")
+		fmt.Fprintf(os.Stderr, "  --include pattern\n")
+		fmt.Fprintf(os.Stderr, "        Only process emojis whose original name matches this glob, e.g. 'cat-*' (repeatable)\n")
+		fmt.Fprintf(os.Stderr, "  --exclude pattern\n")
+		fmt.Fprintf(os.Stderr, "        Skip emojis whose original name matches this glob (repeatable)\n")
+		fmt.Fprintf(os.Stderr, "  --since time\n")
+		fmt.Fprintf(os.Stderr, "        Only process Slack emojis created at or after this RFC3339 time, e.g. 2024-05-01T00:00:00Z\n")
+		fmt.Fprintf(os.Stderr, "  --on-empty string\n")
+		fmt.Fprintf(os.Stderr, "        Names that are empty after sanitization: skip them or hash to name them emoji-<hash> (default skip)\n")
+		fmt.Fprintf(os.Stderr, "  --sort string\n")
+		fmt.Fprintf(os.Stderr, "        Processing order: original or sanitized name (default original)\n")
+		fmt.Fprintf(os.Stderr, "  -r, --retries int\n")
+		fmt.Fprintf(os.Stderr, "        Retries for transient download/upload failures (default 3)\n")
+		fmt.Fprintf(os.Stderr, "  --force\n")
+		fmt.Fprintf(os.Stderr, "        Upload even if an emoji with the same name already exists on the server\n")
+		fmt.Fprintf(os.Stderr, "  --overwrite\n")
+		fmt.Fprintf(os.Stderr, "        Replace emojis that already exist on the server by deleting and re-creating them\n")
+		fmt.Fprintf(os.Stderr, "  --on-conflict string\n")
+		fmt.Fprintf(os.Stderr, "        Names used twice or already on the server: skip, suffix, overwrite or error to abort\n")
+		fmt.Fprintf(os.Stderr, "        (default: suffix within the source, skip for names on the server)\n")
+		fmt.Fprintf(os.Stderr, "  --fail-fast\n")
+		fmt.Fprintf(os.Stderr, "        Stop at the first emoji that fails instead of starting further ones (skips don't count)\n")
+		fmt.Fprintf(os.Stderr, "  --verify\n")
+		fmt.Fprintf(os.Stderr, "        After uploading, check that every emoji can be fetched from the server\n")
+		fmt.Fprintf(os.Stderr, "  --report string\n")
+		fmt.Fprintf(os.Stderr, "        Write a JSON report of the run to this file\n")
+		fmt.Fprintf(os.Stderr, "  --json-summary\n")
+		fmt.Fprintf(os.Stderr, "        Print the summary as a JSON object to stdout and all other output to stderr\n")
+		fmt.Fprintf(os.Stderr, "  --metrics-file string\n")
+		fmt.Fprintf(os.Stderr, "        Write metrics of the run in the Prometheus text format, e.g. for node_exporter's textfile collector\n")
+		fmt.Fprintf(os.Stderr, "  --skipped-out string\n")
+		fmt.Fprintf(os.Stderr, "        Write the failed, skipped and renamed emojis with their reasons to this JSON file\n")
+		fmt.Fprintf(os.Stderr, "  --csv string\n")
+		fmt.Fprintf(os.Stderr, "        Write a CSV row for every emoji to this file as it is processed\n")
+		fmt.Fprintf(os.Stderr, "  --state string\n")
+		fmt.Fprintf(os.Stderr, "        Record finished emojis in this file and skip them on the next run\n")
+		fmt.Fprintf(os.Stderr, "  --strict\n")
+		fmt.Fprintf(os.Stderr, "        Abort if the source file contains invalid entries instead of skipping them\n")
+		fmt.Fprintf(os.Stderr, "  --max-url-reuse int\n")
+		fmt.Fprintf(os.Stderr, "        Warn when one image URL is used by more than this many emojis, abort with --strict (default: no check)\n")
+		fmt.Fprintf(os.Stderr, "  --progress\n")
+		fmt.Fprintf(os.Stderr, "        Show a progress bar instead of a line per emoji (terminals only)\n")
+		fmt.Fprintf(os.Stderr, "  --log-format string\n")
+		fmt.Fprintf(os.Stderr, "        Output format: text or json, one JSON log record per line (default text)\n")
+		fmt.Fprintf(os.Stderr, "  -v, --verbose\n")
+		fmt.Fprintf(os.Stderr, "        Also print URLs, content types, sizes and timings\n")
+		fmt.Fprintf(os.Stderr, "  -q, --quiet\n")
+		fmt.Fprintf(os.Stderr, "        Only print errors and the final summary\n")
+		fmt.Fprintf(os.Stderr, "  --timeout duration\n")
+		fmt.Fprintf(os.Stderr, "        Time limit for a single HTTP request including the body, e.g. 45s or 2m (default 30s)\n")
+		fmt.Fprintf(os.Stderr, "  --item-timeout duration\n")
+		fmt.Fprintf(os.Stderr, "        Time limit for downloading and uploading a single emoji, including retries (default: no limit)\n")
+		fmt.Fprintf(os.Stderr, "  --max-duration duration\n")
+		fmt.Fprintf(os.Stderr, "        Stop starting new emojis once the whole run has taken this long, e.g. 30m (default: no limit)\n")
+		fmt.Fprintf(os.Stderr, "  --rate float\n")
+		fmt.Fprintf(os.Stderr, "        Maximum Mattermost API requests per second shared by all workers, replacing --delay (default: no limit)\n")
+		fmt.Fprintf(os.Stderr, "  --burst int\n")
+		fmt.Fprintf(os.Stderr, "        Requests that may go out at once before --rate applies (default 1)\n")
+		fmt.Fprintf(os.Stderr, "  --delay duration\n")
+		fmt.Fprintf(os.Stderr, "        Average pause between requests, varied by ±50%%, while the server doesn't report a rate limit (default 200ms)\n")
+		fmt.Fprintf(os.Stderr, "  --cache-dir string\n")
+		fmt.Fprintf(os.Stderr, "        Keep downloaded images in this directory and reuse them on later runs\n")
+		fmt.Fprintf(os.Stderr, "  --cache-max-age duration\n")
+		fmt.Fprintf(os.Stderr, "        Remove cached images not fetched or revalidated for this long, e.g. 168h (default: keep)\n")
+		fmt.Fprintf(os.Stderr, "  --max-redirects int\n")
+		fmt.Fprintf(os.Stderr, "        Redirects followed per request before it fails (default 10)\n")
+		fmt.Fprintf(os.Stderr, "  --max-conns int\n")
+		fmt.Fprintf(os.Stderr, "        Maximum connections to a single host, including the Mattermost server (default: no limit)\n")
+		fmt.Fprintf(os.Stderr, "  --max-idle-conns int\n")
+		fmt.Fprintf(os.Stderr, "        Idle connections kept open per host for reuse (default: the larger concurrency, at least 2)\n")
+		fmt.Fprintf(os.Stderr, "  --insecure\n")
+		fmt.Fprintf(os.Stderr, "        Skip TLS certificate verification (unsafe)\n")
+		fmt.Fprintf(os.Stderr, "  --cacert string\n")
+		fmt.Fprintf(os.Stderr, "        PEM file with additional CA certificates to trust, e.g. a private CA\n")
+		fmt.Fprintf(os.Stderr, "  --user-agent string\n")
+		fmt.Fprintf(os.Stderr, "        User-Agent header sent with all requests (default %q)\n", defaultUserAgent())
+		fmt.Fprintf(os.Stderr, "  --proxy string\n")
+		fmt.Fprintf(os.Stderr, "        Proxy for all requests, e.g. http://proxy:3128 or socks5://proxy:1080 (default: HTTP_PROXY/HTTPS_PROXY)\n")
+		fmt.Fprintf(os.Stderr, "  --download-proxy string\n")
+		fmt.Fprintf(os.Stderr, "        SOCKS5 proxy for image downloads only, e.g. socks5://proxy:1080; Mattermost requests are not affected\n")
+		fmt.Fprintf(os.Stderr, "  --no-csrf-header\n")
+		fmt.Fprintf(os.Stderr, "        Don't send X-Requested-With: XMLHttpRequest with API writes\n")
+		fmt.Fprintf(os.Stderr, "  --no-ping\n")
+		fmt.Fprintf(os.Stderr, "        Don't check that the server answers /api/v4/system/ping before starting\n")
+		fmt.Fprintf(os.Stderr, "  --max-bandwidth int\n")
+		fmt.Fprintf(os.Stderr, "        Limit image downloads to this many bytes per second in total, e.g. 1000000 (default: no limit)\n")
+		fmt.Fprintf(os.Stderr, "  --image-header string\n")
+		fmt.Fprintf(os.Stderr, "        Extra \"Key: Value\" header for image downloads, never sent to Mattermost (repeatable)\n")
+		fmt.Fprintf(os.Stderr, "  --image-basic-auth string\n")
+		fmt.Fprintf(os.Stderr, "        user:password for image downloads, never sent to Mattermost\n")
+		fmt.Fprintf(os.Stderr, "  --slack-token string\n")
+		fmt.Fprintf(os.Stderr, "        Slack token for image downloads from slack.com and its subdomains only (default: $%s)\n", slackTokenEnv)
+		fmt.Fprintf(os.Stderr, "  --delete\n")
+		fmt.Fprintf(os.Stderr, "        Delete the emojis listed in --file from the server instead of uploading them\n")
+		fmt.Fprintf(os.Stderr, "  --delete-by-prefix string\n")
+		fmt.Fprintf(os.Stderr, "        Delete every emoji on the server whose name starts with this prefix\n")
+		fmt.Fprintf(os.Stderr, "  --export string\n")
+		fmt.Fprintf(os.Stderr, "        Download all custom emojis from the server into this directory instead of uploading\n")
+		fmt.Fprintf(os.Stderr, "  --list\n")
+		fmt.Fprintf(os.Stderr, "        Print the names of the custom emojis on the server instead of uploading\n")
+		fmt.Fprintf(os.Stderr, "  --list-details\n")
+		fmt.Fprintf(os.Stderr, "        With --list, also print the creator and creation date of every emoji\n")
+		fmt.Fprintf(os.Stderr, "  --output string\n")
+		fmt.Fprintf(os.Stderr, "        Format of --list: text or json (default \"text\")\n")
+		fmt.Fprintf(os.Stderr, "  -n, --dry-run\n")
+		fmt.Fprintf(os.Stderr, "        Only report what would be uploaded; no network requests are made\n")
+		fmt.Fprintf(os.Stderr, "  --confirm-above int\n")
+		fmt.Fprintf(os.Stderr, "        Ask before uploading more than this many emojis, 0 to never ask (default %d)\n", defaultConfirmAbove)
+		fmt.Fprintf(os.Stderr, "  --interactive\n")
+		fmt.Fprintf(os.Stderr, "        Always ask before uploading\n")
+		fmt.Fprintf(os.Stderr, "  -y, --yes\n")
+		fmt.Fprintf(os.Stderr, "        Don't ask, e.g. in scripts; without a terminal the tool never asks\n")
+		fmt.Fprintf(os.Stderr, "  --max-size int\n")
+		fmt.Fprintf(os.Stderr, "        Maximum size of a static image in KB (default 512)\n")
+		fmt.Fprintf(os.Stderr, "  --max-gif-size int\n")
+		fmt.Fprintf(os.Stderr, "        Maximum size of an animated GIF in KB (default 1024)\n")
+		fmt.Fprintf(os.Stderr, "  --max-gif-frames int\n")
+		fmt.Fprintf(os.Stderr, "        Skip animated GIFs with more frames than this, e.g. 100 (default: no limit)\n")
+		fmt.Fprintf(os.Stderr, "  --truncate-gifs\n")
+		fmt.Fprintf(os.Stderr, "        Keep the first --max-gif-frames frames of longer GIFs instead of skipping them\n")
+		fmt.Fprintf(os.Stderr, "  --min-size int\n")
+		fmt.Fprintf(os.Stderr, "        Skip downloaded images smaller than this many bytes, such as tracking pixels (default: no minimum)\n")
+		fmt.Fprintf(os.Stderr, "  --min-dimension int\n")
+		fmt.Fprintf(os.Stderr, "        Skip images narrower or shorter than this many pixels, e.g. 8 (default: no minimum)\n")
+		fmt.Fprintf(os.Stderr, "  --allow-formats list\n")
+		fmt.Fprintf(os.Stderr, "        Image formats that may be uploaded, e.g. png,gif or image/svg+xml; others are skipped (default png,jpeg,gif)\n")
+		fmt.Fprintf(os.Stderr, "  --resize\n")
+		fmt.Fprintf(os.Stderr, "        Downscale static images over the size limit instead of skipping them\n")
+		fmt.Fprintf(os.Stderr, "  --max-aspect float\n")
+		fmt.Fprintf(os.Stderr, "        Skip images whose longer side is more than this many times the shorter one, e.g. 2\n")
+		fmt.Fprintf(os.Stderr, "  --pad-square\n")
+		fmt.Fprintf(os.Stderr, "        Pad non-square images (or those over --max-aspect) with transparency instead\n")
+		fmt.Fprintf(os.Stderr, "  --normalize-jpeg\n")
+		fmt.Fprintf(os.Stderr, "        Apply the EXIF orientation of JPEGs and convert CMYK JPEGs to RGB before uploading\n")
+		fmt.Fprintf(os.Stderr, "  --normalize-jpeg-format string\n")
+		fmt.Fprintf(os.Stderr, "        Format of JPEGs fixed by --normalize-jpeg: jpeg or png (default jpeg)\n")
 		fmt.Fprintf(os.Stderr, "\nExamples:\n")
 		fmt.Fprintf(os.Stderr, "  %s -server https://mattermost.example.com -token TOKEN -file emoji.json\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  %s -s https://mattermost.example.com -t TOKEN -f emoji.json\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s=TOKEN %s -s https://mattermost.example.com -f emoji.json\n", tokenEnv, os.Args[0])
+		fmt.Fprintf(os.Stderr, "\nFlags take precedence over environment variables.\n")
 		fmt.Fprintf(os.Stderr, "\nFor more information, see: https://github.com/formatCvt/mattermost-emoji-uploader\n")
 	}
 
-	flag.StringVar(&serverURL, "server", "", "Mattermost server URL without trailing slash (required)")
-	flag.StringVar(&serverURL, "s", "", "Mattermost server URL without trailing slash (required)")
-	flag.StringVar(&token, "token", "", "Personal Access Token (required)")
-	flag.StringVar(&token, "t", "", "Personal Access Token (required)")
-	flag.StringVar(&jsonFile, "file", "", "Path to your source JSON file (required)")
-	flag.StringVar(&jsonFile, "f", "", "Path to your source JSON file (required)")
+	connectionFlags(flag.CommandLine)
+	sourceFlags(flag.CommandLine)
+	importFlags(flag.CommandLine)
+	modeFlags(flag.CommandLine)
+}
+
+// connectionFlags registers the flags every command needs to reach the
+// server and to control its output
+func connectionFlags(fs *flag.FlagSet) {
+	fs.StringVar(&serverURL, "server", "", "Mattermost server URL, e.g. https://mattermost.example.com (required)")
+	fs.StringVar(&serverURL, "s", "", "Mattermost server URL, e.g. https://mattermost.example.com (required)")
+	fs.StringVar(&token, "token", "", "Personal Access Token (required)")
+	fs.StringVar(&token, "t", "", "Personal Access Token (required)")
+	fs.StringVar(&loginID, "login-id", "", "Username or email to log in with instead of a token")
+	fs.StringVar(&password, "password", "", "Password for --login-id")
+	fs.StringVar(&teamName, "team", "", "Abort unless the user is a member of this team (team name as in the URL)")
+	fs.StringVar(&configFile, "config", "", "JSON file with default values for these flags")
+	fs.IntVar(&retries, "retries", 3, "Retries for transient download/upload failures")
+	fs.IntVar(&retries, "r", 3, "Retries for transient download/upload failures")
+	fs.StringVar(&logFormat, "log-format", logFormatText, "Output format: text or json, one JSON log record per line")
+	fs.BoolVar(&verbose, "verbose", false, "Also print URLs, content types, sizes and timings")
+	fs.BoolVar(&verbose, "v", false, "Also print URLs, content types, sizes and timings")
+	fs.BoolVar(&quiet, "quiet", false, "Only print errors and the final summary")
+	fs.BoolVar(&quiet, "q", false, "Only print errors and the final summary")
+	fs.DurationVar(&timeout, "timeout", 30*time.Second, "Time limit for a single HTTP request including the body")
+	fs.Float64Var(&requestRate, "rate", 0, "Maximum Mattermost API requests per second, replacing --delay")
+	fs.IntVar(&burst, "burst", 1, "Requests that may go out at once before --rate applies")
+	// delay is divided among the workers, see uploadLimiter
+	fs.DurationVar(&delay, "delay", 200*time.Millisecond, "Average pause between requests while the server doesn't report a rate limit")
+	fs.IntVar(&maxRedirects, "max-redirects", 10, "Redirects followed per request before it fails")
+	fs.IntVar(&maxConns, "max-conns", 0, "Maximum connections to a single host, including the Mattermost server")
+	fs.IntVar(&maxIdleConns, "max-idle-conns", 0, "Idle connections kept open per host for reuse")
+	fs.BoolVar(&insecure, "insecure", false, "Skip TLS certificate verification (unsafe)")
+	fs.StringVar(&caCertPath, "cacert", "", "PEM file with additional CA certificates to trust")
+	fs.StringVar(&proxyURL, "proxy", "", "Proxy for all requests (default: HTTP_PROXY/HTTPS_PROXY)")
+	fs.BoolVar(&noCSRFHeader, "no-csrf-header", false, "Don't send X-Requested-With: XMLHttpRequest with API writes")
+	fs.BoolVar(&noPing, "no-ping", false, "Don't check that the server answers /api/v4/system/ping before starting")
+	fs.StringVar(&userAgent, "user-agent", defaultUserAgent(), "User-Agent header sent with all requests")
+}
+
+// sourceFlags registers the flags that read the source of an import and
+// name its emojis; deleting by source uses them as well
+func sourceFlags(fs *flag.FlagSet) {
+	fs.Var(&jsonFiles, "file", "Path to your source JSON or YAML file, or - for stdin; can be repeated (required)")
+	fs.StringVar(&imageDir, "dir", "", "Upload every image in this directory, named after the file, instead of using -f")
+	fs.StringVar(&zipPath, "zip", "", "Upload the images in this ZIP archive instead of using -f")
+	fs.BoolVar(&recursive, "recursive", false, "Include the subdirectories of --dir")
+	fs.BoolVar(&dirPrefixes, "dir-prefixes", false, "Prefix names with their subdirectory")
+	fs.Var(&jsonFiles, "f", "Path to your source JSON or YAML file, or - for stdin; can be repeated (required)")
+	fs.StringVar(&inputFormat, "format", "", "Source file format: json, yaml or slack (default: detected from the file extension)")
+	fs.StringVar(&inputFormat, "input-format", "", "Source file format: json, yaml or slack (default: detected from the file extension)")
+	fs.StringVar(&nameMapPath, "name-map", "", "JSON or YAML file mapping original names to the names to use instead")
+	fs.Var(&replaceRules, "replace", "Rename with a regular expression while sanitizing names, as /pattern/replacement/")
+	fs.BoolVar(&strictNames, "strict-names", false, "Report names that sanitizing would change as invalid entries instead of renaming them")
+	fs.StringVar(&namePrefix, "prefix", "", "Prepend this to every emoji name")
+	fs.StringVar(&nameSuffix, "suffix", "", "Append this to every emoji name")
+	fs.Var(&includePatterns, "include", "Only process emojis whose original name matches this glob")
+	fs.Var(&excludePatterns, "exclude", "Skip emojis whose original name matches this glob")
+	fs.StringVar(&sinceValue, "since", "", "Only process Slack emojis created at or after this RFC3339 time")
+	fs.StringVar(&onEmpty, "on-empty", onEmptySkip, "Names that are empty after sanitization: skip or hash")
+	fs.StringVar(&sortOrder, "sort", sortOriginal, "Processing order: original or sanitized name")
+	fs.BoolVar(&strict, "strict", false, "Abort if the source file contains invalid entries instead of skipping them")
+	fs.IntVar(&maxURLReuse, "max-url-reuse", 0, "Warn when one image URL is used by more than this many emojis")
+}
+
+// importFlags registers the flags of an import: processing, images,
+// downloads, reports and confirmation
+func importFlags(fs *flag.FlagSet) {
+	fs.StringVar(&creatorID, "creator-id", "", "Record this user ID as the creator of the emojis instead of the token owner")
+	fs.StringVar(&creatorUser, "creator-username", "", "Like --creator-id, but looks the user up by username")
+	fs.IntVar(&concurrency, "concurrency", 1, "Number of emojis processed in parallel")
+	fs.IntVar(&concurrency, "c", 1, "Number of emojis processed in parallel")
+	fs.IntVar(&downloadConcurrency, "download-concurrency", 0, "Number of images downloaded in parallel")
+	fs.IntVar(&uploadConcurrency, "upload-concurrency", 0, "Number of emojis uploaded in parallel")
+	fs.IntVar(&perHost, "per-host-concurrency", 0, "Maximum simultaneous image downloads from a single host")
+	fs.IntVar(&limit, "limit", 0, "Stop after this many emojis that aren't already on the server or finished")
+	fs.BoolVar(&force, "force", false, "Upload even if an emoji with the same name already exists on the server")
+	fs.BoolVar(&overwrite, "overwrite", false, "Replace emojis that already exist on the server by deleting and re-creating them")
+	fs.StringVar(&onConflict, "on-conflict", "", "Names used twice or already on the server: skip, suffix, overwrite or error")
+	fs.BoolVar(&failFast, "fail-fast", false, "Stop at the first emoji that fails instead of starting further ones")
+	fs.BoolVar(&verifyUpload, "verify", false, "After uploading, check that every emoji can be fetched from the server")
+	fs.StringVar(&reportFile, "report", "", "Write a JSON report of the run to this file")
+	fs.BoolVar(&jsonSummary, "json-summary", false, "Print the summary as a JSON object to stdout and all other output to stderr")
+	fs.StringVar(&metricsFile, "metrics-file", "", "Write metrics of the run to this file in the Prometheus text format")
+	fs.StringVar(&skippedOut, "skipped-out", "", "Write the failed, skipped and renamed emojis with their reasons to this JSON file")
+	fs.StringVar(&csvFile, "csv", "", "Write a CSV row for every emoji to this file as it is processed")
+	fs.StringVar(&statePath, "state", "", "Record finished emojis in this file and skip them on the next run")
+	fs.BoolVar(&showProgress, "progress", false, "Show a progress bar instead of a line per emoji (terminals only)")
+	fs.DurationVar(&itemTimeout, "item-timeout", 0, "Time limit for downloading and uploading a single emoji, including retries")
+	fs.DurationVar(&maxDuration, "max-duration", 0, "Stop starting new emojis once the whole run has taken this long")
+	fs.StringVar(&cacheDir, "cache-dir", "", "Keep downloaded images in this directory and reuse them on later runs")
+	fs.DurationVar(&cacheMaxAge, "cache-max-age", 0, "Remove cached images not fetched or revalidated for this long")
+	fs.Int64Var(&maxBandwidth, "max-bandwidth", 0, "Limit image downloads to this many bytes per second in total")
+	fs.StringVar(&downloadProxy, "download-proxy", "", "SOCKS5 proxy for image downloads only, e.g. socks5://proxy:1080")
+	fs.Var(headerFlag(imageHeader), "image-header", "Extra \"Key: Value\" header for image downloads (repeatable)")
+	fs.StringVar(&imageBasicAuth, "image-basic-auth", "", "user:password for image downloads")
+	fs.StringVar(&slackToken, "slack-token", "", "Slack token for image downloads from slack.com and its subdomains only")
+	fs.BoolVar(&dryRunMode, "dry-run", false, "Only report what would be uploaded")
+	fs.BoolVar(&dryRunMode, "n", false, "Only report what would be uploaded")
+	fs.IntVar(&confirmAbove, "confirm-above", defaultConfirmAbove, "Ask before uploading more than this many emojis, 0 to never ask")
+	fs.BoolVar(&interactive, "interactive", false, "Always ask before uploading")
+	fs.BoolVar(&assumeYes, "yes", false, "Don't ask before uploading")
+	fs.BoolVar(&assumeYes, "y", false, "Don't ask before uploading")
+	fs.IntVar(&maxSizeKB, "max-size", 512, "Maximum size of a static image in KB")
+	fs.IntVar(&maxGIFSizeKB, "max-gif-size", 1024, "Maximum size of an animated GIF in KB")
+	fs.IntVar(&maxGIFFrames, "max-gif-frames", 0, "Skip animated GIFs with more frames than this")
+	fs.BoolVar(&truncateGIFs, "truncate-gifs", false, "Keep the first --max-gif-frames frames of longer GIFs instead of skipping them")
+	fs.IntVar(&minSize, "min-size", 0, "Skip downloaded images smaller than this many bytes")
+	fs.IntVar(&minDimension, "min-dimension", 0, "Skip images narrower or shorter than this many pixels")
+	fs.Var(&allowFormats, "allow-formats", "Image formats that may be uploaded, e.g. png,gif or image/svg+xml")
+	fs.BoolVar(&resizeImages, "resize", false, "Downscale static images over the size limit instead of skipping them")
+	fs.Float64Var(&maxAspect, "max-aspect", 0, "Skip images whose longer side is more than this many times the shorter one")
+	fs.BoolVar(&padSquare, "pad-square", false, "Pad non-square images (or those over --max-aspect) with transparency instead")
+	fs.BoolVar(&normalizeJPEGs, "normalize-jpeg", false, "Apply the EXIF orientation of JPEGs and convert CMYK JPEGs to RGB before uploading")
+	fs.StringVar(&normalizeJPEGFormat, "normalize-jpeg-format", jpegFormatJPEG, "Format of JPEGs fixed by --normalize-jpeg: jpeg or png")
+}
+
+// modeFlags registers the flags that switch the default command line to
+// another command, kept so that existing scripts work unchanged
+func modeFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&deleteMode, "delete", false, "Delete the emojis listed in --file from the server instead of uploading them")
+	fs.StringVar(&deletePrefix, "delete-by-prefix", "", "Delete every emoji on the server whose name starts with this prefix")
+	fs.StringVar(&exportDir, "export", "", "Download all custom emojis from the server into this directory instead of uploading")
+	fs.BoolVar(&listMode, "list", false, "Print the names of the custom emojis on the server instead of uploading")
+	fs.BoolVar(&listDetails, "list-details", false, "With --list, also print the creator and creation date of every emoji")
+	fs.StringVar(&listOutput, "output", outputText, "Format of --list: text or json")
 }
 
 type EmojiMap map[string]string
 
-type UserInfo struct {
-	ID string `json:"id"`
+// importer holds the state shared by all workers during an import
+type importer struct {
+	// client downloads the images, api talks to Mattermost
+	client *http.Client
+	api    *emojiuploader.Client
+	// header is added to image downloads
+	header http.Header
+	// slackToken authenticates downloads from Slack, see imageHeader
+	slackToken string
+	// baseDir is the directory relative image paths are resolved against
+	baseDir string
+	// existing contains the names of emojis already present on the server
+	existing map[string]bool
+	// names maps the original names of this run's emojis to their final names
+	names map[string]string
+	// done contains the names finished in a previous run according to the state file
+	done    map[string]bool
+	state   *stateFile
+	csv     *csvLog
+	limiter *uploadLimiter
+	cache   *imageCache
+	// disk keeps http(s) downloads across runs with --cache-dir
+	disk *diskCache
+	// hosts limits the downloads per image host, nil without --per-host-concurrency
+	hosts *hostLimiter
+
+	// images keeps the data of emojis uploaded in this run so aliases can reuse it
+	mu     sync.Mutex
+	images map[string]emojiImage
+
+	// stopped is closed when --fail-fast ends the run after a failure. As on
+	// an interrupt, the requests in flight are cancelled with cancelRequests
+	// after a grace period, so that --overwrite doesn't leave emojis deleted.
+	stopped        chan struct{}
+	stopOnce       sync.Once
+	cancelRequests context.CancelFunc
+}
+
+// emojiImage is downloaded image data together with its media type
+type emojiImage struct {
+	data        []byte
+	contentType string
+}
+
+// emojiJob is a single entry from the source file waiting to be processed
+type emojiJob struct {
+	originalName string
+	url          string
+	// safeName is the final Mattermost name, see assignNames
+	safeName string
+	// conflictWith is the original name of another emoji of the run that gets
+	// the same safeName under --on-conflict skip, overwrite or error; the job
+	// is skipped in favor of it
+	conflictWith string
+}
+
+// resolveSetting returns the flag value, falling back to the environment variable when the flag is empty
+func resolveSetting(flagValue, envName string) string {
+	if flagValue != "" {
+		return flagValue
+	}
+	return os.Getenv(envName)
+}
+
+// normalizeServerURL checks that value is an absolute http or https URL and
+// strips trailing slashes, which would otherwise produce "//api/v4" paths
+func normalizeServerURL(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	// Without a scheme url.Parse fails with a confusing message or reads the host as a path
+	lower := strings.ToLower(value)
+	if !strings.HasPrefix(lower, "http://") && !strings.HasPrefix(lower, "https://") {
+		return "", fmt.Errorf("invalid server URL %q: must start with http:// or https://", value)
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		return "", fmt.Errorf("invalid server URL: %w", err)
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("invalid server URL %q: missing host", value)
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("invalid server URL %q: must not contain a query or fragment", value)
+	}
+	u.Path = strings.TrimRight(u.Path, "/")
+	u.RawPath = ""
+	return u.String(), nil
 }
 
 func main() {
-	flag.Parse()
+	os.Exit(run())
+}
 
-	// Validate required flags
-	if serverURL == "" {
-		fmt.Fprintf(os.Stderr, "❌ Error: -server/-s flag is required\n")
-		flag.Usage()
-		os.Exit(1)
+// run is the whole tool. It returns the exit code instead of exiting, so that
+// deferred cleanup such as flushing the state file still happens.
+func run() int {
+	start := time.Now()
+	// Bad flags return exitSetup like every other invalid option, rather than
+	// the flag package's own status 2, which means failed emojis here
+	fs, err := parseCommandLine(os.Args[1:])
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitSetup
 	}
-	if token == "" {
-		fmt.Fprintf(os.Stderr, "❌ Error: -token/-t flag is required\n")
-		flag.Usage()
-		os.Exit(1)
+
+	// Settings from the config file apply where neither a flag nor the environment says otherwise
+	if path := configPath(configFile); path != "" {
+		if err := applyConfig(fs, path); err != nil {
+			logError("❌ Error reading config: %v\n", err)
+			return exitSetup
+		}
+	}
+
+	serverURL = resolveSetting(serverURL, serverURLEnv)
+	token = resolveSetting(token, tokenEnv)
+	slackToken = resolveSetting(slackToken, slackTokenEnv)
+
+	// Validate required flags (server and token are not needed for a dry run)
+	if serverURL == "" && !dryRunMode {
+		fmt.Fprintf(os.Stderr, "❌ Error: -server/-s flag or %s is required\n", serverURLEnv)
+		fs.Usage()
+		return exitSetup
+	}
+	if serverURL != "" {
+		normalized, err := normalizeServerURL(serverURL)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "❌ Error: -server: %v\n", err)
+			fs.Usage()
+			return exitSetup
+		}
+		serverURL = normalized
+	}
+	if (loginID == "") != (password == "") {
+		fmt.Fprintf(os.Stderr, "❌ Error: -login-id and -password must be used together\n")
+		fs.Usage()
+		return exitSetup
+	}
+	if token == "" && loginID == "" && !dryRunMode {
+		fmt.Fprintf(os.Stderr, "❌ Error: -token/-t flag or %s is required (or -login-id and -password)\n", tokenEnv)
+		fs.Usage()
+		return exitSetup
+	}
+	if len(jsonFiles) == 0 && imageDir == "" && zipPath == "" && deletePrefix == "" && exportDir == "" && !listMode {
+		fmt.Fprintf(os.Stderr, "❌ Error: -file/-f, -dir or -zip flag is required\n")
+		fs.Usage()
+		return exitSetup
+	}
+	if creatorID != "" && creatorUser != "" {
+		fmt.Fprintf(os.Stderr, "❌ Error: -creator-id and -creator-username can't be used together\n")
+		fs.Usage()
+		return exitSetup
+	}
+	inputs := 0
+	for _, set := range []bool{len(jsonFiles) > 0, imageDir != "", zipPath != ""} {
+		if set {
+			inputs++
+		}
+	}
+	if inputs > 1 {
+		fmt.Fprintf(os.Stderr, "❌ Error: only one of -file/-f, -dir and -zip can be used\n")
+		fs.Usage()
+		return exitSetup
+	}
+	if (recursive || dirPrefixes) && imageDir == "" {
+		fmt.Fprintf(os.Stderr, "❌ Error: -recursive and -dir-prefixes require -dir\n")
+		fs.Usage()
+		return exitSetup
+	}
+	if sinceValue != "" {
+		if len(jsonFiles) == 0 {
+			fmt.Fprintf(os.Stderr, "❌ Error: -since requires -file/-f\n")
+			fs.Usage()
+			return exitSetup
+		}
+		t, err := time.Parse(time.RFC3339, sinceValue)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "❌ Error: -since must be an RFC3339 time such as 2024-05-01T00:00:00Z: %v\n", err)
+			fs.Usage()
+			return exitSetup
+		}
+		since = t
+	}
+	modes := 0
+	for _, set := range []bool{deleteMode, deletePrefix != "", exportDir != "", listMode, dryRunMode} {
+		if set {
+			modes++
+		}
+	}
+	if modes > 1 {
+		fmt.Fprintf(os.Stderr, "❌ Error: only one of -delete, -delete-by-prefix, -export, -list and -dry-run can be used\n")
+		fs.Usage()
+		return exitSetup
+	}
+	if jsonSummary && modes > 0 {
+		fmt.Fprintf(os.Stderr, "❌ Error: -json-summary can't be combined with -delete, -delete-by-prefix, -export, -list or -dry-run\n")
+		fs.Usage()
+		return exitSetup
+	}
+	if confirmAbove < 0 {
+		fmt.Fprintf(os.Stderr, "❌ Error: -confirm-above must not be negative\n")
+		fs.Usage()
+		return exitSetup
+	}
+	if listOutput != outputText && listOutput != outputJSON {
+		fmt.Fprintf(os.Stderr, "❌ Error: -output must be %s or %s\n", outputText, outputJSON)
+		fs.Usage()
+		return exitSetup
+	}
+	if (listDetails || listOutput != outputText) && !listMode {
+		fmt.Fprintf(os.Stderr, "❌ Error: -list-details and -output require -list\n")
+		fs.Usage()
+		return exitSetup
+	}
+	if normalizeJPEGFormat != jpegFormatJPEG && normalizeJPEGFormat != jpegFormatPNG {
+		fmt.Fprintf(os.Stderr, "❌ Error: -normalize-jpeg-format must be %s or %s\n", jpegFormatJPEG, jpegFormatPNG)
+		fs.Usage()
+		return exitSetup
+	}
+	if normalizeJPEGFormat != jpegFormatJPEG && !normalizeJPEGs {
+		fmt.Fprintf(os.Stderr, "❌ Error: -normalize-jpeg-format requires -normalize-jpeg\n")
+		fs.Usage()
+		return exitSetup
+	}
+	if strictNames && len(replaceRules) > 0 {
+		fmt.Fprintf(os.Stderr, "❌ Error: -strict-names doesn't allow renaming, so it can't be combined with -replace\n")
+		fs.Usage()
+		return exitSetup
+	}
+	if !validAffix(namePrefix) || !validAffix(nameSuffix) {
+		fmt.Fprintf(os.Stderr, "❌ Error: -prefix and -suffix may only contain lowercase letters, digits, '-' and '_'\n")
+		fs.Usage()
+		return exitSetup
+	}
+	if len(namePrefix)+len(nameSuffix) >= emojiuploader.MaxNameLength {
+		fmt.Fprintf(os.Stderr, "❌ Error: -prefix and -suffix must leave room for the emoji name (at most %d characters together)\n", emojiuploader.MaxNameLength-1)
+		fs.Usage()
+		return exitSetup
+	}
+	switch onConflict {
+	case "", conflictSkip, conflictSuffix, conflictOverwrite, conflictError:
+	default:
+		fmt.Fprintf(os.Stderr, "❌ Error: -on-conflict must be %s, %s, %s or %s\n", conflictSkip, conflictSuffix, conflictOverwrite, conflictError)
+		fs.Usage()
+		return exitSetup
+	}
+	if onConflict != "" && force {
+		fmt.Fprintf(os.Stderr, "❌ Error: -force can't be used with -on-conflict, which needs the emojis on the server\n")
+		fs.Usage()
+		return exitSetup
+	}
+	if overwrite && onConflict != "" && onConflict != conflictOverwrite {
+		fmt.Fprintf(os.Stderr, "❌ Error: -overwrite contradicts -on-conflict %s\n", onConflict)
+		fs.Usage()
+		return exitSetup
+	}
+	if onConflict == conflictOverwrite {
+		overwrite = true
+	}
+	if sortOrder != sortOriginal && sortOrder != sortSanitized {
+		fmt.Fprintf(os.Stderr, "❌ Error: -sort must be %s or %s\n", sortOriginal, sortSanitized)
+		fs.Usage()
+		return exitSetup
+	}
+	if onEmpty != onEmptySkip && onEmpty != onEmptyHash {
+		fmt.Fprintf(os.Stderr, "❌ Error: -on-empty must be %s or %s\n", onEmptySkip, onEmptyHash)
+		fs.Usage()
+		return exitSetup
+	}
+	if limit < 0 {
+		fmt.Fprintf(os.Stderr, "❌ Error: -limit must not be negative\n")
+		fs.Usage()
+		return exitSetup
+	}
+	if concurrency < 1 {
+		fmt.Fprintf(os.Stderr, "❌ Error: -concurrency/-c must be at least 1\n")
+		fs.Usage()
+		return exitSetup
+	}
+	if downloadConcurrency < 0 || uploadConcurrency < 0 {
+		fmt.Fprintf(os.Stderr, "❌ Error: -download-concurrency and -upload-concurrency must not be negative\n")
+		fs.Usage()
+		return exitSetup
+	}
+	if downloadConcurrency == 0 {
+		downloadConcurrency = concurrency
+	}
+	if uploadConcurrency == 0 {
+		uploadConcurrency = concurrency
+	}
+	if perHost < 0 {
+		fmt.Fprintf(os.Stderr, "❌ Error: -per-host-concurrency must not be negative\n")
+		fs.Usage()
+		return exitSetup
+	}
+	if maxConns < 0 || maxIdleConns < 0 {
+		fmt.Fprintf(os.Stderr, "❌ Error: -max-conns and -max-idle-conns must not be negative\n")
+		fs.Usage()
+		return exitSetup
+	}
+	if maxIdleConns == 0 {
+		maxIdleConns = max(downloadConcurrency, uploadConcurrency, 2)
+	}
+	if timeout <= 0 {
+		fmt.Fprintf(os.Stderr, "❌ Error: -timeout must be positive\n")
+		fs.Usage()
+		return exitSetup
+	}
+	if maxURLReuse < 0 {
+		fmt.Fprintf(os.Stderr, "❌ Error: -max-url-reuse must not be negative\n")
+		fs.Usage()
+		return exitSetup
+	}
+	if itemTimeout < 0 {
+		fmt.Fprintf(os.Stderr, "❌ Error: -item-timeout must not be negative\n")
+		fs.Usage()
+		return exitSetup
+	}
+	if maxDuration < 0 {
+		fmt.Fprintf(os.Stderr, "❌ Error: -max-duration must not be negative\n")
+		fs.Usage()
+		return exitSetup
+	}
+	if requestRate < 0 {
+		fmt.Fprintf(os.Stderr, "❌ Error: -rate must not be negative\n")
+		fs.Usage()
+		return exitSetup
+	}
+	if burst < 1 {
+		fmt.Fprintf(os.Stderr, "❌ Error: -burst must be at least 1\n")
+		fs.Usage()
+		return exitSetup
+	}
+	if delay < 0 {
+		fmt.Fprintf(os.Stderr, "❌ Error: -delay must not be negative\n")
+		fs.Usage()
+		return exitSetup
+	}
+	if cacheMaxAge < 0 || cacheMaxAge > 0 && cacheDir == "" {
+		fmt.Fprintf(os.Stderr, "❌ Error: -cache-max-age must be positive and requires -cache-dir\n")
+		fs.Usage()
+		return exitSetup
+	}
+	if imageBasicAuth != "" {
+		if !strings.Contains(imageBasicAuth, ":") {
+			fmt.Fprintf(os.Stderr, "❌ Error: -image-basic-auth must be user:password\n")
+			fs.Usage()
+			return exitSetup
+		}
+		if imageHeader.Get("Authorization") != "" {
+			fmt.Fprintf(os.Stderr, "❌ Error: -image-basic-auth and an Authorization -image-header can't be used together\n")
+			fs.Usage()
+			return exitSetup
+		}
+		imageHeader.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(imageBasicAuth)))
 	}
-	if jsonFile == "" {
-		fmt.Fprintf(os.Stderr, "❌ Error: -file/-f flag is required\n")
-		flag.Usage()
-		os.Exit(1)
+	if slackToken != "" && imageHeader.Get("Authorization") != "" {
+		fmt.Fprintf(os.Stderr, "❌ Error: -slack-token can't be combined with -image-basic-auth or an Authorization -image-header\n")
+		fs.Usage()
+		return exitSetup
+	}
+	if maxBandwidth < 0 {
+		fmt.Fprintf(os.Stderr, "❌ Error: -max-bandwidth must not be negative\n")
+		fs.Usage()
+		return exitSetup
+	}
+	if maxRedirects < 0 {
+		fmt.Fprintf(os.Stderr, "❌ Error: -max-redirects must not be negative\n")
+		fs.Usage()
+		return exitSetup
+	}
+	if retries < 0 {
+		fmt.Fprintf(os.Stderr, "❌ Error: -retries/-r must not be negative\n")
+		fs.Usage()
+		return exitSetup
+	}
+	if maxAspect != 0 && maxAspect < 1 {
+		fmt.Fprintf(os.Stderr, "❌ Error: -max-aspect must be at least 1 (1 means square)\n")
+		fs.Usage()
+		return exitSetup
+	}
+	if maxSizeKB < 1 || maxGIFSizeKB < 1 {
+		fmt.Fprintf(os.Stderr, "❌ Error: -max-size and -max-gif-size must be positive\n")
+		fs.Usage()
+		return exitSetup
+	}
+	if maxGIFFrames < 0 {
+		fmt.Fprintf(os.Stderr, "❌ Error: -max-gif-frames must not be negative\n")
+		fs.Usage()
+		return exitSetup
+	}
+	if truncateGIFs && maxGIFFrames == 0 {
+		fmt.Fprintf(os.Stderr, "❌ Error: -truncate-gifs requires -max-gif-frames\n")
+		fs.Usage()
+		return exitSetup
+	}
+	if minSize < 0 || minDimension < 0 {
+		fmt.Fprintf(os.Stderr, "❌ Error: -min-size and -min-dimension must not be negative\n")
+		fs.Usage()
+		return exitSetup
 	}
 
-	// 1. Read the JSON source file
-	file, err := os.ReadFile(jsonFile)
+	if verbose && quiet {
+		fmt.Fprintf(os.Stderr, "❌ Error: -verbose/-v and -quiet/-q can't be used together\n")
+		fs.Usage()
+		return exitSetup
+	}
+	switch {
+	case verbose:
+		verbosity = levelVerbose
+	case quiet || listMode:
+		// The list is the output; progress messages would get in the way of piping it
+		verbosity = levelQuiet
+	}
+	// Keep stdout clean for the summary, so it can be piped into jq
+	if jsonSummary {
+		logOutput = os.Stderr
+	}
+	switch logFormat {
+	case logFormatText:
+	case logFormatJSON:
+		jsonLog = newJSONLogger(logOutput)
+	default:
+		fmt.Fprintf(os.Stderr, "❌ Error: -log-format must be %s or %s\n", logFormatText, logFormatJSON)
+		fs.Usage()
+		return exitSetup
+	}
+
+	// Source files may each have their own extension; this checks --format and
+	// picks the format of a --zip manifest
+	format, err := fileFormat("", inputFormat)
 	if err != nil {
-		fmt.Printf("❌ Error reading file: %v\n", err)
-		return
+		fmt.Fprintf(os.Stderr, "❌ Error: -format: %v\n", err)
+		fs.Usage()
+		return exitSetup
 	}
 
+	if nameMapPath != "" {
+		nameMap, err = loadNameMap(nameMapPath)
+		if err != nil {
+			logError("❌ Error reading name map: %v\n", err)
+			return exitSetup
+		}
+		if nameIssues := validateNameMap(nameMap); len(nameIssues) > 0 {
+			logError("❌ Found %d invalid names in %s:\n", len(nameIssues), nameMapPath)
+			for i, issue := range nameIssues {
+				logError("  %d. %s\n", i+1, issue)
+			}
+			return exitSetup
+		}
+	}
+
+	// 1. Read the JSON/YAML source file, the image directory or the archive (not needed to delete by prefix)
 	var emojis EmojiMap
-	if err := json.Unmarshal(file, &emojis); err != nil {
-		fmt.Printf("❌ Error parsing JSON: %v\n", err)
-		return
+	var issues []string
+	var source, baseDir string
+	if imageDir != "" {
+		source, baseDir = imageDir, imageDir
+		emojis, issues, err = loadDirectory(imageDir, recursive, dirPrefixes)
+		if err != nil {
+			logError("❌ Error reading directory: %v\n", err)
+			return exitSetup
+		}
+
+		var invalid []string
+		emojis, invalid = validateEmojis(emojis, nil, baseDir)
+		issues = append(issues, invalid...)
+	}
+	if zipPath != "" {
+		zr, err := zip.OpenReader(zipPath)
+		if err != nil {
+			logError("❌ Error opening archive: %v\n", err)
+			return exitSetup
+		}
+		defer zr.Close()
+		archive = &zr.Reader
+
+		source = zipPath
+		emojis, entrySettings, issues, err = loadZip(archive, format)
+		if err != nil {
+			logError("❌ Error reading archive: %v\n", err)
+			return exitSetup
+		}
+
+		var invalid []string
+		emojis, invalid = validateEmojis(emojis, entrySettings, baseDir)
+		issues = append(issues, invalid...)
+	}
+	if len(jsonFiles) > 0 {
+		names := make([]string, len(jsonFiles))
+		for i, path := range jsonFiles {
+			names[i] = sourceName(path)
+		}
+		source, baseDir = strings.Join(names, ", "), "."
+		// With a single file relative paths are left to resolve against its directory
+		if len(jsonFiles) == 1 {
+			baseDir = filepath.Dir(jsonFiles[0])
+		}
+
+		emojis, entrySettings, issues, err = loadSourceFiles(jsonFiles, inputFormat)
+		if err != nil {
+			logError("❌ Error %v\n", err)
+			return exitSetup
+		}
+	}
+	issues = append(issues, validateEntryNames(emojis, entrySettings)...)
+	issues = append(issues, validateEntryContentTypes(emojis, entrySettings)...)
+	if strictNames {
+		issues = append(issues, validateStrictNames(emojis)...)
+	}
+	if len(issues) > 0 {
+		logError("⚠️  Found %d invalid entries in %s:\n", len(issues), source)
+		for i, issue := range issues {
+			logError("  %d. %s\n", i+1, issue)
+		}
+		if strict {
+			logError("❌ Aborting because -strict is set\n")
+			return exitSetup
+		}
+		logInfo("Continuing with the %d valid entries\n\n", len(emojis))
+	}
+	var reused []string
+	if maxURLReuse > 0 {
+		reused = reusedURLs(emojis, maxURLReuse)
+	}
+	if len(reused) > 0 {
+		logError("⚠️  %d image URLs are used by more than %d emojis each, which usually means %s was generated incorrectly:\n", len(reused), maxURLReuse, source)
+		for i, issue := range reused {
+			logError("  %d. %s\n", i+1, issue)
+		}
+		if strict {
+			logError("❌ Aborting because -strict is set\n")
+			return exitSetup
+		}
+		logInfo("Continuing anyway\n\n")
+	}
+
+	if dryRunMode {
+		logInfo("🔍 Dry run of %d emojis (nothing will be uploaded)...\n\n", len(emojis))
+		if problems := dryRun(emojis) + len(issues); problems > 0 {
+			logSummary("\n❌ Found %d problem(s)\n", problems)
+			return exitFailures
+		}
+		logSummary("\n✅ No problems found\n")
+		return exitOK
 	}
 
-	client := &http.Client{
-		Timeout: 30 * time.Second,
+	// Ctrl-C stops new emojis from being started; in-flight requests get a
+	// short grace period before they are cancelled too
+	sigCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	reqCtx, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+	// The deferred stop cancels sigCtx as well, which is no interrupt
+	defer context.AfterFunc(sigCtx, func() {
+		// Restore the default behavior so a second Ctrl-C exits immediately
+		stop()
+		logSummary("\n🛑 Interrupted, waiting up to %s for in-flight uploads...\n", shutdownGrace)
+		time.AfterFunc(shutdownGrace, cancelRequests)
+	})()
+
+	// --max-duration ends the run the same way, counted from the start of the tool
+	ctx := sigCtx
+	if maxDuration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadlineCause(sigCtx, start.Add(maxDuration), errMaxDuration)
+		defer cancel()
+		context.AfterFunc(ctx, func() {
+			if context.Cause(ctx) == errMaxDuration {
+				logSummary("\n⏱️  --max-duration %s reached, waiting up to %s for in-flight uploads...\n", maxDuration, shutdownGrace)
+				time.AfterFunc(shutdownGrace, cancelRequests)
+			}
+		})
 	}
 
-	// Get user ID from token
-	userID, err := getUserID(client, serverURL, token)
+	opts := httpOptions{
+		timeout:             timeout,
+		insecure:            insecure,
+		caCertPath:          caCertPath,
+		proxyURL:            proxyURL,
+		userAgent:           userAgent,
+		maxRedirects:        maxRedirects,
+		maxConnsPerHost:     maxConns,
+		maxIdleConnsPerHost: maxIdleConns,
+	}
+	client, err := newHTTPClient(opts)
 	if err != nil {
-		fmt.Printf("❌ Error getting user ID: %v\n", err)
-		return
+		logError("❌ Error configuring the HTTP client: %v\n", err)
+		return exitSetup
+	}
+	// Image downloads may take their own route, e.g. when only the CDNs are
+	// behind a proxy, and are the only requests --max-bandwidth throttles
+	downloadClient := client
+	if downloadProxy != "" || maxBandwidth > 0 {
+		opts.socksProxy = downloadProxy
+		if maxBandwidth > 0 {
+			opts.bandwidth = newBandwidthLimiter(maxBandwidth)
+		}
+		if downloadClient, err = newHTTPClient(opts); err != nil {
+			logError("❌ Error configuring the HTTP client: -download-proxy: %v\n", err)
+			return exitSetup
+		}
+	}
+	if insecure {
+		logError("⚠️  TLS certificate verification is disabled (--insecure); connections can be intercepted\n")
 	}
+	api := emojiuploader.NewClient(serverURL, token, client)
+	api.NoRequestedWith = noCSRFHeader
 
-	fmt.Printf("🚀 Starting import of %d emojis...\n\n", len(emojis))
+	// Requests from all workers share one limiter that follows the server's rate
+	// limit. A --rate token bucket paces every API call on top of it and takes
+	// the place of the fixed --delay.
+	limiterDelay := delay / time.Duration(uploadConcurrency)
+	if requestRate > 0 {
+		api.Limiter = rate.NewLimiter(rate.Limit(requestRate), burst)
+		limiterDelay = 0
+	}
+	limiter := newUploadLimiter(limiterDelay, uploadConcurrency)
+	api.OnRateLimit = limiter.update
 
-	for originalName, url := range emojis {
-		// Clean the name to meet Mattermost requirements (latin, lowercase, no special chars)
-		safeName := sanitizeEmojiName(originalName)
+	// A wrong URL or a server that is down would otherwise surface as a
+	// confusing login or token error, so check the server on its own first
+	if !noPing {
+		err := api.Ping(ctx)
+		var ue *url.Error
+		switch {
+		case errors.As(err, &ue):
+			logError("❌ Can't reach %s: %v\n", serverURL, ue.Err)
+			return exitSetup
+		case err != nil:
+			logError("❌ %s doesn't look like a healthy Mattermost server (%v); check the URL, including any base path, or skip this check with --no-ping\n", serverURL, err)
+			return exitSetup
+		}
+		logDebug("💓 %s is up\n", serverURL)
+	}
 
-		fmt.Printf("Processing: [:%s:] -> [:%s:]... ", originalName, safeName)
+	// Without a token, obtain a session token by logging in; a given token always wins
+	if token == "" {
+		if err := api.Login(ctx, loginID, password); err != nil {
+			logError("❌ Error logging in: %v\n", err)
+			return exitAuth
+		}
+		logInfo("🔑 Logged in as %s\n", loginID)
+	}
 
-		// Skip aliases (they reference existing emojis, not image URLs)
-		if strings.HasPrefix(url, "alias:") {
-			fmt.Println("⏭️  Skipped (alias - references existing emoji)")
-			continue
+	// Get user ID from token. Some bot tokens may not read their own user, or
+	// only get it without an ID; --creator-id then names the bot instead.
+	me, err := api.Me(ctx)
+	restricted := (emojiuploader.HasStatus(err, http.StatusForbidden) || err == nil && me.ID == "") && creatorID != ""
+	switch {
+	case restricted:
+		logError("⚠️  The token can't read its own user, as happens with some bot accounts; using --creator-id %s without checking permissions\n", creatorID)
+		me = &emojiuploader.User{ID: creatorID}
+	case emojiuploader.HasStatus(err, http.StatusUnauthorized):
+		logError("❌ The server rejected the token (HTTP 401); check that it is correct and hasn't expired or been revoked\n")
+		return exitAuth
+	case emojiuploader.HasStatus(err, http.StatusForbidden):
+		logError("❌ The token is not allowed to access the API (HTTP 403); personal access tokens may be disabled for this user, and bot tokens may need --creator-id with the bot's user ID\n")
+		return exitAuth
+	case err != nil:
+		logError("❌ Error getting user ID: %v\n", err)
+		return exitSetup
+	case me.ID == "":
+		logError("❌ The server returned the token's user without an ID; bot tokens may need --creator-id with the bot's user ID\n")
+		return exitAuth
+	case me.IsBot:
+		logInfo("🤖 Authenticated as bot %s\n", me.Username)
+	}
+	api.CreatorID = me.ID
+
+	// Admins may attribute the emojis to someone else, e.g. a service account
+	if (creatorID != "" || creatorUser != "") && !restricted {
+		var creator *emojiuploader.User
+		if creatorUser != "" {
+			creator, err = api.UserByUsername(ctx, strings.TrimPrefix(creatorUser, "@"))
+		} else {
+			creator, err = api.UserByID(ctx, creatorID)
 		}
+		if emojiuploader.HasStatus(err, http.StatusNotFound) {
+			logError("❌ Creator %s%s not found\n", creatorUser, creatorID)
+			return exitSetup
+		}
+		if err != nil {
+			logError("❌ Error looking up the creator: %v\n", err)
+			return exitSetup
+		}
+		api.CreatorID = creator.ID
+		logInfo("👤 Emojis will be created as %s\n", creator.Username)
+	}
 
-		// 2. Download the image into a temporary memory buffer
-		imgData, contentType, err := downloadImage(client, url)
+	// Catch permission problems before any emoji is touched. Membership is
+	// checked for the authenticated user, not for a --creator-id stand-in.
+	if teamName != "" {
+		team, err := api.TeamByName(ctx, teamName)
+		if emojiuploader.HasStatus(err, http.StatusNotFound) {
+			logError("❌ Team %q not found, or not visible to this user\n", teamName)
+			return exitSetup
+		}
 		if err != nil {
-			fmt.Printf("❌ Download error: %v\n", err)
-			continue
+			logError("❌ Error looking up team %q: %v\n", teamName, err)
+			return exitSetup
+		}
+		member, err := api.IsTeamMember(ctx, team.ID, me.ID)
+		if err != nil {
+			logError("❌ Error checking membership of team %q: %v\n", teamName, err)
+			return exitSetup
 		}
+		if !member {
+			logError("❌ The user is not a member of team %q\n", teamName)
+			return exitSetup
+		}
+		logInfo("👥 Member of team %s\n", team.DisplayName)
+	}
 
-		// 3. Upload the buffer to Mattermost
-		err = uploadToMattermost(client, serverURL, token, safeName, imgData, contentType, userID)
+	if deleteMode || deletePrefix != "" {
+		return runDelete(ctx, api, limiter, emojis)
+	}
+	if exportDir != "" {
+		return runExport(ctx, api, limiter, exportDir)
+	}
+	if listMode {
+		return runList(ctx, api, listOutput, listDetails)
+	}
+
+	// Without create_emojis every upload fails with 403, so stop before downloading anything.
+	// Servers that don't let the user read roles are given the benefit of the doubt.
+	if !restricted {
+		allowed, err := api.HasPermission(ctx, me, emojiuploader.PermissionCreateEmojis)
 		if err != nil {
-			// Check if emoji already exists (Mattermost returns 400 for duplicates)
-			if strings.Contains(err.Error(), "400") {
-				fmt.Println("⚠️  Skipped (already exists or invalid name)")
-			} else {
-				fmt.Printf("❌ Upload error: %v\n", err)
+			logError("⚠️  Could not check the %s permission, continuing anyway: %v\n", emojiuploader.PermissionCreateEmojis, err)
+		} else if !allowed {
+			logError("❌ The user %s lacks the %s permission, so uploads would fail with HTTP 403; ask an administrator to allow creating custom emojis\n", me.Username, emojiuploader.PermissionCreateEmojis)
+			return exitAuth
+		}
+	}
+
+	imp := &importer{
+		client:         downloadClient,
+		api:            api,
+		limiter:        limiter,
+		header:         imageHeader,
+		slackToken:     slackToken,
+		cache:          newImageCache(),
+		baseDir:        baseDir,
+		images:         make(map[string]emojiImage),
+		stopped:        make(chan struct{}),
+		cancelRequests: cancelRequests,
+	}
+	if perHost > 0 {
+		imp.hosts = newHostLimiter(perHost)
+	}
+
+	// Look up what's already on the server so re-runs don't redo finished work.
+	// With --overwrite existing emojis are processed anyway.
+	if !force && !overwrite {
+		imp.existing, err = listExistingEmojis(ctx, api)
+		if err != nil {
+			logError("❌ Error listing existing emojis: %v\n", err)
+			return exitSetup
+		}
+		logInfo("📋 Found %d existing emojis on the server\n", len(imp.existing))
+	}
+
+	// Resume from the state file of a previous run
+	if statePath != "" {
+		imp.done, err = loadState(statePath)
+		if err != nil {
+			logError("❌ Error reading state file: %v\n", err)
+			return exitSetup
+		}
+		imp.state, err = openState(statePath)
+		if err != nil {
+			logError("❌ Error opening state file: %v\n", err)
+			return exitSetup
+		}
+		defer imp.state.Close()
+		if len(imp.done) > 0 {
+			logInfo("📌 Resuming: %d emojis were finished in a previous run\n", len(imp.done))
+		}
+	}
+
+	if cacheDir != "" {
+		imp.disk, err = openDiskCache(cacheDir, cacheMaxAge)
+		if err != nil {
+			logError("❌ Error opening cache directory: %v\n", err)
+			return exitSetup
+		}
+	}
+
+	if csvFile != "" {
+		imp.csv, err = createCSVLog(csvFile)
+		if err != nil {
+			logError("❌ Error creating CSV file: %v\n", err)
+			return exitSetup
+		}
+		defer imp.csv.Close()
+	}
+
+	// With --on-conflict suffix, emojis on the server are renamed around too,
+	// except those finished in a previous run, which --state skips anyway
+	var taken map[string]bool
+	if onConflict == conflictSuffix {
+		taken = make(map[string]bool, len(imp.existing))
+		for name := range imp.existing {
+			if !imp.done[name] {
+				taken[name] = true
+			}
+		}
+	}
+
+	// Aliases go last so the images of targets uploaded in this run are available to them
+	regular, aliases, renamed := planJobs(emojis, taken)
+	if renamed > 0 {
+		logInfo("\n")
+	}
+	if onConflict == conflictError {
+		if conflicts := imp.nameConflicts(regular, aliases); len(conflicts) > 0 {
+			for _, conflict := range conflicts {
+				logError("❌ %s\n", conflict)
 			}
+			logError("\n❌ Stopping because of %d name conflicts (--on-conflict %s)\n", len(conflicts), conflictError)
+			return exitSetup
+		}
+	}
+	imp.names = make(map[string]string, len(emojis))
+	for _, job := range append(regular, aliases...) {
+		imp.names[job.originalName] = job.safeName
+	}
+
+	total := len(regular) + len(aliases)
+	if limit > 0 {
+		remaining := limit
+		regular, remaining = imp.limitJobs(regular, remaining)
+		aliases, _ = imp.limitJobs(aliases, remaining)
+		if n := len(regular) + len(aliases); n < total {
+			logInfo("✂️  --limit %d: processing %d of %d emojis\n", limit, n, total)
+			total = n
+		}
+	}
+
+	// Guard against pointing a large import at the wrong server
+	if pending := imp.pendingUploads(regular, aliases); needsConfirmation(pending) {
+		ok, err := confirm(ctx, os.Stdin, os.Stderr, fmt.Sprintf("About to upload %d emojis to %s. Continue?", pending, serverURL))
+		if err != nil && ctx.Err() == nil {
+			logError("❌ Error reading the answer: %v\n", err)
+			return exitSetup
+		}
+		if !ok {
+			logSummary("🛑 Aborted, nothing was uploaded\n")
+			return exitInterrupted
+		}
+	}
+
+	if downloadConcurrency == uploadConcurrency {
+		logInfo("🚀 Starting import of %d emojis with %d worker(s)...\n\n", total, uploadConcurrency)
+	} else {
+		logInfo("🚀 Starting import of %d emojis with %d download and %d upload worker(s)...\n\n", total, downloadConcurrency, uploadConcurrency)
+	}
+
+	results := &stats{}
+
+	// A progress bar would only get in the way of the JSON records
+	if showProgress && jsonLog == nil {
+		progress = newProgressBar(total)
+	}
+	imp.run(ctx, reqCtx, regular, results)
+	imp.run(ctx, reqCtx, aliases, results)
+	if progress != nil {
+		progress.finish()
+		progress = nil
+	}
+
+	interrupted := sigCtx.Err() != nil
+	timedOut := !interrupted && context.Cause(ctx) == errMaxDuration
+	if interrupted || timedOut || imp.isStopped() {
+		logSummary("\n🛑 Stopped early: %d of %d emojis were not processed\n", total-len(results.results), total)
+	}
+	results.printSummary(time.Since(start))
+	if jsonSummary {
+		if err := writeJSONSummary(os.Stdout, results, time.Since(start)); err != nil {
+			logError("❌ Error writing the summary: %v\n", err)
+			return exitSetup
+		}
+	}
+
+	unverified := 0
+	if verifyUpload && !interrupted && !timedOut {
+		unverified = imp.verify(ctx, results.results)
+	}
+
+	if reportFile != "" {
+		if err := writeReport(reportFile, results, time.Since(start)); err != nil {
+			logError("❌ Error writing report: %v\n", err)
+			return exitSetup
+		}
+		logInfo("📝 Report written to %s\n", reportFile)
+	}
+	if skippedOut != "" {
+		if err := writeProblems(skippedOut, results); err != nil {
+			logError("❌ Error writing skipped emojis: %v\n", err)
 		} else {
-			fmt.Println("✅ Success!")
+			logInfo("📝 Emojis that need attention written to %s\n", skippedOut)
 		}
+	}
 
-		// Brief pause to avoid triggering rate limits
-		time.Sleep(200 * time.Millisecond)
+	code := exitOK
+	switch {
+	case interrupted || timedOut:
+		code = exitInterrupted
+	case results.failed > 0 || unverified > 0:
+		// Skips are expected, failed uploads are not
+		code = exitFailures
 	}
+
+	if metricsFile != "" {
+		if err := writeMetrics(metricsFile, results, unverified, time.Since(start), code); err != nil {
+			logError("❌ Error writing metrics: %v\n", err)
+			return exitSetup
+		}
+		logDebug("🔎 Metrics written to %s\n", metricsFile)
+	}
+	return code
 }
 
-// sanitizeEmojiName converts names to Mattermost-compatible format
-func sanitizeEmojiName(name string) string {
-	// Transliterate non-latin characters (e.g., "жду" -> "zhdu")
-	name = unidecode.Unidecode(name)
-	// Convert to lowercase
-	name = strings.ToLower(name)
-	// Replace spaces with dashes
-	name = strings.ReplaceAll(name, " ", "-")
-	// Remove all forbidden characters (anything not a-z, 0-9, - or _)
-	reg := regexp.MustCompile(`[^a-z0-9\-_]+`)
-	name = reg.ReplaceAllString(name, "")
-	// Truncate to Mattermost limit (64 chars)
-	if len(name) > 64 {
-		name = name[:64]
-	}
-	return name
+// limitJobs keeps jobs up to and including the n-th one that still needs work,
+// so emojis already on the server, finished in a previous run or skipped as
+// name conflicts don't count towards --limit. It returns the kept jobs and how
+// much of n is left.
+func (imp *importer) limitJobs(jobs []emojiJob, n int) ([]emojiJob, int) {
+	for i, job := range jobs {
+		if imp.done[job.safeName] || imp.existing[job.safeName] || job.conflictWith != "" {
+			continue
+		}
+		if n == 0 {
+			return jobs[:i], 0
+		}
+		n--
+	}
+	return jobs, n
 }
 
-// downloadImage fetches the image from Slack/external URL
-func downloadImage(client *http.Client, url string) ([]byte, string, error) {
-	resp, err := client.Get(url)
-	if err != nil {
-		return nil, "", err
+// run processes jobs in two stages and waits for both to finish: a pool of
+// downloadConcurrency workers fetches and checks the images, and a pool of
+// uploadConcurrency workers uploads them. Once ctx is cancelled no new jobs are
+// started; requests use reqCtx instead so that jobs already in progress,
+// including those downloaded and waiting for an upload worker, can complete.
+func (imp *importer) run(ctx, reqCtx context.Context, jobs []emojiJob, results *stats) {
+	queue := make(chan emojiJob)
+	// Downloads may run ahead of the uploads by this many images. When the
+	// uploads fall behind, the buffer fills up and the downloaders wait, which
+	// keeps the images held in memory bounded.
+	ready := make(chan *emojiItem, uploadConcurrency)
+
+	finish := func(it *emojiItem, res Result) {
+		res = imp.finish(it, res)
+		results.record(res)
+		if res.Action == actionFailed && failFast {
+			imp.stop(res)
+		}
+	}
+
+	var downloaders sync.WaitGroup
+	for i := 0; i < downloadConcurrency; i++ {
+		downloaders.Add(1)
+		go func() {
+			defer downloaders.Done()
+			for job := range queue {
+				it := &emojiItem{
+					job: job,
+					res: Result{OriginalName: job.originalName, SanitizedName: job.safeName, source: job.url},
+				}
+				ctx, done := it.stage(reqCtx)
+				res, upload := imp.prepare(ctx, it)
+				done()
+				if !upload {
+					finish(it, res)
+					continue
+				}
+				it.res = res
+				ready <- it
+			}
+		}()
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, "", fmt.Errorf("HTTP %d", resp.StatusCode)
+	var uploaders sync.WaitGroup
+	for i := 0; i < uploadConcurrency; i++ {
+		uploaders.Add(1)
+		go func() {
+			defer uploaders.Done()
+			for it := range ready {
+				ctx, done := it.stage(reqCtx)
+				res := imp.upload(ctx, it)
+				done()
+				finish(it, res)
+			}
+		}()
 	}
 
-	data, err := io.ReadAll(resp.Body)
+dispatch:
+	for _, job := range jobs {
+		if imp.isStopped() {
+			break
+		}
+		select {
+		case <-imp.stopped:
+			break dispatch
+		case queue <- job:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(queue)
+	downloaders.Wait()
+	close(ready)
+	uploaders.Wait()
+}
+
+// stop ends the run after the failure of res with --fail-fast
+func (imp *importer) stop(res Result) {
+	imp.stopOnce.Do(func() {
+		logError("🛑 --fail-fast: stopping after [:%s:] failed: %s\n", res.OriginalName, res.Reason)
+		close(imp.stopped)
+		time.AfterFunc(shutdownGrace, imp.cancelRequests)
+	})
+}
+
+// isStopped reports whether --fail-fast has ended the run
+func (imp *importer) isStopped() bool {
+	select {
+	case <-imp.stopped:
+		return true
+	default:
+		return false
+	}
+}
+
+// errMaxDuration is the cancellation cause when the run reaches --max-duration
+var errMaxDuration = errors.New("--max-duration exceeded")
+
+// errItemTimeout is the cancellation cause when an emoji runs out of --item-timeout
+var errItemTimeout = errors.New("--item-timeout exceeded")
+
+// emojiItem is an emoji on its way through the download and upload stages
+type emojiItem struct {
+	job emojiJob
+	res Result
+	// ctx is the context of the last stage, spent the time taken by all stages
+	ctx   context.Context
+	spent time.Duration
+
+	// Set by the download stage for images that still have to be uploaded
+	data        []byte
+	contentType string
+	notes       []string
+	animated    bool
+	wasResized  bool
+}
+
+// stage returns the context for the next stage of it and the function to call
+// when the stage is over. One slow emoji must not hold up a worker for longer
+// than --item-timeout; the time spent waiting between the stages doesn't count.
+func (it *emojiItem) stage(ctx context.Context) (context.Context, func()) {
+	start := time.Now()
+	cancel := context.CancelFunc(func() {})
+	if itemTimeout > 0 {
+		ctx, cancel = context.WithTimeoutCause(ctx, itemTimeout-it.spent, errItemTimeout)
+	}
+	it.ctx = ctx
+	return ctx, func() {
+		it.spent += time.Since(start)
+		cancel()
+	}
+}
+
+// finish completes an emoji with its final result: it records the result in
+// the state and CSV files and prints the status line
+func (imp *importer) finish(it *emojiItem, res Result) Result {
+	// Not every error reports the cause, only the bare deadline
+	timedOut := errors.Is(context.Cause(it.ctx), errItemTimeout)
+	if res.Action == actionFailed && timedOut && !strings.Contains(res.Reason, errItemTimeout.Error()) {
+		res.Reason += " (" + errItemTimeout.Error() + ")"
+	}
+
+	// Everything except failures is final and doesn't need to be retried on the next run
+	if imp.state != nil && res.Action != actionFailed && !imp.done[res.SanitizedName] {
+		if err := imp.state.record(res.SanitizedName); err != nil {
+			logError("⚠️  Could not update state file: %v\n", err)
+		}
+	}
+	if imp.csv != nil {
+		if err := imp.csv.record(res); err != nil {
+			logError("⚠️  Could not update CSV file: %v\n", err)
+		}
+	}
+	if jsonLog != nil {
+		logResult(res, it.spent)
+		return res
+	}
+	// The progress bar replaces the per-emoji lines, except for failures
+	line := fmt.Sprintf("Processing: [:%s:] -> [:%s:]... %s\n", res.OriginalName, res.SanitizedName, res.statusText())
+	if res.Action == actionFailed {
+		logError("%s", line)
+	} else if progress == nil {
+		logInfo("%s", line)
+	}
+	if progress != nil {
+		progress.record(res)
+	}
+	return res
+}
+
+// prepare is the download stage of an emoji: it skips emojis that are already
+// done, and downloads, converts and checks the image. It returns the final
+// result and false when there is nothing to upload.
+func (imp *importer) prepare(ctx context.Context, it *emojiItem) (Result, bool) {
+	job, res := it.job, it.res
+	safeName := res.SanitizedName
+	if job.conflictWith != "" {
+		return res.skipped(skipConflict, conflictReason(job)), false
+	}
+	if imp.done[safeName] {
+		return res.skipped(skipResumed, "finished in a previous run"), false
+	}
+	if imp.existing[safeName] {
+		return res.skipped(skipExists, "already exists on the server"), false
+	}
+
+	// Aliases reference another emoji instead of an image URL and have nothing to download
+	if strings.HasPrefix(job.url, "alias:") {
+		return res, true
+	}
+
+	// 2. Download the image into a temporary memory buffer, unless another
+	// emoji of this run already did
+	started := time.Now()
+	img, from, err := imp.cache.fetch(ctx, job.url, safeName, func() ([]byte, string, error) {
+		var data []byte
+		var contentType string
+		err := withRetry(ctx, retries+1, func() error {
+			release, err := imp.hosts.acquire(ctx, job.url)
+			if err != nil {
+				return err
+			}
+			defer release()
+
+			if imp.disk != nil && isHTTPSource(job.url) {
+				data, contentType, err = imp.disk.download(ctx, imp.client, job.url, imp.imageHeader(job.url))
+			} else {
+				data, contentType, err = loadImage(ctx, imp.client, job.url, imp.baseDir, imp.imageHeader(job.url))
+			}
+			return err
+		})
+		// Identical images from other sources still had to be downloaded
+		res.downloadedBytes = len(data)
+		return data, contentType, err
+	})
 	if err != nil {
-		return nil, "", err
+		return res.failed("Download error", err), false
+	}
+	imgData, contentType := img.data, img.contentType
+	res.SizeBytes = len(imgData)
+	if from != "" {
+		logDebug("🔎 [:%s:] reusing image from :%s:\n", safeName, from)
+	} else {
+		logDebug("🔎 [:%s:] fetched %s (%s, %s) in %s\n", safeName, describeSource(job.url), contentType, formatSize(len(imgData)), time.Since(started).Round(time.Millisecond))
+	}
+	// For hosts that send a wrong type, the source can say what the image really is
+	if override := entrySettings[job.originalName].ContentType; override != "" && override != contentType {
+		logDebug("🔎 [:%s:] using the content type %s from the source instead of %s\n", safeName, override, contentType)
+		contentType = override
+	}
+
+	// Broken links sometimes answer 200 with a tracking pixel or a tiny error image
+	if len(imgData) < minSize {
+		return res.rejected(skipTooSmall, fmt.Sprintf("too small: %d bytes < %d, probably not a real image", len(imgData), minSize)), false
+	}
+
+	// Mattermost doesn't accept WebP, so convert it to PNG first
+	var notes []string
+	if contentType == "image/webp" {
+		converted, animatedWebP, err := convertWebP(imgData)
+		if err != nil {
+			return res.failed("Conversion error", err), false
+		}
+		if animatedWebP {
+			notes = append(notes, "animated WebP, only the first frame was kept")
+		}
+		imgData, contentType = converted, "image/png"
+	}
+
+	// Anything else Mattermost can't display would only fail with a confusing error
+	if !allowFormats.allowed(contentType) {
+		format := contentType
+		if format == "" {
+			format = "unrecognized"
+		}
+		return res.rejected(skipFormat, "unsupported format: "+format), false
+	}
+
+	// Sideways photos and CMYK colors would otherwise end up on the server as they are
+	if normalizeJPEGs && contentType == "image/jpeg" {
+		normalized, normalizedType, note, err := normalizeJPEG(imgData, normalizeJPEGFormat == jpegFormatPNG)
+		if err != nil {
+			return res.failed("Conversion error", err), false
+		}
+		if note != "" {
+			notes = append(notes, note)
+		}
+		imgData, contentType = normalized, normalizedType
+	}
+
+	// Images that can't be decoded are left for Mattermost to judge here as well
+	if minDimension > 0 {
+		if w, h, err := imageDimensions(imgData); err == nil && min(w, h) < minDimension {
+			return res.rejected(skipTooSmall, fmt.Sprintf("too small: %dx%d, below %dpx", w, h, minDimension)), false
+		}
+	}
+
+	animated := isAnimatedGIF(imgData)
+
+	// Long animations may exceed what the server accepts and are tiring to look at
+	if animated && maxGIFFrames > 0 {
+		if frames, err := gifFrames(imgData); err == nil && frames > maxGIFFrames {
+			if !truncateGIFs {
+				return res.rejected(skipFrames, fmt.Sprintf("too many frames: %d > %d", frames, maxGIFFrames)), false
+			}
+			truncated, err := truncateGIF(imgData, maxGIFFrames)
+			if err != nil {
+				return res.failed("Truncation error", err), false
+			}
+			notes = append(notes, fmt.Sprintf("truncated from %d to %d frames", frames, maxGIFFrames))
+			imgData = truncated
+			animated = maxGIFFrames > 1
+		}
+	}
+
+	// Very wide or tall images look bad as emojis. Images that can't be decoded
+	// are left for Mattermost to judge.
+	if maxAspect > 0 || padSquare {
+		w, h, err := imageDimensions(imgData)
+		aspectLimit := maxAspect
+		if aspectLimit == 0 {
+			// --pad-square alone makes every image square
+			aspectLimit = 1
+		}
+		if ratio := aspectRatio(w, h); err == nil && ratio > aspectLimit {
+			switch {
+			case animated:
+				notes = append(notes, fmt.Sprintf("animated GIF with aspect ratio %.1f:1 kept as is", ratio))
+			case !padSquare:
+				return res.rejected(skipAspect, fmt.Sprintf("aspect ratio %.1f:1 (%dx%d) exceeds %g:1", ratio, w, h, maxAspect)), false
+			default:
+				padded, err := padToSquare(imgData)
+				if err != nil {
+					return res.failed("Padding error", err), false
+				}
+				side := max(w, h)
+				notes = append(notes, fmt.Sprintf("padded %dx%d -> %dx%d", w, h, side, side))
+				imgData, contentType = padded, "image/png"
+			}
+		}
+	}
+
+	// Don't waste an upload on an image Mattermost would reject as too large
+	wasResized := false
+	if sizeMax := sizeLimit(animated); len(imgData) > sizeMax {
+		tooLarge := fmt.Sprintf("too large: %s > %s", formatSize(len(imgData)), formatSize(sizeMax))
+		if !resizeImages {
+			return res.rejected(skipTooLarge, tooLarge), false
+		}
+		if animated {
+			return res.rejected(skipTooLarge, tooLarge+", animated GIFs are not resized"), false
+		}
+
+		resized, err := resizeImage(imgData, resizeMaxDimension)
+		if err != nil {
+			return res.failed("Resize error", err), false
+		}
+		if len(resized) > sizeMax {
+			return res.rejected(skipTooLarge, fmt.Sprintf("%s, still %s after resizing", tooLarge, formatSize(len(resized)))), false
+		}
+		notes = append(notes, fmt.Sprintf("resized %s -> %s", formatSize(len(imgData)), formatSize(len(resized))))
+		imgData, contentType = resized, "image/png"
+		wasResized = true
+	}
+	res.SizeBytes = len(imgData)
+	if _, known := emojiuploader.Extension(contentType); !known {
+		kind := "unknown image type " + contentType
+		if contentType == "" {
+			kind = "unrecognized image format"
+		}
+		logError("⚠️  [:%s:] %s, uploading it as %s\n", safeName, kind, emojiuploader.DefaultExtension)
 	}
 
-	contentType := resp.Header.Get("Content-Type")
-	return data, contentType, nil
+	it.data, it.contentType, it.notes = imgData, contentType, notes
+	it.animated, it.wasResized = animated, wasResized
+	return res, true
 }
 
-// getUserID retrieves the user ID from the token
-func getUserID(client *http.Client, serverURL, token string) (string, error) {
-	req, err := http.NewRequest("GET", serverURL+"/api/v4/users/me", nil)
+// upload is the second stage of an emoji: it uploads the image prepared by
+// the download stage, or creates the alias
+func (imp *importer) upload(ctx context.Context, it *emojiItem) Result {
+	res, safeName := it.res, it.res.SanitizedName
+	if target, ok := strings.CutPrefix(it.job.url, "alias:"); ok {
+		return imp.handleAlias(ctx, target, res)
+	}
+	imgData, contentType, notes := it.data, it.contentType, it.notes
+
+	// 3. Upload the buffer to Mattermost, waiting for our turn to avoid triggering rate limits
+	var created *emojiuploader.Emoji
+	upload := func() error {
+		imp.limiter.wait(ctx)
+		return withRetry(ctx, retries+1, func() (err error) {
+			created, err = imp.api.Upload(ctx, safeName, imgData, contentType)
+			return err
+		})
+	}
+	started := time.Now()
+	err := upload()
+
+	// The server's file size limit may be below our defaults; with --resize, try once more smaller.
+	// Servers behind a proxy may answer 413 before Mattermost sees the request.
+	serverTooLarge := emojiuploader.HasStatus(err, http.StatusRequestEntityTooLarge) || emojiuploader.HasErrorID(err, emojiuploader.ErrorIDTooLarge)
+	if serverTooLarge && resizeImages && !it.animated && !it.wasResized {
+		if resized, resizeErr := resizeImage(imgData, resizeMaxDimension); resizeErr == nil && len(resized) < len(imgData) {
+			notes = append(notes, fmt.Sprintf("resized %s -> %s after the server refused it as too large", formatSize(len(imgData)), formatSize(len(resized))))
+			imgData, contentType = resized, "image/png"
+			res.SizeBytes = len(imgData)
+			err = upload()
+		}
+	}
+	if err != nil && overwrite && emojiuploader.HasErrorID(err, emojiuploader.ErrorIDDuplicate) {
+		if err = imp.replace(ctx, safeName, upload); err == nil {
+			notes = append(notes, "replaced the existing emoji")
+		}
+	}
 	if err != nil {
-		return "", err
+		return uploadError(res, err)
 	}
 
-	req.Header.Set("Authorization", "Bearer "+token)
+	res.EmojiID = created.ID
+	logDebug("🔎 [:%s:] uploaded %s as %s in %s, id %s\n", safeName, formatSize(len(imgData)), contentType, time.Since(started).Round(time.Millisecond), describeID(res.EmojiID))
 
-	resp, err := client.Do(req)
+	imp.storeImage(safeName, emojiImage{data: imgData, contentType: contentType})
+	return res.succeeded(actionUploaded, strings.Join(notes, ", "))
+}
+
+// replace deletes the existing emoji called name and calls upload again to
+// re-create it, since Mattermost can't change the image of an emoji in place.
+// upload is expected to wait for the limiter itself.
+func (imp *importer) replace(ctx context.Context, name string, upload func() error) error {
+	err := withRetry(ctx, retries+1, func() error {
+		emoji, err := imp.api.EmojiByName(ctx, name)
+		if err != nil {
+			return err
+		}
+		return imp.api.Delete(ctx, emoji.ID)
+	})
 	if err != nil {
-		return "", err
+		return fmt.Errorf("deleting the existing emoji: %w", err)
 	}
-	defer resp.Body.Close()
+	logDebug("🔎 [:%s:] deleted the existing emoji to replace it\n", name)
 
-	if resp.StatusCode != http.StatusOK {
-		respBody, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("status %d: %s", resp.StatusCode, string(respBody))
+	if err := upload(); err != nil {
+		// The old emoji is gone at this point, which the user needs to know
+		return fmt.Errorf("the existing emoji was deleted, but re-creating it failed: %w", err)
 	}
+	return nil
+}
 
-	var userInfo UserInfo
-	if err := json.NewDecoder(resp.Body).Decode(&userInfo); err != nil {
-		return "", err
+// uploadError returns the result for a failed upload. Mattermost answers 400
+// both for duplicates and for invalid names, which the error id in the
+// response body tells apart.
+func uploadError(res Result, err error) Result {
+	if errors.Is(err, emojiuploader.ErrInvalidName) {
+		return res.rejected(skipInvalidName, err.Error())
+	}
+	var se *emojiuploader.StatusError
+	if !errors.As(err, &se) {
+		return res.failed("Upload error", err)
 	}
 
-	return userInfo.ID, nil
+	message := strings.TrimSuffix(se.Message, ".")
+	switch {
+	case se.ErrorID == emojiuploader.ErrorIDDuplicate:
+		res.HTTPStatus = se.StatusCode
+		return res.skipped(skipExists, "already exists on the server")
+	case se.ErrorID == emojiuploader.ErrorIDInvalidName:
+		res.HTTPStatus = se.StatusCode
+		return res.rejected(skipInvalidName, "invalid name: "+message)
+	case se.ErrorID == emojiuploader.ErrorIDTooLarge, se.StatusCode == http.StatusRequestEntityTooLarge:
+		return tooLargeForServer(res, se.StatusCode)
+	case se.ErrorID == emojiuploader.ErrorIDOtherUser:
+		return res.failed("Upload error", fmt.Errorf("the server doesn't allow creating emojis for another user: %w", err))
+	case se.StatusCode == http.StatusBadRequest && message != "":
+		res.HTTPStatus = se.StatusCode
+		return res.rejected(skipRejected, message)
+	case se.StatusCode == http.StatusBadRequest:
+		// Without an error id the two causes can't be told apart
+		res.HTTPStatus = se.StatusCode
+		return res.rejected(skipRejected, "already exists or invalid name")
+	}
+	return res.failed("Upload error", err)
 }
 
-// uploadToMattermost performs the multipart/form-data POST request
-func uploadToMattermost(client *http.Client, serverURL, token, name string, imgData []byte, contentType string, creatorID string) error {
-	body := &bytes.Buffer{}
-	writer := multipart.NewWriter(body)
-
-	// 'emoji' field containing JSON metadata with creator_id
-	emojiMeta := fmt.Sprintf(`{"name":"%s","creator_id":"%s"}`, name, creatorID)
-	_ = writer.WriteField("emoji", emojiMeta)
+// tooLargeForServer returns the result for an upload the server refused as
+// too large, which means its file size limit is lower than --max-size
+func tooLargeForServer(res Result, status int) Result {
+	res.HTTPStatus = status
+	return res.rejected(skipTooLarge, fmt.Sprintf("image too large for this server: %d bytes, HTTP %d", res.SizeBytes, status))
+}
 
-	// 'image' field containing binary data
-	// Detect extension based on Content-Type for the filename parameter
-	ext := ".png"
-	if contentType == "image/gif" {
-		ext = ".gif"
-	} else if contentType == "image/jpeg" {
-		ext = ".jpg"
+// handleAlias uploads a copy of the target emoji's image under the alias name.
+// Mattermost has no native aliases, so this is the closest equivalent.
+func (imp *importer) handleAlias(ctx context.Context, target string, res Result) Result {
+	// Prefer the name the target got in this run, which may carry a collision suffix
+	targetName, ok := imp.names[target]
+	if !ok {
+		targetName = emojiName(target)
 	}
 
-	part, err := writer.CreateFormFile("image", name+ext)
+	img, err := imp.aliasTargetImage(ctx, targetName)
 	if err != nil {
-		return err
+		if emojiuploader.HasStatus(err, http.StatusNotFound) {
+			return res.skipped(skipAliasTarget, fmt.Sprintf("alias target :%s: is neither uploaded in this run nor on the server", targetName))
+		}
+		return res.failed(fmt.Sprintf("Error fetching alias target :%s:", targetName), err)
+	}
+	res.SizeBytes = len(img.data)
+
+	var created *emojiuploader.Emoji
+	upload := func() error {
+		imp.limiter.wait(ctx)
+		return withRetry(ctx, retries+1, func() (err error) {
+			created, err = imp.api.Upload(ctx, res.SanitizedName, img.data, img.contentType)
+			return err
+		})
+	}
+	err = upload()
+	note := fmt.Sprintf("alias of :%s:", targetName)
+	if err != nil && overwrite && emojiuploader.HasErrorID(err, emojiuploader.ErrorIDDuplicate) {
+		if err = imp.replace(ctx, res.SanitizedName, upload); err == nil {
+			note += ", replaced the existing emoji"
+		}
 	}
-	_, err = part.Write(imgData)
 	if err != nil {
-		return err
+		return uploadError(res, err)
 	}
 
-	writer.Close()
+	res.EmojiID = created.ID
+	logDebug("🔎 [:%s:] uploaded as an alias of :%s:, id %s\n", res.SanitizedName, targetName, describeID(res.EmojiID))
+	imp.storeImage(res.SanitizedName, img)
+	return res.succeeded(actionAlias, note)
+}
 
-	req, err := http.NewRequest("POST", serverURL+"/api/v4/emoji", body)
-	if err != nil {
-		return err
+// aliasTargetImage returns the image of an emoji uploaded in this run or,
+// failing that, downloads it from the server
+func (imp *importer) aliasTargetImage(ctx context.Context, name string) (emojiImage, error) {
+	imp.mu.Lock()
+	img, ok := imp.images[name]
+	imp.mu.Unlock()
+	if ok {
+		return img, nil
 	}
 
-	req.Header.Set("Authorization", "Bearer "+token)
-	req.Header.Set("Content-Type", writer.FormDataContentType())
+	err := withRetry(ctx, retries+1, func() error {
+		emoji, err := imp.api.EmojiByName(ctx, name)
+		if err != nil {
+			return err
+		}
+		img.data, img.contentType, err = imp.api.EmojiImage(ctx, emoji.ID)
+		return err
+	})
+	return img, err
+}
+
+// storeImage remembers the image uploaded for an emoji
+func (imp *importer) storeImage(name string, img emojiImage) {
+	imp.mu.Lock()
+	defer imp.mu.Unlock()
+	imp.images[name] = img
+}
 
-	resp, err := client.Do(req)
+// listExistingEmojis collects the names of all custom emojis on the server
+func listExistingEmojis(ctx context.Context, api *emojiuploader.Client) (map[string]bool, error) {
+	emojis, err := listEmojis(ctx, api)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	// Mattermost may return either 200 (OK) or 201 (Created) for successful emoji creation
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
-		respBody, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("status %d: %s", resp.StatusCode, string(respBody))
+	names := make(map[string]bool, len(emojis))
+	for _, emoji := range emojis {
+		names[emoji.Name] = true
 	}
+	return names, nil
+}
 
-	return nil
+// listEmojis pages through GET /api/v4/emoji and returns all custom emojis
+func listEmojis(ctx context.Context, api *emojiuploader.Client) ([]emojiuploader.Emoji, error) {
+	var all []emojiuploader.Emoji
+	for page := 0; ; page++ {
+		var emojis []emojiuploader.Emoji
+		err := withRetry(ctx, retries+1, func() error {
+			var err error
+			emojis, err = api.EmojiPage(ctx, page, emojiuploader.MaxPageSize)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		all = append(all, emojis...)
+		if len(emojis) < emojiuploader.MaxPageSize {
+			return all, nil
+		}
+	}
 }
@@ -0,0 +1,1163 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// pngData is a valid 1x1 PNG
+var pngData = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR\x00\x00\x00\x01\x00\x00\x00\x01\x08\x06\x00\x00\x00\x1f\x15\xc4\x89\x00\x00\x00\rIDATx\x9cc\xf8\xff\xff?\x00\x05\xfe\x02\xfe\xa75\x81\x84\x00\x00\x00\x00IEND\xaeB`\x82")
+
+// testdata is the absolute path of the testdata directory, as runCLI runs
+// the tool in a temporary directory
+var testdata, _ = filepath.Abs("testdata")
+
+// sourceFile writes a JSON source mapping every name to the next URL
+func sourceFile(t *testing.T, pairs ...string) string {
+	t.Helper()
+	var b strings.Builder
+	b.WriteString("{")
+	for i := 0; i < len(pairs); i += 2 {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		b.WriteString(`"` + pairs[i] + `":"` + pairs[i+1] + `"`)
+	}
+	b.WriteString("}")
+	return writeFile(t, t.TempDir(), "emoji.json", []byte(b.String()))
+}
+
+// runImport uploads the emojis of file to srv with args added to the
+// command line. It returns the exit code, the --report of the run, which is
+// empty if the run ended before writing one, and the output.
+func runImport(t *testing.T, srv *fakeServer, file string, args ...string) (int, Report, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "report.json")
+	args = append([]string{"-s", srv.URL, "-t", "tok", "-f", file, "--report", path, "--delay", "0"}, args...)
+	code, out := runCLI(t, args...)
+
+	var report Report
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return code, report, out
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatalf("invalid report: %v", err)
+	}
+	return code, report, out
+}
+
+// byName returns the results of a report by original name
+func byName(r Report) map[string]Result {
+	m := make(map[string]Result, len(r.Results))
+	for _, res := range r.Results {
+		m[res.OriginalName] = res
+	}
+	return m
+}
+
+// noisyPNG returns a w x h PNG of random pixels, which barely compresses
+func noisyPNG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	rng := rand.New(rand.NewSource(1))
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.NRGBA{uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256)), 255})
+		}
+	}
+	return encodePNG(t, img)
+}
+
+// solidPNG returns a w x h PNG of a single opaque color
+func solidPNG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.NRGBA{200, 30, 30, 255})
+		}
+	}
+	return encodePNG(t, img)
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestResolveSetting(t *testing.T) {
+	t.Setenv("TEST_SETTING", "from-env")
+	if got := resolveSetting("from-flag", "TEST_SETTING"); got != "from-flag" {
+		t.Errorf("flag and environment: got %q, want the flag", got)
+	}
+	if got := resolveSetting("", "TEST_SETTING"); got != "from-env" {
+		t.Errorf("environment only: got %q, want the environment", got)
+	}
+	t.Setenv("TEST_SETTING", "")
+	if got := resolveSetting("", "TEST_SETTING"); got != "" {
+		t.Errorf("neither: got %q, want nothing", got)
+	}
+}
+
+// authServer is a fakeServer recording the Authorization header of the users/me lookup
+func authServer(t *testing.T) (*fakeServer, *string) {
+	srv := newFakeServer(t)
+	var auth string
+	srv.handle("/api/v4/users/me", func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		w.Write([]byte(`{"id":"user1","username":"me","roles":"system_user"}`))
+	})
+	return srv, &auth
+}
+
+func TestTokenFromEnvironment(t *testing.T) {
+	srv, auth := authServer(t)
+	file := sourceFile(t, "party", srv.img("party.png", pngData))
+
+	code, out := runCLIEnv(t, map[string]string{tokenEnv: "env-token"}, "-s", srv.URL, "-f", file, "--delay", "0")
+	if code != exitOK {
+		t.Fatalf("exit code %d, output:\n%s", code, out)
+	}
+	if *auth != "Bearer env-token" {
+		t.Errorf("Authorization = %q, want the token from %s", *auth, tokenEnv)
+	}
+}
+
+func TestTokenFlagOverridesEnvironment(t *testing.T) {
+	srv, auth := authServer(t)
+	file := sourceFile(t, "party", srv.img("party.png", pngData))
+
+	code, out := runCLIEnv(t, map[string]string{tokenEnv: "env-token"}, "-s", srv.URL, "-t", "flag-token", "-f", file, "--delay", "0")
+	if code != exitOK {
+		t.Fatalf("exit code %d, output:\n%s", code, out)
+	}
+	if *auth != "Bearer flag-token" {
+		t.Errorf("Authorization = %q, want the token from -t", *auth)
+	}
+}
+
+func TestMissingToken(t *testing.T) {
+	srv := newFakeServer(t)
+	file := sourceFile(t, "party", srv.img("party.png", pngData))
+
+	if code, _ := runCLI(t, "-s", srv.URL, "-f", file); code != exitSetup {
+		t.Errorf("exit code %d without a token, want %d", code, exitSetup)
+	}
+	if n := srv.requestCount(""); n != 0 {
+		t.Errorf("%d requests without a token, want none", n)
+	}
+}
+
+func TestPasswordLogin(t *testing.T) {
+	srv := newFakeServer(t)
+	file := sourceFile(t, "party", srv.img("party.png", pngData))
+
+	code, out := runCLI(t, "-s", srv.URL, "--login-id", "me", "--password", "secret", "-f", file, "--delay", "0")
+	if code != exitOK {
+		t.Fatalf("exit code %d, output:\n%s", code, out)
+	}
+	uploads := srv.uploaded()
+	if len(uploads) != 1 {
+		t.Fatalf("%d uploads, want 1\n%s", len(uploads), out)
+	}
+	if auth := uploads[0].Header.Get("Authorization"); auth != "Bearer session-token" {
+		t.Errorf("upload Authorization = %q, want the session token", auth)
+	}
+}
+
+func TestPasswordLoginFails(t *testing.T) {
+	srv := newFakeServer(t)
+	file := sourceFile(t, "party", srv.img("party.png", pngData))
+
+	code, out := runCLI(t, "-s", srv.URL, "--login-id", "me", "--password", "wrong", "-f", file, "--delay", "0")
+	if code != exitAuth {
+		t.Errorf("exit code %d, want %d\n%s", code, exitAuth, out)
+	}
+	if !strings.Contains(out, "Invalid credentials") {
+		t.Errorf("the server's error isn't shown:\n%s", out)
+	}
+	if len(srv.uploaded()) != 0 {
+		t.Error("uploaded without logging in")
+	}
+}
+
+func TestLoginIDRequiresPassword(t *testing.T) {
+	srv := newFakeServer(t)
+	file := sourceFile(t, "party", srv.img("party.png", pngData))
+
+	if code, _ := runCLI(t, "-s", srv.URL, "--login-id", "me", "-f", file); code != exitSetup {
+		t.Errorf("exit code %d for --login-id without --password, want %d", code, exitSetup)
+	}
+	if n := srv.requestCount(""); n != 0 {
+		t.Errorf("%d requests, want none", n)
+	}
+}
+
+func TestTeamMembership(t *testing.T) {
+	tests := []struct {
+		name    string
+		team    string
+		members map[string]bool
+		want    bool
+	}{
+		{"member", "t", map[string]bool{"user1": true}, true},
+		{"not a member", "t", map[string]bool{"svc1": true}, false},
+		{"unknown team", "nope", map[string]bool{"user1": true}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newFakeServer(t)
+			srv.mu.Lock()
+			srv.members = tt.members
+			srv.mu.Unlock()
+			file := sourceFile(t, "party", srv.img("party.png", pngData))
+
+			_, out := runCLI(t, "-s", srv.URL, "-t", "tok", "-f", file, "--delay", "0", "--team", tt.team)
+			if uploaded := len(srv.uploaded()) > 0; uploaded != tt.want {
+				t.Errorf("uploaded: %v, want %v\n%s", uploaded, tt.want, out)
+			}
+		})
+	}
+}
+
+func TestItemTimeout(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.handle("/img/slow.gif", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	})
+	file := sourceFile(t,
+		"slow", srv.URL+"/img/slow.gif",
+		"fast", srv.img("fast.png", pngData),
+		"quick", srv.img("quick.png", pngData),
+	)
+
+	start := time.Now()
+	code, report, out := runImport(t, srv, file, "--item-timeout", "200ms", "--concurrency", "2", "--retries", "0")
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("run took %v, want the slow emoji cut off after 200ms", elapsed)
+	}
+	if code != exitFailures {
+		t.Errorf("exit code %d, want %d\n%s", code, exitFailures, out)
+	}
+	results := byName(report)
+	if r := results["slow"]; r.Action != actionFailed || !strings.Contains(r.Reason, errItemTimeout.Error()) {
+		t.Errorf("slow: %+v, want failed with %q", r, errItemTimeout)
+	}
+	// The other emojis have their own deadline and are unaffected
+	for _, name := range []string{"fast", "quick"} {
+		if r := results[name]; r.Action != actionUploaded {
+			t.Errorf("%s: %+v, want uploaded", name, r)
+		}
+	}
+}
+
+func TestInvalidItemTimeout(t *testing.T) {
+	srv := newFakeServer(t)
+	code, _, out := runImport(t, srv, sourceFile(t, "x", srv.img("x.png", pngData)), "--item-timeout", "-1s")
+	if code != exitSetup {
+		t.Errorf("exit code %d, want %d\n%s", code, exitSetup, out)
+	}
+	if n := len(srv.uploaded()); n != 0 {
+		t.Errorf("%d emojis were uploaded despite the invalid --item-timeout", n)
+	}
+}
+
+func TestPermissionPreflight(t *testing.T) {
+	forbidden := func(w http.ResponseWriter, r *http.Request) {
+		writeAppError(w, http.StatusForbidden, "api.context.permissions.app_error", "You do not have the appropriate permissions.")
+	}
+	tests := []struct {
+		name     string
+		handlers map[string]http.HandlerFunc
+		wantCode int
+		wantOut  string
+	}{
+		{"allowed", nil, exitOK, ""},
+		{"bad token", map[string]http.HandlerFunc{"/api/v4/users/me": func(w http.ResponseWriter, r *http.Request) {
+			writeAppError(w, http.StatusUnauthorized, "api.context.session_expired.app_error", "Invalid or expired session.")
+		}}, exitAuth, "rejected the token (HTTP 401)"},
+		{"no API access", map[string]http.HandlerFunc{"/api/v4/users/me": forbidden}, exitAuth, "not allowed to access the API (HTTP 403)"},
+		{"missing permission", map[string]http.HandlerFunc{"/api/v4/roles/names": func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `[{"name":"system_user","permissions":["list_team_channels"]}]`)
+		}}, exitAuth, "lacks the create_emojis permission"},
+		{"permission from a team role", map[string]http.HandlerFunc{
+			"/api/v4/users/me/teams/members": func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, `[{"team_id":"t1","roles":"team_user"}]`)
+			},
+			"/api/v4/roles/names": func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, `[{"name":"system_user","permissions":[]},{"name":"team_user","permissions":["create_emojis"]}]`)
+			},
+		}, exitOK, ""},
+		// Servers that hide the roles don't stop the import
+		{"roles not readable", map[string]http.HandlerFunc{"/api/v4/roles/names": forbidden}, exitOK, "Could not check the create_emojis permission"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newFakeServer(t)
+			for path, h := range tt.handlers {
+				srv.handle(path, h)
+			}
+			code, _, out := runImport(t, srv, sourceFile(t, "party", srv.img("party.png", pngData)))
+			if code != tt.wantCode {
+				t.Errorf("exit code %d, want %d\n%s", code, tt.wantCode, out)
+			}
+			if !strings.Contains(out, tt.wantOut) {
+				t.Errorf("output doesn't contain %q:\n%s", tt.wantOut, out)
+			}
+			// A failed preflight stops before any image is downloaded
+			if downloads := srv.requestCount("GET /img/"); (tt.wantCode == exitOK) != (downloads == 1) {
+				t.Errorf("%d downloads with exit code %d", downloads, code)
+			}
+		})
+	}
+}
+
+func TestLimit(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.addEmoji("b", pngData)
+	var pairs []string
+	for _, name := range []string{"e", "d", "c", "b", "a"} {
+		pairs = append(pairs, name, srv.img(name+".png", pngData))
+	}
+
+	code, report, out := runImport(t, srv, sourceFile(t, pairs...), "--limit", "2")
+	if code != exitOK {
+		t.Fatalf("exit code %d, output:\n%s", code, out)
+	}
+	// b is already on the server and doesn't count towards the limit
+	var uploaded []string
+	for _, u := range srv.uploaded() {
+		uploaded = append(uploaded, u.Name)
+	}
+	if want := []string{"a", "c"}; !slices.Equal(uploaded, want) {
+		t.Errorf("uploaded %q, want %q", uploaded, want)
+	}
+	if n := srv.requestCount("GET /img/"); n != 2 {
+		t.Errorf("%d downloads, want 2", n)
+	}
+	results := byName(report)
+	for _, name := range []string{"d", "e"} {
+		if r, ok := results[name]; ok {
+			t.Errorf("%s was attempted beyond the limit: %+v", name, r)
+		}
+	}
+	if !strings.Contains(out, "--limit 2: processing 3 of 5 emojis") {
+		t.Errorf("output doesn't report the limit:\n%s", out)
+	}
+}
+
+func TestUploadTooLargeForServer(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		write  func(w http.ResponseWriter)
+	}{
+		{"proxy 413", http.StatusRequestEntityTooLarge, func(w http.ResponseWriter) {
+			w.Header().Set("Content-Type", "text/html")
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			fmt.Fprint(w, "<html><body><h1>413 Request Entity Too Large</h1></body></html>")
+		}},
+		{"Mattermost limit", http.StatusBadRequest, func(w http.ResponseWriter) {
+			writeAppError(w, http.StatusBadRequest, "api.emoji.create.too_large.app_error", "Unable to create emoji. Image must be less than 64 KB in size.")
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newFakeServer(t)
+			var calls atomic.Int32
+			srv.handle("POST /api/v4/emoji", func(w http.ResponseWriter, r *http.Request) {
+				calls.Add(1)
+				tt.write(w)
+			})
+			code, report, out := runImport(t, srv, sourceFile(t, "big", srv.img("big.png", pngData)))
+			if code != exitOK {
+				t.Errorf("exit code %d, output:\n%s", code, out)
+			}
+			want := fmt.Sprintf("image too large for this server: %d bytes, HTTP %d", len(pngData), tt.status)
+			r := byName(report)["big"]
+			if r.Action != actionSkipped || r.SkipReason != skipTooLarge || r.HTTPStatus != tt.status || r.Reason != want {
+				t.Errorf("result %+v, want skipped with %q", r, want)
+			}
+			if n := calls.Load(); n != 1 {
+				t.Errorf("%d upload attempts, want 1", n)
+			}
+		})
+	}
+}
+
+func TestUploadTooLargeForServerResizes(t *testing.T) {
+	srv := newFakeServer(t)
+	large := noisyPNG(t, 200, 200)
+	var sizes []int
+	srv.handle("POST /api/v4/emoji", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Errorf("invalid upload: %v", err)
+			return
+		}
+		_, fh, err := r.FormFile("image")
+		if err != nil {
+			t.Errorf("no image: %v", err)
+			return
+		}
+		srv.mu.Lock()
+		sizes = append(sizes, int(fh.Size))
+		first := len(sizes) == 1
+		srv.mu.Unlock()
+		if first {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, `{"id":"e1","name":"big"}`)
+	})
+
+	code, report, out := runImport(t, srv, sourceFile(t, "big", srv.img("big.png", large)), "--resize")
+	if code != exitOK {
+		t.Fatalf("exit code %d, output:\n%s", code, out)
+	}
+	if len(sizes) != 2 || sizes[0] != len(large) || sizes[1] >= len(large) {
+		t.Fatalf("upload sizes %v, want %d and then a smaller retry", sizes, len(large))
+	}
+	if r := byName(report)["big"]; r.Action != actionUploaded || !strings.Contains(r.Reason, "after the server refused it as too large") {
+		t.Errorf("result %+v, want uploaded after resizing", r)
+	}
+}
+
+func TestUploadErrorBodies(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantAction string
+		wantSkip   string
+		wantReason string
+	}{
+		{"duplicate",
+			`{"id":"api.emoji.create.duplicate.app_error","message":"Unable to create emoji. Another emoji with the same name already exists.","detailed_error":"","request_id":"8tqzpfgb1inrjfmd5z7j5ta1pe","status_code":400}`,
+			actionSkipped, skipExists, "already exists on the server"},
+		{"invalid name",
+			`{"id":"model.emoji.name.app_error","message":"Name must be between 1 and 64 lowercase alphanumeric characters.","detailed_error":"","request_id":"ckd6sbk4b7bdjp1k3u8x7ge8hw","status_code":400}`,
+			actionSkipped, skipInvalidName, "invalid name: Name must be between 1 and 64 lowercase alphanumeric characters"},
+		{"other rejection",
+			`{"id":"api.emoji.create.parse.app_error","message":"Unable to create emoji. Could not understand request.","detailed_error":"","status_code":400}`,
+			actionSkipped, skipRejected, "Unable to create emoji. Could not understand request"},
+		{"no error body", ``, actionSkipped, skipRejected, "already exists or invalid name"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newFakeServer(t)
+			srv.handle("POST /api/v4/emoji", func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, tt.body)
+			})
+			code, report, out := runImport(t, srv, sourceFile(t, "party", srv.img("party.png", pngData)))
+			if code != exitOK {
+				t.Errorf("exit code %d, output:\n%s", code, out)
+			}
+			r := byName(report)["party"]
+			if r.Action != tt.wantAction || r.SkipReason != tt.wantSkip || r.Reason != tt.wantReason || r.HTTPStatus != http.StatusBadRequest {
+				t.Errorf("result %+v, want %s/%s with %q", r, tt.wantAction, tt.wantSkip, tt.wantReason)
+			}
+			if !strings.Contains(out, tt.wantReason) {
+				t.Errorf("output doesn't contain %q:\n%s", tt.wantReason, out)
+			}
+		})
+	}
+}
+
+// emojiRequests returns the requests srv received for emoji endpoints, in order
+func (s *fakeServer) emojiRequests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var reqs []string
+	for _, r := range s.requests {
+		if strings.Contains(r, "/api/v4/emoji") {
+			reqs = append(reqs, r)
+		}
+	}
+	return reqs
+}
+
+func TestOverwrite(t *testing.T) {
+	srv := newFakeServer(t)
+	old := srv.addEmoji("party", []byte("GIF89a old"))
+	file := sourceFile(t, "party", srv.img("party.png", pngData))
+
+	code, report, out := runImport(t, srv, file, "--overwrite")
+	if code != exitOK {
+		t.Fatalf("exit code %d, output:\n%s", code, out)
+	}
+	want := []string{
+		"POST /api/v4/emoji",
+		"GET /api/v4/emoji/name/party",
+		"DELETE /api/v4/emoji/" + old.ID,
+		"POST /api/v4/emoji",
+	}
+	if got := srv.emojiRequests(); !slices.Equal(got, want) {
+		t.Errorf("requests %q, want %q", got, want)
+	}
+	uploads := srv.uploaded()
+	if len(uploads) != 1 || !bytes.Equal(uploads[0].Data, pngData) || uploads[0].ID == old.ID {
+		t.Fatalf("%d uploads, want the new image under a new id", len(uploads))
+	}
+	r := byName(report)["party"]
+	if r.Action != actionUploaded || r.EmojiID != uploads[0].ID || !strings.Contains(r.Reason, "replaced the existing emoji") {
+		t.Errorf("result %+v, want uploaded as a replacement", r)
+	}
+}
+
+func TestWithoutOverwrite(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.addEmoji("party", []byte("GIF89a old"))
+	code, _, out := runImport(t, srv, sourceFile(t, "party", srv.img("party.png", pngData)), "--force")
+	if code != exitOK {
+		t.Fatalf("exit code %d, output:\n%s", code, out)
+	}
+	if deleted := srv.deletedNames(); len(deleted) != 0 {
+		t.Errorf("deleted %q without --overwrite", deleted)
+	}
+}
+
+func TestOverwriteRecreateFails(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.addEmoji("party", []byte("GIF89a old"))
+	var posts atomic.Int32
+	srv.handle("POST /api/v4/emoji", func(w http.ResponseWriter, r *http.Request) {
+		if posts.Add(1) > 1 {
+			writeAppError(w, http.StatusForbidden, "api.context.permissions.app_error", "You do not have the appropriate permissions.")
+			return
+		}
+		srv.mu.Lock()
+		defer srv.mu.Unlock()
+		srv.createEmoji(w, r)
+	})
+
+	code, report, out := runImport(t, srv, sourceFile(t, "party", srv.img("party.png", pngData)), "--overwrite", "--retries", "0")
+	if code != exitFailures {
+		t.Errorf("exit code %d, want %d\n%s", code, exitFailures, out)
+	}
+	// The user has to learn that the old emoji is gone
+	if r := byName(report)["party"]; r.Action != actionFailed || !strings.Contains(r.Reason, "the existing emoji was deleted, but re-creating it failed") {
+		t.Errorf("result %+v, want a failure that mentions the deletion", r)
+	}
+}
+
+func TestCreatorOverride(t *testing.T) {
+	srv := newFakeServer(t)
+	file := sourceFile(t, "party", srv.img("party.png", pngData))
+
+	code, out := runCLI(t, "-s", srv.URL, "-t", "tok", "-f", file, "--delay", "0", "--team", "t", "--creator-username", "svc")
+	if code != exitOK {
+		t.Fatalf("exit code %d, output:\n%s", code, out)
+	}
+	uploads := srv.uploaded()
+	if len(uploads) != 1 || uploads[0].CreatorID != "svc1" {
+		t.Fatalf("uploads = %+v, want one created by svc1", uploads)
+	}
+	// The membership check is for the token's user, not the creator
+	if n := srv.requestCount("GET /api/v4/teams/t1/members/user1"); n != 1 {
+		t.Errorf("checked the membership of user1 %d times, want 1", n)
+	}
+}
+
+func TestCreatorOverrideByID(t *testing.T) {
+	srv := newFakeServer(t)
+	file := sourceFile(t, "party", srv.img("party.png", pngData))
+
+	code, out := runCLI(t, "-s", srv.URL, "-t", "tok", "-f", file, "--delay", "0", "--creator-id", "svc1")
+	if code != exitOK {
+		t.Fatalf("exit code %d, output:\n%s", code, out)
+	}
+	if uploads := srv.uploaded(); len(uploads) != 1 || uploads[0].CreatorID != "svc1" {
+		t.Fatalf("uploads = %+v, want one created by svc1\n%s", uploads, out)
+	}
+}
+
+func TestServerBasePath(t *testing.T) {
+	srv := newFakeServer(t)
+	// Mattermost behind a reverse proxy at /mattermost; images stay at the root
+	inner := srv.Config.Handler
+	var misses atomic.Int32
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/api/") {
+			misses.Add(1)
+			http.NotFound(w, r)
+			return
+		}
+		http.StripPrefix("/mattermost", inner).ServeHTTP(w, r)
+	})
+	srv.addEmoji("existing", pngData)
+	file := sourceFile(t,
+		"party", srv.URL+"/mattermost/img/party.png",
+		"existing", srv.URL+"/mattermost/img/existing.png",
+	)
+	srv.img("party.png", pngData)
+	srv.img("existing.png", pngData)
+
+	for _, server := range []string{srv.URL + "/mattermost", srv.URL + "/mattermost/"} {
+		code, out := runCLI(t, "-s", server, "-t", "tok", "-f", file, "--delay", "0")
+		if code != exitOK {
+			t.Fatalf("%s: exit code %d, output:\n%s", server, code, out)
+		}
+	}
+	if misses.Load() != 0 {
+		t.Errorf("%d API requests without the base path", misses.Load())
+	}
+	if uploads := srv.uploaded(); len(uploads) != 1 || uploads[0].Name != "party" {
+		t.Errorf("uploads %v, want party once, the second run skipping it", uploads)
+	}
+
+	// The root of the same host isn't Mattermost, which the ping catches
+	code, out := runCLI(t, "-s", srv.URL, "-t", "tok", "-f", file, "--delay", "0")
+	if code != exitSetup || !strings.Contains(out, "including any base path") {
+		t.Errorf("at the root: exit code %d, output:\n%s", code, out)
+	}
+}
+
+func TestPingCheck(t *testing.T) {
+	tests := []struct {
+		name   string
+		ping   http.HandlerFunc
+		args   []string
+		code   int
+		output string
+	}{
+		{"healthy", nil, nil, exitOK, ""},
+		{"unhealthy", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"status":"UNHEALTHY"}`))
+		}, nil, exitSetup, "doesn't look like a healthy Mattermost server"},
+		{"wrong URL", func(w http.ResponseWriter, r *http.Request) {
+			http.NotFound(w, r)
+		}, nil, exitSetup, "check the URL"},
+		{"skipped", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"status":"UNHEALTHY"}`))
+		}, []string{"--no-ping"}, exitOK, ""},
+	}
+	for _, tt := range tests {
+		srv := newFakeServer(t)
+		if tt.ping != nil {
+			srv.handle("GET /api/v4/system/ping", tt.ping)
+		}
+		file := sourceFile(t, "party", srv.img("party.png", pngData))
+		code, _, out := runImport(t, srv, file, tt.args...)
+		if code != tt.code || !strings.Contains(out, tt.output) {
+			t.Errorf("%s: exit code %d, want %d and %q in the output:\n%s", tt.name, code, tt.code, tt.output, out)
+		}
+		want := 1
+		if slices.Contains(tt.args, "--no-ping") {
+			want = 0
+		}
+		if n := srv.requestCount("GET /api/v4/system/ping"); n != want {
+			t.Errorf("%s: %d pings, want %d", tt.name, n, want)
+		}
+		// A failed ping stops before the token is tried
+		if tt.code == exitSetup {
+			if n := srv.requestCount("GET /api/v4/users/me"); n != 0 {
+				t.Errorf("%s: user looked up after the failed ping", tt.name)
+			}
+		}
+	}
+}
+
+func TestPingUnreachable(t *testing.T) {
+	srv := newFakeServer(t)
+	file := sourceFile(t, "party", srv.img("party.png", pngData))
+	url := srv.URL
+	srv.Close()
+
+	code, out := runCLI(t, "-s", url, "-t", "tok", "-f", file, "--delay", "0", "--retries", "0")
+	if code != exitSetup || !strings.Contains(out, "Can't reach "+url) {
+		t.Errorf("exit code %d, want %d and an unreachable server:\n%s", code, exitSetup, out)
+	}
+}
+
+func TestCSRFHeader(t *testing.T) {
+	for _, args := range [][]string{nil, {"--no-csrf-header"}} {
+		srv := newFakeServer(t)
+		var posts, marked atomic.Int32
+		inner := srv.Config.Handler
+		srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPost && r.URL.Path == "/api/v4/emoji" {
+				posts.Add(1)
+				if r.Header.Get("X-Requested-With") == "XMLHttpRequest" {
+					marked.Add(1)
+				}
+			}
+			inner.ServeHTTP(w, r)
+		})
+		file := sourceFile(t, "party", srv.img("party.png", pngData), "wave", srv.img("wave.png", pngData))
+
+		if code, _, out := runImport(t, srv, file, args...); code != exitOK {
+			t.Fatalf("%v: exit code %d, output:\n%s", args, code, out)
+		}
+		want := posts.Load()
+		if len(args) > 0 {
+			want = 0
+		}
+		if posts.Load() != 2 || marked.Load() != want {
+			t.Errorf("%v: %d of %d uploads sent X-Requested-With, want %d", args, marked.Load(), posts.Load(), want)
+		}
+	}
+}
+
+func TestBotToken(t *testing.T) {
+	// What /users/me returns for a bot account's access token
+	botUser := `{"id":"bot1","username":"emojibot","first_name":"","roles":"system_user","is_bot":true,"bot_description":"Imports emojis","props":{}}`
+	tests := []struct {
+		name        string
+		status      int
+		body        string
+		args        []string
+		wantCode    int
+		wantCreator string
+		wantOutput  string
+	}{
+		{"full user", http.StatusOK, botUser, nil, exitOK, "bot1", "Authenticated as bot emojibot"},
+		{"forbidden with --creator-id", http.StatusForbidden, `{"id":"api.context.permissions.app_error","message":"You do not have the appropriate permissions.","status_code":403}`,
+			[]string{"--creator-id", "bot1"}, exitOK, "bot1", "using --creator-id bot1 without checking permissions"},
+		{"forbidden", http.StatusForbidden, `{"id":"api.context.permissions.app_error","message":"You do not have the appropriate permissions.","status_code":403}`,
+			nil, exitAuth, "", "bot tokens may need --creator-id"},
+		{"user without id and --creator-id", http.StatusOK, `{"username":"emojibot","is_bot":true}`,
+			[]string{"--creator-id", "bot1"}, exitOK, "bot1", "using --creator-id bot1 without checking permissions"},
+		{"user without id", http.StatusOK, `{"username":"emojibot","is_bot":true}`, nil, exitAuth, "", "returned the token's user without an ID"},
+	}
+	for _, tt := range tests {
+		srv := newFakeServer(t)
+		srv.handle("/api/v4/users/me", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tt.status)
+			fmt.Fprint(w, tt.body)
+		})
+		file := sourceFile(t, "party", srv.img("party.png", pngData))
+
+		args := append([]string{"-s", srv.URL, "-t", "bot-token", "-f", file, "--delay", "0"}, tt.args...)
+		code, out := runCLI(t, args...)
+		if code != tt.wantCode {
+			t.Errorf("%s: exit code %d, want %d\n%s", tt.name, code, tt.wantCode, out)
+		}
+		if !strings.Contains(out, tt.wantOutput) {
+			t.Errorf("%s: output doesn't mention %q:\n%s", tt.name, tt.wantOutput, out)
+		}
+		uploads := srv.uploaded()
+		switch {
+		case tt.wantCreator == "" && len(uploads) > 0:
+			t.Errorf("%s: %d uploads, want none", tt.name, len(uploads))
+		case tt.wantCreator != "" && (len(uploads) != 1 || uploads[0].CreatorID != tt.wantCreator):
+			t.Errorf("%s: uploads = %+v, want one created by %s", tt.name, uploads, tt.wantCreator)
+		}
+	}
+}
+
+func TestNormalizeServerURL(t *testing.T) {
+	good := []struct{ in, want string }{
+		{"https://chat.example.com", "https://chat.example.com"},
+		{"https://chat.example.com/", "https://chat.example.com"},
+		{"https://chat.example.com///", "https://chat.example.com"},
+		{"  http://localhost:8065/  ", "http://localhost:8065"},
+		{"HTTPS://chat.example.com", "https://chat.example.com"},
+		{"https://example.com/mattermost/", "https://example.com/mattermost"},
+		{"https://example.com/team%20chat/", "https://example.com/team%20chat"},
+		{"http://[::1]:8065", "http://[::1]:8065"},
+	}
+	for _, tt := range good {
+		got, err := normalizeServerURL(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("normalizeServerURL(%q) = %q, %v, want %q", tt.in, got, err, tt.want)
+		}
+	}
+
+	bad := []string{
+		"",
+		"chat.example.com",
+		"ftp://chat.example.com",
+		"//chat.example.com",
+		"https://",
+		"https:///path",
+		"https://chat.example.com/?team=x",
+		"https://chat.example.com/#top",
+		"https://chat example.com",
+		"http://[::1",
+	}
+	for _, in := range bad {
+		if got, err := normalizeServerURL(in); err == nil {
+			t.Errorf("normalizeServerURL(%q) = %q, want an error", in, got)
+		}
+	}
+}
+
+func TestServerURLTrailingSlash(t *testing.T) {
+	srv := newFakeServer(t)
+	file := sourceFile(t, "party", srv.img("party.png", pngData))
+	code, out := runCLI(t, "-s", srv.URL+"/", "-t", "tok", "-f", file, "--delay", "0")
+	if code != exitOK {
+		t.Fatalf("exit code %d, output:\n%s", code, out)
+	}
+	srv.mu.Lock()
+	for _, r := range srv.requests {
+		if strings.Contains(r, "//") {
+			t.Errorf("request %q has a doubled slash", r)
+		}
+	}
+	srv.mu.Unlock()
+	if len(srv.uploaded()) != 1 {
+		t.Errorf("%d uploads, want 1", len(srv.uploaded()))
+	}
+
+	if code, _ := runCLI(t, "-s", "chat.example.com", "-t", "tok", "-f", file); code != exitSetup {
+		t.Errorf("URL without a scheme: exit code %d, want %d", code, exitSetup)
+	}
+}
+
+// failFastSources returns a source file of a, an emoji b that is already on
+// the server, a failing c and further emojis d to k
+func failFastSources(t *testing.T, srv *fakeServer) string {
+	srv.addEmoji("b", pngData)
+	srv.handle("/img/c.png", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	pairs := []string{"c", srv.URL + "/img/c.png"}
+	for _, name := range strings.Split("a b d e f g h i j k", " ") {
+		pairs = append(pairs, name, srv.img(name+".png", pngData))
+	}
+	return sourceFile(t, pairs...)
+}
+
+func TestFailFast(t *testing.T) {
+	srv := newFakeServer(t)
+	code, report, out := runImport(t, srv, failFastSources(t, srv), "--fail-fast", "--retries", "0")
+	if code != exitFailures {
+		t.Errorf("exit code %d, want %d\n%s", code, exitFailures, out)
+	}
+	// The skip of b doesn't stop the run, the failure of c does
+	var names []string
+	for _, r := range report.Results {
+		names = append(names, r.OriginalName)
+	}
+	slices.Sort(names)
+	if want := []string{"a", "b", "c"}; !slices.Equal(names, want) {
+		t.Errorf("processed %q, want %q", names, want)
+	}
+	if !strings.Contains(out, "--fail-fast: stopping after [:c:] failed") {
+		t.Errorf("output doesn't name the failing emoji:\n%s", out)
+	}
+}
+
+func TestFailFastConcurrent(t *testing.T) {
+	srv := newFakeServer(t)
+	file := failFastSources(t, srv)
+	// Slow downloads after c keep the other workers from getting through the
+	// whole list before the failure is reported
+	for _, name := range strings.Split("d e f g h i j k", " ") {
+		srv.handle("/img/"+name+".png", func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(50 * time.Millisecond)
+			w.Write(pngData)
+		})
+	}
+	code, report, out := runImport(t, srv, file, "--fail-fast", "--retries", "0", "--concurrency", "3")
+	if code != exitFailures {
+		t.Errorf("exit code %d, want %d\n%s", code, exitFailures, out)
+	}
+	// The workers may have started a few more, but not the rest of the list
+	if n := len(report.Results); n >= 11 {
+		t.Errorf("%d of 11 emojis processed, want the run stopped early", n)
+	}
+
+	srv = newFakeServer(t)
+	if _, report, _ := runImport(t, srv, failFastSources(t, srv), "--retries", "0", "--concurrency", "3"); len(report.Results) != 11 {
+		t.Errorf("without --fail-fast %d of 11 emojis processed", len(report.Results))
+	}
+}
+
+func TestUnknownImageType(t *testing.T) {
+	srv := newFakeServer(t)
+	file := sourceFile(t, "icon", srv.img("icon.ico", []byte("\x00\x00\x01\x00not really an icon")))
+	srv.imageTypes["icon.ico"] = "image/x-icon"
+
+	// Let the type through the format check to reach the upload
+	code, _, out := runImport(t, srv, file, "--allow-formats", "png,image/x-icon")
+	if code != exitOK {
+		t.Fatalf("exit code %d, output:\n%s", code, out)
+	}
+	if want := "[:icon:] unknown image type image/x-icon, uploading it as .png"; !strings.Contains(out, want) {
+		t.Errorf("output doesn't warn %q:\n%s", want, out)
+	}
+	if uploads := srv.uploaded(); len(uploads) != 1 || uploads[0].Filename != "icon.png" {
+		t.Errorf("uploads = %+v, want icon.png", uploads)
+	}
+}
+
+func TestEmojiIDRecorded(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.addEmoji("existing", pngData)
+	file := sourceFile(t,
+		"party", srv.img("party.png", pngData),
+		"existing", srv.img("existing.png", pngData),
+		"echo", "alias:party",
+	)
+	path := filepath.Join(t.TempDir(), "results.csv")
+
+	code, report, out := runImport(t, srv, file, "--csv", path, "--verbose")
+	if code != exitOK {
+		t.Fatalf("exit code %d, output:\n%s", code, out)
+	}
+	ids := make(map[string]string)
+	for _, u := range srv.uploaded() {
+		ids[u.Name] = u.ID
+	}
+	results := byName(report)
+	for _, name := range []string{"party", "echo"} {
+		if ids[name] == "" || results[name].EmojiID != ids[name] {
+			t.Errorf("%s: report id %q, want the server's %q", name, results[name].EmojiID, ids[name])
+		}
+	}
+	// Emojis that weren't created in this run have no id
+	if id := results["existing"].EmojiID; id != "" {
+		t.Errorf("existing: id %q, want none", id)
+	}
+	for _, row := range readCSV(t, path)[1:] {
+		if row[6] != ids[row[1]] {
+			t.Errorf("CSV row %q, want id %q", row, ids[row[1]])
+		}
+	}
+	for _, want := range []string{
+		`\[:party:\] uploaded 1KB as image/png in \S+, id ` + ids["party"] + "\n",
+		`\[:echo:\] uploaded as an alias of :party:, id ` + ids["echo"] + "\n",
+	} {
+		if !regexp.MustCompile(want).MatchString(out) {
+			t.Errorf("verbose output doesn't match %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestMaxDuration(t *testing.T) {
+	srv := newFakeServer(t)
+	var pairs []string
+	for i := 0; i < 10; i++ {
+		name := fmt.Sprintf("slow%d", i)
+		pairs = append(pairs, name, srv.URL+"/img/"+name+".png")
+		srv.handle("/img/"+name+".png", func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(60 * time.Millisecond)
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(pngData)
+		})
+	}
+
+	start := time.Now()
+	code, report, out := runImport(t, srv, sourceFile(t, pairs...), "--max-duration", "200ms")
+	if code != exitInterrupted {
+		t.Errorf("exit code %d, want %d after the deadline\n%s", code, exitInterrupted, out)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("the run took %v with a deadline of 200ms", elapsed)
+	}
+	uploaded := len(srv.uploaded())
+	if uploaded == 0 || uploaded == 10 {
+		t.Errorf("%d of 10 emojis uploaded, want some but not all\n%s", uploaded, out)
+	}
+	// The emoji in flight at the deadline is finished rather than failed
+	for _, r := range report.Results {
+		if r.Action == actionFailed {
+			t.Errorf("%s failed: %s", r.OriginalName, r.Reason)
+		}
+	}
+	remaining := 10 - len(report.Results)
+	for _, want := range []string{
+		"--max-duration 200ms reached",
+		fmt.Sprintf("Stopped early: %d of 10 emojis were not processed", remaining),
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output doesn't mention %q:\n%s", want, out)
+		}
+	}
+	if remaining == 0 {
+		t.Errorf("all emojis were processed despite the deadline")
+	}
+}
+
+func TestInvalidMaxDuration(t *testing.T) {
+	srv := newFakeServer(t)
+	file := sourceFile(t, "party", srv.img("party.png", pngData))
+	if code, _ := runCLI(t, "-s", srv.URL, "-t", "tok", "-f", file, "--max-duration", "-1m"); code != exitSetup {
+		t.Errorf("exit code %d for a negative --max-duration, want %d", code, exitSetup)
+	}
+}
+
+func TestExitCodes(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(srv *fakeServer) []string
+		want  int
+	}{
+		{"all uploaded", func(srv *fakeServer) []string {
+			return []string{"-f", sourceFile(t, "party", srv.img("party.png", pngData))}
+		}, exitOK},
+		{"only skips", func(srv *fakeServer) []string {
+			srv.addEmoji("party", pngData)
+			return []string{"-f", sourceFile(t, "party", srv.img("party.png", pngData))}
+		}, exitOK},
+		{"no source file", func(srv *fakeServer) []string {
+			return nil
+		}, exitSetup},
+		{"unreadable source file", func(srv *fakeServer) []string {
+			return []string{"-f", "missing.json"}
+		}, exitSetup},
+		{"unknown flag", func(srv *fakeServer) []string {
+			return []string{"-f", sourceFile(t, "party", srv.img("party.png", pngData)), "--no-such-flag"}
+		}, exitSetup},
+		{"failed upload", func(srv *fakeServer) []string {
+			return []string{"-f", sourceFile(t, "party", srv.img("party.png", pngData), "gone", srv.URL+"/img/gone.png"), "--retries", "0"}
+		}, exitFailures},
+		{"rejected token", func(srv *fakeServer) []string {
+			srv.handle("/api/v4/users/me", func(w http.ResponseWriter, r *http.Request) {
+				writeAppError(w, http.StatusUnauthorized, "api.context.session_expired.app_error", "Invalid or expired session.")
+			})
+			return []string{"-f", sourceFile(t, "party", srv.img("party.png", pngData))}
+		}, exitAuth},
+		{"deadline", func(srv *fakeServer) []string {
+			srv.handle("/img/slow.png", func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(100 * time.Millisecond)
+				w.Write(pngData)
+			})
+			return []string{"-f", sourceFile(t, "slow", srv.URL+"/img/slow.png", "next", srv.img("next.png", pngData)), "--max-duration", "20ms"}
+		}, exitInterrupted},
+	}
+	for _, tt := range tests {
+		srv := newFakeServer(t)
+		args := append([]string{"-s", srv.URL, "-t", "tok", "--delay", "0"}, tt.setup(srv)...)
+		if code, out := runCLI(t, args...); code != tt.want {
+			t.Errorf("%s: exit code %d, want %d\n%s", tt.name, code, tt.want, out)
+		}
+	}
+}
+
+// pipelineSources returns a source file of n distinct images on srv, whose
+// downloads are counted in g while they take a little while
+func pipelineSources(t *testing.T, srv *fakeServer, n int, g *gauge) string {
+	var pairs []string
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("emoji%02d", i)
+		data := solidPNG(t, i+1, 1)
+		pairs = append(pairs, name, srv.URL+"/img/"+name+".png")
+		srv.handle("/img/"+name+".png", func(w http.ResponseWriter, r *http.Request) {
+			g.enter()
+			defer g.leave()
+			time.Sleep(10 * time.Millisecond)
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(data)
+		})
+	}
+	return sourceFile(t, pairs...)
+}
+
+func TestPipeline(t *testing.T) {
+	srv := newFakeServer(t)
+	var downloads, uploads gauge
+	file := pipelineSources(t, srv, 20, &downloads)
+	srv.handle("POST /api/v4/emoji", func(w http.ResponseWriter, r *http.Request) {
+		uploads.enter()
+		defer uploads.leave()
+		time.Sleep(10 * time.Millisecond)
+		srv.mu.Lock()
+		defer srv.mu.Unlock()
+		srv.createEmoji(w, r)
+	})
+
+	code, report, out := runImport(t, srv, file, "--download-concurrency", "4", "--upload-concurrency", "2")
+	if code != exitOK {
+		t.Fatalf("exit code %d, output:\n%s", code, out)
+	}
+	if !strings.Contains(out, "with 4 download and 2 upload worker(s)") {
+		t.Errorf("output doesn't show the worker counts:\n%s", out)
+	}
+	// Every emoji is uploaded exactly once, with its own image
+	if len(report.Results) != 20 || report.Summary.Uploaded != 20 {
+		t.Errorf("%d results, summary %+v, want 20 uploads", len(report.Results), report.Summary)
+	}
+	seen := make(map[string]bool)
+	for _, u := range srv.uploaded() {
+		var i int
+		fmt.Sscanf(u.Name, "emoji%02d", &i)
+		if seen[u.Name] || !bytes.Equal(u.Data, solidPNG(t, i+1, 1)) {
+			t.Errorf("%s uploaded twice or with the wrong image", u.Name)
+		}
+		seen[u.Name] = true
+	}
+	if peak := downloads.peak.Load(); peak > 4 || peak < 2 {
+		t.Errorf("%d downloads at once, want up to 4 in parallel", peak)
+	}
+	if peak := uploads.peak.Load(); peak > 2 {
+		t.Errorf("%d uploads at once, want at most 2", peak)
+	}
+}
+
+func TestPipelineBackPressure(t *testing.T) {
+	srv := newFakeServer(t)
+	var downloads gauge
+	file := pipelineSources(t, srv, 20, &downloads)
+	release := make(chan struct{})
+	srv.handle("POST /api/v4/emoji", func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		srv.mu.Lock()
+		defer srv.mu.Unlock()
+		srv.createEmoji(w, r)
+	})
+
+	done := make(chan int)
+	go func() {
+		code, _, _ := runImport(t, srv, file, "--download-concurrency", "3", "--upload-concurrency", "1")
+		done <- code
+	}()
+
+	// With the upload stuck, the downloaders can get ahead by one image in
+	// the upload worker, one in the buffer and one each waiting to hand theirs over
+	const ahead = 1 + 1 + 3
+	deadline := time.Now().Add(5 * time.Second)
+	for srv.requestCount("GET /img/") < ahead && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := srv.requestCount("GET /img/"); n != ahead {
+		t.Errorf("%d images downloaded while the uploads were blocked, want %d", n, ahead)
+	}
+
+	close(release)
+	if code := <-done; code != exitOK {
+		t.Errorf("exit code %d after unblocking the uploads", code)
+	}
+	if n := len(srv.uploaded()); n != 20 {
+		t.Errorf("%d uploads, want all 20", n)
+	}
+}
+
+func TestInvalidStageConcurrency(t *testing.T) {
+	for _, flag := range []string{"--download-concurrency", "--upload-concurrency"} {
+		if code, _ := runCLI(t, "-s", "http://localhost", "-t", "tok", "-f", "x.json", flag, "-1"); code != exitSetup {
+			t.Errorf("exit code %d for %s -1, want %d", code, flag, exitSetup)
+		}
+	}
+}
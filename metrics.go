@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"time"
+)
+
+// metricsPrefix starts the names of all metrics written with --metrics-file
+const metricsPrefix = "mattermost_emoji_uploader_"
+
+// writeMetrics saves the outcome of the run to path in the Prometheus text
+// format, for node_exporter's textfile collector (--metrics-file). Every
+// value describes the last run, so all of them are gauges. The file is
+// replaced atomically so the collector never reads half of it.
+func writeMetrics(path string, s *stats, unverified int, duration time.Duration, code int) error {
+	var buf bytes.Buffer
+	metric := func(name, help string) {
+		fmt.Fprintf(&buf, "# HELP %s%s %s\n# TYPE %s%s gauge\n", metricsPrefix, name, help, metricsPrefix, name)
+	}
+
+	metric("emojis", "Emojis processed in the last run by result.")
+	fmt.Fprintf(&buf, "%semojis{result=\"succeeded\"} %d\n", metricsPrefix, s.succeeded)
+	fmt.Fprintf(&buf, "%semojis{result=\"skipped\"} %d\n", metricsPrefix, s.skipped)
+	fmt.Fprintf(&buf, "%semojis{result=\"failed\"} %d\n", metricsPrefix, s.failed)
+
+	metric("emojis_processed", "Emojis processed in the last run.")
+	fmt.Fprintf(&buf, "%semojis_processed %d\n", metricsPrefix, len(s.results))
+
+	metric("skipped_emojis", "Emojis skipped in the last run by reason.")
+	for _, row := range summaryRows {
+		if row.key == actionUploaded || row.key == actionAlias || row.key == actionFailed {
+			continue
+		}
+		fmt.Fprintf(&buf, "%sskipped_emojis{reason=%q} %d\n", metricsPrefix, row.key, s.counts[row.key])
+	}
+
+	metric("unverified_emojis", "Uploaded emojis that failed --verify in the last run.")
+	fmt.Fprintf(&buf, "%sunverified_emojis %d\n", metricsPrefix, unverified)
+
+	metric("transferred_bytes", "Image data moved in the last run: fetched from the sources and uploaded to Mattermost.")
+	fmt.Fprintf(&buf, "%stransferred_bytes{direction=\"downloaded\"} %d\n", metricsPrefix, s.downloadedBytes)
+	fmt.Fprintf(&buf, "%stransferred_bytes{direction=\"uploaded\"} %d\n", metricsPrefix, s.uploadedBytes)
+
+	metric("run_duration_seconds", "Duration of the last run.")
+	fmt.Fprintf(&buf, "%srun_duration_seconds %g\n", metricsPrefix, duration.Seconds())
+
+	metric("last_run_timestamp_seconds", "Unix time the last run finished.")
+	fmt.Fprintf(&buf, "%slast_run_timestamp_seconds %d\n", metricsPrefix, time.Now().Unix())
+
+	metric("last_run_exit_code", "Exit status of the last run, 0 on success.")
+	fmt.Fprintf(&buf, "%slast_run_exit_code %d\n", metricsPrefix, code)
+
+	if err := writeFileAtomic(path, buf.Bytes()); err != nil {
+		return err
+	}
+	// Temporary files are private, but the collector usually runs as another user
+	return os.Chmod(path, 0o644)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readMetrics parses a --metrics-file into its samples by name and labels,
+// failing on lines that aren't in the Prometheus text format
+func readMetrics(t *testing.T, path string) map[string]float64 {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	samples := map[string]float64{}
+	typed := map[string]bool{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "# TYPE ") {
+			fields := strings.Fields(line)
+			if len(fields) != 4 || fields[3] != "gauge" {
+				t.Errorf("invalid TYPE line %q", line)
+				continue
+			}
+			typed[fields[2]] = true
+			continue
+		}
+		if strings.HasPrefix(line, "# HELP ") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, " ")
+		v, err := strconv.ParseFloat(value, 64)
+		if !ok || err != nil || !strings.HasPrefix(key, metricsPrefix) {
+			t.Errorf("invalid sample %q", line)
+			continue
+		}
+		name, _, _ := strings.Cut(key, "{")
+		if !typed[name] {
+			t.Errorf("sample %q before the TYPE of %s", line, name)
+		}
+		samples[strings.TrimPrefix(key, metricsPrefix)] = v
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return samples
+}
+
+func TestMetricsFile(t *testing.T) {
+	fastRetries(t)
+	srv := newFakeServer(t)
+	srv.addEmoji("existing", pngData)
+	file := sourceFile(t,
+		"party", srv.img("party.png", pngData),
+		"wave", srv.img("wave.png", solidPNG(t, 16, 16)),
+		"existing", srv.img("existing.png", pngData),
+		"gone", srv.URL+"/img/gone.png",
+	)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "emoji.prom")
+
+	before := time.Now().Unix()
+	code, _, out := runImport(t, srv, file, "--metrics-file", path)
+	if code != exitFailures {
+		t.Fatalf("exit code %d, want %d\n%s", code, exitFailures, out)
+	}
+
+	var uploadedBytes int
+	for _, u := range srv.uploaded() {
+		if u.Name != "existing" {
+			uploadedBytes += len(u.Data)
+		}
+	}
+	samples := readMetrics(t, path)
+	want := map[string]float64{
+		`emojis{result="succeeded"}`:                    2,
+		`emojis{result="skipped"}`:                      1,
+		`emojis{result="failed"}`:                       1,
+		`emojis_processed`:                              4,
+		`skipped_emojis{reason="` + skipExists + `"}`:   1,
+		`skipped_emojis{reason="` + skipTooLarge + `"}`: 0,
+		`unverified_emojis`:                             0,
+		`transferred_bytes{direction="uploaded"}`:       float64(uploadedBytes),
+		`last_run_exit_code`:                            exitFailures,
+	}
+	for key, v := range want {
+		if got, ok := samples[key]; !ok || got != v {
+			t.Errorf("%s = %v (present: %v), want %v", key, got, ok, v)
+		}
+	}
+	if v := samples[`transferred_bytes{direction="downloaded"}`]; v < float64(uploadedBytes) {
+		t.Errorf("downloaded %v bytes, want at least the %d uploaded", v, uploadedBytes)
+	}
+	if v, ok := samples["run_duration_seconds"]; !ok || v <= 0 {
+		t.Errorf("run_duration_seconds = %v, want the duration of the run", v)
+	}
+	if v := samples["last_run_timestamp_seconds"]; v < float64(before) || v > float64(time.Now().Unix()) {
+		t.Errorf("last_run_timestamp_seconds = %v, want the end of the run", v)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0o644 {
+		t.Errorf("file mode %v, want it readable by the collector", mode)
+	}
+	// Nothing is left over from the atomic write
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("%d files in the metrics directory, want only emoji.prom", len(entries))
+	}
+}
+
+func TestMetricsFileReplaced(t *testing.T) {
+	srv := newFakeServer(t)
+	file := sourceFile(t, "party", srv.img("party.png", pngData))
+	path := writeFile(t, t.TempDir(), "emoji.prom", []byte("stale 1\n"))
+
+	if code, _, out := runImport(t, srv, file, "--metrics-file", path); code != exitOK {
+		t.Fatalf("exit code %d, output:\n%s", code, out)
+	}
+	// readMetrics fails on the old line if it was kept
+	if v := readMetrics(t, path)["last_run_exit_code"]; v != exitOK {
+		t.Errorf("last_run_exit_code = %v, want %d", v, exitOK)
+	}
+}
+
+func TestMetricsFileUnwritable(t *testing.T) {
+	srv := newFakeServer(t)
+	file := sourceFile(t, "party", srv.img("party.png", pngData))
+	path := filepath.Join(t.TempDir(), "missing", "emoji.prom")
+
+	if code, _, out := runImport(t, srv, file, "--metrics-file", path); code != exitSetup {
+		t.Errorf("exit code %d, want %d\n%s", code, exitSetup, out)
+	}
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/formatCvt/mattermost-emoji-uploader/emojiuploader"
+	"gopkg.in/yaml.v3"
+)
+
+// emojiName returns the Mattermost name for an emoji from the source file: the
+// sanitized name, or its --name-map override or the name set by its entry,
+// between --prefix and --suffix. When the result is too long, the middle is
+// clipped so the affixes are always kept intact.
+func emojiName(originalName string) string {
+	name, ok := nameMap[originalName]
+	if !ok {
+		name = entrySettings[originalName].Name
+		ok = name != ""
+	}
+	if !ok {
+		name = sanitizedName(originalName)
+	}
+	if keep := emojiuploader.MaxNameLength - len(namePrefix) - len(nameSuffix); len(name) > keep {
+		name = name[:keep]
+	}
+	return namePrefix + name + nameSuffix
+}
+
+// Values of --on-empty
+const (
+	onEmptySkip = "skip"
+	onEmptyHash = "hash"
+)
+
+// onEmpty is the --on-empty setting
+var onEmpty = onEmptySkip
+
+// sanitizedName returns the sanitized form of originalName. Names that
+// sanitize to nothing, such as "🎉🎉", stay empty with --on-empty skip and
+// get a name derived from their hash with --on-empty hash.
+func sanitizedName(originalName string) string {
+	name := emojiuploader.SanitizeNameWith(originalName, replaceRules...)
+	if name == "" && onEmpty == onEmptyHash {
+		sum := sha256.Sum256([]byte(originalName))
+		name = "emoji-" + hex.EncodeToString(sum[:4])
+	}
+	return name
+}
+
+// replaceFlag collects repeated --replace rules written as
+// /pattern/replacement/. Any character can be used as the delimiter instead
+// of "/", and a delimiter inside the pattern or replacement is escaped with a
+// backslash. Patterns are compiled while the flags are parsed, so a bad one
+// stops the tool before anything is processed.
+type replaceFlag []emojiuploader.NameRule
+
+func (f *replaceFlag) String() string {
+	rules := make([]string, len(*f))
+	for i, rule := range *f {
+		rules[i] = "/" + rule.Pattern.String() + "/" + rule.Replacement + "/"
+	}
+	return strings.Join(rules, ",")
+}
+
+func (f *replaceFlag) Set(value string) error {
+	delim, size := utf8.DecodeRuneInString(value)
+	if value == "" || delim == '\\' || unicode.IsLetter(delim) || unicode.IsDigit(delim) {
+		return errors.New("expected /pattern/replacement/")
+	}
+	parts := splitUnescaped(value[size:], delim)
+	if len(parts) != 3 || parts[2] != "" {
+		return errors.New("expected /pattern/replacement/")
+	}
+	if parts[0] == "" {
+		return errors.New("the pattern is empty")
+	}
+	pattern, err := regexp.Compile(parts[0])
+	if err != nil {
+		return err
+	}
+	*f = append(*f, emojiuploader.NameRule{Pattern: pattern, Replacement: parts[1]})
+	return nil
+}
+
+// splitUnescaped splits s at every delim that isn't preceded by a backslash
+// and removes the backslashes escaping one. Other escapes are kept for the
+// regular expression.
+func splitUnescaped(s string, delim rune) []string {
+	var parts []string
+	var part strings.Builder
+	runes := []rune(s)
+	for i := 0; i < len(runes); i++ {
+		switch {
+		case runes[i] == '\\' && i+1 < len(runes) && runes[i+1] == delim:
+			part.WriteRune(delim)
+			i++
+		case runes[i] == delim:
+			parts = append(parts, part.String())
+			part.Reset()
+		default:
+			part.WriteRune(runes[i])
+		}
+	}
+	return append(parts, part.String())
+}
+
+// replaceRules are the --replace rules, applied while names are sanitized
+var replaceRules replaceFlag
+
+// nameMap holds the --name-map overrides, keyed by original name
+var nameMap map[string]string
+
+// entrySettings holds the names and content types set by object entries of
+// the source, keyed by original name; --name-map overrides their names
+var entrySettings map[string]emojiEntry
+
+// loadNameMap reads a --name-map file, a JSON or YAML object mapping original
+// names to the names they should get instead of the sanitized ones
+func loadNameMap(path string) (map[string]string, error) {
+	format, err := fileFormat(path, "")
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var m map[string]string
+	if format == "yaml" {
+		err = yaml.Unmarshal(data, &m)
+	} else {
+		err = json.Unmarshal(data, &m)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", strings.ToUpper(format), err)
+	}
+	return m, nil
+}
+
+// validateNameMap checks that every override is a valid Mattermost name that
+// leaves room for --prefix and --suffix, and describes each problem found
+func validateNameMap(m map[string]string) []string {
+	originals := make([]string, 0, len(m))
+	for original := range m {
+		originals = append(originals, original)
+	}
+	sort.Strings(originals)
+
+	var issues []string
+	for _, original := range originals {
+		name := m[original]
+		if name == "" {
+			issues = append(issues, fmt.Sprintf("[:%s:] is mapped to an empty name", original))
+		} else if problem := nameProblem(name); problem != "" {
+			issues = append(issues, fmt.Sprintf("[:%s:] is mapped to %q, which %s", original, name, problem))
+		}
+	}
+	return issues
+}
+
+// validateEntryNames checks the names set by the entries of a source like
+// --name-map overrides, unless --name-map overrides them in turn. Entries with
+// an invalid name are removed from emojis and described in the issues.
+func validateEntryNames(emojis EmojiMap, settings map[string]emojiEntry) []string {
+	originals := make([]string, 0, len(settings))
+	for original, entry := range settings {
+		if entry.Name != "" {
+			originals = append(originals, original)
+		}
+	}
+	sort.Strings(originals)
+
+	var issues []string
+	for _, original := range originals {
+		if _, overridden := nameMap[original]; overridden {
+			continue
+		}
+		name := settings[original].Name
+		if problem := nameProblem(name); problem != "" {
+			issues = append(issues, fmt.Sprintf("[:%s:] has the name %q, which %s", original, name, problem))
+			delete(emojis, original)
+		}
+	}
+	return issues
+}
+
+// strictNames is the --strict-names setting
+var strictNames bool
+
+// validateStrictNames checks with --strict-names that every emoji keeps its
+// original name apart from lowercasing: sanitizing must not strip, replace or
+// transliterate a character, and nothing may be clipped to fit between
+// --prefix and --suffix. Names given by --name-map or the entry itself are
+// exempt, as they are chosen explicitly. Entries that would be renamed are
+// removed from emojis and described in the issues.
+func validateStrictNames(emojis EmojiMap) []string {
+	originals := make([]string, 0, len(emojis))
+	for original := range emojis {
+		originals = append(originals, original)
+	}
+	sort.Strings(originals)
+
+	var issues []string
+	for _, original := range originals {
+		_, mapped := nameMap[original]
+		named := entrySettings[original].Name != ""
+		if mapped || named {
+			continue
+		}
+		if name := emojiName(original); name != namePrefix+strings.ToLower(original)+nameSuffix {
+			issues = append(issues, fmt.Sprintf("[:%s:] would be renamed to :%s:, which -strict-names doesn't allow", original, name))
+			delete(emojis, original)
+		}
+	}
+	return issues
+}
+
+// nameProblem describes why a non-empty name given by the user can't be used
+// between --prefix and --suffix, or returns "" if it can
+func nameProblem(name string) string {
+	if maxLength := emojiuploader.MaxNameLength - len(namePrefix) - len(nameSuffix); len(name) > maxLength {
+		return fmt.Sprintf("is longer than %d characters", maxLength)
+	}
+	if emojiuploader.SanitizeName(name) != name {
+		return "may only contain lowercase letters, digits, '-' and '_'"
+	}
+	return ""
+}
+
+// validAffix reports whether a --prefix or --suffix only uses characters
+// allowed in emoji names
+func validAffix(affix string) bool {
+	return emojiuploader.SanitizeName(affix) == affix
+}
+
+// Values of --sort
+const (
+	sortOriginal  = "original"
+	sortSanitized = "sanitized"
+)
+
+// sortOrder is the --sort setting
+var sortOrder = sortOriginal
+
+// Values of --on-conflict
+const (
+	conflictSkip      = "skip"
+	conflictSuffix    = "suffix"
+	conflictOverwrite = "overwrite"
+	conflictError     = "error"
+)
+
+// onConflict is the --on-conflict setting. Empty keeps the default of adding
+// suffixes to names that collide within the source and skipping names that
+// are already on the server (or replacing them with --overwrite).
+var onConflict string
+
+// planJobs splits the emojis into regular ones and aliases, sorts both by
+// sortOrder and assigns their final names. Regular emojis are named first so
+// they keep their names when an alias collides with them. A fixed order makes
+// logs, collision suffixes and --limit reproducible. Jobs excluded by
+// --include/--exclude are dropped only after naming, so that filtering doesn't
+// change the names of the others. Names in taken, i.e. those on the server
+// with --on-conflict suffix, are avoided like the names of other jobs.
+func planJobs(emojis EmojiMap, taken map[string]bool) (regular, aliases []emojiJob, renamed int) {
+	for originalName, url := range emojis {
+		job := emojiJob{originalName: originalName, url: url}
+		if strings.HasPrefix(url, "alias:") {
+			aliases = append(aliases, job)
+		} else {
+			regular = append(regular, job)
+		}
+	}
+	sortJobs(regular)
+	sortJobs(aliases)
+
+	used := make(map[string]bool, len(taken))
+	for name := range taken {
+		used[name] = true
+	}
+	owners := make(map[string]*emojiJob)
+	renamed = assignNames(regular, used, owners) + assignNames(aliases, used, owners)
+
+	if len(includePatterns) > 0 || len(excludePatterns) > 0 {
+		var droppedRegular, droppedAliases int
+		regular, droppedRegular = filterJobs(regular)
+		aliases, droppedAliases = filterJobs(aliases)
+		if dropped := droppedRegular + droppedAliases; dropped > 0 {
+			logInfo("🔎 Skipping %d of %d emojis that don't match --include/--exclude\n", dropped, len(emojis))
+		}
+	}
+	return regular, aliases, renamed
+}
+
+// sortJobs orders jobs by sortOrder. Sanitized names are compared before
+// collision suffixes are added, with ties broken by the original name.
+func sortJobs(jobs []emojiJob) {
+	key := func(job emojiJob) string { return job.originalName }
+	if sortOrder == sortSanitized {
+		key = func(job emojiJob) string { return emojiName(job.originalName) }
+	}
+	sort.SliceStable(jobs, func(i, j int) bool {
+		ki, kj := key(jobs[i]), key(jobs[j])
+		if ki != kj {
+			return ki < kj
+		}
+		return jobs[i].originalName < jobs[j].originalName
+	})
+}
+
+// assignNames sanitizes the name of every job and resolves collisions between
+// them according to onConflict: by default and with suffix by appending a
+// numeric suffix, with skip and error by marking the later job as conflicting
+// with the earlier, and with overwrite the other way around. Names already
+// taken are tracked in used and the job holding each in owners, so several
+// batches of jobs can share the same namespace; names in used without an
+// owner are on the server. It returns the number of renamed jobs.
+func assignNames(jobs []emojiJob, used map[string]bool, owners map[string]*emojiJob) int {
+	renamed := 0
+	for i := range jobs {
+		job := &jobs[i]
+		name := emojiName(job.originalName)
+		if owner := owners[name]; owner != nil && name != "" {
+			switch onConflict {
+			case conflictSkip, conflictError:
+				job.safeName, job.conflictWith = name, owner.originalName
+				continue
+			case conflictOverwrite:
+				job.safeName, owner.conflictWith = name, job.originalName
+				owners[name] = job
+				continue
+			}
+		}
+
+		job.safeName = uniqueName(name, used)
+		owners[job.safeName] = job
+		// Renames of emojis that are filtered out anyway are just noise
+		if job.safeName != name && matchesFilter(job.originalName) {
+			reason := "is already used by another emoji"
+			if owners[name] == nil {
+				reason = "already exists on the server"
+			}
+			logInfo("🔀 Renamed [:%s:] -> [:%s:] (:%s: %s)\n", job.originalName, job.safeName, name, reason)
+			renamed++
+		}
+	}
+	return renamed
+}
+
+// conflictReason explains why a job that lost its name to another one of the
+// run is skipped
+func conflictReason(job emojiJob) string {
+	if onConflict == conflictOverwrite {
+		return fmt.Sprintf("replaced by [:%s:], which gets the same name", job.conflictWith)
+	}
+	return fmt.Sprintf("the name is already used by [:%s:]", job.conflictWith)
+}
+
+// nameConflicts lists the jobs that would get a name already used by another
+// job or, unless finished in a previous run, by an emoji on the server, for
+// --on-conflict error
+func (imp *importer) nameConflicts(jobs ...[]emojiJob) []string {
+	var conflicts []string
+	for _, list := range jobs {
+		for _, job := range list {
+			switch {
+			case job.conflictWith != "":
+				conflicts = append(conflicts, fmt.Sprintf("[:%s:] -> [:%s:] is also the name of [:%s:]", job.originalName, job.safeName, job.conflictWith))
+			case imp.existing[job.safeName] && !imp.done[job.safeName]:
+				conflicts = append(conflicts, fmt.Sprintf("[:%s:] -> [:%s:] already exists on the server", job.originalName, job.safeName))
+			}
+		}
+	}
+	return conflicts
+}
+
+// uniqueName returns name, or name with the smallest "-N" suffix (N >= 2) that
+// isn't in used yet, and marks the result as used. The base name is shortened
+// when needed so the suffix always fits within emojiuploader.MaxNameLength.
+func uniqueName(name string, used map[string]bool) string {
+	// An empty name is invalid anyway; suffixing it would only hide the problem
+	if name == "" || !used[name] {
+		used[name] = true
+		return name
+	}
+
+	for n := 2; ; n++ {
+		suffix := fmt.Sprintf("-%d", n)
+		base := name
+		if over := len(base) + len(suffix) - emojiuploader.MaxNameLength; over > 0 {
+			// Clip the middle rather than --suffix, unless there isn't enough of it
+			if end := len(base) - len(nameSuffix); end-over >= len(namePrefix) {
+				base = base[:end-over] + base[end:]
+			} else {
+				base = base[:emojiuploader.MaxNameLength-len(suffix)]
+			}
+		}
+		if candidate := base + suffix; !used[candidate] {
+			used[candidate] = true
+			return candidate
+		}
+	}
+}
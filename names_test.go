@@ -0,0 +1,595 @@
+package main
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/formatCvt/mattermost-emoji-uploader/emojiuploader"
+)
+
+func TestAssignNamesResolvesCollisions(t *testing.T) {
+	jobs := []emojiJob{
+		{originalName: "Party"},
+		{originalName: "party"},
+		{originalName: "party!"},
+		{originalName: "other"},
+	}
+	if renamed := assignNames(jobs, map[string]bool{}, map[string]*emojiJob{}); renamed != 2 {
+		t.Errorf("%d renamed, want 2", renamed)
+	}
+
+	want := []string{"party", "party-2", "party-3", "other"}
+	for i, job := range jobs {
+		if job.safeName != want[i] {
+			t.Errorf("[:%s:] -> %q, want %q", job.originalName, job.safeName, want[i])
+		}
+	}
+}
+
+func TestAliasesYieldToRegularEmojis(t *testing.T) {
+	used, owners := map[string]bool{}, map[string]*emojiJob{}
+	regular := []emojiJob{{originalName: "A_Party"}}
+	aliases := []emojiJob{{originalName: "a_party"}}
+	assignNames(regular, used, owners)
+	assignNames(aliases, used, owners)
+	if regular[0].safeName != "a_party" || aliases[0].safeName != "a_party-2" {
+		t.Errorf("image -> %q, alias -> %q, want the image to keep a_party", regular[0].safeName, aliases[0].safeName)
+	}
+}
+
+func TestUniqueName(t *testing.T) {
+	used := map[string]bool{}
+	for _, want := range []string{"party", "party-2", "party-3"} {
+		if got := uniqueName("party", used); got != want {
+			t.Errorf("uniqueName = %q, want %q", got, want)
+		}
+	}
+	// An empty name stays empty rather than becoming "-2"
+	uniqueName("", used)
+	if got := uniqueName("", used); got != "" {
+		t.Errorf("uniqueName(\"\") = %q", got)
+	}
+}
+
+func TestUniqueNameFitsMaxLength(t *testing.T) {
+	long := strings.Repeat("a", emojiuploader.MaxNameLength)
+	used := map[string]bool{long: true}
+	got := uniqueName(long, used)
+	if len(got) > emojiuploader.MaxNameLength || !strings.HasSuffix(got, "-2") {
+		t.Errorf("uniqueName = %q (%d characters), want a -2 suffix within %d", got, len(got), emojiuploader.MaxNameLength)
+	}
+}
+
+func TestUploadCollidingNames(t *testing.T) {
+	srv := newFakeServer(t)
+	file := sourceFile(t,
+		"Party", srv.img("1.png", pngData),
+		"party", srv.img("2.png", pngData),
+		"PARTY", srv.img("3.png", pngData),
+	)
+
+	code, _, out := runImport(t, srv, file)
+	if code != exitOK {
+		t.Fatalf("exit code %d, output:\n%s", code, out)
+	}
+	uploaded := make(map[string]bool)
+	for _, u := range srv.uploaded() {
+		uploaded[u.Name] = true
+	}
+	for _, name := range []string{"party", "party-2", "party-3"} {
+		if !uploaded[name] {
+			t.Errorf("%s wasn't uploaded, got %v", name, uploaded)
+		}
+	}
+}
+
+func TestEmojiNameAffixes(t *testing.T) {
+	namePrefix, nameSuffix = "team-", "_v2"
+	t.Cleanup(func() { namePrefix, nameSuffix = "", "" })
+
+	if got := emojiName("Party Parrot"); got != "team-party-parrot_v2" {
+		t.Errorf("emojiName = %q, want the sanitized name between the affixes", got)
+	}
+
+	// A long name is clipped in the middle so that both affixes survive
+	long := strings.Repeat("x", emojiuploader.MaxNameLength)
+	got := emojiName(long)
+	if len(got) != emojiuploader.MaxNameLength {
+		t.Errorf("emojiName has %d characters, want %d", len(got), emojiuploader.MaxNameLength)
+	}
+	if !strings.HasPrefix(got, "team-") || !strings.HasSuffix(got, "_v2") {
+		t.Errorf("emojiName = %q, want the affixes kept", got)
+	}
+
+	// Collision suffixes are clipped out of the name as well, not out of the affixes
+	used := map[string]bool{got: true}
+	if second := uniqueName(got, used); len(second) > emojiuploader.MaxNameLength || !strings.HasPrefix(second, "team-") || !strings.HasSuffix(second, "_v2-2") {
+		t.Errorf("uniqueName = %q", second)
+	}
+
+	// Names chosen explicitly get the affixes too
+	nameMap = map[string]string{"Party Parrot": "parrot"}
+	t.Cleanup(func() { nameMap = nil })
+	if got := emojiName("Party Parrot"); got != "team-parrot_v2" {
+		t.Errorf("mapped: emojiName = %q, want team-parrot_v2", got)
+	}
+}
+
+func TestValidAffix(t *testing.T) {
+	for affix, want := range map[string]bool{"": true, "team-": true, "_v2": true, "Team-": false, "a b": false, "é": false} {
+		if got := validAffix(affix); got != want {
+			t.Errorf("validAffix(%q) = %v, want %v", affix, got, want)
+		}
+	}
+}
+
+func TestInvalidAffixFlag(t *testing.T) {
+	srv := newFakeServer(t)
+	file := sourceFile(t, "party", srv.img("party.png", pngData))
+	if code, _ := runCLI(t, "-s", srv.URL, "-t", "tok", "-f", file, "--prefix", "Team "); code != exitSetup {
+		t.Errorf("exit code %d for an invalid --prefix, want %d", code, exitSetup)
+	}
+	if n := len(srv.uploaded()); n != 0 {
+		t.Errorf("%d uploads with an invalid --prefix", n)
+	}
+}
+
+func TestNameMap(t *testing.T) {
+	srv := newFakeServer(t)
+	file := sourceFile(t,
+		"Party Parrot", srv.img("1.png", pngData),
+		"жду", srv.img("2.png", pngData),
+		"thumbsup", srv.img("3.png", pngData),
+	)
+	nameMap := writeFile(t, t.TempDir(), "names.yaml", []byte("Party Parrot: parrot\nжду: waiting\n"))
+
+	code, report, out := runImport(t, srv, file, "--name-map", nameMap)
+	if code != exitOK {
+		t.Fatalf("exit code %d, output:\n%s", code, out)
+	}
+	results := byName(report)
+	for original, want := range map[string]string{"Party Parrot": "parrot", "жду": "waiting", "thumbsup": "thumbsup"} {
+		if r := results[original]; r.Action != actionUploaded || r.SanitizedName != want {
+			t.Errorf("[:%s:] %+v, want uploaded as %s", original, r, want)
+		}
+	}
+}
+
+func TestLoadNameMap(t *testing.T) {
+	dir := t.TempDir()
+	want := map[string]string{"Party Parrot": "parrot"}
+	for _, name := range []string{"names.json", "names.yml"} {
+		data := `{"Party Parrot": "parrot"}`
+		if name == "names.yml" {
+			data = "Party Parrot: parrot\n"
+		}
+		got, err := loadNameMap(writeFile(t, dir, name, []byte(data)))
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: %v, %v, want %v", name, got, err, want)
+		}
+	}
+	if _, err := loadNameMap(writeFile(t, dir, "broken.json", []byte(`{"a": 1}`))); err == nil {
+		t.Error("no error for a name map with a number")
+	}
+}
+
+func TestInvalidNameMap(t *testing.T) {
+	issues := validateNameMap(map[string]string{"a": "ok_name", "b": "Not OK", "c": "", "d": strings.Repeat("x", 65)})
+	if len(issues) != 3 {
+		t.Fatalf("issues = %q, want 3", issues)
+	}
+	for i, original := range []string{"b", "c", "d"} {
+		if !strings.HasPrefix(issues[i], "[:"+original+":]") {
+			t.Errorf("issue %d = %q, want one about %s", i, issues[i], original)
+		}
+	}
+
+	srv := newFakeServer(t)
+	file := sourceFile(t, "b", srv.img("b.png", pngData))
+	path := writeFile(t, t.TempDir(), "names.json", []byte(`{"b": "Not OK"}`))
+	if code, _, _ := runImport(t, srv, file, "--name-map", path); code != exitSetup {
+		t.Errorf("exit code %d for an invalid name map, want %d", code, exitSetup)
+	}
+	if len(srv.uploaded()) != 0 {
+		t.Error("uploaded despite an invalid name map")
+	}
+}
+
+// originalNames returns the original names of jobs in order
+func originalNames(jobs []emojiJob) []string {
+	var names []string
+	for _, job := range jobs {
+		names = append(names, job.originalName)
+	}
+	return names
+}
+
+func TestPlanJobsOrder(t *testing.T) {
+	emojis := EmojiMap{
+		"zebra":  "https://example.com/1.png",
+		"Mango":  "https://example.com/2.png",
+		"apple":  "https://example.com/3.png",
+		"Berry":  "https://example.com/4.png",
+		"banana": "alias:apple",
+		"Alias":  "alias:zebra",
+	}
+	tests := []struct {
+		sort             string
+		regular, aliases []string
+	}{
+		// Byte order of the original names puts capitals first
+		{sortOriginal, []string{"Berry", "Mango", "apple", "zebra"}, []string{"Alias", "banana"}},
+		{sortSanitized, []string{"apple", "Berry", "Mango", "zebra"}, []string{"Alias", "banana"}},
+	}
+	t.Cleanup(func() { sortOrder = sortOriginal })
+	for _, tt := range tests {
+		sortOrder = tt.sort
+		for run := 0; run < 5; run++ {
+			regular, aliases, _ := planJobs(emojis, nil)
+			if got := originalNames(regular); !reflect.DeepEqual(got, tt.regular) {
+				t.Fatalf("--sort %s: regular = %q, want %q", tt.sort, got, tt.regular)
+			}
+			if got := originalNames(aliases); !reflect.DeepEqual(got, tt.aliases) {
+				t.Fatalf("--sort %s: aliases = %q, want %q", tt.sort, got, tt.aliases)
+			}
+		}
+	}
+}
+
+func TestSortFlag(t *testing.T) {
+	for _, tt := range []struct {
+		sort string
+		want []string
+	}{
+		{sortOriginal, []string{"Mango", "apple", "zebra"}},
+		{sortSanitized, []string{"apple", "mango", "zebra"}},
+	} {
+		srv := newFakeServer(t)
+		file := sourceFile(t,
+			"zebra", srv.img("zebra.png", pngData),
+			"Mango", srv.img("mango.png", pngData),
+			"apple", srv.img("apple.png", pngData),
+		)
+		code, _, out := runImport(t, srv, file, "--sort", tt.sort)
+		if code != exitOK {
+			t.Fatalf("exit code %d, output:\n%s", code, out)
+		}
+		var got []string
+		for _, u := range srv.uploaded() {
+			got = append(got, u.Name)
+		}
+		want := make([]string, len(tt.want))
+		for i, name := range tt.want {
+			want[i] = strings.ToLower(name)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("--sort %s: uploaded %q, want %q", tt.sort, got, want)
+		}
+	}
+
+	srv := newFakeServer(t)
+	if code, _, out := runImport(t, srv, sourceFile(t, "x", srv.img("x.png", pngData)), "--sort", "random"); code != exitSetup {
+		t.Errorf("--sort random: exit code %d, want %d\n%s", code, exitSetup, out)
+	}
+}
+
+func TestEmptySanitizedName(t *testing.T) {
+	inputs := []string{"🎉🎉", "🐱‍👤", "✨💯", "!!!", "#@*%"}
+	t.Cleanup(func() { onEmpty = onEmptySkip })
+	for _, mode := range []string{onEmptySkip, onEmptyHash} {
+		onEmpty = mode
+		seen := make(map[string]string)
+		for _, in := range inputs {
+			name := sanitizedName(in)
+			if mode == onEmptySkip {
+				if name != "" {
+					t.Errorf("skip: sanitizedName(%q) = %q, want empty", in, name)
+				}
+				continue
+			}
+			if !strings.HasPrefix(name, "emoji-") || len(name) != len("emoji-")+8 {
+				t.Errorf("hash: sanitizedName(%q) = %q, want emoji-<8 hex digits>", in, name)
+			}
+			if err := emojiuploader.ValidateName(name); err != nil {
+				t.Errorf("hash: %q is not a valid name: %v", name, err)
+			}
+			if name != sanitizedName(in) {
+				t.Errorf("hash: %q isn't stable", in)
+			}
+			if prev, ok := seen[name]; ok {
+				t.Errorf("hash: %q and %q both became %q", prev, in, name)
+			}
+			seen[name] = in
+		}
+	}
+	// Names with anything left keep it
+	onEmpty = onEmptyHash
+	if got := sanitizedName("🎉party🎉"); got != "party" {
+		t.Errorf("sanitizedName(🎉party🎉) = %q, want party", got)
+	}
+}
+
+func TestOnEmptyFlag(t *testing.T) {
+	for _, mode := range []string{onEmptySkip, onEmptyHash} {
+		srv := newFakeServer(t)
+		file := sourceFile(t, "🎉🎉", srv.img("tada.png", pngData), "!!!", srv.img("bang.png", solidPNG(t, 2, 2)), "ok", srv.img("ok.png", solidPNG(t, 3, 3)))
+
+		code, _, out := runImport(t, srv, file, "--on-empty", mode)
+		if code != exitOK {
+			t.Fatalf("%s: exit code %d, output:\n%s", mode, code, out)
+		}
+		var names []string
+		for _, u := range srv.uploaded() {
+			names = append(names, u.Name)
+		}
+		switch mode {
+		case onEmptySkip:
+			if !reflect.DeepEqual(names, []string{"ok"}) {
+				t.Errorf("skip: uploaded %q, want only ok", names)
+			}
+			for _, in := range []string{"🎉🎉", "!!!"} {
+				if want := "[:" + in + ":] name is empty after sanitization"; !strings.Contains(out, want) {
+					t.Errorf("skip: output doesn't contain %q:\n%s", want, out)
+				}
+			}
+		case onEmptyHash:
+			if len(names) != 3 {
+				t.Errorf("hash: uploaded %q, want all 3", names)
+			}
+			for _, name := range names {
+				if name != "ok" && !strings.HasPrefix(name, "emoji-") {
+					t.Errorf("hash: uploaded %q, want a hashed name", name)
+				}
+			}
+		}
+	}
+}
+
+func TestReplaceFlag(t *testing.T) {
+	tests := []struct {
+		value, pattern, replacement string
+	}{
+		{"/^slack_//", "^slack_", ""},
+		{"/-+/-/", "-+", "-"},
+		{"#(\\w+)/(\\w+)#${2}_$1#", "(\\w+)/(\\w+)", "${2}_$1"},
+		// An escaped delimiter is part of the pattern
+		{`/a\/b/ab/`, "a/b", "ab"},
+	}
+	for _, tt := range tests {
+		var f replaceFlag
+		if err := f.Set(tt.value); err != nil {
+			t.Errorf("Set(%q): %v", tt.value, err)
+			continue
+		}
+		if len(f) != 1 || f[0].Pattern.String() != tt.pattern || f[0].Replacement != tt.replacement {
+			t.Errorf("Set(%q) = %v, want pattern %q and replacement %q", tt.value, f, tt.pattern, tt.replacement)
+		}
+	}
+
+	for _, value := range []string{"", "slack_", "/slack_/", "/a/b/c/", "//x/", "/[a-/x/", "/(unclosed/x/", "aslack_a_a"} {
+		var f replaceFlag
+		if err := f.Set(value); err == nil {
+			t.Errorf("Set(%q): no error", value)
+		}
+	}
+}
+
+func TestReplaceRules(t *testing.T) {
+	t.Cleanup(func() { replaceRules = nil })
+	for _, rule := range []string{"/^slack_//", "/-{2,}/-/"} {
+		if err := replaceRules.Set(rule); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := map[string]string{
+		"slack_party":      "party",
+		"Slack_Party Time": "party-time",
+		"not_slack_party":  "not_slack_party",
+		"wave -- hello":    "wave-hello",
+		"a---b":            "a-b",
+		// The rules run after transliteration and before the strip, which
+		// only then removes the "!" between the dashes
+		"a-!-b": "a--b",
+	}
+	for in, want := range tests {
+		if got := sanitizedName(in); got != want {
+			t.Errorf("sanitizedName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestReplaceFlagUpload(t *testing.T) {
+	srv := newFakeServer(t)
+	file := sourceFile(t, "slack_party", srv.img("party.png", pngData), "slack_wave", srv.img("wave.png", solidPNG(t, 2, 2)))
+
+	code, _, out := runImport(t, srv, file, "--replace", "/^slack_//", "--replace", "|wave|hello|")
+	if code != exitOK {
+		t.Fatalf("exit code %d, output:\n%s", code, out)
+	}
+	var names []string
+	for _, u := range srv.uploaded() {
+		names = append(names, u.Name)
+	}
+	if want := []string{"party", "hello"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("uploaded %q, want %q", names, want)
+	}
+}
+
+func TestInvalidReplaceFlag(t *testing.T) {
+	srv := newFakeServer(t)
+	file := sourceFile(t, "party", srv.img("party.png", pngData))
+	if code, _ := runCLI(t, "-s", srv.URL, "-t", "tok", "-f", file, "--replace", "/[a-/x/"); code != exitSetup {
+		t.Errorf("exit code %d for an invalid pattern, want %d", code, exitSetup)
+	}
+	// The pattern is rejected before the server is contacted
+	if n := srv.requestCount(""); n != 0 {
+		t.Errorf("%d requests with an invalid --replace", n)
+	}
+}
+
+func TestOnConflict(t *testing.T) {
+	tests := []struct {
+		strategy string
+		code     int
+		// uploads maps the uploaded names to the original name whose image they got
+		uploads map[string]string
+		deleted []string
+	}{
+		{conflictSkip, exitOK, map[string]string{"wave": "Wave", "new": "new"}, nil},
+		{conflictSuffix, exitOK, map[string]string{"party-2": "party", "wave": "Wave", "wave-2": "wave", "new": "new"}, nil},
+		// The later of two colliding names wins, like it does over the server
+		{conflictOverwrite, exitOK, map[string]string{"party": "party", "wave": "wave", "new": "new"}, []string{"party"}},
+		{conflictError, exitSetup, map[string]string{}, nil},
+	}
+	for _, tt := range tests {
+		srv := newFakeServer(t)
+		srv.addEmoji("party", pngData)
+		images := map[string][]byte{
+			"party": solidPNG(t, 1, 2),
+			"Wave":  solidPNG(t, 2, 2),
+			"wave":  solidPNG(t, 3, 2),
+			"new":   solidPNG(t, 4, 2),
+		}
+		var pairs []string
+		for name, data := range images {
+			pairs = append(pairs, name, srv.img(name+".png", data))
+		}
+
+		code, _, out := runImport(t, srv, sourceFile(t, pairs...), "--on-conflict", tt.strategy)
+		if code != tt.code {
+			t.Errorf("%s: exit code %d, want %d\n%s", tt.strategy, code, tt.code, out)
+		}
+		got := make(map[string]string)
+		for _, u := range srv.uploaded() {
+			for original, data := range images {
+				if reflect.DeepEqual(u.Data, data) {
+					got[u.Name] = original
+				}
+			}
+		}
+		if !reflect.DeepEqual(got, tt.uploads) {
+			t.Errorf("%s: uploaded %v, want %v\n%s", tt.strategy, got, tt.uploads, out)
+		}
+		srv.mu.Lock()
+		deleted := srv.deleted
+		srv.mu.Unlock()
+		if !reflect.DeepEqual(deleted, tt.deleted) {
+			t.Errorf("%s: deleted %q, want %q", tt.strategy, deleted, tt.deleted)
+		}
+		if tt.strategy == conflictError {
+			for _, want := range []string{"[:party:]", "[:wave:]", "Stopping because of 2 name conflicts"} {
+				if !strings.Contains(out, want) {
+					t.Errorf("error: output doesn't mention %q:\n%s", want, out)
+				}
+			}
+		}
+	}
+}
+
+func TestInvalidOnConflict(t *testing.T) {
+	for _, args := range [][]string{
+		{"--on-conflict", "rename"},
+		{"--on-conflict", conflictSkip, "--force"},
+		{"--on-conflict", conflictSuffix, "--overwrite"},
+	} {
+		args = append([]string{"-s", "http://localhost", "-t", "tok", "-f", "x.json"}, args...)
+		if code, _ := runCLI(t, args...); code != exitSetup {
+			t.Errorf("%q: exit code %d, want %d", args, code, exitSetup)
+		}
+	}
+}
+
+func TestValidateStrictNames(t *testing.T) {
+	resetOptions(t)
+	emojis := EmojiMap{
+		"party_parrot": "u1",
+		"Wave":         "u2",
+		"thumbs-up":    "u3",
+		"жду":          "u4",
+		"thumbs up":    "u5",
+		"dot.ted":      "u6",
+		"Café":         "u7",
+		"Mapped!":      "u8",
+		"named!":       "u9",
+	}
+	nameMap = map[string]string{"Mapped!": "mapped"}
+	entrySettings = map[string]emojiEntry{"named!": {Name: "named"}}
+
+	issues := validateStrictNames(emojis)
+	// Lowercasing is the only change allowed, and chosen names are exempt
+	var kept []string
+	for original := range emojis {
+		kept = append(kept, original)
+	}
+	slices.Sort(kept)
+	if want := []string{"Mapped!", "Wave", "named!", "party_parrot", "thumbs-up"}; !slices.Equal(kept, want) {
+		t.Errorf("kept %q, want %q", kept, want)
+	}
+	if len(issues) != 4 {
+		t.Fatalf("issues %q, want 4", issues)
+	}
+	if want := "[:thumbs up:] would be renamed to :thumbs-up:"; !slices.ContainsFunc(issues, func(s string) bool { return strings.HasPrefix(s, want) }) {
+		t.Errorf("issues %q, want %q", issues, want)
+	}
+}
+
+func TestValidateStrictNamesAffixes(t *testing.T) {
+	resetOptions(t)
+	namePrefix = "team-"
+	long := strings.Repeat("x", emojiuploader.MaxNameLength)
+	emojis := EmojiMap{"party": "u1", long: "u2"}
+
+	// The affixes are expected, clipping the name to fit them is not
+	if issues := validateStrictNames(emojis); len(issues) != 1 || !strings.Contains(issues[0], long) {
+		t.Errorf("issues %q, want one for the clipped name", issues)
+	}
+	if _, ok := emojis["party"]; !ok || len(emojis) != 1 {
+		t.Errorf("kept %v, want only party", emojis)
+	}
+}
+
+func TestStrictNamesFlag(t *testing.T) {
+	srv := newFakeServer(t)
+	file := sourceFile(t,
+		"Party", srv.img("party.png", pngData),
+		"жду", srv.img("zhdu.png", pngData),
+		"thumbs up", srv.img("thumbs.png", pngData),
+	)
+
+	code, _, out := runImport(t, srv, file, "--strict-names")
+	if code != exitOK {
+		t.Fatalf("exit code %d, output:\n%s", code, out)
+	}
+	if uploads := srv.uploaded(); len(uploads) != 1 || uploads[0].Name != "party" {
+		t.Errorf("uploads %v, want only party", uploads)
+	}
+	for _, issue := range []string{"[:жду:] would be renamed to :zhdu:", "[:thumbs up:] would be renamed to :thumbs-up:"} {
+		if !strings.Contains(out, issue) {
+			t.Errorf("output doesn't report %q:\n%s", issue, out)
+		}
+	}
+
+	// Without the flag the names are sanitized as usual
+	srv = newFakeServer(t)
+	file = sourceFile(t, "thumbs up", srv.img("thumbs.png", pngData))
+	if code, _, out := runImport(t, srv, file); code != exitOK || len(srv.uploaded()) != 1 {
+		t.Errorf("exit code %d without --strict-names, output:\n%s", code, out)
+	}
+}
+
+func TestStrictNamesWithStrict(t *testing.T) {
+	srv := newFakeServer(t)
+	file := sourceFile(t, "party", srv.img("party.png", pngData), "thumbs up", srv.img("thumbs.png", pngData))
+
+	if code, _, out := runImport(t, srv, file, "--strict-names", "--strict"); code != exitSetup {
+		t.Errorf("exit code %d, want %d\n%s", code, exitSetup, out)
+	}
+	if code, _, out := runImport(t, srv, file, "--strict-names", "--replace", "/a/b/"); code != exitSetup {
+		t.Errorf("with --replace: exit code %d, want %d\n%s", code, exitSetup, out)
+	}
+	if n := len(srv.uploaded()); n != 0 {
+		t.Errorf("%d uploads, want none", n)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/term"
+)
+
+// progressWidth is the number of characters used for the bar itself
+const progressWidth = 30
+
+// progressBar renders a single, continuously updated status line with the
+// number of processed emojis. Other output is printed above it.
+type progressBar struct {
+	mu     sync.Mutex
+	total  int
+	done   int
+	failed int
+}
+
+// newProgressBar returns a progress bar for total emojis, or nil if logOutput
+// is not a terminal, in which case the regular per-emoji lines are printed instead
+func newProgressBar(total int) *progressBar {
+	if f, ok := logOutput.(*os.File); !ok || !term.IsTerminal(int(f.Fd())) {
+		return nil
+	}
+	p := &progressBar{total: total}
+	p.draw()
+	return p
+}
+
+// record counts a finished emoji and redraws the bar
+func (p *progressBar) record(r Result) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.done++
+	if r.Action == actionFailed {
+		p.failed++
+	}
+	p.draw()
+}
+
+// print writes text above the bar without clobbering it
+func (p *progressBar) print(text string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	fmt.Fprint(logOutput, "\r\033[K"+text)
+	p.draw()
+}
+
+// finish draws the final state and ends the line so later output starts cleanly
+func (p *progressBar) finish() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.draw()
+	fmt.Fprintln(logOutput)
+}
+
+// draw renders the bar; the caller must hold p.mu (or own p exclusively)
+func (p *progressBar) draw() {
+	filled := progressWidth
+	if p.total > 0 {
+		filled = p.done * progressWidth / p.total
+	}
+	bar := strings.Repeat("█", filled) + strings.Repeat("░", progressWidth-filled)
+	fmt.Fprintf(logOutput, "\r\033[K%s %d/%d (%d failed)", bar, p.done, p.total, p.failed)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/formatCvt/mattermost-emoji-uploader/emojiuploader"
+)
+
+// uploadLimiter paces the requests of all workers. Until the server reports
+// its rate limit, requests are spaced out by a fixed delay; once it does, they
+// go out as fast as the reported budget allows and only pause when it runs low.
+type uploadLimiter struct {
+	mu    sync.Mutex
+	delay time.Duration
+	// rand varies every delay by up to ±50%, so that workers started together
+	// don't hit the server in lockstep
+	rand *rand.Rand
+	// reserve is the remaining budget at which requests pause until the reset,
+	// leaving room for the requests other workers already have in flight
+	reserve int
+	// adaptive is set once the server sent rate limit headers
+	adaptive bool
+	// next is the earliest time the next request may start
+	next time.Time
+}
+
+// newUploadLimiter returns a limiter using delay as long as the server doesn't
+// report a rate limit
+func newUploadLimiter(delay time.Duration, reserve int) *uploadLimiter {
+	return &uploadLimiter{
+		delay:   delay,
+		reserve: reserve,
+		rand:    rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64())),
+	}
+}
+
+// jittered returns a random duration between half and one and a half times d.
+// It must be called with mu held.
+func (l *uploadLimiter) jittered(d time.Duration) time.Duration {
+	if d <= 0 {
+		return d
+	}
+	return d/2 + time.Duration(l.rand.Int64N(int64(d)+1))
+}
+
+// wait blocks until the next request may be sent or ctx is cancelled
+func (l *uploadLimiter) wait(ctx context.Context) {
+	l.mu.Lock()
+	start := time.Now()
+	if l.next.After(start) {
+		start = l.next
+	}
+	if !l.adaptive {
+		l.next = start.Add(l.jittered(l.delay))
+	}
+	l.mu.Unlock()
+
+	timer := time.NewTimer(time.Until(start))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
+
+// update adjusts the pace to the budget reported in a response; it is used as
+// emojiuploader.Client.OnRateLimit
+func (l *uploadLimiter) update(rl emojiuploader.RateLimit) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.adaptive = true
+	if rl.Remaining > l.reserve {
+		return
+	}
+
+	pause := rl.Reset
+	if pause <= 0 {
+		pause = l.delay
+	}
+	if resume := time.Now().Add(pause); resume.After(l.next) {
+		if !l.next.After(time.Now()) {
+			logDebug("⏸️  Rate limit almost reached (%d requests left), pausing for %s\n", rl.Remaining, pause.Round(time.Millisecond))
+		}
+		l.next = resume
+	}
+}
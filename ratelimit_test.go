@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/formatCvt/mattermost-emoji-uploader/emojiuploader"
+)
+
+// seededLimiter returns an uploadLimiter whose jitter is reproducible
+func seededLimiter(delay time.Duration) *uploadLimiter {
+	l := newUploadLimiter(delay, 0)
+	l.rand = rand.New(rand.NewPCG(1, 2))
+	return l
+}
+
+func TestJitterRange(t *testing.T) {
+	const delay = 200 * time.Millisecond
+	l := seededLimiter(delay)
+	lowest, highest := time.Duration(1<<62), time.Duration(0)
+	var total time.Duration
+	const n = 10000
+	for i := 0; i < n; i++ {
+		d := l.jittered(delay)
+		if d < delay/2 || d > delay*3/2 {
+			t.Fatalf("jittered(%v) = %v, want within ±50%%", delay, d)
+		}
+		lowest, highest = min(lowest, d), max(highest, d)
+		total += d
+	}
+	// The delays are spread over the whole range, around the base delay
+	if lowest > 105*time.Millisecond || highest < 295*time.Millisecond {
+		t.Errorf("delays only range from %v to %v", lowest, highest)
+	}
+	if mean := total / n; mean < 195*time.Millisecond || mean > 205*time.Millisecond {
+		t.Errorf("mean delay %v, want about %v", mean, delay)
+	}
+
+	// The same seed gives the same delays
+	a, b := seededLimiter(delay), seededLimiter(delay)
+	for i := 0; i < 10; i++ {
+		if da, db := a.jittered(delay), b.jittered(delay); da != db {
+			t.Fatalf("delay %d: %v and %v from the same seed", i, da, db)
+		}
+	}
+	if d := l.jittered(0); d != 0 {
+		t.Errorf("jittered(0) = %v, want no delay", d)
+	}
+}
+
+func TestLimiterSpacesRequests(t *testing.T) {
+	const delay = 20 * time.Millisecond
+	l := seededLimiter(delay)
+	ctx := context.Background()
+	// Measured from the first request, as a late wakeup shortens the next gap
+	first := time.Now()
+	l.wait(ctx)
+	for i := 1; i <= 5; i++ {
+		l.wait(ctx)
+		if elapsed, min := time.Since(first), time.Duration(i)*delay/2; elapsed < min {
+			t.Errorf("request %d after %v, want at least %v", i, elapsed, min)
+		}
+	}
+}
+
+func TestLimiterFollowsRateLimit(t *testing.T) {
+	l := seededLimiter(time.Hour)
+	ctx := context.Background()
+	// Once the server reports its budget, the fixed delay no longer applies
+	l.update(emojiuploader.RateLimit{Remaining: 50, Reset: time.Minute})
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		l.wait(ctx)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("3 requests with budget left took %v", elapsed)
+	}
+
+	// With the budget used up the requests pause until the reset
+	l.update(emojiuploader.RateLimit{Remaining: 0, Reset: 50 * time.Millisecond})
+	start = time.Now()
+	l.wait(ctx)
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("request after %v, want to wait for the reset", elapsed)
+	}
+
+	// A cancelled context stops the wait
+	l.update(emojiuploader.RateLimit{Remaining: 0, Reset: time.Hour})
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	start = time.Now()
+	l.wait(cancelled)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("cancelled wait took %v", elapsed)
+	}
+}
+
+// requestTimes makes srv record when each request arrived, split into API
+// requests and image downloads
+func requestTimes(srv *fakeServer) func() (api, images []time.Time) {
+	var mu sync.Mutex
+	var api, images []time.Time
+	inner := srv.Config.Handler
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		if strings.HasPrefix(r.URL.Path, "/api/") {
+			api = append(api, time.Now())
+		} else {
+			images = append(images, time.Now())
+		}
+		mu.Unlock()
+		inner.ServeHTTP(w, r)
+	})
+	return func() ([]time.Time, []time.Time) {
+		mu.Lock()
+		defer mu.Unlock()
+		return slices.Clone(api), slices.Clone(images)
+	}
+}
+
+func TestRateFlag(t *testing.T) {
+	srv := newFakeServer(t)
+	times := requestTimes(srv)
+	var pairs []string
+	for i := 0; i < 6; i++ {
+		name := fmt.Sprintf("emoji%d", i)
+		pairs = append(pairs, name, srv.img(name+".png", solidPNG(t, i+1, 1)))
+	}
+
+	const perSecond, burst = 20, 2
+	code, _, out := runImport(t, srv, sourceFile(t, pairs...),
+		"--rate", strconv.Itoa(perSecond), "--burst", strconv.Itoa(burst),
+		"--download-concurrency", "6", "--upload-concurrency", "3")
+	if code != exitOK {
+		t.Fatalf("exit code %d, output:\n%s", code, out)
+	}
+	api, images := times()
+	if len(images) != 6 || len(api) < 6 {
+		t.Fatalf("%d API requests and %d downloads", len(api), len(images))
+	}
+	// Past the burst, API requests go out at most perSecond a second
+	span := api[len(api)-1].Sub(api[0])
+	if minimum := time.Duration(len(api)-burst) * time.Second / perSecond; span < minimum*9/10 {
+		t.Errorf("%d API requests in %v, want at least %v at %d per second", len(api), span, minimum, perSecond)
+	}
+	// Downloads aren't paced, so the six workers fetch their images together
+	if span := images[len(images)-1].Sub(images[0]); span >= time.Second/perSecond {
+		t.Errorf("downloads spread over %v, want them to ignore --rate", span)
+	}
+}
+
+func TestInvalidRateFlags(t *testing.T) {
+	for _, args := range [][]string{{"--rate", "-1"}, {"--burst", "0"}} {
+		args = append([]string{"-s", "http://localhost", "-t", "tok", "-f", "x.json"}, args...)
+		if code, _ := runCLI(t, args...); code != exitSetup {
+			t.Errorf("%q: exit code %d, want %d", args, code, exitSetup)
+		}
+	}
+}
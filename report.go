@@ -0,0 +1,362 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/formatCvt/mattermost-emoji-uploader/emojiuploader"
+)
+
+// Actions recorded for a processed emoji
+const (
+	actionUploaded = "uploaded"
+	actionAlias    = "alias"
+	actionSkipped  = "skipped"
+	actionFailed   = "failed"
+)
+
+// Reasons for skipping an emoji, used to break down the skips in the summary
+const (
+	skipExists      = "exists"
+	skipResumed     = "resumed"
+	skipAliasTarget = "alias_target_missing"
+	skipTooLarge    = "too_large"
+	skipTooSmall    = "too_small"
+	skipAspect      = "aspect_ratio"
+	skipRejected    = "rejected"
+	skipInvalidName = "invalid_name"
+	skipFormat      = "unsupported_format"
+	skipConflict    = "name_conflict"
+	skipFrames      = "too_many_frames"
+)
+
+// summaryRows are the lines of the final summary table in display order,
+// keyed by action or skip category
+var summaryRows = []struct {
+	key   string
+	label string
+}{
+	{actionUploaded, "Uploaded"},
+	{actionAlias, "Uploaded as alias"},
+	{skipExists, "Skipped, already on the server"},
+	{skipResumed, "Skipped, finished in a previous run"},
+	{skipConflict, "Skipped, name used by another emoji"},
+	{skipAliasTarget, "Skipped, alias target missing"},
+	{skipTooLarge, "Skipped, too large"},
+	{skipTooSmall, "Skipped, too small"},
+	{skipAspect, "Skipped, aspect ratio"},
+	{skipFrames, "Skipped, too many frames"},
+	{skipFormat, "Skipped, unsupported format"},
+	{skipInvalidName, "Skipped, invalid name"},
+	{skipRejected, "Skipped, rejected by the server"},
+	{actionFailed, "Failed"},
+}
+
+// Result describes what happened to a single emoji
+type Result struct {
+	OriginalName  string `json:"original_name"`
+	SanitizedName string `json:"sanitized_name"`
+	Action        string `json:"action"`
+	Reason        string `json:"reason,omitempty"`
+	SkipReason    string `json:"skip_reason,omitempty"`
+	HTTPStatus    int    `json:"http_status,omitempty"`
+	SizeBytes     int    `json:"size_bytes,omitempty"`
+	// EmojiID is the ID the server assigned to an uploaded emoji
+	EmojiID string `json:"emoji_id,omitempty"`
+	// source is the image URL, path or alias from the source file
+	source string
+	// downloadedBytes is the size of the image as fetched for this emoji; it is
+	// 0 when the image was shared with another emoji of the run
+	downloadedBytes int
+
+	// warning marks skips caused by a problem rather than a deliberate decision
+	warning bool
+}
+
+// succeeded returns the result for an uploaded emoji with optional notes
+func (r Result) succeeded(action, notes string) Result {
+	r.Action = action
+	r.Reason = notes
+	return r
+}
+
+// skipped returns the result for an emoji that was intentionally not uploaded;
+// category is one of the skip* constants
+func (r Result) skipped(category, reason string) Result {
+	r.Action = actionSkipped
+	r.SkipReason = category
+	r.Reason = reason
+	return r
+}
+
+// rejected returns the result for an emoji that was skipped because of a problem with it
+func (r Result) rejected(category, reason string) Result {
+	r = r.skipped(category, reason)
+	r.warning = true
+	return r
+}
+
+// failed returns the result for an emoji that could not be processed
+func (r Result) failed(stage string, err error) Result {
+	r.Action = actionFailed
+	r.Reason = fmt.Sprintf("%s: %v", stage, err)
+
+	var se *emojiuploader.StatusError
+	if errors.As(err, &se) {
+		r.HTTPStatus = se.StatusCode
+	}
+	return r
+}
+
+// describeID formats an emoji ID for log messages; servers that answer an
+// upload without the created emoji leave it empty
+func describeID(id string) string {
+	if id == "" {
+		return "unknown"
+	}
+	return id
+}
+
+// statusText renders the result the way it is shown at the end of a "Processing" line
+func (r Result) statusText() string {
+	switch r.Action {
+	case actionUploaded, actionAlias:
+		if r.Reason != "" {
+			return "✅ Success! (" + r.Reason + ")"
+		}
+		return "✅ Success!"
+	case actionSkipped:
+		if r.warning {
+			return "⚠️  Skipped (" + r.Reason + ")"
+		}
+		return "⏭️  Skipped (" + r.Reason + ")"
+	default:
+		return "❌ " + r.Reason
+	}
+}
+
+// stats aggregates results reported by all workers
+type stats struct {
+	mu        sync.Mutex
+	succeeded int
+	skipped   int
+	failed    int
+	// counts holds the number of results per summaryRows key
+	counts  map[string]int
+	results []Result
+
+	// Data moved during the run: images fetched from their sources and
+	// images uploaded successfully
+	downloads, uploads             int
+	downloadedBytes, uploadedBytes int64
+}
+
+func (s *stats) record(r Result) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := r.Action
+	switch r.Action {
+	case actionUploaded, actionAlias:
+		s.succeeded++
+		s.uploads++
+		s.uploadedBytes += int64(r.SizeBytes)
+	case actionSkipped:
+		s.skipped++
+		key = r.SkipReason
+	case actionFailed:
+		s.failed++
+	}
+	if s.counts == nil {
+		s.counts = make(map[string]int)
+	}
+	s.counts[key]++
+	s.results = append(s.results, r)
+	if r.downloadedBytes > 0 {
+		s.downloads++
+		s.downloadedBytes += int64(r.downloadedBytes)
+	}
+}
+
+// printSummary prints the totals of the run followed by a table of the
+// non-empty categories
+func (s *stats) printSummary(duration time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if jsonLog != nil {
+		attrs := []slog.Attr{
+			slog.Float64("duration_seconds", duration.Seconds()),
+			slog.Int("succeeded", s.succeeded),
+			slog.Int("skipped", s.skipped),
+			slog.Int("failed", s.failed),
+			slog.Int64("downloaded_bytes", s.downloadedBytes),
+			slog.Int64("uploaded_bytes", s.uploadedBytes),
+		}
+		var counts []any
+		for _, row := range summaryRows {
+			if n := s.counts[row.key]; n > 0 {
+				counts = append(counts, slog.Int(row.key, n))
+			}
+		}
+		if len(counts) > 0 {
+			attrs = append(attrs, slog.Group("counts", counts...))
+		}
+		jsonLog.LogAttrs(context.Background(), slog.LevelInfo, "done", attrs...)
+		return
+	}
+
+	logSummary("\n🏁 Done in %s: %d succeeded, %d skipped, %d failed\n", duration.Round(100*time.Millisecond), s.succeeded, s.skipped, s.failed)
+	width := 0
+	for _, row := range summaryRows {
+		width = max(width, len(row.label))
+	}
+	for _, row := range summaryRows {
+		if n := s.counts[row.key]; n > 0 {
+			logSummary("   %-*s %5d\n", width, row.label, n)
+		}
+	}
+	if s.downloads > 0 || s.uploads > 0 {
+		logSummary("📦 Downloaded %s across %d emojis, uploaded %s across %d emojis\n", formatBytes(s.downloadedBytes), s.downloads, formatBytes(s.uploadedBytes), s.uploads)
+	}
+}
+
+// Report is the machine-readable summary written by --report
+type Report struct {
+	Summary ReportSummary `json:"summary"`
+	Results []Result      `json:"results"`
+}
+
+// ReportSummary holds the totals of a run
+type ReportSummary struct {
+	Total           int     `json:"total"`
+	Uploaded        int     `json:"uploaded"`
+	Aliases         int     `json:"aliases"`
+	Skipped         int     `json:"skipped"`
+	Failed          int     `json:"failed"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	// DownloadedBytes counts every distinct image fetched once, UploadedBytes
+	// the successful uploads
+	DownloadedBytes int64 `json:"downloaded_bytes"`
+	UploadedBytes   int64 `json:"uploaded_bytes"`
+	// SkippedBy breaks down the skips by skip_reason
+	SkippedBy map[string]int `json:"skipped_by,omitempty"`
+}
+
+// summary computes the totals of the run; the caller must hold s.mu
+func (s *stats) summary(duration time.Duration) ReportSummary {
+	summary := ReportSummary{
+		Total:           len(s.results),
+		Skipped:         s.skipped,
+		Failed:          s.failed,
+		DurationSeconds: duration.Seconds(),
+		DownloadedBytes: s.downloadedBytes,
+		UploadedBytes:   s.uploadedBytes,
+	}
+	for _, r := range s.results {
+		switch r.Action {
+		case actionUploaded:
+			summary.Uploaded++
+		case actionAlias:
+			summary.Aliases++
+		case actionSkipped:
+			if summary.SkippedBy == nil {
+				summary.SkippedBy = make(map[string]int)
+			}
+			summary.SkippedBy[r.SkipReason]++
+		}
+	}
+	return summary
+}
+
+// writeJSONSummary prints the totals of the run to w as a single line of JSON
+// (--json-summary), the same object as the summary of a --report
+func writeJSONSummary(w io.Writer, s *stats, duration time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return enc.Encode(s.summary(duration))
+}
+
+// writeReport marshals the collected results into a JSON file at path
+func writeReport(path string, s *stats, duration time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	report := Report{Summary: s.summary(duration), Results: s.results}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(f)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ProblemEntry is an emoji listed in the --skipped-out file
+type ProblemEntry struct {
+	OriginalName  string `json:"original_name"`
+	SanitizedName string `json:"sanitized_name"`
+	Source        string `json:"source"`
+	Action        string `json:"action"`
+	Reason        string `json:"reason"`
+}
+
+// writeProblems writes the emojis that need attention to path: failures,
+// skips other than those already on the server or finished in a previous
+// run, and emojis renamed because of a name collision
+func writeProblems(path string, s *stats) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	problems := make([]ProblemEntry, 0)
+	for _, r := range s.results {
+		entry := ProblemEntry{
+			OriginalName:  r.OriginalName,
+			SanitizedName: r.SanitizedName,
+			Source:        r.source,
+			Action:        r.Action,
+			Reason:        r.Reason,
+		}
+		switch {
+		case r.Action == actionFailed:
+		case r.Action == actionSkipped && r.SkipReason != skipExists && r.SkipReason != skipResumed:
+		case r.SanitizedName != emojiName(r.OriginalName):
+			entry.Reason = fmt.Sprintf("renamed because :%s: is used by another emoji", emojiName(r.OriginalName))
+		default:
+			continue
+		}
+		problems = append(problems, entry)
+	}
+	sort.Slice(problems, func(i, j int) bool { return problems[i].OriginalName < problems[j].OriginalName })
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(f)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(problems); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
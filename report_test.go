@@ -0,0 +1,285 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mixedStats records one result of every kind, and two uploads
+func mixedStats() *stats {
+	s := &stats{}
+	base := Result{OriginalName: "x", SanitizedName: "x"}
+	for _, r := range []Result{
+		base.succeeded(actionUploaded, ""),
+		base.succeeded(actionUploaded, "resized"),
+		base.succeeded(actionAlias, ""),
+		base.skipped(skipExists, "already exists on the server"),
+		base.skipped(skipResumed, "finished in a previous run"),
+		base.rejected(skipTooLarge, "too large"),
+		base.rejected(skipTooLarge, "too large"),
+		base.rejected(skipAspect, "aspect ratio"),
+		base.failed("Download error", errors.New("connection refused")),
+	} {
+		s.record(r)
+	}
+	return s
+}
+
+func TestReportSummary(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.json")
+	if err := writeReport(path, mixedStats(), 2*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report Report
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatal(err)
+	}
+
+	want := ReportSummary{
+		Total:           9,
+		Uploaded:        2,
+		Aliases:         1,
+		Skipped:         5,
+		Failed:          1,
+		DurationSeconds: 2,
+		SkippedBy:       map[string]int{skipExists: 1, skipResumed: 1, skipTooLarge: 2, skipAspect: 1},
+	}
+	if !reflect.DeepEqual(report.Summary, want) {
+		t.Errorf("summary = %+v, want %+v", report.Summary, want)
+	}
+}
+
+func TestSummaryOfMixedRun(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.addEmoji("existing", pngData)
+	file := sourceFile(t,
+		"new", srv.img("new.png", pngData),
+		"existing", srv.img("existing.png", pngData),
+		"text", srv.img("text.png", []byte("plain text, not an image")),
+		"missing", srv.URL+"/img/missing.png",
+	)
+
+	code, report, out := runImport(t, srv, file, "--retries", "0")
+	if code != exitFailures {
+		t.Errorf("exit code %d, want %d\n%s", code, exitFailures, out)
+	}
+	want := map[string]int{skipExists: 1, skipFormat: 1}
+	if s := report.Summary; s.Total != 4 || s.Uploaded != 1 || s.Skipped != 2 || s.Failed != 1 || !reflect.DeepEqual(s.SkippedBy, want) {
+		t.Errorf("summary = %+v", s)
+	}
+	if !strings.Contains(out, "1 succeeded, 2 skipped, 1 failed") {
+		t.Errorf("no totals line:\n%s", out)
+	}
+	// Only the categories that occurred are listed, in the order of summaryRows
+	var rows []string
+	for _, line := range strings.Split(out, "\n") {
+		if fields := strings.Fields(line); len(fields) > 1 && strings.HasPrefix(line, "   ") {
+			rows = append(rows, strings.Join(fields, " "))
+		}
+	}
+	wantRows := []string{
+		"Uploaded 1",
+		"Skipped, already on the server 1",
+		"Skipped, unsupported format 1",
+		"Failed 1",
+	}
+	if !reflect.DeepEqual(rows, wantRows) {
+		t.Errorf("summary rows = %q, want %q", rows, wantRows)
+	}
+}
+
+func TestSkippedOut(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.addEmoji("existing", pngData)
+	file := sourceFile(t,
+		"ok", srv.img("ok.png", pngData),
+		"existing", srv.img("existing.png", pngData),
+		"Party", srv.img("party1.png", pngData),
+		"party", srv.img("party2.png", solidPNG(t, 2, 2)),
+		"text", srv.img("text.png", []byte("plain text, not an image")),
+		"missing", srv.URL+"/img/missing.png",
+	)
+	path := filepath.Join(t.TempDir(), "problems.json")
+
+	code, _, out := runImport(t, srv, file, "--skipped-out", path, "--retries", "0")
+	if code != exitFailures {
+		t.Errorf("exit code %d, want %d\n%s", code, exitFailures, out)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var problems []ProblemEntry
+	if err := json.Unmarshal(data, &problems); err != nil {
+		t.Fatalf("invalid --skipped-out file: %v", err)
+	}
+
+	// Uploads and emojis already on the server need no attention
+	var names []string
+	for _, p := range problems {
+		names = append(names, p.OriginalName)
+	}
+	if want := []string{"missing", "party", "text"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("problems for %q, want %q", names, want)
+	}
+	want := map[string]ProblemEntry{
+		"missing": {Action: actionFailed, Source: srv.URL + "/img/missing.png"},
+		"party":   {Action: actionUploaded, SanitizedName: "party-2", Reason: "renamed because :party: is used by another emoji"},
+		"text":    {Action: actionSkipped},
+	}
+	for _, p := range problems {
+		w := want[p.OriginalName]
+		if p.Action != w.Action || (w.SanitizedName != "" && p.SanitizedName != w.SanitizedName) || (w.Reason != "" && p.Reason != w.Reason) || (w.Source != "" && p.Source != w.Source) {
+			t.Errorf("%s: %+v, want %+v", p.OriginalName, p, w)
+		}
+		if p.Reason == "" {
+			t.Errorf("%s: no reason", p.OriginalName)
+		}
+	}
+}
+
+func TestByteAccounting(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.addEmoji("existing", pngData)
+	square := solidPNG(t, 10, 10)
+	text := []byte("plain text, not an image")
+	shared := srv.img("square.png", square)
+	file := sourceFile(t,
+		"a", srv.img("a.png", pngData),
+		"b", shared,
+		"b2", shared,
+		"c", "alias:a",
+		"existing", srv.img("existing.png", pngData),
+		"text", srv.img("text.txt", text),
+	)
+
+	code, report, out := runImport(t, srv, file)
+	if code != exitOK {
+		t.Fatalf("exit code %d, output:\n%s", code, out)
+	}
+	// The shared image is fetched once and the alias copies a's image; the
+	// emoji already on the server isn't fetched at all
+	downloaded := len(pngData) + len(square) + len(text)
+	uploaded := len(pngData) + 2*len(square) + len(pngData)
+	if s := report.Summary; s.DownloadedBytes != int64(downloaded) || s.UploadedBytes != int64(uploaded) {
+		t.Errorf("downloaded %d and uploaded %d bytes, want %d and %d", s.DownloadedBytes, s.UploadedBytes, downloaded, uploaded)
+	}
+	want := fmt.Sprintf("Downloaded %s across 3 emojis, uploaded %s across 4 emojis", formatBytes(int64(downloaded)), formatBytes(int64(uploaded)))
+	if !strings.Contains(out, want) {
+		t.Errorf("output doesn't contain %q:\n%s", want, out)
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KB"},
+		{1536, "1.5 KB"},
+		{42*1024*1024 + 300*1024, "42.3 MB"},
+		{3 << 30, "3.0 GB"},
+		{5 << 40, "5.0 TB"},
+		{2048 << 40, "2048.0 TB"},
+	}
+	for _, tt := range tests {
+		if got := formatBytes(tt.n); got != tt.want {
+			t.Errorf("formatBytes(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestWriteJSONSummary(t *testing.T) {
+	var out strings.Builder
+	if err := writeJSONSummary(&out, mixedStats(), 1500*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(out.String(), "\n") != 1 {
+		t.Errorf("summary %q is not a single line", out.String())
+	}
+	var got ReportSummary
+	if err := json.Unmarshal([]byte(out.String()), &got); err != nil {
+		t.Fatalf("invalid JSON %q: %v", out.String(), err)
+	}
+	if got.Total != 9 || got.Uploaded != 2 || got.Aliases != 1 || got.Skipped != 5 || got.Failed != 1 || got.DurationSeconds != 1.5 {
+		t.Errorf("summary = %+v", got)
+	}
+	if got.SkippedBy[skipTooLarge] != 2 {
+		t.Errorf("skipped_by = %v, want 2 %s", got.SkippedBy, skipTooLarge)
+	}
+}
+
+func TestJSONSummaryFlag(t *testing.T) {
+	fastRetries(t)
+	srv := newFakeServer(t)
+	srv.addEmoji("existing", pngData)
+	file := sourceFile(t,
+		"party", srv.img("party.png", pngData),
+		"existing", srv.img("existing.png", pngData),
+		"gone", srv.URL+"/img/gone.png",
+	)
+
+	// All human output goes to stderr in this mode
+	stderr, err := os.Create(filepath.Join(t.TempDir(), "stderr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stderr.Close()
+	oldStderr := os.Stderr
+	os.Stderr = stderr
+	defer func() { os.Stderr = oldStderr }()
+
+	var code int
+	stdout := captureStdout(t, func() {
+		code, _, _ = runImport(t, srv, file, "--json-summary", "-v")
+	})
+	if code != exitFailures {
+		t.Errorf("exit code %d, want %d", code, exitFailures)
+	}
+
+	dec := json.NewDecoder(strings.NewReader(stdout))
+	var got ReportSummary
+	if err := dec.Decode(&got); err != nil {
+		t.Fatalf("stdout %q is not JSON: %v", stdout, err)
+	}
+	if dec.More() {
+		t.Errorf("stdout holds more than the summary: %q", stdout)
+	}
+	if got.Total != 3 || got.Uploaded != 1 || got.Skipped != 1 || got.Failed != 1 || got.SkippedBy[skipExists] != 1 {
+		t.Errorf("summary = %+v", got)
+	}
+
+	logged, err := os.ReadFile(stderr.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(logged), "party") {
+		t.Errorf("stderr %q doesn't have the progress messages", logged)
+	}
+}
+
+func TestJSONSummaryWithOtherModes(t *testing.T) {
+	srv := newFakeServer(t)
+	file := sourceFile(t, "party", srv.img("party.png", pngData))
+	for _, mode := range []string{"--dry-run", "--list"} {
+		if code, _, out := runImport(t, srv, file, "--json-summary", mode); code != exitSetup {
+			t.Errorf("%s: exit code %d, want %d\n%s", mode, code, exitSetup, out)
+		}
+	}
+	if n := len(srv.uploaded()); n != 0 {
+		t.Errorf("%d uploads, want none", n)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/formatCvt/mattermost-emoji-uploader/emojiuploader"
+)
+
+// retryBaseDelay is the backoff before the first retry; it doubles on every
+// attempt. It is a variable so tests don't have to wait.
+var retryBaseDelay = 500 * time.Millisecond
+
+// withRetry calls fn up to attempts times, sleeping with exponential backoff
+// between calls. Only retryable errors (see isRetryable) trigger another attempt,
+// and none is made once ctx is done.
+func withRetry(ctx context.Context, attempts int, fn func() error) error {
+	delay := retryBaseDelay
+	var err error
+	for i := 0; i < attempts; i++ {
+		if err = fn(); err == nil || !isRetryable(err) || ctx.Err() != nil {
+			return err
+		}
+		if i == attempts-1 {
+			break
+		}
+
+		wait := delay
+		var se *emojiuploader.StatusError
+		if errors.As(err, &se) && se.RetryAfter > 0 {
+			wait = se.RetryAfter
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return err
+		}
+		delay *= 2
+	}
+	return err
+}
+
+// isRetryable reports whether err is a transient failure worth retrying:
+// connection errors, HTTP 429 and 5xx. Other statuses (400 duplicate, 404, ...) are final.
+func isRetryable(err error) bool {
+	// Cancellation is deliberate and must never be retried
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	// A redirect chain won't get shorter on the next attempt
+	if errors.Is(err, errTooManyRedirects) {
+		return false
+	}
+
+	var se *emojiuploader.StatusError
+	if errors.As(err, &se) {
+		return se.StatusCode == http.StatusTooManyRequests || se.StatusCode >= 500
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	return errors.Is(err, io.ErrUnexpectedEOF)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/formatCvt/mattermost-emoji-uploader/emojiuploader"
+)
+
+// flakyServer fails the first failures requests with status and then answers
+// 200. It returns the server and a counter of the requests it received.
+func flakyServer(t *testing.T, failures, status int, header http.Header) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	calls := new(atomic.Int32)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if int(calls.Add(1)) <= failures {
+			for k, v := range header {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(status)
+			fmt.Fprint(w, `{"id":"x","message":"failed"}`)
+			return
+		}
+		fmt.Fprint(w, "ok")
+	}))
+	t.Cleanup(srv.Close)
+	return srv, calls
+}
+
+// get fetches url and turns a non-200 answer into a StatusError, like the client does
+func get(ctx context.Context, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return emojiuploader.NewStatusError(resp, string(body))
+	}
+	return nil
+}
+
+// fastRetries shortens the backoff for the rest of the test
+func fastRetries(t *testing.T) {
+	old := retryBaseDelay
+	retryBaseDelay = time.Millisecond
+	t.Cleanup(func() { retryBaseDelay = old })
+}
+
+func TestWithRetry(t *testing.T) {
+	fastRetries(t)
+	tests := []struct {
+		name      string
+		failures  int
+		status    int
+		attempts  int
+		wantCalls int
+		wantErr   bool
+	}{
+		{"success", 0, 0, 3, 1, false},
+		{"5xx then success", 2, http.StatusBadGateway, 3, 3, false},
+		{"429 then success", 1, http.StatusTooManyRequests, 3, 2, false},
+		{"4xx is final", 1, http.StatusBadRequest, 3, 1, true},
+		{"404 is final", 1, http.StatusNotFound, 3, 1, true},
+		{"attempts exhausted", 5, http.StatusServiceUnavailable, 3, 3, true},
+		{"single attempt", 1, http.StatusInternalServerError, 1, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, calls := flakyServer(t, tt.failures, tt.status, nil)
+			ctx := context.Background()
+			err := withRetry(ctx, tt.attempts, func() error { return get(ctx, srv.URL) })
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want error: %v", err, tt.wantErr)
+			}
+			if int(calls.Load()) != tt.wantCalls {
+				t.Errorf("%d requests, want %d", calls.Load(), tt.wantCalls)
+			}
+			if tt.wantErr && !emojiuploader.HasStatus(err, tt.status) {
+				t.Errorf("err = %v, want the last HTTP %d", err, tt.status)
+			}
+		})
+	}
+}
+
+func TestWithRetryHonorsRetryAfter(t *testing.T) {
+	fastRetries(t)
+	srv, calls := flakyServer(t, 1, http.StatusTooManyRequests, http.Header{"Retry-After": {"1"}})
+
+	ctx := context.Background()
+	start := time.Now()
+	if err := withRetry(ctx, 2, func() error { return get(ctx, srv.URL) }); err != nil {
+		t.Fatal(err)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("%d requests, want 2", n)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %v, want to wait the 1s of Retry-After", elapsed)
+	}
+}
+
+func TestWithRetryStopsOnCancel(t *testing.T) {
+	fastRetries(t)
+	srv, calls := flakyServer(t, 5, http.StatusServiceUnavailable, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err := withRetry(ctx, 5, func() error {
+		err := get(ctx, srv.URL)
+		cancel()
+		return err
+	})
+	if err == nil {
+		t.Fatal("no error after cancelling")
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("%d requests, want no retry once cancelled", n)
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"500", &emojiuploader.StatusError{StatusCode: 500}, true},
+		{"503", &emojiuploader.StatusError{StatusCode: 503}, true},
+		{"429", &emojiuploader.StatusError{StatusCode: 429}, true},
+		{"400", &emojiuploader.StatusError{StatusCode: 400}, false},
+		{"404", &emojiuploader.StatusError{StatusCode: 404}, false},
+		{"413", &emojiuploader.StatusError{StatusCode: 413}, false},
+		{"wrapped 502", fmt.Errorf("upload: %w", &emojiuploader.StatusError{StatusCode: 502}), true},
+		{"unexpected EOF", io.ErrUnexpectedEOF, true},
+		{"other", errors.New("invalid image"), false},
+		{"canceled", context.Canceled, false},
+		{"too many redirects", errTooManyRedirects, false},
+	}
+	for _, tt := range tests {
+		if got := isRetryable(tt.err); got != tt.want {
+			t.Errorf("isRetryable(%s) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestIsRetryableConnectionError(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	url := srv.URL
+	srv.Close()
+
+	err := get(context.Background(), url)
+	if err == nil {
+		t.Fatal("request to a closed server succeeded")
+	}
+	if !isRetryable(err) {
+		t.Errorf("isRetryable(%v) = false, want connection errors retried", err)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// slackExport is the envelope of Slack's emoji.list and admin.emoji.list
+// responses. Plain exports without the envelope are handled as well.
+type slackExport struct {
+	OK    *bool           `json:"ok"`
+	Error string          `json:"error"`
+	Emoji json.RawMessage `json:"emoji"`
+}
+
+// slackEmoji is a single emoji with metadata, as returned by the admin APIs
+type slackEmoji struct {
+	Name     string `json:"name"`
+	URL      string `json:"url"`
+	IsAlias  int    `json:"is_alias"`
+	AliasFor string `json:"alias_for"`
+	// DateCreated is a Unix timestamp in seconds
+	DateCreated int64 `json:"date_created"`
+}
+
+// value returns the emoji's URL, or an alias: reference for aliases
+func (e slackEmoji) value() string {
+	if e.AliasFor != "" {
+		return "alias:" + e.AliasFor
+	}
+	return e.URL
+}
+
+// parseSlackEmojis extracts the name to URL mapping from a Slack emoji export.
+// The emojis can be nested under an "emoji" key or be at the top level, and
+// each one can be a URL, an "alias:name" reference, an object with "url" and
+// "alias_for" fields, or an element of a list of such objects.
+func parseSlackEmojis(data []byte) (EmojiMap, error) {
+	emojis, _, err := parseSlackExport(data)
+	return emojis, err
+}
+
+// slackCreatedTimes returns when each emoji of a Slack export was created.
+// Emojis without a date_created field, such as those of emoji.list, are missing.
+func slackCreatedTimes(data []byte) (map[string]time.Time, error) {
+	_, created, err := parseSlackExport(data)
+	return created, err
+}
+
+// parseSlackExport decodes a Slack export into its emojis and their creation times
+func parseSlackExport(data []byte) (EmojiMap, map[string]time.Time, error) {
+	// A flat export may contain an emoji called "emoji", so only unwrap objects and lists
+	var export slackExport
+	if err := json.Unmarshal(data, &export); err == nil && isJSONContainer(export.Emoji) {
+		if export.OK != nil && !*export.OK {
+			return nil, nil, fmt.Errorf("parsing Slack export: response is not ok: %s", export.Error)
+		}
+		data = export.Emoji
+	}
+
+	emojis, created, err := parseSlackEmojiList(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing Slack export: %w", err)
+	}
+	return emojis, created, nil
+}
+
+// isJSONContainer reports whether raw holds a JSON object or array
+func isJSONContainer(raw json.RawMessage) bool {
+	raw = bytes.TrimSpace(raw)
+	return len(raw) > 0 && (raw[0] == '{' || raw[0] == '[')
+}
+
+// parseSlackEmojiList decodes the emojis themselves, either as an object keyed
+// by name or as a list of emoji objects
+func parseSlackEmojiList(data []byte) (EmojiMap, map[string]time.Time, error) {
+	emojis := make(EmojiMap)
+	created := make(map[string]time.Time)
+	add := func(name string, e slackEmoji) {
+		emojis[name] = e.value()
+		if e.DateCreated > 0 {
+			created[name] = time.Unix(e.DateCreated, 0)
+		}
+	}
+
+	if data = bytes.TrimSpace(data); len(data) > 0 && data[0] == '[' {
+		var list []slackEmoji
+		if err := json.Unmarshal(data, &list); err != nil {
+			return nil, nil, err
+		}
+		for _, e := range list {
+			add(e.Name, e)
+		}
+		return emojis, created, nil
+	}
+
+	var entries map[string]json.RawMessage
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, nil, err
+	}
+	for name, raw := range entries {
+		var url string
+		if err := json.Unmarshal(raw, &url); err == nil {
+			emojis[name] = url
+			continue
+		}
+		var e slackEmoji
+		if err := json.Unmarshal(raw, &e); err != nil {
+			return nil, nil, fmt.Errorf("emoji %q: expected a URL or an emoji object", name)
+		}
+		add(name, e)
+	}
+	return emojis, created, nil
+}
+
+// filterSince drops the emojis created before since. Emojis without a known
+// creation time are kept, unless keepUnknown is false. It returns the kept
+// emojis and the number dropped.
+func filterSince(emojis EmojiMap, created map[string]time.Time, since time.Time, keepUnknown bool) (EmojiMap, int) {
+	kept := make(EmojiMap, len(emojis))
+	for name, source := range emojis {
+		t, ok := created[name]
+		if ok && t.Before(since) || !ok && !keepUnknown {
+			logDebug("🔎 [:%s:] skipped by -since (created %s)\n", name, describeCreated(t, ok))
+			continue
+		}
+		kept[name] = source
+	}
+	return kept, len(emojis) - len(kept)
+}
+
+// describeCreated renders a creation time for log messages
+func describeCreated(t time.Time, known bool) string {
+	if !known {
+		return "unknown"
+	}
+	return t.Format(time.RFC3339)
+}
+
+// isSlackURL reports whether source is an https URL on slack.com or one of
+// its subdomains, such as files.slack.com, the only hosts --slack-token is
+// sent to. Look-alikes such as slack.com.example.org and other CDNs don't
+// match, so the token can't leak to them.
+func isSlackURL(source string) bool {
+	u, err := url.Parse(source)
+	if err != nil || !strings.EqualFold(u.Scheme, "https") || u.User != nil {
+		return false
+	}
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	return host == "slack.com" || strings.HasSuffix(host, ".slack.com")
+}
+
+// imageHeader returns the headers for downloading source: --image-header and
+// --image-basic-auth, plus the --slack-token as a bearer token for images
+// hosted by Slack. Go's client drops the Authorization header on redirects
+// to other domains, so it stays with Slack even if the image is served elsewhere.
+func (imp *importer) imageHeader(source string) http.Header {
+	if imp.slackToken == "" || !isSlackURL(source) {
+		return imp.header
+	}
+	header := imp.header.Clone()
+	header.Set("Authorization", "Bearer "+imp.slackToken)
+	return header
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/formatCvt/mattermost-emoji-uploader/emojiuploader"
+)
+
+// headerFlag collects repeated "Key: Value" flags into an http.Header
+type headerFlag http.Header
+
+func (f headerFlag) String() string {
+	return ""
+}
+
+func (f headerFlag) Set(value string) error {
+	key, val, ok := strings.Cut(value, ":")
+	key = strings.TrimSpace(key)
+	if !ok || key == "" || strings.ContainsAny(key, " \t") {
+		return fmt.Errorf("expected \"Key: Value\", got %q", value)
+	}
+	http.Header(f).Add(key, strings.TrimSpace(val))
+	return nil
+}
+
+// loadImage fetches an emoji image from an http(s) URL, a file:// URL, a
+// data: URI, an entry of the --zip archive or a local path. Relative paths
+// are resolved against baseDir, the directory of the source file. header is
+// only sent with http(s) downloads, never to Mattermost.
+func loadImage(ctx context.Context, client *http.Client, source, baseDir string, header http.Header) ([]byte, string, error) {
+	if isDataURI(source) {
+		return decodeDataURI(source)
+	}
+	if entry, ok := strings.CutPrefix(source, zipPrefix); ok {
+		return readZipImage(entry)
+	}
+
+	u, err := url.Parse(source)
+	if err != nil || len(u.Scheme) <= 1 {
+		// Not a URL (or a Windows drive letter such as C:\), so it must be a local path
+		return readLocalImage(source, baseDir)
+	}
+
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+		return emojiuploader.DownloadWithHeader(ctx, client, source, header)
+	case "file":
+		path := u.Path
+		if u.Host != "" && u.Host != "localhost" {
+			// file://images/smile.png is treated as the relative path images/smile.png
+			path = u.Host + u.Path
+		}
+		return readLocalImage(filepath.FromSlash(path), baseDir)
+	default:
+		return nil, "", fmt.Errorf("unsupported URL scheme %q", u.Scheme)
+	}
+}
+
+// readLocalImage reads an image from disk and detects its type from the contents
+func readLocalImage(path, baseDir string) ([]byte, string, error) {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(baseDir, path)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, emojiuploader.DetectContentType(data, ""), nil
+}
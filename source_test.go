@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestLoadLocalImage(t *testing.T) {
+	dir := t.TempDir()
+	path := writeFile(t, dir, "images/party.png", pngData)
+
+	tests := []struct {
+		name, source string
+	}{
+		{"absolute path", path},
+		{"relative path", "images/party.png"},
+		{"file URL", "file://" + filepath.ToSlash(path)},
+		{"file URL with localhost", "file://localhost" + filepath.ToSlash(path)},
+		{"relative file URL", "file://images/party.png"},
+	}
+	for _, tt := range tests {
+		data, contentType, err := loadImage(context.Background(), http.DefaultClient, tt.source, dir, nil)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if string(data) != string(pngData) || contentType != "image/png" {
+			t.Errorf("%s: got %d bytes of %s, want the PNG", tt.name, len(data), contentType)
+		}
+	}
+}
+
+func TestLoadMissingLocalImage(t *testing.T) {
+	dir := t.TempDir()
+	for _, source := range []string{"missing.png", "file://" + filepath.ToSlash(filepath.Join(dir, "missing.png"))} {
+		if _, _, err := loadImage(context.Background(), http.DefaultClient, source, dir, nil); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("%s: err = %v, want a missing file", source, err)
+		}
+		if problem := validateEntry("missing", source, "", dir); !strings.Contains(problem, "file not found") {
+			t.Errorf("%s: validation problem = %q, want file not found", source, problem)
+		}
+	}
+	if problem := validateEntry("dir", dir, "", dir); !strings.Contains(problem, "is a directory") {
+		t.Errorf("directory: validation problem = %q", problem)
+	}
+	if _, _, err := loadImage(context.Background(), http.DefaultClient, "ftp://example.com/party.png", dir, nil); err == nil {
+		t.Error("no error for an ftp:// URL")
+	}
+}
+
+func TestUploadLocalFiles(t *testing.T) {
+	srv := newFakeServer(t)
+	dir := t.TempDir()
+	abs := writeFile(t, dir, "abs.png", pngData)
+	writeFile(t, dir, "images/rel.png", pngData)
+	// Relative paths are resolved against the directory of the source file
+	file := writeFile(t, dir, "emoji.json", []byte(`{
+		"relative": "images/rel.png",
+		"absolute": "`+filepath.ToSlash(abs)+`",
+		"fileurl": "file://`+filepath.ToSlash(abs)+`",
+		"missing": "images/missing.png"
+	}`))
+
+	code, _, out := runImport(t, srv, file)
+	if code != exitOK {
+		t.Fatalf("exit code %d, output:\n%s", code, out)
+	}
+	var names []string
+	for _, u := range srv.uploaded() {
+		names = append(names, u.Name)
+	}
+	slices.Sort(names)
+	if want := []string{"absolute", "fileurl", "relative"}; !slices.Equal(names, want) {
+		t.Errorf("uploaded %q, want %q", names, want)
+	}
+	if !strings.Contains(out, "[:missing:] file not found") {
+		t.Errorf("the missing file isn't reported:\n%s", out)
+	}
+}
+
+func TestImageHeaders(t *testing.T) {
+	srv := newFakeServer(t)
+	var download http.Header
+	srv.handle("/img/private.png", func(w http.ResponseWriter, r *http.Request) {
+		download = r.Header.Clone()
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(pngData)
+	})
+	file := sourceFile(t, "private", srv.URL+"/img/private.png")
+
+	code, _, out := runImport(t, srv, file,
+		"--image-header", "X-Image-Token: secret",
+		"--image-header", "X-Team: design",
+		"--image-basic-auth", "alice:pa:ss")
+	if code != exitOK {
+		t.Fatalf("exit code %d, output:\n%s", code, out)
+	}
+	if download == nil {
+		t.Fatal("the image was never downloaded")
+	}
+	if v := download.Get("X-Image-Token"); v != "secret" {
+		t.Errorf("download X-Image-Token = %q, want secret", v)
+	}
+	if v := download.Get("X-Team"); v != "design" {
+		t.Errorf("download X-Team = %q, want design", v)
+	}
+	if user, pass, ok := (&http.Request{Header: download}).BasicAuth(); !ok || user != "alice" || pass != "pa:ss" {
+		t.Errorf("download basic auth = %q, %q, %v, want alice and pa:ss", user, pass, ok)
+	}
+
+	// Mattermost gets its own token and none of the image credentials
+	uploads := srv.uploaded()
+	if len(uploads) != 1 {
+		t.Fatalf("%d uploads, want 1", len(uploads))
+	}
+	upload := uploads[0].Header
+	if v := upload.Get("Authorization"); v != "Bearer tok" {
+		t.Errorf("upload Authorization = %q, want the API token", v)
+	}
+	for _, key := range []string{"X-Image-Token", "X-Team"} {
+		if v := upload.Get(key); v != "" {
+			t.Errorf("upload %s = %q, want it only on the download", key, v)
+		}
+	}
+}
+
+func TestInvalidImageHeaderFlags(t *testing.T) {
+	for _, args := range [][]string{
+		{"--image-header", "no colon"},
+		{"--image-header", ": empty key"},
+		{"--image-basic-auth", "nopassword"},
+		{"--image-basic-auth", "a:b", "--image-header", "Authorization: Bearer x"},
+	} {
+		srv := newFakeServer(t)
+		code, _, out := runImport(t, srv, sourceFile(t, "x", srv.img("x.png", pngData)), args...)
+		if code != exitSetup {
+			t.Errorf("%q: exit code %d, want %d\n%s", args, code, exitSetup, out)
+		}
+	}
+}
+
+func TestUploadGzipEncodedImage(t *testing.T) {
+	srv := newFakeServer(t)
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write(pngData)
+	zw.Close()
+	srv.handle("/img/party.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(gz.Bytes())
+	})
+	file := sourceFile(t, "party", srv.URL+"/img/party.png")
+
+	// The custom Accept-Encoding keeps Go's transport from decompressing the body
+	code, _, out := runImport(t, srv, file, "--image-header", "Accept-Encoding: gzip")
+	if code != exitOK {
+		t.Fatalf("exit code %d, output:\n%s", code, out)
+	}
+	if uploads := srv.uploaded(); len(uploads) != 1 || !bytes.Equal(uploads[0].Data, pngData) {
+		t.Errorf("uploads = %+v, want the decompressed PNG", uploads)
+	}
+}
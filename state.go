@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"strings"
+	"sync"
+)
+
+// stateFile records the sanitized names of finished emojis, one per line, so an
+// interrupted import can be resumed. The file is only ever appended to, so a
+// crash can at worst leave an unterminated last line, which is ignored on load.
+type stateFile struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// loadState reads the names recorded in a state file. A missing file is not an error.
+func loadState(path string) (map[string]bool, error) {
+	done := make(map[string]bool)
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return done, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	lines := strings.Split(string(data), "\n")
+	// The last element is either empty or a partially written line
+	for _, line := range lines[:len(lines)-1] {
+		if line = strings.TrimSpace(line); line != "" {
+			done[line] = true
+		}
+	}
+	return done, nil
+}
+
+// openState opens a state file for appending, creating it if necessary
+func openState(path string) (*stateFile, error) {
+	// Drop a partially written last line; loadState ignores it as well
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		if err := os.Truncate(path, int64(bytes.LastIndexByte(data, '\n')+1)); err != nil {
+			return nil, err
+		}
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &stateFile{file: f}, nil
+}
+
+// record appends a finished emoji name with a single write
+func (s *stateFile) record(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.file.WriteString(name + "\n")
+	return err
+}
+
+func (s *stateFile) Close() error {
+	return s.file.Close()
+}
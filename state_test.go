@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadState(t *testing.T) {
+	dir := t.TempDir()
+	done, err := loadState(filepath.Join(dir, "missing.state"))
+	if err != nil || len(done) != 0 {
+		t.Errorf("missing file: %v, %v, want nothing done", done, err)
+	}
+
+	// A crash can leave the last line unterminated
+	path := writeFile(t, dir, "run.state", []byte("alpha\n  beta \n\ngam"))
+	done, err = loadState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]bool{"alpha": true, "beta": true}; !reflect.DeepEqual(done, want) {
+		t.Errorf("done = %v, want %v", done, want)
+	}
+}
+
+func TestOpenStateDropsPartialLine(t *testing.T) {
+	path := writeFile(t, t.TempDir(), "run.state", []byte("alpha\ngam"))
+	s, err := openState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.record("gamma"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "alpha\ngamma\n" {
+		t.Errorf("state file = %q", data)
+	}
+}
+
+func TestResumeFromState(t *testing.T) {
+	srv := newFakeServer(t)
+	file := sourceFile(t,
+		"alpha", srv.img("alpha.png", pngData),
+		"beta", srv.img("beta.png", pngData),
+		"gamma", srv.img("gamma.png", pngData),
+	)
+	// A previous run crashed while writing gamma, after finishing alpha
+	path := writeFile(t, t.TempDir(), "run.state", []byte("alpha\ngam"))
+
+	code, report, out := runImport(t, srv, file, "--state", path)
+	if code != exitOK {
+		t.Fatalf("exit code %d, output:\n%s", code, out)
+	}
+	results := byName(report)
+	if r := results["alpha"]; r.Action != actionSkipped || r.Reason != "finished in a previous run" {
+		t.Errorf("alpha: %+v, want skipped as finished", r)
+	}
+	for _, name := range []string{"beta", "gamma"} {
+		if r := results[name]; r.Action != actionUploaded {
+			t.Errorf("%s: %+v, want uploaded", name, r)
+		}
+	}
+	if n := srv.requestCount("GET /img/alpha.png"); n != 0 {
+		t.Errorf("alpha was downloaded %d times, want 0", n)
+	}
+
+	done, err := loadState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]bool{"alpha": true, "beta": true, "gamma": true}; !reflect.DeepEqual(done, want) {
+		t.Errorf("state after the run = %v, want %v", done, want)
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"runtime/debug"
+)
+
+// version is the tool version, set when building a release with
+// -ldflags "-X main.version=v1.2.3"
+var version = ""
+
+// toolVersion returns version, or the module version recorded by
+// "go install ...@version" when it isn't set
+func toolVersion() string {
+	if version != "" {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	return "dev"
+}
+
+// defaultUserAgent identifies the tool to Mattermost and image hosts, some of
+// which block the default Go user agent
+func defaultUserAgent() string {
+	return "mattermost-emoji-uploader/" + toolVersion() + " (+https://github.com/formatCvt/mattermost-emoji-uploader)"
+}
+
+// userAgentTransport sets the User-Agent of every request that doesn't have
+// one yet, so a User-Agent given with --image-header still wins for downloads
+type userAgentTransport struct {
+	base  http.RoundTripper
+	agent string
+}
+
+func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("User-Agent") != "" {
+		return t.base.RoundTrip(req)
+	}
+	// RoundTrippers must not modify the request they are given
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", t.agent)
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestDefaultUserAgent(t *testing.T) {
+	ua := defaultUserAgent()
+	if !strings.HasPrefix(ua, "mattermost-emoji-uploader/"+toolVersion()+" ") {
+		t.Errorf("defaultUserAgent() = %q, want the tool name and version", ua)
+	}
+
+	old := version
+	version = "v1.2.3"
+	t.Cleanup(func() { version = old })
+	if ua := defaultUserAgent(); !strings.HasPrefix(ua, "mattermost-emoji-uploader/v1.2.3 ") {
+		t.Errorf("defaultUserAgent() = %q with version v1.2.3", ua)
+	}
+}
+
+func TestUserAgentTransport(t *testing.T) {
+	var got []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.UserAgent())
+	}))
+	defer srv.Close()
+	client := &http.Client{Transport: &userAgentTransport{base: http.DefaultTransport, agent: "tool/1"}}
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	own, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	own.Header.Set("User-Agent", "mine/2")
+	for _, r := range []*http.Request{req, own} {
+		resp, err := client.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	if len(got) != 2 || got[0] != "tool/1" || got[1] != "mine/2" {
+		t.Errorf("User-Agents = %q, want the default and then the request's own", got)
+	}
+	if req.Header.Get("User-Agent") != "" {
+		t.Error("the transport modified the request it was given")
+	}
+}
+
+// recordUserAgents makes srv remember the User-Agent of every request by path
+func recordUserAgents(srv *fakeServer) func() map[string]string {
+	var mu sync.Mutex
+	agents := make(map[string]string)
+	inner := srv.Config.Handler
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		agents[r.Method+" "+r.URL.Path] = r.UserAgent()
+		mu.Unlock()
+		inner.ServeHTTP(w, r)
+	})
+	return func() map[string]string {
+		mu.Lock()
+		defer mu.Unlock()
+		return agents
+	}
+}
+
+func TestUserAgentFlag(t *testing.T) {
+	tests := []struct {
+		name      string
+		args      []string
+		api, imgs string
+	}{
+		{"default", nil, defaultUserAgent(), defaultUserAgent()},
+		{"custom", []string{"--user-agent", "emoji-sync/7 (ops@example.com)"}, "emoji-sync/7 (ops@example.com)", "emoji-sync/7 (ops@example.com)"},
+		// A User-Agent for the image hosts only leaves the API requests alone
+		{"image header", []string{"--image-header", "User-Agent: Mozilla/5.0"}, defaultUserAgent(), "Mozilla/5.0"},
+	}
+	for _, tt := range tests {
+		srv := newFakeServer(t)
+		agents := recordUserAgents(srv)
+		file := sourceFile(t, "party", srv.img("party.png", pngData))
+
+		code, _, out := runImport(t, srv, file, tt.args...)
+		if code != exitOK {
+			t.Fatalf("%s: exit code %d, output:\n%s", tt.name, code, out)
+		}
+		for req, ua := range agents() {
+			want := tt.api
+			if strings.HasPrefix(req, "GET /img/") {
+				want = tt.imgs
+			}
+			if ua != want {
+				t.Errorf("%s: %s sent User-Agent %q, want %q", tt.name, req, ua, want)
+			}
+		}
+		if ua := agents()["POST /api/v4/emoji"]; ua != tt.api {
+			t.Errorf("%s: upload sent User-Agent %q, want %q", tt.name, ua, tt.api)
+		}
+		if ua := agents()["GET /img/party.png"]; ua != tt.imgs {
+			t.Errorf("%s: download sent User-Agent %q, want %q", tt.name, ua, tt.imgs)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// validateEmojis checks every entry of the source file before any network work
+// is done. settings are those of object entries, see parseEmojiMap. It
+// returns the valid entries and a description of each problem found.
+func validateEmojis(emojis EmojiMap, settings map[string]emojiEntry, baseDir string) (EmojiMap, []string) {
+	originals := make([]string, 0, len(emojis))
+	for originalName := range emojis {
+		originals = append(originals, originalName)
+	}
+	sort.Strings(originals)
+
+	valid := make(EmojiMap, len(emojis))
+	var issues []string
+	for _, originalName := range originals {
+		if problem := validateEntry(originalName, emojis[originalName], settings[originalName].Name, baseDir); problem != "" {
+			issues = append(issues, fmt.Sprintf("[:%s:] %s", originalName, problem))
+			continue
+		}
+		valid[originalName] = emojis[originalName]
+	}
+	return valid, issues
+}
+
+// reusedURLs groups the entries by image source and describes every source
+// shared by more than max emojis. Many names pointing at one placeholder image
+// usually mean the file was generated incorrectly. Aliases are not counted.
+func reusedURLs(emojis EmojiMap, max int) []string {
+	bySource := make(map[string][]string)
+	for originalName, source := range emojis {
+		source = strings.TrimSpace(source)
+		if strings.HasPrefix(source, "alias:") {
+			continue
+		}
+		bySource[source] = append(bySource[source], originalName)
+	}
+
+	var sources []string
+	for source, names := range bySource {
+		if len(names) > max {
+			sources = append(sources, source)
+		}
+	}
+	// Most reused first, so the likely culprit heads the list
+	sort.Slice(sources, func(i, j int) bool {
+		if ni, nj := len(bySource[sources[i]]), len(bySource[sources[j]]); ni != nj {
+			return ni > nj
+		}
+		return sources[i] < sources[j]
+	})
+
+	const shown = 5
+	issues := make([]string, 0, len(sources))
+	for _, source := range sources {
+		names := bySource[source]
+		sort.Strings(names)
+		list := strings.Join(names[:min(len(names), shown)], ", ")
+		if len(names) > shown {
+			list += fmt.Sprintf(" and %d more", len(names)-shown)
+		}
+		issues = append(issues, fmt.Sprintf("%s is used by %d emojis: %s", describeSource(source), len(names), list))
+	}
+	return issues
+}
+
+// validateEntry returns what is wrong with a single entry, or "" if it looks
+// fine. name is the name set by the entry itself, if any.
+func validateEntry(originalName, source, name, baseDir string) string {
+	if strings.TrimSpace(originalName) == "" {
+		return "emoji name is empty"
+	}
+	// The original name is not used when the entry sets one
+	if _, mapped := nameMap[originalName]; !mapped && name == "" && sanitizedName(originalName) == "" {
+		return "name is empty after sanitization (see --on-empty)"
+	}
+
+	source = strings.TrimSpace(source)
+	if source == "" {
+		return "no image URL"
+	}
+	if target, ok := strings.CutPrefix(source, "alias:"); ok {
+		if strings.TrimSpace(target) == "" {
+			return "alias has no target"
+		}
+		return ""
+	}
+
+	if entry, ok := strings.CutPrefix(source, zipPrefix); ok {
+		return checkZipEntry(entry)
+	}
+	if isDataURI(source) {
+		if _, _, err := decodeDataURI(source); err != nil {
+			return fmt.Sprintf("invalid data URI: %v", err)
+		}
+		return ""
+	}
+
+	u, err := url.Parse(source)
+	if err != nil || len(u.Scheme) <= 1 {
+		return checkLocalFile(source, baseDir)
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+		if u.Host == "" {
+			return fmt.Sprintf("URL %q has no host", source)
+		}
+	case "file":
+		path := u.Path
+		if u.Host != "" && u.Host != "localhost" {
+			path = u.Host + u.Path
+		}
+		return checkLocalFile(filepath.FromSlash(path), baseDir)
+	default:
+		return fmt.Sprintf("unsupported URL scheme %q", u.Scheme)
+	}
+	return ""
+}
+
+// checkLocalFile makes sure a local image path points to an existing file
+func checkLocalFile(path, baseDir string) string {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(baseDir, path)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Sprintf("file not found: %s", path)
+	}
+	if info.IsDir() {
+		return fmt.Sprintf("%s is a directory, not an image", path)
+	}
+	return ""
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestReusedURLs(t *testing.T) {
+	emojis := parseSource(t, filepath.Join(testdata, "reused-urls.json"))
+	tests := []struct {
+		max  int
+		want []string
+	}{
+		{2, []string{
+			"https://emoji.example.com/placeholder.png is used by 7 emojis: blob_angry, blob_cool, blob_dance, blob_happy, blob_sad and 2 more",
+			"https://emoji.example.com/cat.png is used by 3 emojis: cat_1, cat_2, cat_3",
+		}},
+		{3, []string{
+			"https://emoji.example.com/placeholder.png is used by 7 emojis: blob_angry, blob_cool, blob_dance, blob_happy, blob_sad and 2 more",
+		}},
+		// The three aliases of partyparrot don't count as reuse
+		{7, nil},
+	}
+	for _, tt := range tests {
+		if got := reusedURLs(emojis, tt.max); !slices.Equal(got, tt.want) {
+			t.Errorf("reusedURLs(max %d) = %q, want %q", tt.max, got, tt.want)
+		}
+	}
+}
+
+func TestMaxURLReuse(t *testing.T) {
+	file := filepath.Join(testdata, "reused-urls.json")
+	code, out := runCLI(t, "-f", file, "--dry-run", "--max-url-reuse", "3")
+	if code != exitOK {
+		t.Errorf("exit code %d, want %d for a warning\n%s", code, exitOK, out)
+	}
+	for _, want := range []string{
+		"1 image URLs are used by more than 3 emojis each",
+		"placeholder.png is used by 7 emojis",
+		"Continuing anyway",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output doesn't mention %q:\n%s", want, out)
+		}
+	}
+
+	// Without the flag nothing is checked
+	if _, out := runCLI(t, "-f", file, "--dry-run"); strings.Contains(out, "is used by") {
+		t.Errorf("reused URLs reported without --max-url-reuse:\n%s", out)
+	}
+}
+
+func TestMaxURLReuseStrict(t *testing.T) {
+	srv := newFakeServer(t)
+	code, out := runCLI(t, "-s", srv.URL, "-t", "tok", "-f", filepath.Join(testdata, "reused-urls.json"), "--max-url-reuse", "3", "--strict")
+	if code != exitSetup {
+		t.Errorf("exit code %d, want %d with --strict\n%s", code, exitSetup, out)
+	}
+	if !strings.Contains(out, "Aborting because -strict is set") {
+		t.Errorf("output doesn't explain the abort:\n%s", out)
+	}
+	if n := srv.requestCount(""); n != 0 {
+		t.Errorf("%d requests before aborting, want none", n)
+	}
+}
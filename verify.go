@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/formatCvt/mattermost-emoji-uploader/emojiuploader"
+)
+
+// verify looks up every emoji uploaded in this run by name and downloads its
+// image to make sure the server stored what was sent (--verify). It returns
+// the number of emojis that failed the check.
+func (imp *importer) verify(ctx context.Context, results []Result) int {
+	var uploaded []Result
+	for _, r := range results {
+		if r.Action == actionUploaded || r.Action == actionAlias {
+			uploaded = append(uploaded, r)
+		}
+	}
+	if len(uploaded) == 0 {
+		return 0
+	}
+	logInfo("\n🔍 Verifying %d uploaded emojis...\n", len(uploaded))
+
+	queue := make(chan Result)
+	var mu sync.Mutex
+	problems := 0
+	var wg sync.WaitGroup
+	for i := 0; i < uploadConcurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r := range queue {
+				if problem := imp.verifyEmoji(ctx, r); problem != "" {
+					logError("❌ [:%s:] %s\n", r.SanitizedName, problem)
+					mu.Lock()
+					problems++
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+dispatch:
+	for _, r := range uploaded {
+		select {
+		case queue <- r:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	if problems > 0 {
+		logSummary("❌ %d of %d uploaded emojis failed verification\n", problems, len(uploaded))
+	} else if ctx.Err() == nil {
+		logSummary("✅ All %d uploaded emojis are on the server\n", len(uploaded))
+	}
+	return problems
+}
+
+// verifyEmoji checks a single uploaded emoji and describes what is wrong with
+// it, or returns "" when it is fine. A size that differs from the upload is
+// only warned about.
+func (imp *importer) verifyEmoji(ctx context.Context, r Result) string {
+	var emoji *emojiuploader.Emoji
+	err := withRetry(ctx, retries+1, func() error {
+		imp.limiter.wait(ctx)
+		var err error
+		emoji, err = imp.api.EmojiByName(ctx, r.SanitizedName)
+		return err
+	})
+	if emojiuploader.HasStatus(err, http.StatusNotFound) {
+		return "not found on the server"
+	}
+	if err != nil {
+		return fmt.Sprintf("lookup failed: %v", err)
+	}
+
+	var data []byte
+	err = withRetry(ctx, retries+1, func() error {
+		imp.limiter.wait(ctx)
+		var err error
+		data, _, err = imp.api.EmojiImage(ctx, emoji.ID)
+		return err
+	})
+	switch {
+	case err != nil:
+		return fmt.Sprintf("image download failed: %v", err)
+	case len(data) == 0:
+		return "the server returned an empty image"
+	case len(data) != r.SizeBytes:
+		// Servers or proxies that re-encode images store a different size, so
+		// this is only worth a look
+		logError("⚠️  [:%s:] size mismatch: uploaded %d bytes, the server returned %d\n", r.SanitizedName, r.SizeBytes, len(data))
+		return ""
+	}
+	logDebug("🔎 [:%s:] verified (%s)\n", r.SanitizedName, formatSize(len(data)))
+	return ""
+}
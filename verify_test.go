@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	srv := newFakeServer(t)
+	file := sourceFile(t,
+		"good", srv.img("good.png", pngData),
+		"short", srv.img("short.png", pngData),
+		"gone", srv.img("gone.png", pngData),
+	)
+	// The server loses one emoji and truncates the image of another
+	srv.handle("GET /api/v4/emoji/name/gone", func(w http.ResponseWriter, r *http.Request) {
+		writeAppError(w, http.StatusNotFound, "app.emoji.get_by_name.no_result", "No emoji found.")
+	})
+	for i := 1; i <= 3; i++ {
+		id := "e" + strconv.Itoa(i)
+		srv.handle("GET /api/v4/emoji/"+id+"/image", func(w http.ResponseWriter, r *http.Request) {
+			srv.mu.Lock()
+			defer srv.mu.Unlock()
+			for _, e := range srv.emojis {
+				if e.ID != id {
+					continue
+				}
+				if e.Name == "short" {
+					w.Write(e.Data[:10])
+				} else {
+					w.Write(e.Data)
+				}
+				return
+			}
+			http.NotFound(w, r)
+		})
+	}
+
+	code, _, out := runImport(t, srv, file, "--verify")
+	if code != exitFailures {
+		t.Errorf("exit code %d, want %d for emojis that failed verification\n%s", code, exitFailures, out)
+	}
+	// A different size is only a warning, as servers may re-encode images
+	for _, want := range []string{
+		"❌ [:gone:] not found on the server",
+		"⚠️  [:short:] size mismatch: uploaded " + strconv.Itoa(len(pngData)) + " bytes, the server returned 10",
+		"1 of 3 uploaded emojis failed verification",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output doesn't mention %q:\n%s", want, out)
+		}
+	}
+	for _, name := range []string{"good", "short"} {
+		if strings.Contains(out, "❌ [:"+name+":]") {
+			t.Errorf("%s reported as a problem:\n%s", name, out)
+		}
+	}
+}
+
+func TestVerifyAllGood(t *testing.T) {
+	srv := newFakeServer(t)
+	file := sourceFile(t, "a", srv.img("a.png", pngData), "b", srv.img("b.png", pngData))
+
+	code, _, out := runImport(t, srv, file, "--verify")
+	if code != exitOK {
+		t.Fatalf("exit code %d, output:\n%s", code, out)
+	}
+	if !strings.Contains(out, "All 2 uploaded emojis are on the server") {
+		t.Errorf("output doesn't confirm the upload:\n%s", out)
+	}
+	if n := srv.requestCount("GET /api/v4/emoji/name/"); n != 2 {
+		t.Errorf("%d lookups, want one per uploaded emoji", n)
+	}
+}
+
+func TestVerifySizeMismatchOnly(t *testing.T) {
+	srv := newFakeServer(t)
+	file := sourceFile(t, "party", srv.img("party.png", pngData))
+	srv.handle("GET /api/v4/emoji/e1/image", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(pngData[:10])
+	})
+
+	code, _, out := runImport(t, srv, file, "--verify")
+	if code != exitOK {
+		t.Errorf("exit code %d, want %d for a re-encoded image\n%s", code, exitOK, out)
+	}
+	if !strings.Contains(out, "[:party:] size mismatch") || !strings.Contains(out, "All 1 uploaded emojis are on the server") {
+		t.Errorf("output doesn't warn about the size and confirm the upload:\n%s", out)
+	}
+}
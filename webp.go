@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image/png"
+
+	"golang.org/x/image/webp"
+)
+
+// convertWebP decodes a WebP image and re-encodes it as PNG, since Mattermost
+// doesn't accept WebP emojis. Animated WebP is reduced to its first frame and
+// reported via the animated return value.
+func convertWebP(data []byte) (converted []byte, animated bool, err error) {
+	if isAnimatedWebP(data) {
+		animated = true
+		if data, err = firstWebPFrame(data); err != nil {
+			return nil, true, err
+		}
+	}
+
+	img, err := webp.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, animated, fmt.Errorf("decode webp: %w", err)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, img); err != nil {
+		return nil, animated, fmt.Errorf("encode png: %w", err)
+	}
+	return buf.Bytes(), animated, nil
+}
+
+// webpChunk is a single chunk of a RIFF WebP container
+type webpChunk struct {
+	fourCC  string
+	payload []byte
+}
+
+// webpChunks splits a RIFF WebP file or a nested chunk list into chunks
+func webpChunks(data []byte) ([]webpChunk, error) {
+	var chunks []webpChunk
+	for len(data) >= 8 {
+		size := int(binary.LittleEndian.Uint32(data[4:8]))
+		if 8+size > len(data) {
+			return nil, errors.New("webp: truncated chunk")
+		}
+		chunks = append(chunks, webpChunk{fourCC: string(data[:4]), payload: data[8 : 8+size]})
+
+		// Chunks are padded to an even size
+		next := 8 + size + size%2
+		if next > len(data) {
+			break
+		}
+		data = data[next:]
+	}
+	return chunks, nil
+}
+
+// isAnimatedWebP checks the animation flag of an extended (VP8X) WebP header
+func isAnimatedWebP(data []byte) bool {
+	return len(data) >= 21 &&
+		string(data[0:4]) == "RIFF" && string(data[8:12]) == "WEBP" &&
+		string(data[12:16]) == "VP8X" && data[20]&0x02 != 0
+}
+
+// firstWebPFrame rebuilds a standalone still WebP from the first ANMF frame of
+// an animated one, so it can be handled by a decoder without animation support
+func firstWebPFrame(data []byte) ([]byte, error) {
+	chunks, err := webpChunks(data[12:])
+	if err != nil {
+		return nil, err
+	}
+
+	for _, chunk := range chunks {
+		// ANMF payload: 16 bytes of frame geometry and timing, then the frame's own chunks
+		if chunk.fourCC != "ANMF" || len(chunk.payload) < 16 {
+			continue
+		}
+		frame := chunk.payload[16:]
+		frameChunks, err := webpChunks(frame)
+		if err != nil {
+			return nil, err
+		}
+
+		body := &bytes.Buffer{}
+		body.WriteString("WEBP")
+		for _, fc := range frameChunks {
+			if fc.fourCC != "ALPH" {
+				continue
+			}
+			// Lossy frames with alpha need a VP8X header announcing the alpha channel
+			vp8x := make([]byte, 10)
+			vp8x[0] = 0x10
+			copy(vp8x[4:7], chunk.payload[6:9])   // canvas width - 1
+			copy(vp8x[7:10], chunk.payload[9:12]) // canvas height - 1
+			writeWebPChunk(body, "VP8X", vp8x)
+			break
+		}
+		body.Write(frame)
+
+		out := &bytes.Buffer{}
+		out.WriteString("RIFF")
+		_ = binary.Write(out, binary.LittleEndian, uint32(body.Len()))
+		out.Write(body.Bytes())
+		return out.Bytes(), nil
+	}
+	return nil, errors.New("webp: animation has no frames")
+}
+
+// writeWebPChunk appends a chunk with its header and padding to buf
+func writeWebPChunk(buf *bytes.Buffer, fourCC string, payload []byte) {
+	buf.WriteString(fourCC)
+	_ = binary.Write(buf, binary.LittleEndian, uint32(len(payload)))
+	buf.Write(payload)
+	if len(payload)%2 == 1 {
+		buf.WriteByte(0)
+	}
+}
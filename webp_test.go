@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/image/webp"
+)
+
+// readWebP returns the still WebP test image and its dimensions
+func readWebP(t *testing.T) ([]byte, int, int) {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(testdata, "gopher.webp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := webp.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data, cfg.Width, cfg.Height
+}
+
+// animatedWebP wraps the image chunk of a still WebP into an animation of frames frames
+func animatedWebP(still []byte, w, h, frames int) []byte {
+	uint24 := func(v int) []byte { return []byte{byte(v), byte(v >> 8), byte(v >> 16)} }
+	image := still[12:] // the VP8L chunk after RIFF, size and WEBP
+
+	body := &bytes.Buffer{}
+	body.WriteString("WEBP")
+	vp8x := append([]byte{0x02, 0, 0, 0}, append(uint24(w-1), uint24(h-1)...)...)
+	writeWebPChunk(body, "VP8X", vp8x)
+	writeWebPChunk(body, "ANIM", make([]byte, 6))
+	for i := 0; i < frames; i++ {
+		anmf := append(uint24(0), uint24(0)...)
+		anmf = append(anmf, uint24(w-1)...)
+		anmf = append(anmf, uint24(h-1)...)
+		anmf = append(anmf, uint24(100)...)
+		anmf = append(anmf, 0)
+		writeWebPChunk(body, "ANMF", append(anmf, image...))
+	}
+
+	out := &bytes.Buffer{}
+	out.WriteString("RIFF")
+	binary.Write(out, binary.LittleEndian, uint32(body.Len()))
+	out.Write(body.Bytes())
+	return out.Bytes()
+}
+
+// checkPNG fails unless data is a w x h PNG
+func checkPNG(t *testing.T, data []byte, w, h int) {
+	t.Helper()
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("not a valid PNG: %v", err)
+	}
+	if b := img.Bounds(); b.Dx() != w || b.Dy() != h {
+		t.Errorf("PNG is %dx%d, want %dx%d", b.Dx(), b.Dy(), w, h)
+	}
+}
+
+func TestConvertWebP(t *testing.T) {
+	data, w, h := readWebP(t)
+	converted, animated, err := convertWebP(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if animated {
+		t.Error("a still WebP was reported as animated")
+	}
+	checkPNG(t, converted, w, h)
+}
+
+func TestConvertAnimatedWebP(t *testing.T) {
+	data, w, h := readWebP(t)
+	anim := animatedWebP(data, w, h, 3)
+	if !isAnimatedWebP(anim) {
+		t.Fatal("isAnimatedWebP = false for an animation")
+	}
+	converted, animated, err := convertWebP(anim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !animated {
+		t.Error("an animated WebP was not reported as animated")
+	}
+	checkPNG(t, converted, w, h)
+}
+
+func TestConvertInvalidWebP(t *testing.T) {
+	if _, _, err := convertWebP([]byte("RIFF\x04\x00\x00\x00WEBPnope")); err == nil {
+		t.Error("no error for a broken WebP")
+	}
+}
+
+func TestUploadWebP(t *testing.T) {
+	srv := newFakeServer(t)
+	data, w, h := readWebP(t)
+	file := sourceFile(t,
+		"gopher", srv.img("gopher.webp", data),
+		"anim", srv.img("anim.webp", animatedWebP(data, w, h, 2)),
+	)
+
+	code, report, out := runImport(t, srv, file)
+	if code != exitOK {
+		t.Fatalf("exit code %d, output:\n%s", code, out)
+	}
+	uploads := srv.uploaded()
+	if len(uploads) != 2 {
+		t.Fatalf("%d uploads, want 2\n%s", len(uploads), out)
+	}
+	for _, u := range uploads {
+		checkPNG(t, u.Data, w, h)
+		if !strings.HasSuffix(u.Filename, ".png") {
+			t.Errorf("%s uploaded as %q, want a .png file", u.Name, u.Filename)
+		}
+	}
+	if r := byName(report)["anim"]; !strings.Contains(r.Reason, "only the first frame") {
+		t.Errorf("anim: reason = %q, want a note about the dropped frames", r.Reason)
+	}
+}
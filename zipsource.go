@@ -0,0 +1,129 @@
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/formatCvt/mattermost-emoji-uploader/emojiuploader"
+)
+
+// zipPrefix marks emoji sources that are entries of the --zip archive
+const zipPrefix = "zip:"
+
+// zipManifest is the optional emoji map at the root of a --zip archive
+const zipManifest = "emoji.json"
+
+// archive is the --zip archive the images are read from, if any
+var archive *zip.Reader
+
+// loadZip builds an emoji map from a ZIP archive. The emojis listed in its
+// emoji.json manifest come first, with paths resolved against the root of the
+// archive; every other image in it is added under its file name without the
+// extension. Image entries are referenced as zip:<entry> and read straight
+// from the archive when they are uploaded. Names used twice are reported as
+// issues, keeping the manifest entry or the first image in sorted order. The
+// settings of manifest entries are returned as well, see parseEmojiMap.
+func loadZip(zr *zip.Reader, format string) (EmojiMap, map[string]emojiEntry, []string, error) {
+	emojis := make(EmojiMap)
+	var settings map[string]emojiEntry
+	var issues []string
+	referenced := make(map[string]bool)
+
+	if data, err := fs.ReadFile(zr, zipManifest); err == nil {
+		var manifest EmojiMap
+		manifest, settings, err = parseEmojiMap(data, format)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("%s: %w", zipManifest, err)
+		}
+		for name, source := range manifest {
+			entry, ok := zipEntryName(source)
+			if !ok {
+				emojis[name] = source
+				continue
+			}
+			if !fs.ValidPath(entry) {
+				issues = append(issues, fmt.Sprintf("[:%s:] %s is outside the archive", name, source))
+				continue
+			}
+			emojis[name] = zipPrefix + entry
+			referenced[entry] = true
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, nil, fmt.Errorf("%s: %w", zipManifest, err)
+	}
+
+	var entries []string
+	for _, f := range zr.File {
+		name := f.Name
+		if f.FileInfo().IsDir() || referenced[name] || !fs.ValidPath(name) {
+			continue
+		}
+		// Skip metadata such as __MACOSX/ folders and .DS_Store files
+		if strings.HasPrefix(name, "__MACOSX/") || strings.HasPrefix(path.Base(name), ".") {
+			continue
+		}
+		if imageExtensions[strings.ToLower(path.Ext(name))] {
+			entries = append(entries, name)
+		}
+	}
+	sort.Strings(entries)
+
+	for _, entry := range entries {
+		name := strings.TrimSuffix(path.Base(entry), path.Ext(entry))
+		if _, ok := emojis[name]; ok {
+			issues = append(issues, fmt.Sprintf("[:%s:] %s ignored, the name is already used", name, entry))
+			continue
+		}
+		emojis[name] = zipPrefix + entry
+	}
+	if len(emojis) == 0 && len(issues) == 0 {
+		return nil, nil, nil, fmt.Errorf("no %s or image files found in the archive", zipManifest)
+	}
+	return emojis, settings, issues, nil
+}
+
+// zipEntryName returns the archive entry a manifest value refers to, or false
+// for values that aren't local paths, such as URLs and aliases
+func zipEntryName(source string) (string, bool) {
+	source = strings.TrimSpace(source)
+	if strings.HasPrefix(source, "alias:") || isDataURI(source) || strings.Contains(source, "://") {
+		return "", false
+	}
+	return path.Clean(strings.TrimPrefix(strings.ReplaceAll(source, `\`, "/"), "./")), true
+}
+
+// readZipImage reads an image entry of the --zip archive
+func readZipImage(entry string) ([]byte, string, error) {
+	if archive == nil {
+		return nil, "", fmt.Errorf("%s%s: no --zip archive is open", zipPrefix, entry)
+	}
+	f, err := archive.Open(entry)
+	if err != nil {
+		return nil, "", err
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, emojiuploader.DetectContentType(data, ""), nil
+}
+
+// checkZipEntry makes sure an image entry exists in the --zip archive
+func checkZipEntry(entry string) string {
+	info, err := fs.Stat(archive, entry)
+	if err != nil {
+		return fmt.Sprintf("not found in the archive: %s", entry)
+	}
+	if info.IsDir() {
+		return fmt.Sprintf("%s is a directory, not an image", entry)
+	}
+	return ""
+}
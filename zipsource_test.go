@@ -0,0 +1,132 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+// buildZip returns an in-memory ZIP archive of the given entries
+func buildZip(t *testing.T, files map[string][]byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(files[name]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// emojiPack returns the entries of an emoji pack with a manifest that points
+// at remote for one of its emojis
+func emojiPack(t *testing.T, remote string) map[string][]byte {
+	gifData := animatedGIF(t, 4, 4, 1)
+	return map[string][]byte{
+		zipManifest: []byte(`{
+			"party": "images/party.png",
+			"blob": "./images/blob.gif",
+			"remote": "` + remote + `",
+			"escape": "../outside.png"
+		}`),
+		"images/party.png":    pngData,
+		"images/blob.gif":     gifData,
+		"wave.png":            pngData,
+		"extra/party.gif":     gifData,
+		"notes.txt":           []byte("not an image"),
+		"__MACOSX/._wave.png": []byte("metadata"),
+		".DS_Store":           []byte("metadata"),
+	}
+}
+
+func TestLoadZip(t *testing.T) {
+	data := buildZip(t, emojiPack(t, "https://example.com/remote.png"))
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	emojis, _, issues, err := loadZip(zr, "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := EmojiMap{
+		"party":  "zip:images/party.png",
+		"blob":   "zip:images/blob.gif",
+		"remote": "https://example.com/remote.png",
+		"wave":   "zip:wave.png",
+	}
+	if !reflect.DeepEqual(emojis, want) {
+		t.Errorf("emojis = %v, want %v", emojis, want)
+	}
+	wantIssues := []string{
+		"[:escape:] ../outside.png is outside the archive",
+		"[:party:] extra/party.gif ignored, the name is already used",
+	}
+	if !slices.Equal(issues, wantIssues) {
+		t.Errorf("issues = %q, want %q", issues, wantIssues)
+	}
+}
+
+func TestLoadZipWithoutManifest(t *testing.T) {
+	data := buildZip(t, map[string][]byte{"a.png": pngData, "dir/b.jpg": pngData, "c.txt": nil})
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	emojis, _, issues, err := loadZip(zr, "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (EmojiMap{"a": "zip:a.png", "b": "zip:dir/b.jpg"}); !reflect.DeepEqual(emojis, want) || len(issues) > 0 {
+		t.Errorf("emojis = %v, issues = %q, want %v", emojis, issues, want)
+	}
+
+	for name, files := range map[string]map[string][]byte{
+		"no images":    {"notes.txt": []byte("nothing")},
+		"bad manifest": {zipManifest: []byte("{"), "a.png": pngData},
+	} {
+		data := buildZip(t, files)
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, _, err := loadZip(zr, "json"); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+func TestUploadZip(t *testing.T) {
+	srv := newFakeServer(t)
+	pack := emojiPack(t, srv.img("remote.png", pngData))
+	path := writeFile(t, t.TempDir(), "pack.zip", buildZip(t, pack))
+
+	code, out := runCLI(t, "-s", srv.URL, "-t", "tok", "--zip", path, "--delay", "0")
+	if code != exitOK {
+		t.Fatalf("exit code %d, output:\n%s", code, out)
+	}
+	uploads := make(map[string][]byte)
+	for _, u := range srv.uploaded() {
+		uploads[u.Name] = u.Data
+	}
+	want := map[string][]byte{"party": pngData, "blob": pack["images/blob.gif"], "remote": pngData, "wave": pngData}
+	if !reflect.DeepEqual(uploads, want) {
+		t.Errorf("uploaded %d emojis, want %d from the archive and the URL\n%s", len(uploads), len(want), out)
+	}
+}